@@ -0,0 +1,367 @@
+package cloudstorage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ArchiveStoreType is the Type of an ArchiveStore.
+const ArchiveStoreType = "archive"
+
+// ArchiveFormat is the file format of the archive of an ArchiveStore.
+type ArchiveFormat int
+
+const (
+	// ArchiveTar is an uncompressed tar archive, a compressed tar has no
+	// random access so has to be decompressed first.
+	ArchiveTar ArchiveFormat = iota
+	// ArchiveZip is a zip archive.
+	ArchiveZip
+)
+
+func (f ArchiveFormat) String() string {
+	switch f {
+	case ArchiveTar:
+		return "tar"
+	case ArchiveZip:
+		return "zip"
+	}
+	return fmt.Sprintf("ArchiveFormat(%d)", int(f))
+}
+
+// ArchiveStore is a read only Store of the files in a tar or zip archive, ie
+// a dataset shipped as a single file.  The entries are indexed once, from
+// the zip central directory or by scanning the tar headers, and read with
+// random access through the io.ReaderAt.  Directories are only folders, as
+// for object stores.  Writes and deletes fail with ErrReadOnly.
+type ArchiveStore struct {
+	r       io.ReaderAt
+	format  ArchiveFormat
+	names   []string
+	entries map[string]*archiveEntry
+}
+
+type archiveEntry struct {
+	name    string
+	size    int64
+	updated time.Time
+	// offset of the tar entry's bytes in the archive.
+	offset int64
+	// zf is the zip entry.
+	zf *zip.File
+}
+
+// NewArchiveStore create a store of the files in the archive r of size bytes.
+func NewArchiveStore(r io.ReaderAt, size int64, format ArchiveFormat) (Store, error) {
+	a := &ArchiveStore{r: r, format: format, entries: make(map[string]*archiveEntry)}
+	switch format {
+	case ArchiveTar:
+		if err := a.indexTar(size); err != nil {
+			return nil, err
+		}
+	case ArchiveZip:
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zip archive err=%v", err)
+		}
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			a.add(&archiveEntry{name: zf.Name, size: int64(zf.UncompressedSize64), updated: zf.Modified, zf: zf})
+		}
+	default:
+		return nil, fmt.Errorf("unrecognized archive format %v", format)
+	}
+	sort.Strings(a.names)
+	return a, nil
+}
+
+// offsetReader counts the bytes read, the tar reader doesn't read ahead of
+// an entry's header so it is the offset of the entry's bytes.
+type offsetReader struct {
+	r      io.Reader
+	offset int64
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (a *ArchiveStore) indexTar(size int64) error {
+	or := &offsetReader{r: io.NewSectionReader(a.r, 0, size)}
+	tr := tar.NewReader(or)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid tar archive err=%v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		a.add(&archiveEntry{name: hdr.Name, size: hdr.Size, updated: hdr.ModTime, offset: or.offset})
+	}
+}
+
+func (a *ArchiveStore) add(e *archiveEntry) {
+	// archives are often made of "./" relative paths.
+	e.name = strings.TrimLeft(strings.TrimPrefix(e.name, "./"), "/")
+	if e.name == "" {
+		return
+	}
+	if _, ok := a.entries[e.name]; !ok {
+		a.names = append(a.names, e.name)
+	}
+	// as when extracted, a later entry replaces an earlier one.
+	a.entries[e.name] = e
+}
+
+// Type of store = "archive"
+func (a *ArchiveStore) Type() string {
+	return ArchiveStoreType
+}
+
+// Client is the io.ReaderAt of the archive.
+func (a *ArchiveStore) Client() interface{} {
+	return a.r
+}
+
+// Get an entry of the archive.
+func (a *ArchiveStore) Get(ctx context.Context, name string) (Object, error) {
+	e, ok := a.entries[name]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return newArchiveObject(e, a), nil
+}
+
+// Objects iterates the entries of the archive, in name order.
+func (a *ArchiveStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	resp, err := a.List(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	objs := resp.Objects
+	if q.Limit > 0 && len(objs) > q.Limit {
+		objs = objs[:q.Limit]
+	}
+	return &listingIterator{objs: objs}, nil
+}
+
+// List the entries of the archive matching the query, as a single page.
+func (a *ArchiveStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	resp := NewObjectsResponse()
+	start := sort.SearchStrings(a.names, q.Prefix)
+	for _, name := range a.names[start:] {
+		if !strings.HasPrefix(name, q.Prefix) {
+			break
+		}
+		if q.Delimiter != "" && strings.Contains(name[len(q.Prefix):], q.Delimiter) {
+			continue
+		}
+		resp.Objects = append(resp.Objects, newArchiveObject(a.entries[name], a))
+	}
+	resp.Objects = q.ApplyFilters(resp.Objects)
+	return resp, nil
+}
+
+// Folders are the directories under the query prefix, with a trailing "/".
+func (a *ArchiveStore) Folders(ctx context.Context, q Query) ([]string, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	folders := make([]string, 0)
+	start := sort.SearchStrings(a.names, q.Prefix)
+	for _, name := range a.names[start:] {
+		if !strings.HasPrefix(name, q.Prefix) {
+			break
+		}
+		i := strings.Index(name[len(q.Prefix):], "/")
+		if i < 0 {
+			continue
+		}
+		folder := name[:len(q.Prefix)+i+1]
+		if len(folders) == 0 || folders[len(folders)-1] != folder {
+			folders = append(folders, folder)
+		}
+	}
+	return folders, nil
+}
+
+// NewReader of an entry of the archive.
+func (a *ArchiveStore) NewReader(name string) (io.ReadCloser, error) {
+	return a.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of an entry of the archive.  Zip entries are checked
+// against their crc32 as they are read, tar entries have no checksum so
+// VerifyChecksum is ErrChecksumUnavailable.
+func (a *ArchiveStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	e, ok := a.entries[name]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	if e.zf == nil && len(opts) > 0 && opts[0].VerifyChecksum {
+		return nil, ErrChecksumUnavailable
+	}
+	rc, err := a.open(e)
+	if err != nil {
+		return nil, err
+	}
+	return MaxBytesReader(rc, opts), nil
+}
+
+func (a *ArchiveStore) open(e *archiveEntry) (io.ReadCloser, error) {
+	if e.zf != nil {
+		return e.zf.Open()
+	}
+	return ioutil.NopCloser(io.NewSectionReader(a.r, e.offset, e.size)), nil
+}
+
+// NewWriter is ErrReadOnly.
+func (a *ArchiveStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return nil, ErrReadOnly
+}
+
+// NewWriterWithContext is ErrReadOnly.
+func (a *ArchiveStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	return nil, ErrReadOnly
+}
+
+// NewObject is ErrReadOnly.
+func (a *ArchiveStore) NewObject(name string) (Object, error) {
+	return nil, ErrReadOnly
+}
+
+// ResolveKey is the name, entries are named by their path in the archive
+// less any leading "./" or "/".
+func (a *ArchiveStore) ResolveKey(name string) string {
+	return name
+}
+
+// Delete is ErrReadOnly.
+func (a *ArchiveStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	return ErrReadOnly
+}
+
+func (a *ArchiveStore) String() string {
+	return fmt.Sprintf("archive(%s)", a.format)
+}
+
+// archiveObject is an entry of an ArchiveStore, Open extracts it to a local
+// temp file.
+type archiveObject struct {
+	e        *archiveEntry
+	a        *ArchiveStore
+	metadata map[string]string
+	f        *os.File
+}
+
+func newArchiveObject(e *archiveEntry, a *ArchiveStore) *archiveObject {
+	md := make(map[string]string)
+	EnsureContextType(e.name, md)
+	return &archiveObject{e: e, a: a, metadata: md}
+}
+
+func (o *archiveObject) Name() string       { return o.e.name }
+func (o *archiveObject) String() string     { return o.e.name }
+func (o *archiveObject) Updated() time.Time { return o.e.updated }
+func (o *archiveObject) Size() int64        { return o.e.size }
+func (o *archiveObject) MD5() []byte        { return nil }
+
+// ETag is a version tag from the entry's modified time and size, archives
+// have no native ETag.
+func (o *archiveObject) ETag() string {
+	return fmt.Sprintf("%x-%x", o.e.updated.UnixNano(), o.e.size)
+}
+
+func (o *archiveObject) ContentType() string                { return o.metadata[ContentTypeKey] }
+func (o *archiveObject) MetaData() map[string]string        { return o.metadata }
+func (o *archiveObject) SetMetaData(meta map[string]string) { o.metadata = meta }
+func (o *archiveObject) StorageSource() string              { return ArchiveStoreType }
+
+// Open extracts the entry to a temp file, ReadWrite is ErrReadOnly.
+func (o *archiveObject) Open(accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	if !accesslevel.Valid() {
+		return nil, ErrInvalidAccessLevel
+	}
+	if accesslevel != ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if o.f != nil {
+		return o.f, nil
+	}
+	rc, err := o.a.NewReaderWithContext(context.Background(), o.e.name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	f, err := ioutil.TempFile("", "cloudstorage-archive")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	o.f = f
+	return f, nil
+}
+
+// Release removes the extracted temp file.
+func (o *archiveObject) Release() error {
+	if o.f == nil {
+		return nil
+	}
+	o.f.Close()
+	err := os.Remove(o.f.Name())
+	o.f = nil
+	return err
+}
+
+func (o *archiveObject) Read(p []byte) (int, error) {
+	if o.f == nil {
+		return 0, fmt.Errorf("object %q is not opened", o.e.name)
+	}
+	return o.f.Read(p)
+}
+
+// Write is ErrReadOnly.
+func (o *archiveObject) Write(p []byte) (int, error) { return 0, ErrReadOnly }
+
+// Sync is ErrReadOnly.
+func (o *archiveObject) Sync() error { return ErrReadOnly }
+
+// Close releases the extracted temp file.
+func (o *archiveObject) Close() error { return o.Release() }
+
+func (o *archiveObject) File() *os.File { return o.f }
+
+// Delete is ErrReadOnly.
+func (o *archiveObject) Delete() error { return ErrReadOnly }
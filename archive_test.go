@@ -0,0 +1,99 @@
+package cloudstorage_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+
+	"github.com/lytics/cloudstorage"
+)
+
+var archiveFiles = []struct{ name, body string }{
+	{"./data/a.csv", "a,b,c\n"},
+	{"./data/nested/b.csv", "d,e,f\n"},
+	{"./readme.txt", "dataset"},
+}
+
+func tarArchive(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	assert.Equal(t, nil, tw.WriteHeader(&tar.Header{Name: "./data/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for _, f := range archiveFiles {
+		err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), ModTime: time.Now()})
+		assert.Equal(t, nil, err)
+		_, err = tw.Write([]byte(f.body))
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, nil, tw.Close())
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, f := range archiveFiles {
+		w, err := zw.Create(f.name[2:])
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(f.body))
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, nil, zw.Close())
+	return buf.Bytes()
+}
+
+func TestArchiveStore(t *testing.T) {
+	ctx := context.Background()
+	for format, b := range map[cloudstorage.ArchiveFormat][]byte{
+		cloudstorage.ArchiveTar: tarArchive(t),
+		cloudstorage.ArchiveZip: zipArchive(t),
+	} {
+		store, err := cloudstorage.NewArchiveStore(bytes.NewReader(b), int64(len(b)), format)
+		assert.Equal(t, nil, err, format.String())
+
+		iter, err := store.Objects(ctx, cloudstorage.NewQuery("data/"))
+		assert.Equal(t, nil, err)
+		names := []string{}
+		for {
+			o, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			assert.Equal(t, nil, err)
+			names = append(names, o.Name())
+		}
+		assert.Equal(t, []string{"data/a.csv", "data/nested/b.csv"}, names, format.String())
+
+		folders, err := store.Folders(ctx, cloudstorage.NewQueryForFolders(""))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []string{"data/"}, folders)
+		folders, err = store.Folders(ctx, cloudstorage.NewQueryForFolders("data/"))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []string{"data/nested/"}, folders)
+
+		o, err := store.Get(ctx, "data/nested/b.csv")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, int64(6), o.Size())
+		assert.Equal(t, "d,e,f\n", readAll(t, store, "data/nested/b.csv"))
+		f, err := o.Open(cloudstorage.ReadOnly)
+		assert.Equal(t, nil, err)
+		body, err := ioutil.ReadAll(f)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "d,e,f\n", string(body))
+		assert.Equal(t, nil, o.Close())
+
+		_, err = store.Get(ctx, "data/missing.csv")
+		assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+		_, err = store.NewWriter("data/c.csv", nil)
+		assert.Equal(t, cloudstorage.ErrReadOnly, err)
+		assert.Equal(t, cloudstorage.ErrReadOnly, store.Delete(ctx, "readme.txt"))
+		_, err = o.Open(cloudstorage.ReadWrite)
+		assert.Equal(t, cloudstorage.ErrReadOnly, err)
+	}
+}
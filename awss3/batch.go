@@ -0,0 +1,172 @@
+package awss3
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/araddon/gou"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3control"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+
+	"github.com/lytics/cloudstorage"
+)
+
+const (
+	// ConfKeyAccountID config key name of the aws account id S3 Batch
+	// Operations jobs are created in, see SubmitBulkCopy.
+	ConfKeyAccountID = "account_id"
+	// ConfKeyBatchRoleARN config key name of the IAM role S3 Batch Operations
+	// jobs run as, it needs to read the manifest and copy in the bucket.
+	ConfKeyBatchRoleARN = "batch_role_arn"
+
+	// batchManifestPrefix is where SubmitBulkCopy writes job manifests.
+	batchManifestPrefix = ".cloudstorage-batch/"
+)
+
+// SubmitBulkCopy creates an S3 Batch Operations job copying the objects of
+// the bucket.  The objects are listed into a manifest written under
+// ".cloudstorage-batch/", removed once BulkJobStatus sees the job finish.
+// S3 Batch Operations prepends dstPrefix to the full key, it can't replace
+// srcPrefix as CopyPrefix does, so only whole bucket copies (an empty
+// srcPrefix) are batch jobs, others are ErrFeatureNotSupported and so run by
+// cloudstorage.SubmitBulkCopy in process.  Needs the ConfKeyAccountID and
+// ConfKeyBatchRoleARN settings.
+func (f *FS) SubmitBulkCopy(ctx context.Context, srcPrefix, dstPrefix string) (cloudstorage.JobID, error) {
+	if srcPrefix != "" {
+		return "", cloudstorage.ErrFeatureNotSupported
+	}
+	if f.accountID == "" || f.batchRoleARN == "" {
+		return "", fmt.Errorf("s3 batch operations need the %s and %s settings", ConfKeyAccountID, ConfKeyBatchRoleARN)
+	}
+	manifest := fmt.Sprintf("%s%d.csv", batchManifestPrefix, time.Now().UnixNano())
+	etag, err := f.writeManifest(ctx, manifest, srcPrefix)
+	if err != nil {
+		return "", err
+	}
+	out, err := s3control.New(f.sess).CreateJobWithContext(ctx, &s3control.CreateJobInput{
+		AccountId:            aws.String(f.accountID),
+		ClientRequestToken:   aws.String(manifest),
+		ConfirmationRequired: aws.Bool(false),
+		Description:          aws.String(fmt.Sprintf("cloudstorage copy s3://%s/%s to %s", f.bucket, srcPrefix, dstPrefix)),
+		Manifest: &s3control.JobManifest{
+			Spec: &s3control.JobManifestSpec{
+				Format: aws.String(s3control.JobManifestFormatS3batchOperationsCsv20180820),
+				Fields: aws.StringSlice([]string{s3control.JobManifestFieldNameBucket, s3control.JobManifestFieldNameKey}),
+			},
+			Location: &s3control.JobManifestLocation{
+				ObjectArn: aws.String(fmt.Sprintf("arn:aws:s3:::%s/%s", f.bucket, manifest)),
+				ETag:      aws.String(etag),
+			},
+		},
+		Operation: &s3control.JobOperation{
+			S3PutObjectCopy: &s3control.S3CopyObjectOperation{
+				TargetResource:  aws.String("arn:aws:s3:::" + f.bucket),
+				TargetKeyPrefix: aws.String(dstPrefix),
+			},
+		},
+		Priority: aws.Int64(10),
+		Report:   &s3control.JobReport{Enabled: aws.Bool(false)},
+		RoleArn:  aws.String(f.batchRoleARN),
+	})
+	if err != nil {
+		return "", err
+	}
+	return cloudstorage.JobID(aws.StringValue(out.JobId)), nil
+}
+
+// writeManifest writes the csv manifest of the objects under prefix, returning
+// its etag.
+func (f *FS) writeManifest(ctx context.Context, name, prefix string) (string, error) {
+	iter, err := f.Objects(ctx, cloudstorage.NewQuery(prefix))
+	if err != nil {
+		return "", err
+	}
+	defer iter.Close()
+	wc, err := f.NewWriterWithContext(ctx, name, map[string]string{cloudstorage.ContentTypeKey: "text/csv"})
+	if err != nil {
+		return "", err
+	}
+	w := csv.NewWriter(wc)
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			wc.Close()
+			return "", err
+		}
+		if strings.HasPrefix(o.Name(), batchManifestPrefix) {
+			continue
+		}
+		// manifest keys are url encoded
+		w.Write([]string{f.bucket, (&url.URL{Path: o.Name()}).EscapedPath()})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		wc.Close()
+		return "", err
+	}
+	if err := wc.Close(); err != nil {
+		return "", err
+	}
+	obj, err := f.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return obj.ETag(), nil
+}
+
+// BulkJobStatus of an S3 Batch Operations job created by SubmitBulkCopy.
+func (f *FS) BulkJobStatus(ctx context.Context, id cloudstorage.JobID) (*cloudstorage.BulkJob, error) {
+	out, err := s3control.New(f.sess).DescribeJobWithContext(ctx, &s3control.DescribeJobInput{
+		AccountId: aws.String(f.accountID),
+		JobId:     aws.String(string(id)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3control.ErrCodeNotFoundException {
+			return nil, cloudstorage.ErrJobNotFound
+		}
+		return nil, err
+	}
+	desc := out.Job
+	job := &cloudstorage.BulkJob{ID: id}
+	if p := desc.ProgressSummary; p != nil {
+		job.Copied = aws.Int64Value(p.NumberOfTasksSucceeded)
+		job.Failed = aws.Int64Value(p.NumberOfTasksFailed)
+	}
+	switch status := aws.StringValue(desc.Status); status {
+	case s3control.JobStatusComplete:
+		job.Done = true
+	case s3control.JobStatusFailed, s3control.JobStatusCancelled:
+		job.Done = true
+		reason := status
+		if len(desc.FailureReasons) > 0 {
+			reason = aws.StringValue(desc.FailureReasons[0].FailureReason)
+		}
+		job.Err = fmt.Errorf("s3 batch job %s %s: %s", id, status, reason)
+	}
+	if job.Done {
+		f.deleteManifest(ctx, desc.Manifest)
+	}
+	return job, nil
+}
+
+// deleteManifest of a finished job, if it is one SubmitBulkCopy wrote.
+func (f *FS) deleteManifest(ctx context.Context, m *s3control.JobManifest) {
+	if m == nil || m.Location == nil {
+		return
+	}
+	name := strings.TrimPrefix(aws.StringValue(m.Location.ObjectArn), "arn:aws:s3:::"+f.bucket+"/")
+	if !strings.HasPrefix(name, batchManifestPrefix) {
+		return
+	}
+	if err := f.Delete(ctx, name); err != nil && err != cloudstorage.ErrObjectNotFound {
+		gou.Warnf("could not delete s3 batch manifest %s err=%v", name, err)
+	}
+}
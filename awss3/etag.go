@@ -0,0 +1,151 @@
+package awss3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// uploadMetaData is the user metadata of an upload, metadata and the upload
+// part size.
+func uploadMetaData(metadata map[string]string, partSize int64) map[string]*string {
+	md := aws.StringMap(metadata)
+	md[MetaKeyPartSize] = aws.String(strconv.FormatInt(partSize, 10))
+	return md
+}
+
+// uploadPartSize is the part size to upload an object of size bytes with,
+// the store's part size grown as needed to fit in MaxUploadParts.
+func (f *FS) uploadPartSize(size int64) int64 {
+	partSize := s3manager.DefaultUploadPartSize
+	if f.partSize > 0 {
+		partSize = f.partSize
+	}
+	if size/partSize >= int64(s3manager.MaxUploadParts) {
+		partSize = size/int64(s3manager.MaxUploadParts) + 1
+	}
+	return partSize
+}
+
+// newUploader is an uploader of partSize parts, with the store's concurrency.
+// Uploads smaller than a part are a single PUT, and a failed part aborts the
+// multipart upload so no parts are left behind.
+func (f *FS) newUploader(partSize int64) *s3manager.Uploader {
+	return s3manager.NewUploader(f.sess, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		if f.concurrency > 0 {
+			u.Concurrency = f.concurrency
+		}
+	})
+}
+
+// etagMD5 is the md5 of an object from its (cleaned) etag, nil for multipart
+// uploads whose etag isn't an md5 of the object.
+func etagMD5(etag string) []byte {
+	if len(etag) != 2*md5.Size {
+		return nil
+	}
+	sum, err := hex.DecodeString(etag)
+	if err != nil {
+		return nil
+	}
+	return sum
+}
+
+// verifyReader wraps the object body to verify its checksum.
+func verifyReader(res *s3.GetObjectOutput) (io.ReadCloser, error) {
+	md, _ := convertMetaData(res.Metadata)
+	encrypted := isEncrypted(res.ServerSideEncryption, res.SSECustomerAlgorithm)
+	h, expected, ok := expectedChecksum(aws.StringValue(res.ETag), md, encrypted)
+	if !ok {
+		res.Body.Close()
+		return nil, cloudstorage.ErrChecksumUnavailable
+	}
+	return cloudstorage.NewChecksumReader(res.Body, h, expected), nil
+}
+
+// expectedChecksum finds the checksum to verify an object against.  Single
+// part uploads have an ETag that is the md5 of the object.  Multipart uploads
+// have an ETag of md5(md5(part1)+...+md5(partN))-N which we can only recompute
+// if the part size was recorded at upload, otherwise fall back to any
+// checksum in the metadata.
+func expectedChecksum(etag string, md map[string]string, encrypted bool) (hash.Hash, []byte, bool) {
+	etag = cloudstorage.CleanETag(etag)
+	if i := strings.LastIndex(etag, "-"); i > 0 {
+		partSize, err := strconv.ParseInt(md[MetaKeyPartSize], 10, 64)
+		if err == nil && partSize > 0 {
+			if expected, err := hex.DecodeString(etag[:i]); err == nil {
+				return newMultipartHash(partSize), expected, true
+			}
+		}
+	} else if !encrypted {
+		if expected, err := hex.DecodeString(etag); err == nil && len(expected) == md5.Size {
+			return md5.New(), expected, true
+		}
+	}
+	return cloudstorage.ChecksumFromMetaData(md)
+}
+
+// isEncrypted kms and customer key encrypted objects don't have an md5 etag.
+func isEncrypted(sse, sseCustomerAlgorithm *string) bool {
+	return aws.StringValue(sse) == s3.ServerSideEncryptionAwsKms || aws.StringValue(sseCustomerAlgorithm) != ""
+}
+
+// multipartHash computes the digest part of an S3 multipart ETag for a given
+// part size.
+type multipartHash struct {
+	partSize int64
+	part     hash.Hash
+	n        int64
+	sums     []byte
+}
+
+func newMultipartHash(partSize int64) hash.Hash {
+	return &multipartHash{partSize: partSize, part: md5.New()}
+}
+
+func (h *multipartHash) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		chunk := p
+		if room := h.partSize - h.n; int64(len(chunk)) > room {
+			chunk = p[:room]
+		}
+		h.part.Write(chunk)
+		h.n += int64(len(chunk))
+		p = p[len(chunk):]
+		if h.n == h.partSize {
+			h.sums = h.part.Sum(h.sums)
+			h.part.Reset()
+			h.n = 0
+		}
+	}
+	return written, nil
+}
+
+func (h *multipartHash) Sum(b []byte) []byte {
+	sums := append([]byte(nil), h.sums...)
+	if h.n > 0 {
+		sums = h.part.Sum(sums)
+	}
+	sum := md5.Sum(sums)
+	return append(b, sum[:]...)
+}
+
+func (h *multipartHash) Reset() {
+	h.part.Reset()
+	h.n = 0
+	h.sums = nil
+}
+
+func (h *multipartHash) Size() int      { return md5.Size }
+func (h *multipartHash) BlockSize() int { return md5.BlockSize }
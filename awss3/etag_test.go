@@ -0,0 +1,37 @@
+package awss3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestMultipartHash(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+
+	// parts of 8 bytes: [0:8] [8:16] [16:20]
+	sums := make([]byte, 0)
+	for _, part := range [][]byte{data[0:8], data[8:16], data[16:20]} {
+		s := md5.Sum(part)
+		sums = append(sums, s[:]...)
+	}
+	expected := md5.Sum(sums)
+
+	// write in sizes that don't line up with the parts
+	h := newMultipartHash(8)
+	h.Write(data[0:3])
+	h.Write(data[3:13])
+	h.Write(data[13:])
+	assert.Equal(t, hex.EncodeToString(expected[:]), hex.EncodeToString(h.Sum(nil)))
+	// Sum doesn't change state
+	assert.Equal(t, hex.EncodeToString(expected[:]), hex.EncodeToString(h.Sum(nil)))
+
+	// exact multiple of the part size has no empty trailing part
+	h = newMultipartHash(10)
+	h.Write(data)
+	a, b := md5.Sum(data[:10]), md5.Sum(data[10:])
+	expected = md5.Sum(append(a[:], b[:]...))
+	assert.Equal(t, hex.EncodeToString(expected[:]), hex.EncodeToString(h.Sum(nil)))
+}
@@ -0,0 +1,175 @@
+package awss3
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/net/context"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// uploadSession is the persisted state of a resumable upload, the parts
+// uploaded are listed from the multipart upload.
+type uploadSession struct {
+	UploadID string `json:"upload_id"`
+	PartSize int64  `json:"part_size"`
+}
+
+// resumableWriter uploads the bytes written as the parts of a multipart
+// upload, one part at a time, see cloudstorage.Opts.Resume.
+type resumableWriter struct {
+	ctx     context.Context
+	f       *FS
+	name    string
+	payer   *string
+	path    string
+	sess    uploadSession
+	parts   []*s3.CompletedPart
+	resumed int64
+	buf     []byte
+	closed  bool
+}
+
+func (f *FS) newResumableWriter(ctx context.Context, name string, metadata map[string]string, opts cloudstorage.Opts) (io.WriteCloser, error) {
+	w := &resumableWriter{
+		ctx:   ctx,
+		f:     f,
+		name:  name,
+		payer: f.payer(opts.RequestPayer),
+		path:  cloudstorage.UploadSessionPath(f.cachepath, StoreType, f.bucket, name),
+	}
+	ok, err := cloudstorage.LoadUploadSession(w.path, &w.sess)
+	if err != nil {
+		return nil, err
+	}
+	if ok && w.sess.UploadID != "" && w.sess.PartSize > 0 {
+		err := w.listParts()
+		if err == nil {
+			w.resumed = int64(len(w.parts)) * w.sess.PartSize
+			return w, nil
+		}
+		if !strings.Contains(err.Error(), "NoSuchUpload") {
+			return nil, err
+		}
+		w.parts = nil
+	}
+
+	// the part size is recorded, so the etag of the object can be checked.
+	partSize := f.uploadPartSize(0)
+	resp, err := f.client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(f.bucket),
+		Key:                  aws.String(name),
+		ACL:                  acl(opts.PublicRead),
+		ContentType:          contentType(metadata),
+		Metadata:             uploadMetaData(metadata, partSize),
+		RequestPayer:         w.payer,
+		ServerSideEncryption: f.sseAlgorithm,
+		SSEKMSKeyId:          f.sseKMSKeyID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.sess = uploadSession{UploadID: aws.StringValue(resp.UploadId), PartSize: partSize}
+	if err := cloudstorage.SaveUploadSession(w.path, w.sess); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// listParts finds the parts the upload has, the leading run of whole parts
+// is kept and the upload resumes after them.
+func (w *resumableWriter) listParts() error {
+	var parts []*s3.Part
+	err := w.f.client.ListPartsPagesWithContext(w.ctx, &s3.ListPartsInput{
+		Bucket:       aws.String(w.f.bucket),
+		Key:          aws.String(w.name),
+		UploadId:     aws.String(w.sess.UploadID),
+		RequestPayer: w.payer,
+	}, func(page *s3.ListPartsOutput, last bool) bool {
+		parts = append(parts, page.Parts...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.Int64Value(parts[i].PartNumber) < aws.Int64Value(parts[j].PartNumber)
+	})
+	for i, p := range parts {
+		if aws.Int64Value(p.PartNumber) != int64(i+1) || aws.Int64Value(p.Size) != w.sess.PartSize {
+			break
+		}
+		w.parts = append(w.parts, &s3.CompletedPart{ETag: p.ETag, PartNumber: p.PartNumber})
+	}
+	return nil
+}
+
+// Resumed for cloudstorage.WriterResume.
+func (w *resumableWriter) Resumed() int64 {
+	return w.resumed
+}
+
+func (w *resumableWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to a closed resumable upload")
+	}
+	w.buf = append(w.buf, p...)
+	for int64(len(w.buf)) >= w.sess.PartSize {
+		if err := w.uploadPart(w.buf[:w.sess.PartSize]); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[w.sess.PartSize:]...)
+	}
+	return len(p), nil
+}
+
+func (w *resumableWriter) uploadPart(part []byte) error {
+	n := int64(len(w.parts) + 1)
+	resp, err := w.f.client.UploadPartWithContext(w.ctx, &s3.UploadPartInput{
+		Bucket:       aws.String(w.f.bucket),
+		Key:          aws.String(w.name),
+		UploadId:     aws.String(w.sess.UploadID),
+		PartNumber:   aws.Int64(n),
+		Body:         bytes.NewReader(part),
+		RequestPayer: w.payer,
+	})
+	if err != nil {
+		return fmt.Errorf("could not upload part %d of %s err=%v", n, w.name, err)
+	}
+	w.parts = append(w.parts, &s3.CompletedPart{ETag: resp.ETag, PartNumber: aws.Int64(n)})
+	return nil
+}
+
+// Close uploads the last part and completes the upload.  A failed Close
+// leaves the upload to be resumed.
+func (w *resumableWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	// the last part may be short, or empty for an empty object.
+	if len(w.buf) > 0 || len(w.parts) == 0 {
+		if err := w.uploadPart(w.buf); err != nil {
+			return err
+		}
+		w.buf = w.buf[:0]
+	}
+	_, err := w.f.client.CompleteMultipartUploadWithContext(w.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(w.f.bucket),
+		Key:             aws.String(w.name),
+		UploadId:        aws.String(w.sess.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: w.parts},
+		RequestPayer:    w.payer,
+	})
+	if err != nil {
+		return err
+	}
+	w.closed = true
+	cloudstorage.RemoveUploadSession(w.path)
+	return nil
+}
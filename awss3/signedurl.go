@@ -0,0 +1,49 @@
+package awss3
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/net/context"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// DefaultSignedURLExpiry is the expiry of signed urls without one.
+const DefaultSignedURLExpiry = 15 * time.Minute
+
+var _ cloudstorage.StoreSignedURL = (*FS)(nil)
+
+// SignedURL creates a presigned url for object name with the credentials of
+// the store, valid for at most 7 days.  A PUT with a ContentType has to be
+// sent with that Content-Type.
+func (f *FS) SignedURL(ctx context.Context, name string, opts cloudstorage.SignedURLOptions) (string, error) {
+	expiry := opts.Expiry
+	if expiry <= 0 {
+		expiry = DefaultSignedURLExpiry
+	}
+	var req *request.Request
+	switch opts.Method {
+	case "", http.MethodGet:
+		req, _ = f.client.GetObjectRequest(&s3.GetObjectInput{
+			Bucket:       aws.String(f.bucket),
+			Key:          aws.String(name),
+			RequestPayer: f.payer(""),
+		})
+	case http.MethodPut:
+		req, _ = f.client.PutObjectRequest(&s3.PutObjectInput{
+			Bucket:       aws.String(f.bucket),
+			Key:          aws.String(name),
+			ContentType:  contentType(map[string]string{cloudstorage.ContentTypeKey: opts.ContentType}),
+			RequestPayer: f.payer(""),
+		})
+	default:
+		return "", fmt.Errorf("signed url method %q not supported", opts.Method)
+	}
+	req.SetContext(ctx)
+	return req.Presign(expiry)
+}
@@ -0,0 +1,29 @@
+package awss3
+
+import (
+	"fmt"
+
+	"github.com/araddon/gou"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// SpacesConfig is the config of a DigitalOcean Spaces bucket in region (ie
+// "nyc3"), an s3 store of the region's https://<region>.digitaloceanspaces.com
+// endpoint addressing buckets by path.  The access key is a Spaces key, set
+// the TmpDir (and any other Settings) before cloudstorage.NewStore.
+func SpacesConfig(region, bucket, accessKey, secretKey string) *cloudstorage.Config {
+	return &cloudstorage.Config{
+		Type:       StoreType,
+		AuthMethod: AuthAccessKey,
+		Bucket:     bucket,
+		// requests are signed for us-east-1, the endpoint is of the region.
+		Region: "us-east-1",
+		Settings: gou.JsonHelper{
+			ConfKeyAccessKey:        accessKey,
+			ConfKeyAccessSecret:     secretKey,
+			ConfKeyEndpoint:         fmt.Sprintf("https://%s.digitaloceanspaces.com", region),
+			ConfKeyS3ForcePathStyle: true,
+		},
+	}
+}
@@ -1,11 +1,17 @@
 package awss3
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,8 +19,10 @@ import (
 	"github.com/araddon/gou"
 	"github.com/pborman/uuid"
 	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -40,10 +48,34 @@ const (
 	ConfKeyARN = "arn"
 	// ConfKeyDisableSSL config key name of disabling ssl flag
 	ConfKeyDisableSSL = "disable_ssl"
+	// ConfKeyEndpoint config key name of the endpoint of an S3 compatible
+	// store, ie "minio.example.com:9000".  Empty (and no Config.BaseUrl) uses
+	// the AWS endpoints of the region.
+	ConfKeyEndpoint = "endpoint"
+	// ConfKeyS3ForcePathStyle config key name of the flag addressing buckets
+	// by path (http://endpoint/bucket/key) rather than by virtual host, as
+	// MinIO requires.  Config.BaseUrl endpoints always use path style.
+	ConfKeyS3ForcePathStyle = "s3_force_path_style"
+	// ConfKeyRequestPayer config key name of the request payer, "requester"
+	// to accept the charges of requests to requester pays buckets.
+	// ReadOptions.RequestPayer and Opts.RequestPayer override it per call.
+	ConfKeyRequestPayer = "request_payer"
+	// ConfKeySSEAlgorithm config key name of the server side encryption of
+	// written objects, "aws:kms" or "AES256".  Empty uses the bucket's default
+	// encryption, or "aws:kms" if there is a ConfKeySSEKMSKeyID.  The copies
+	// of SubmitBulkCopy jobs always use the bucket default.
+	ConfKeySSEAlgorithm = "sse_algorithm"
+	// ConfKeySSEKMSKeyID config key name of the id (or arn) of the KMS key
+	// objects are written encrypted with by SSE-KMS.
+	ConfKeySSEKMSKeyID = "sse_kms_key_id"
 	// Authentication Source's
 
 	// AuthAccessKey is for using aws access key/secret pairs
 	AuthAccessKey cloudstorage.AuthMethod = "aws_access_key"
+
+	// MetaKeyPartSize metadata key recording the part size objects were
+	// uploaded with, needed to recompute multipart ETags for verification.
+	MetaKeyPartSize = "x-part-size"
 )
 
 var (
@@ -60,6 +92,10 @@ var (
 	ErrNoAccessSecret = fmt.Errorf("no settings.access_secret")
 	// ErrNoAuth error for no findable auth
 	ErrNoAuth = fmt.Errorf("No auth provided")
+
+	_ cloudstorage.ObjectOpenContext = (*object)(nil)
+	_ cloudstorage.ObjectRange       = (*object)(nil)
+	_ cloudstorage.StoreDeleteAll    = (*FS)(nil)
 )
 
 func init() {
@@ -81,9 +117,23 @@ type (
 		ID        string
 		client    *s3.S3
 		sess      *session.Session
-		endpoint  string
 		bucket    string
 		cachepath string
+		// accountID and batchRoleARN of S3 Batch Operations jobs.
+		accountID    string
+		batchRoleARN string
+		// separator of the folders in object names, the delimiter of Folders.
+		separator string
+		// requestPayer of the requests, see ConfKeyRequestPayer.
+		requestPayer string
+		// sseAlgorithm and sseKMSKeyID of the writes, nil for the bucket
+		// default, see ConfKeySSEAlgorithm.
+		sseAlgorithm *string
+		sseKMSKeyID  *string
+		// partSize and concurrency of the multipart uploads, the uploader's
+		// defaults if 0, see cloudstorage.Config.UploadPartSize.
+		partSize    int64
+		concurrency int
 	}
 
 	object struct {
@@ -91,13 +141,22 @@ type (
 		o          *s3.GetObjectOutput
 		cachedcopy *os.File
 
-		name      string    // aka "key" in s3
-		updated   time.Time // LastModifyied in s3
-		metadata  map[string]string
-		bucket    string
-		readonly  bool
-		opened    bool
-		cachepath string
+		name        string    // aka "key" in s3
+		updated     time.Time // LastModifyied in s3
+		etag        string
+		size        int64
+		contentType string
+		metadata    map[string]string
+		bucket      string
+		readonly    bool
+		opened      bool
+		cachepath   string
+		// encrypted with kms or a customer key, so the etag isn't an md5.
+		encrypted bool
+		// ctx of OpenWithContext, of the download and the upload of Sync.
+		ctx context.Context
+		// payer of the open's ReadOptions, of the upload of Sync too.
+		payer string
 
 		infoOnce sync.Once
 		infoErr  error
@@ -115,6 +174,10 @@ func NewClient(conf *cloudstorage.Config) (*s3.S3, *session.Session, error) {
 		WithLogLevel(aws.LogOff).
 		WithSleepDelay(time.Sleep)
 
+	if conf.HTTPClient != nil {
+		awsConf.WithHTTPClient(conf.HTTPClient)
+	}
+
 	if conf.Region != "" {
 		awsConf.WithRegion(conf.Region)
 	} else {
@@ -136,9 +199,14 @@ func NewClient(conf *cloudstorage.Config) (*s3.S3, *session.Session, error) {
 		return nil, nil, ErrNoAuth
 	}
 
-	if conf.BaseUrl != "" {
+	if endpoint := conf.Settings.String(ConfKeyEndpoint); endpoint != "" {
+		awsConf.WithEndpoint(endpoint)
+	} else if conf.BaseUrl != "" {
 		awsConf.WithEndpoint(conf.BaseUrl).WithS3ForcePathStyle(true)
 	}
+	if conf.Settings.Bool(ConfKeyS3ForcePathStyle) {
+		awsConf.WithS3ForcePathStyle(true)
+	}
 
 	disableSSL := conf.Settings.Bool(ConfKeyDisableSSL)
 	if disableSSL {
@@ -166,19 +234,50 @@ func NewStore(c *s3.S3, sess *session.Session, conf *cloudstorage.Config) (*FS,
 		return nil, fmt.Errorf("unable to create cachepath. config.tmpdir=%q err=%v", conf.TmpDir, err)
 	}
 
+	algorithm := conf.Settings.String(ConfKeySSEAlgorithm)
+	kmsKeyID := conf.Settings.String(ConfKeySSEKMSKeyID)
+	if algorithm == "" && kmsKeyID != "" {
+		algorithm = s3.ServerSideEncryptionAwsKms
+	}
+	switch {
+	case algorithm != "" && algorithm != s3.ServerSideEncryptionAwsKms && algorithm != s3.ServerSideEncryptionAes256:
+		return nil, fmt.Errorf("unknown %s=%q", ConfKeySSEAlgorithm, algorithm)
+	case kmsKeyID != "" && algorithm != s3.ServerSideEncryptionAwsKms:
+		return nil, fmt.Errorf("%s needs %s=%q", ConfKeySSEKMSKeyID, ConfKeySSEAlgorithm, s3.ServerSideEncryptionAwsKms)
+	}
+	if conf.UploadPartSize != 0 && conf.UploadPartSize < s3manager.MinUploadPartSize {
+		return nil, fmt.Errorf("UploadPartSize=%d is less than the s3 minimum part size %d", conf.UploadPartSize, s3manager.MinUploadPartSize)
+	}
+
 	uid := uuid.NewUUID().String()
 	uid = strings.Replace(uid, "-", "", -1)
 
 	return &FS{
-		client:    c,
-		sess:      sess,
-		bucket:    conf.Bucket,
-		cachepath: conf.TmpDir,
-		ID:        uid,
-		PageSize:  cloudstorage.MaxResults,
+		client:       c,
+		sess:         sess,
+		bucket:       conf.Bucket,
+		cachepath:    conf.TmpDir,
+		ID:           uid,
+		PageSize:     cloudstorage.MaxResults,
+		accountID:    conf.Settings.String(ConfKeyAccountID),
+		batchRoleARN: conf.Settings.String(ConfKeyBatchRoleARN),
+		separator:    conf.KeySeparator(),
+		requestPayer: conf.Settings.String(ConfKeyRequestPayer),
+		sseAlgorithm: optionalString(algorithm),
+		sseKMSKeyID:  optionalString(kmsKeyID),
+		partSize:     conf.UploadPartSize,
+		concurrency:  conf.UploadConcurrency,
 	}, nil
 }
 
+// optionalString is nil for an empty s, so the request leaves it unset.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
 // Type of store = "s3"
 func (f *FS) Type() string {
 	return StoreType
@@ -189,11 +288,52 @@ func (f *FS) Client() interface{} {
 	return f.client
 }
 
+// ResolveKey is the s3 key of object o, names are used as is.
+func (f *FS) ResolveKey(o string) string {
+	return o
+}
+
+// UpdateMetadata replaces the metadata of object o, and its content type if
+// metadata has a ContentTypeKey, by a server side copy of the object onto
+// itself.  S3 only copies objects up to 5GB.
+func (f *FS) UpdateMetadata(ctx context.Context, o string, metadata map[string]string) error {
+	head, err := f.getObjectMeta(ctx, o)
+	if err != nil {
+		return err
+	}
+	md := make(map[string]*string, len(metadata)+1)
+	for k, v := range metadata {
+		md[k] = aws.String(v)
+	}
+	if ps, ok := head.metadata[MetaKeyPartSize]; ok {
+		// keep the part size the etag is verified with
+		md[MetaKeyPartSize] = aws.String(ps)
+	}
+	_, err = f.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:               aws.String(f.bucket),
+		Key:                  aws.String(o),
+		CopySource:           copySource(f.bucket, o),
+		MetadataDirective:    aws.String(s3.MetadataDirectiveReplace),
+		Metadata:             md,
+		ContentType:          contentType(metadata),
+		RequestPayer:         f.payer(""),
+		ServerSideEncryption: f.sseAlgorithm,
+		SSEKMSKeyId:          f.sseKMSKeyID,
+	})
+	return err
+}
+
 // String function to provide s3://..../file   path
 func (f *FS) String() string {
 	return fmt.Sprintf("s3://%s/", f.bucket)
 }
 
+// KeySeparator for cloudstorage.StoreSeparator, the separator of the folders
+// in object names.
+func (f *FS) KeySeparator() string {
+	return f.separator
+}
+
 // NewObject of Type s3.
 func (f *FS) NewObject(objectname string) (cloudstorage.Object, error) {
 	obj, err := f.Get(context.Background(), objectname)
@@ -216,7 +356,8 @@ func (f *FS) NewObject(objectname string) (cloudstorage.Object, error) {
 }
 
 // Get a single File Object
-func (f *FS) Get(ctx context.Context, objectpath string) (cloudstorage.Object, error) {
+func (f *FS) Get(ctx context.Context, objectpath string) (_ cloudstorage.Object, err error) {
+	defer cloudstorage.RecoverPanic("s3 get", &err)
 
 	obj, err := f.getObjectMeta(ctx, objectpath)
 	if err != nil {
@@ -232,8 +373,9 @@ func (f *FS) Get(ctx context.Context, objectpath string) (cloudstorage.Object, e
 func (f *FS) getObjectMeta(ctx context.Context, objectname string) (*object, error) {
 
 	req := &s3.HeadObjectInput{
-		Key:    aws.String(objectname),
-		Bucket: aws.String(f.bucket),
+		Key:          aws.String(objectname),
+		Bucket:       aws.String(f.bucket),
+		RequestPayer: f.payer(""),
 	}
 
 	res, err := f.client.HeadObjectWithContext(ctx, req)
@@ -248,11 +390,68 @@ func (f *FS) getObjectMeta(ctx context.Context, objectname string) (*object, err
 	return newObjectFromHead(f, objectname, res), nil
 }
 
-func (f *FS) getS3OpenObject(ctx context.Context, objectname string) (*s3.GetObjectOutput, error) {
+// GetInline gets object o with a GET of its first threshold bytes, which are
+// its contents if it is smaller than threshold.  Larger objects are returned
+// without contents, having read at most threshold bytes of them.
+func (f *FS) GetInline(ctx context.Context, o string, threshold int64) (cloudstorage.Object, []byte, error) {
+	if threshold <= 0 {
+		obj, err := f.getObjectMeta(ctx, o)
+		return obj, nil, err
+	}
+	res, err := f.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:          aws.String(o),
+		Bucket:       aws.String(f.bucket),
+		Range:        aws.String(fmt.Sprintf("bytes=0-%d", threshold-1)),
+		RequestPayer: f.payer(""),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchKey") {
+			return nil, nil, cloudstorage.ErrObjectNotFound
+		} else if strings.Contains(err.Error(), "InvalidRange") {
+			// no byte 0, the object is empty.
+			obj, err := f.getObjectMeta(ctx, o)
+			if err != nil {
+				return nil, nil, err
+			}
+			return obj, []byte{}, nil
+		}
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	obj := newObjectFromHead(f, o, &s3.HeadObjectOutput{
+		ETag:                 res.ETag,
+		ContentLength:        res.ContentLength,
+		ContentType:          res.ContentType,
+		LastModified:         res.LastModified,
+		Metadata:             res.Metadata,
+		ServerSideEncryption: res.ServerSideEncryption,
+		SSECustomerAlgorithm: res.SSECustomerAlgorithm,
+	})
+	// the content length is of the range, the size is in the content range,
+	// ie "bytes 0-1023/4096".  Without one the whole object was sent.
+	if cr := aws.StringValue(res.ContentRange); cr != "" {
+		size, err := strconv.ParseInt(cr[strings.LastIndex(cr, "/")+1:], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("s3 invalid content range %q of %s", cr, o)
+		}
+		obj.size = size
+	}
+	if obj.size >= threshold {
+		return obj, nil, nil
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return obj, b, nil
+}
+
+func (f *FS) getS3OpenObject(ctx context.Context, objectname, payer string) (*s3.GetObjectOutput, error) {
 
 	res, err := f.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
-		Key:    aws.String(objectname),
-		Bucket: aws.String(f.bucket),
+		Key:          aws.String(objectname),
+		Bucket:       aws.String(f.bucket),
+		RequestPayer: f.payer(payer),
 	})
 	if err != nil {
 		// translate the string error to typed error
@@ -278,18 +477,27 @@ func convertMetaData(m map[string]*string) (map[string]string, error) {
 }
 
 // List objects from this store.
-func (f *FS) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.ObjectsResponse, error) {
+func (f *FS) List(ctx context.Context, q cloudstorage.Query) (_ *cloudstorage.ObjectsResponse, err error) {
+	defer cloudstorage.RecoverPanic("s3 list", &err)
 
 	itemLimit := int64(f.PageSize)
 	if q.PageSize > 0 {
 		itemLimit = int64(q.PageSize)
 	}
 
+	// markers are the (exclusive) key to list after, so resume from
+	// StartAfter on the first page.
+	marker := q.Marker
+	if marker == "" {
+		marker = q.StartAfter
+	}
+
 	params := &s3.ListObjectsInput{
-		Bucket:  aws.String(f.bucket),
-		Marker:  &q.Marker,
-		MaxKeys: &itemLimit,
-		Prefix:  &q.Prefix,
+		Bucket:       aws.String(f.bucket),
+		Marker:       &marker,
+		MaxKeys:      &itemLimit,
+		Prefix:       &q.Prefix,
+		RequestPayer: f.payer(""),
 	}
 
 	resp, err := f.client.ListObjects(params)
@@ -303,12 +511,22 @@ func (f *FS) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.Obje
 	}
 
 	for i, o := range resp.Contents {
+		if q.IncludeMetadata {
+			// listings don't have the content type or metadata
+			obj, err := f.getObjectMeta(ctx, *o.Key)
+			if err != nil {
+				return nil, err
+			}
+			objResp.Objects[i] = obj
+			continue
+		}
 		objResp.Objects[i] = newObject(f, o)
 	}
 
 	if resp.IsTruncated != nil && *resp.IsTruncated {
 		lastObj := *resp.Contents[len(resp.Contents)-1].Key
 		objResp.NextMarker = lastObj
+		objResp.HasMore = true
 	}
 
 	return objResp, nil
@@ -321,9 +539,10 @@ func (f *FS) Objects(ctx context.Context, q cloudstorage.Query) (cloudstorage.Ob
 }
 
 // Folders get folders list.
-func (f *FS) Folders(ctx context.Context, q cloudstorage.Query) ([]string, error) {
+func (f *FS) Folders(ctx context.Context, q cloudstorage.Query) (_ []string, err error) {
+	defer cloudstorage.RecoverPanic("s3 folders", &err)
 
-	q.Delimiter = "/"
+	q.Delimiter = f.separator
 
 	// Think we should just put 1 here right?
 	itemLimit := int64(f.PageSize)
@@ -332,10 +551,11 @@ func (f *FS) Folders(ctx context.Context, q cloudstorage.Query) ([]string, error
 	}
 
 	params := &s3.ListObjectsInput{
-		Bucket:    aws.String(f.bucket),
-		MaxKeys:   &itemLimit,
-		Prefix:    &q.Prefix,
-		Delimiter: &q.Delimiter,
+		Bucket:       aws.String(f.bucket),
+		MaxKeys:      &itemLimit,
+		Prefix:       &q.Prefix,
+		Delimiter:    &q.Delimiter,
+		RequestPayer: f.payer(""),
 	}
 
 	folders := make([]string, 0)
@@ -361,48 +581,92 @@ func (f *FS) Folders(ctx context.Context, q cloudstorage.Query) ([]string, error
 	}
 }
 
-/*
-// Copy from src to destination
-func (f *FS) Copy(ctx context.Context, src, des cloudstorage.Object) error {
-
-	so, ok := src.(*object)
-	if !ok {
-		return fmt.Errorf("Copy source file expected s3 but got %T", src)
+// ListLevel lists the objects and folders directly under prefix, paging
+// through a delimited listing until it has limit of them.
+func (f *FS) ListLevel(ctx context.Context, prefix string, limit int) (_ cloudstorage.Objects, _ []string, err error) {
+	defer cloudstorage.RecoverPanic("s3 list level", &err)
+	params := &s3.ListObjectsInput{
+		Bucket:       aws.String(f.bucket),
+		MaxKeys:      aws.Int64(int64(f.PageSize)),
+		Prefix:       aws.String(prefix),
+		Delimiter:    aws.String(f.separator),
+		RequestPayer: f.payer(""),
 	}
-	do, ok := des.(*object)
-	if !ok {
-		return fmt.Errorf("Copy destination expected s3 but got %T", des)
+	if limit > 0 && limit < f.PageSize {
+		// MaxKeys counts the common prefixes too.
+		params.MaxKeys = aws.Int64(int64(limit))
 	}
 
-	oh := so.b.Object(so.name)
-	dh := do.b.Object(do.name)
-
-	_, err := dh.CopierFrom(oh).Run(ctx)
-	return err
+	objects := make(cloudstorage.Objects, 0)
+	folders := make([]string, 0)
+	err = f.client.ListObjectsPagesWithContext(ctx, params, func(page *s3.ListObjectsOutput, last bool) bool {
+		for _, o := range page.Contents {
+			objects = append(objects, newObject(f, o))
+		}
+		for _, cp := range page.CommonPrefixes {
+			folders = append(folders, strings.TrimPrefix(*cp.Prefix, `/`))
+		}
+		return limit <= 0 || len(objects)+len(folders) < limit
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	objects, folders = cloudstorage.LimitLevel(objects, folders, limit)
+	return objects, folders, nil
 }
 
-// Move which is a Copy & Delete
-func (f *FS) Move(ctx context.Context, src, des cloudstorage.Object) error {
+// maxCopySize is the largest object S3 copies in a single request.
+const maxCopySize = 5 * 1024 * 1024 * 1024
+
+// Copy from src to destination, server side keeping the metadata.  Objects
+// too large for a single request copy are streamed, see
+// cloudstorage.StreamCopy.  The copy is checked against the md5 of src if it
+// has one.
+func (f *FS) Copy(ctx context.Context, src, des cloudstorage.Object) (err error) {
+	defer cloudstorage.RecoverPanic("s3 copy", &err)
 
 	so, ok := src.(*object)
 	if !ok {
-		return fmt.Errorf("Move source file expected s3 but got %T", src)
+		return fmt.Errorf("Copy source file expected s3 but got %T", src)
 	}
 	do, ok := des.(*object)
 	if !ok {
-		return fmt.Errorf("Move destination expected s3 but got %T", des)
+		return fmt.Errorf("Copy destination expected s3 but got %T", des)
+	}
+	if so.size > maxCopySize {
+		return cloudstorage.StreamCopy(ctx, f, src, des)
 	}
 
-	oh := so.b.Object(so.name)
-	dh := do.b.Object(des.name)
-
-	if _, err := dh.CopierFrom(oh).Run(ctx); err != nil {
+	out, err := f.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:               aws.String(f.bucket),
+		Key:                  aws.String(do.name),
+		CopySource:           copySource(f.bucket, so.name),
+		MetadataDirective:    aws.String(s3.MetadataDirectiveCopy),
+		RequestPayer:         f.payer(""),
+		ServerSideEncryption: f.sseAlgorithm,
+		SSEKMSKeyId:          f.sseKMSKeyID,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchKey") {
+			return cloudstorage.ErrObjectNotFound
+		}
 		return err
 	}
+	// the etags of encrypted copies aren't md5s.
+	encrypted := isEncrypted(out.ServerSideEncryption, out.SSECustomerAlgorithm)
+	if sum := so.MD5(); sum != nil && out.CopyObjectResult != nil && !encrypted {
+		etag := cloudstorage.CleanETag(aws.StringValue(out.CopyObjectResult.ETag))
+		if dsum := etagMD5(etag); dsum != nil && !bytes.Equal(dsum, sum) {
+			return cloudstorage.ErrChecksumMismatch
+		}
+	}
+	return nil
+}
 
-	return oh.Delete(ctx)
+// copySource is the url encoded CopySource of key.
+func copySource(bucket, key string) *string {
+	return aws.String((&url.URL{Path: bucket + "/" + key}).EscapedPath())
 }
-*/
 
 // NewReader create file reader.
 func (f *FS) NewReader(o string) (io.ReadCloser, error) {
@@ -410,10 +674,16 @@ func (f *FS) NewReader(o string) (io.ReadCloser, error) {
 }
 
 // NewReaderWithContext create new File reader with context.
-func (f *FS) NewReaderWithContext(ctx context.Context, objectname string) (io.ReadCloser, error) {
+func (f *FS) NewReaderWithContext(ctx context.Context, objectname string, opts ...cloudstorage.ReadOptions) (_ io.ReadCloser, err error) {
+	defer cloudstorage.RecoverPanic("s3 read", &err)
+	payer := ""
+	if len(opts) > 0 {
+		payer = opts[0].RequestPayer
+	}
 	res, err := f.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
-		Key:    aws.String(objectname),
-		Bucket: aws.String(f.bucket),
+		Key:          aws.String(objectname),
+		Bucket:       aws.String(f.bucket),
+		RequestPayer: f.payer(payer),
 	})
 	if err != nil {
 		// translate the string error to typed error
@@ -422,7 +692,14 @@ func (f *FS) NewReaderWithContext(ctx context.Context, objectname string) (io.Re
 		}
 		return nil, err
 	}
-	return res.Body, nil
+	if len(opts) > 0 && opts[0].VerifyChecksum {
+		rc, err := verifyReader(res)
+		if err != nil {
+			return nil, err
+		}
+		return cloudstorage.MaxBytesReader(rc, opts), nil
+	}
+	return cloudstorage.MaxBytesReader(res.Body, opts), nil
 }
 
 // NewWriter create Object Writer.
@@ -431,39 +708,266 @@ func (f *FS) NewWriter(objectName string, metadata map[string]string) (io.WriteC
 }
 
 // NewWriterWithContext create writer with provided context and metadata.
-func (f *FS) NewWriterWithContext(ctx context.Context, objectName string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
-	if len(opts) > 0 && opts[0].IfNotExists {
-		return nil, fmt.Errorf("options IfNotExists not supported for store type")
+func (f *FS) NewWriterWithContext(ctx context.Context, objectName string, metadata map[string]string, opts ...cloudstorage.Opts) (_ io.WriteCloser, err error) {
+	defer cloudstorage.RecoverPanic("s3 write", &err)
+	if len(opts) > 0 && opts[0].IfGenerationMatch != 0 {
+		return nil, cloudstorage.ErrConditionNotSupported
+	}
+	if resumes, err := cloudstorage.Resumes(metadata, opts); err != nil {
+		return nil, err
+	} else if resumes {
+		return f.newResumableWriter(ctx, objectName, metadata, opts[0])
+	}
+	if cloudstorage.SkipsIfIdentical(opts) {
+		return cloudstorage.NewSkipIfIdenticalWriter(ctx, f, objectName, metadata, opts)
+	}
+	if cloudstorage.VerifiesOnClose(opts) {
+		return cloudstorage.NewVerifyOnCloseWriter(ctx, f, objectName, metadata, opts)
+	}
+	if cloudstorage.DetectsContentType(metadata, opts) {
+		return cloudstorage.NewContentTypeWriter(objectName, metadata, opts, func(md map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+			return f.NewWriterWithContext(ctx, objectName, md, opts...)
+		}), nil
+	}
+
+	if len(opts) > 0 && (opts[0].ContentMD5 != nil || opts[0].IfMatch != "" || opts[0].IfNotExists) {
+		return f.newMD5Writer(ctx, objectName, metadata, opts[0])
+	}
+	payer, publicRead := "", false
+	if len(opts) > 0 {
+		payer, publicRead = opts[0].RequestPayer, opts[0].PublicRead
 	}
 
-	// Create an uploader with the session and default options
-	uploader := s3manager.NewUploader(f.sess)
+	// the size isn't known up front, so the part size isn't grown to fit.
+	partSize := f.uploadPartSize(0)
+	uploader := f.newUploader(partSize)
 
 	pr, pw := io.Pipe()
 	bw := csbufio.NewWriter(pw)
 
-	go func() {
-		// TODO:  this needs to be managed, ie shutdown signals, close, handler err etc.
-
-		// Upload the file to S3.
-		_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
-			Bucket: aws.String(f.bucket),
-			Key:    aws.String(objectName),
-			Body:   pr,
+	g, _ := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		defer func() {
+			if err != nil {
+				// unblock writes to the pipe
+				pr.CloseWithError(err)
+			}
+		}()
+		defer cloudstorage.RecoverPanic("s3 upload", &err)
+		// Upload the file to S3, an empty body still creates an empty object.
+		_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:               aws.String(f.bucket),
+			Key:                  aws.String(objectName),
+			Body:                 pr,
+			ACL:                  acl(publicRead),
+			ContentType:          contentType(metadata),
+			Metadata:             uploadMetaData(metadata, partSize),
+			RequestPayer:         f.payer(payer),
+			ServerSideEncryption: f.sseAlgorithm,
+			SSEKMSKeyId:          f.sseKMSKeyID,
 		})
 		if err != nil {
 			gou.Warnf("could not upload %v", err)
+			return err
 		}
-	}()
+		return nil
+	})
+
+	return &s3WriteCloser{bw, g}, nil
+}
+
+// s3WriteCloser pipes writes to the upload, Close blocks until the upload
+// has completed so the object exists once Close returns, even when empty.
+type s3WriteCloser struct {
+	wc io.WriteCloser
+	g  *errgroup.Group
+}
+
+func (w *s3WriteCloser) Write(p []byte) (int, error) {
+	return w.wc.Write(p)
+}
+
+// Close flushes the buffered bytes and waits for the upload.
+func (w *s3WriteCloser) Close() error {
+	// closing the pipe ends the upload's body.
+	if err := w.wc.Close(); err != nil {
+		return err
+	}
+	return w.g.Wait()
+}
+
+// payer is the RequestPayer of a request, override or else the store's.
+func (f *FS) payer(override string) *string {
+	if override != "" {
+		return aws.String(override)
+	}
+	if f.requestPayer != "" {
+		return aws.String(f.requestPayer)
+	}
+	return nil
+}
+
+// acl is the canned ACL of a write, nil (the bucket's default, private)
+// unless publicRead.
+func acl(publicRead bool) *string {
+	if publicRead {
+		return aws.String(s3.ObjectCannedACLPublicRead)
+	}
+	return nil
+}
+
+// contentType is the MetadataContentType of metadata, nil if it isn't set.
+func contentType(metadata map[string]string) *string {
+	if ctype := cloudstorage.MetadataContentType(metadata); ctype != "" {
+		return aws.String(ctype)
+	}
+	return nil
+}
 
-	return bw, nil
+// maxPutSize is the largest object S3 accepts in a single PUT.
+const maxPutSize = 5 * 1024 * 1024 * 1024
+
+// md5Upload buffers the object to a local file so that it can be sent with
+// its Content-MD5 in a single PUT which S3 verifies, a streamed multipart
+// upload has no md5 of the whole object for S3 to check.  Conditional writes
+// (Opts.IfMatch, IfNotExists) are buffered the same way, as S3 only checks
+// the conditions of a single PUT.
+type md5Upload struct {
+	ctx        context.Context
+	f          *FS
+	name       string
+	metadata   map[string]string
+	contentMD5 []byte
+	opts       cloudstorage.Opts
+	file       *os.File
+}
+
+func (f *FS) newMD5Writer(ctx context.Context, name string, metadata map[string]string, opts cloudstorage.Opts) (io.WriteCloser, error) {
+	file, err := ioutil.TempFile(f.cachepath, "upload")
+	if err != nil {
+		return nil, err
+	}
+	u := &md5Upload{ctx: ctx, f: f, name: name, metadata: metadata, contentMD5: opts.ContentMD5, opts: opts, file: file}
+	if opts.ContentMD5 == nil {
+		return u, nil
+	}
+	// the bytes are checked as they are buffered, so a mismatch is caught
+	// before anything is sent.
+	return cloudstorage.NewChecksumWriter(u, md5.New(), opts.ContentMD5, u.release), nil
+}
+
+func (u *md5Upload) Write(p []byte) (int, error) {
+	return u.file.Write(p)
+}
+
+func (u *md5Upload) release() error {
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+// Close uploads the buffered object.
+func (u *md5Upload) Close() error {
+	defer u.release()
+
+	fi, err := u.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := u.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	conditional := u.opts.IfMatch != "" || u.opts.IfNotExists
+	if fi.Size() > maxPutSize && conditional {
+		return fmt.Errorf("conditional writes larger than %d bytes not supported for store type", maxPutSize)
+	}
+	if fi.Size() > maxPutSize {
+		// too large for a single PUT, the md5 was verified while buffering.
+		partSize := u.f.uploadPartSize(fi.Size())
+		uploader := u.f.newUploader(partSize)
+		_, err = uploader.UploadWithContext(u.ctx, &s3manager.UploadInput{
+			Bucket:               aws.String(u.f.bucket),
+			Key:                  aws.String(u.name),
+			Body:                 u.file,
+			ACL:                  acl(u.opts.PublicRead),
+			ContentType:          contentType(u.metadata),
+			Metadata:             uploadMetaData(u.metadata, partSize),
+			RequestPayer:         u.f.payer(u.opts.RequestPayer),
+			ServerSideEncryption: u.f.sseAlgorithm,
+			SSEKMSKeyId:          u.f.sseKMSKeyID,
+		})
+		return err
+	}
+
+	params := &s3.PutObjectInput{
+		Bucket:               aws.String(u.f.bucket),
+		Key:                  aws.String(u.name),
+		Body:                 u.file,
+		ACL:                  acl(u.opts.PublicRead),
+		ContentType:          contentType(u.metadata),
+		Metadata:             aws.StringMap(u.metadata),
+		RequestPayer:         u.f.payer(u.opts.RequestPayer),
+		ServerSideEncryption: u.f.sseAlgorithm,
+		SSEKMSKeyId:          u.f.sseKMSKeyID,
+	}
+	if u.contentMD5 != nil {
+		params.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(u.contentMD5))
+	}
+	// PutObjectInput doesn't model the conditions, so set them on the request.
+	req, _ := u.f.client.PutObjectRequest(params)
+	req.SetContext(u.ctx)
+	if u.opts.IfMatch != "" {
+		req.HTTPRequest.Header.Set("If-Match", `"`+u.opts.IfMatch+`"`)
+	}
+	if u.opts.IfNotExists {
+		req.HTTPRequest.Header.Set("If-None-Match", "*")
+	}
+	err = req.Send()
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "BadDigest" {
+		return cloudstorage.ErrChecksumMismatch
+	}
+	if rerr, ok := err.(awserr.RequestFailure); ok && conditional &&
+		(rerr.StatusCode() == http.StatusPreconditionFailed || rerr.StatusCode() == http.StatusNotFound ||
+			rerr.StatusCode() == http.StatusConflict) {
+		// a conflict is a concurrent conditional write winning.
+		return cloudstorage.ErrPreconditionFailed
+	}
+	return err
 }
 
 // Delete requested object path string.
-func (f *FS) Delete(ctx context.Context, obj string) error {
+func (f *FS) Delete(ctx context.Context, obj string, opts ...cloudstorage.DeleteOptions) (err error) {
+	defer cloudstorage.RecoverPanic("s3 delete", &err)
+	if err := f.delete(ctx, obj, opts...); err != nil {
+		return err
+	}
+	if len(opts) > 0 && opts[0].WaitConsistent {
+		return cloudstorage.WaitDeleted(ctx, f, obj)
+	}
+	return nil
+}
+
+func (f *FS) delete(ctx context.Context, obj string, opts ...cloudstorage.DeleteOptions) error {
 	params := &s3.DeleteObjectInput{
-		Bucket: aws.String(f.bucket),
-		Key:    aws.String(obj),
+		Bucket:       aws.String(f.bucket),
+		Key:          aws.String(obj),
+		RequestPayer: f.payer(""),
+	}
+
+	if len(opts) > 0 && opts[0].IfGenerationMatch != 0 {
+		return cloudstorage.ErrConditionNotSupported
+	}
+	if len(opts) > 0 && opts[0].IfMatch != "" {
+		// DeleteObjectInput doesn't model If-Match, so set it on the request.
+		req, _ := f.client.DeleteObjectRequest(params)
+		req.SetContext(ctx)
+		req.HTTPRequest.Header.Set("If-Match", `"`+opts[0].IfMatch+`"`)
+		err := req.Send()
+		if rerr, ok := err.(awserr.RequestFailure); ok &&
+			(rerr.StatusCode() == http.StatusPreconditionFailed || rerr.StatusCode() == http.StatusNotFound) {
+			return cloudstorage.ErrPreconditionFailed
+		}
+		return err
 	}
 
 	_, err := f.client.DeleteObjectWithContext(ctx, params)
@@ -473,12 +977,69 @@ func (f *FS) Delete(ctx context.Context, obj string) error {
 	return nil
 }
 
+// maxDeleteObjects is the most keys a DeleteObjects request takes.
+const maxDeleteObjects = 1000
+
+// DeleteAll deletes names with DeleteObjects requests of up to 1000 keys.
+// A failed request fails the names of its batch only, the following batches
+// are still sent.
+func (f *FS) DeleteAll(ctx context.Context, names []string) []error {
+	errs := make([]error, len(names))
+	for start := 0; start < len(names); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(names) {
+			end = len(names)
+		}
+		f.deleteBatch(ctx, names[start:end], errs[start:end])
+	}
+	return errs
+}
+
+// deleteBatch deletes names in one request, setting the error of each name
+// that failed in errs.
+func (f *FS) deleteBatch(ctx context.Context, names []string, errs []error) {
+	if err := ctx.Err(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return
+	}
+	ids := make([]*s3.ObjectIdentifier, len(names))
+	for i, name := range names {
+		ids[i] = &s3.ObjectIdentifier{Key: aws.String(name)}
+	}
+	resp, err := f.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket:       aws.String(f.bucket),
+		Delete:       &s3.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		RequestPayer: f.payer(""),
+	})
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return
+	}
+	// quiet responses only list the keys that failed.
+	failed := make(map[string]error, len(resp.Errors))
+	for _, e := range resp.Errors {
+		failed[aws.StringValue(e.Key)] = awserr.New(aws.StringValue(e.Code), aws.StringValue(e.Message), nil)
+	}
+	for i, name := range names {
+		errs[i] = failed[name]
+	}
+}
+
 func newObject(f *FS, o *s3.Object) *object {
 	obj := &object{
 		fs:        f,
 		name:      *o.Key,
 		bucket:    f.bucket,
 		cachepath: cloudstorage.CachePathObj(f.cachepath, *o.Key, f.ID),
+		etag:      cloudstorage.CleanETag(aws.StringValue(o.ETag)),
+		size:      aws.Int64Value(o.Size),
+		// listings don't have the encryption of the objects, they are
+		// taken to be encrypted as the store writes them.
+		encrypted: isEncrypted(f.sseAlgorithm, nil),
 	}
 	if o.LastModified != nil {
 		obj.updated = *o.LastModified
@@ -487,10 +1048,14 @@ func newObject(f *FS, o *s3.Object) *object {
 }
 func newObjectFromHead(f *FS, name string, o *s3.HeadObjectOutput) *object {
 	obj := &object{
-		fs:        f,
-		name:      name,
-		bucket:    f.bucket,
-		cachepath: cloudstorage.CachePathObj(f.cachepath, name, f.ID),
+		fs:          f,
+		name:        name,
+		bucket:      f.bucket,
+		cachepath:   cloudstorage.CachePathObj(f.cachepath, name, f.ID),
+		etag:        cloudstorage.CleanETag(aws.StringValue(o.ETag)),
+		size:        aws.Int64Value(o.ContentLength),
+		contentType: aws.StringValue(o.ContentType),
+		encrypted:   isEncrypted(o.ServerSideEncryption, o.SSECustomerAlgorithm),
 	}
 	if o.LastModified != nil {
 		obj.updated = *o.LastModified
@@ -512,6 +1077,21 @@ func (o *object) String() string {
 func (o *object) Updated() time.Time {
 	return o.updated
 }
+func (o *object) ETag() string {
+	return o.etag
+}
+func (o *object) Size() int64 {
+	return o.size
+}
+func (o *object) ContentType() string {
+	return o.contentType
+}
+func (o *object) MD5() []byte {
+	if o.encrypted {
+		return nil
+	}
+	return etagMD5(o.etag)
+}
 func (o *object) MetaData() map[string]string {
 	return o.metadata
 }
@@ -523,15 +1103,50 @@ func (o *object) Delete() error {
 	return o.fs.Delete(context.Background(), o.name)
 }
 
-func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
+// Open the object, concurrent ReadOnly opens of the same object share one
+// download, see cloudstorage.SharedDownload.
+func (o *object) Open(accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+// OpenWithContext for cloudstorage.ObjectOpenContext, the download (and the
+// upload of Sync) is aborted once ctx is done.
+func (o *object) OpenWithContext(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	if !accesslevel.Valid() {
+		return nil, cloudstorage.ErrInvalidAccessLevel
+	}
+	if !cloudstorage.SharesDownload(ctx, accesslevel) || o.opened {
+		return o.open(ctx, accesslevel, opts...)
+	}
+	f, shared, err := cloudstorage.SharedDownload(o.cachepath, opts, func() (*os.File, error) {
+		return o.open(ctx, accesslevel, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		o.cachedcopy = f
+		o.readonly = true
+		o.opened = true
+	}
+	return cloudstorage.VerifyOpened(o, f, opts)
+}
+
+func (o *object) open(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (_ *os.File, err error) {
+	defer cloudstorage.RecoverPanic("s3 open", &err)
 	if o.opened {
 		return nil, fmt.Errorf("the store object is already opened. %s", o.name)
 	}
+	o.ctx = ctx
 
 	var errs []error = make([]error, 0)
 	var cachedcopy *os.File = nil
-	var err error
 	var readonly = accesslevel == cloudstorage.ReadOnly
+	var payer string
+	if len(opts) > 0 {
+		payer = opts[0].RequestPayer
+	}
+	o.payer = payer
 
 	err = os.MkdirAll(path.Dir(o.cachepath), 0775)
 	if err != nil {
@@ -549,8 +1164,24 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 	}
 
 	for try := 0; try < Retries; try++ {
-		if o.o == nil {
-			obj, err := o.fs.getS3OpenObject(context.Background(), o.name)
+		if err := ctx.Err(); err != nil {
+			return nil, o.abortOpen(cachedcopy, err)
+		}
+		ranged := false
+		if readonly && len(opts) > 0 && opts[0].DownloadConcurrency > 1 {
+			ranged, err = o.downloadRanges(ctx, cachedcopy, opts[0])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error downloading ranges err=%v", err))
+				if err := cachedcopy.Truncate(0); err != nil {
+					return nil, fmt.Errorf("error resetting the cachedcopy err=%v", err) //don't retry on local fs errors
+				}
+				cloudstorage.Backoff(try)
+				continue
+			}
+		}
+
+		if o.o == nil && !ranged {
+			obj, err := o.fs.getS3OpenObject(ctx, o.name, payer)
 			if err != nil {
 				if err == cloudstorage.ErrObjectNotFound {
 					// New, this is fine
@@ -612,10 +1243,97 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 		o.opened = true
 		return o.cachedcopy, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, o.abortOpen(cachedcopy, err)
+	}
 
 	return nil, fmt.Errorf("fetch error retry cnt reached: obj=%s tfile=%v errs:[%v]", o.name, o.cachepath, errs)
 }
 
+// abortOpen removes the partial cachedcopy of an open cancelled by err.
+func (o *object) abortOpen(cachedcopy *os.File, err error) error {
+	cachedcopy.Close()
+	os.Remove(o.cachepath)
+	return err
+}
+
+// context of the open, Background unless opened by OpenWithContext.
+func (o *object) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// downloadRanges fetches the object into cachedcopy as parallel ranges if it
+// is large enough, returning false if it should be read as a single stream.
+func (o *object) downloadRanges(ctx context.Context, cachedcopy *os.File, opts cloudstorage.ReadOptions) (bool, error) {
+	head, err := o.fs.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Key:          aws.String(o.name),
+		Bucket:       aws.String(o.fs.bucket),
+		RequestPayer: o.fs.payer(opts.RequestPayer),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "Not Found") {
+			// New, this is fine
+			return false, nil
+		}
+		return false, err
+	}
+	size := aws.Int64Value(head.ContentLength)
+	if !opts.ParallelDownload(size) {
+		return false, nil
+	}
+
+	// pin the ranges to the version we did the HEAD of
+	etag := aws.StringValue(head.ETag)
+	err = cloudstorage.DownloadRanges(ctx, cachedcopy, size, opts,
+		func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+			res, err := o.fs.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+				Key:          aws.String(o.name),
+				Bucket:       aws.String(o.fs.bucket),
+				Range:        aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+				IfMatch:      aws.String(etag),
+				RequestPayer: o.fs.payer(opts.RequestPayer),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return res.Body, nil
+		})
+	if err != nil {
+		return true, err
+	}
+
+	md, _ := convertMetaData(head.Metadata)
+	encrypted := isEncrypted(head.ServerSideEncryption, head.SSECustomerAlgorithm)
+	if h, expected, ok := expectedChecksum(etag, md, encrypted); ok {
+		return true, cloudstorage.VerifyFile(cachedcopy, h, expected)
+	}
+	return true, nil
+}
+
+// OpenRange for cloudstorage.ObjectRange, a ranged GET of the object.
+func (o *object) OpenRange(ctx context.Context, start, length int64) (_ io.ReadCloser, err error) {
+	defer cloudstorage.RecoverPanic("s3 read", &err)
+	res, err := o.fs.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:          aws.String(o.name),
+		Bucket:       aws.String(o.fs.bucket),
+		Range:        aws.String(cloudstorage.RangeHeader(start, length)),
+		RequestPayer: o.fs.payer(""),
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchKey") {
+			return nil, cloudstorage.ErrObjectNotFound
+		}
+		if strings.Contains(err.Error(), "InvalidRange") {
+			return nil, cloudstorage.ErrRangeNotSatisfiable
+		}
+		return nil, err
+	}
+	return res.Body, nil
+}
+
 // File get the current file handle for cached copy.
 func (o *object) File() *os.File {
 	return o.cachedcopy
@@ -628,6 +1346,9 @@ func (o *object) Read(p []byte) (n int, err error) {
 
 // Write bytes to local file, will be synced on close/sync.
 func (o *object) Write(p []byte) (n int, err error) {
+	if o.opened && o.readonly {
+		return 0, cloudstorage.ErrReadOnly
+	}
 	if o.cachedcopy == nil {
 		_, err := o.Open(cloudstorage.ReadWrite)
 		if err != nil {
@@ -638,13 +1359,14 @@ func (o *object) Write(p []byte) (n int, err error) {
 }
 
 // Sync syncs any changes in file up to s3.
-func (o *object) Sync() error {
+func (o *object) Sync() (err error) {
+	defer cloudstorage.RecoverPanic("s3 sync", &err)
 
 	if !o.opened {
 		return fmt.Errorf("object isn't opened object:%s", o.name)
 	}
 	if o.readonly {
-		return fmt.Errorf("trying to Sync a readonly object:%s", o.name)
+		return cloudstorage.ErrReadOnly
 	}
 
 	cachedcopy, err := os.OpenFile(o.cachepath, os.O_RDWR, 0664)
@@ -653,18 +1375,28 @@ func (o *object) Sync() error {
 	}
 	defer cachedcopy.Close()
 
-	// Create an uploader with the session and default options
-	uploader := s3manager.NewUploader(o.fs.sess)
+	// The uploader grows the part size for very large files, so fix it
+	// up front to be able to record it.
+	partSize := o.fs.uploadPartSize(0)
+	if fi, err := cachedcopy.Stat(); err == nil {
+		partSize = o.fs.uploadPartSize(fi.Size())
+	}
+	uploader := o.fs.newUploader(partSize)
 
 	if _, err := cachedcopy.Seek(0, os.SEEK_SET); err != nil {
 		return fmt.Errorf("error seeking to start of cachedcopy err=%v", err) //don't retry on local filesystem errors
 	}
 
 	// Upload the file to S3.
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(o.fs.bucket),
-		Key:    aws.String(o.name),
-		Body:   cachedcopy,
+	_, err = uploader.UploadWithContext(o.context(), &s3manager.UploadInput{
+		Bucket:               aws.String(o.fs.bucket),
+		Key:                  aws.String(o.name),
+		Body:                 cachedcopy,
+		ContentType:          contentType(o.metadata),
+		Metadata:             uploadMetaData(o.metadata, partSize),
+		RequestPayer:         o.fs.payer(o.payer),
+		ServerSideEncryption: o.fs.sseAlgorithm,
+		SSEKMSKeyId:          o.fs.sseKMSKeyID,
 	})
 	if err != nil {
 		gou.Warnf("could not upload %v", err)
@@ -1,10 +1,19 @@
 package awss3_test
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/araddon/gou"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/bmizerany/assert"
 
 	"github.com/lytics/cloudstorage"
@@ -105,3 +114,617 @@ func TestAll(t *testing.T) {
 	}
 	testutils.RunTests(t, store, config)
 }
+
+// panicTransport panics on every request, as a buggy proxy or sdk could.
+type panicTransport struct{}
+
+func (panicTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	panic("malformed response")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     "bucket",
+		TmpDir:     "/tmp/localcache/aws_panic",
+		HTTPClient: &http.Client{Transport: panicTransport{}},
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+
+	_, err = store.Get(context.Background(), "a.csv")
+	assert.NotEqual(t, nil, err)
+	_, err = store.List(context.Background(), cloudstorage.NewQuery(""))
+	assert.NotEqual(t, nil, err)
+	assert.NotEqual(t, nil, store.Delete(context.Background(), "a.csv"))
+}
+
+// payerTransport records the request payer of each request, failing it.
+type payerTransport struct {
+	payers []string
+}
+
+func (p *payerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.payers = append(p.payers, req.Header.Get("x-amz-request-payer"))
+	return nil, fmt.Errorf("offline")
+}
+
+func TestRequestPayer(t *testing.T) {
+	transport := &payerTransport{}
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     "bucket",
+		TmpDir:     "/tmp/localcache/aws_payer",
+		HTTPClient: &http.Client{Transport: transport},
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+
+	_, err = store.NewReaderWithContext(context.Background(), "a.csv")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, "", transport.payers[len(transport.payers)-1])
+
+	// the read of a requester pays bucket overrides the store default.
+	_, err = store.NewReaderWithContext(context.Background(), "a.csv", cloudstorage.ReadOptions{RequestPayer: "requester"})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, "requester", transport.payers[len(transport.payers)-1])
+}
+
+// sseTransport records the encryption headers of each request, failing it.
+type sseTransport struct {
+	algorithms []string
+	keys       []string
+}
+
+func (s *sseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.algorithms = append(s.algorithms, req.Header.Get("x-amz-server-side-encryption"))
+	s.keys = append(s.keys, req.Header.Get("x-amz-server-side-encryption-aws-kms-key-id"))
+	return nil, fmt.Errorf("offline")
+}
+
+func TestServerSideEncryption(t *testing.T) {
+	transport := &sseTransport{}
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     "bucket",
+		TmpDir:     "/tmp/localcache/aws_sse",
+		HTTPClient: &http.Client{Transport: transport},
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+			awss3.ConfKeySSEKMSKeyID:  "alias/compliance",
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+
+	wc, err := store.NewWriterWithContext(context.Background(), "a.csv", nil)
+	assert.Equal(t, nil, err)
+	wc.Write([]byte("a,b,c\n"))
+	assert.NotEqual(t, nil, wc.Close())
+	// the kms key id defaults the algorithm to aws:kms.
+	assert.Equal(t, "aws:kms", transport.algorithms[len(transport.algorithms)-1])
+	assert.Equal(t, "alias/compliance", transport.keys[len(transport.keys)-1])
+
+	conf.Settings[awss3.ConfKeySSEAlgorithm] = "AES256"
+	_, err = cloudstorage.NewStore(conf)
+	assert.NotEqual(t, nil, err)
+}
+
+type queryTransport struct {
+	queries []string
+}
+
+func (q *queryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	q.queries = append(q.queries, req.URL.RawQuery)
+	return nil, fmt.Errorf("offline")
+}
+
+func TestUploadPartSize(t *testing.T) {
+	transport := &queryTransport{}
+	conf := &cloudstorage.Config{
+		Type:              awss3.StoreType,
+		AuthMethod:        awss3.AuthAccessKey,
+		Bucket:            "bucket",
+		TmpDir:            "/tmp/localcache/aws_parts",
+		HTTPClient:        &http.Client{Transport: transport},
+		UploadPartSize:    1024,
+		UploadConcurrency: 2,
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+		},
+	}
+	// parts are at least 5MiB.
+	_, err := cloudstorage.NewStore(conf)
+	assert.NotEqual(t, nil, err)
+
+	conf.UploadPartSize = 6 * 1024 * 1024
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+
+	// writes smaller than a part are a single PUT, larger ones multipart.
+	multipart := func(size int64) bool {
+		transport.queries = nil
+		wc, err := store.NewWriterWithContext(context.Background(), "a.csv", nil)
+		assert.Equal(t, nil, err)
+		wc.Write(make([]byte, size))
+		assert.NotEqual(t, nil, wc.Close())
+		for _, q := range transport.queries {
+			if strings.Contains(q, "uploads") {
+				return true
+			}
+		}
+		return false
+	}
+	assert.Equal(t, false, multipart(conf.UploadPartSize-1))
+	assert.Equal(t, true, multipart(conf.UploadPartSize+1))
+}
+
+func TestResumableUpload(t *testing.T) {
+	if os.Getenv("AWS_BUCKET") == "" || os.Getenv("AWS_SECRET_KEY") == "" || os.Getenv("AWS_ACCESS_KEY") == "" {
+		t.Logf("No aws credentials, skipping")
+		t.Skip()
+		return
+	}
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     os.Getenv("AWS_BUCKET"),
+		TmpDir:     "/tmp/localcache/aws_resume",
+		Region:     "us-east-1",
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    os.Getenv("AWS_ACCESS_KEY"),
+			awss3.ConfKeyAccessSecret: os.Getenv("AWS_SECRET_KEY"),
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	ctx := context.Background()
+	defer store.Delete(ctx, "resume/a.csv")
+
+	// two whole parts and a short one, the first writer dies after a part.
+	part := 5 * 1024 * 1024
+	body := strings.Repeat("a,b,c\n", (2*part+100)/6)
+	wc, err := store.NewWriterWithContext(ctx, "resume/a.csv", nil, cloudstorage.Opts{Resume: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), cloudstorage.Resumed(wc))
+	_, err = wc.Write([]byte(body[:part+10]))
+	assert.Equal(t, nil, err)
+
+	wc, err = store.NewWriterWithContext(ctx, "resume/a.csv", nil, cloudstorage.Opts{Resume: true})
+	assert.Equal(t, nil, err)
+	n := cloudstorage.Resumed(wc)
+	assert.Equal(t, int64(part), n)
+	_, err = wc.Write([]byte(body[n:]))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, wc.Close())
+
+	rc, err := store.NewReaderWithContext(ctx, "resume/a.csv", cloudstorage.ReadOptions{VerifyChecksum: true})
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, body, string(b))
+}
+
+func TestServerSideEncryptionKMS(t *testing.T) {
+	key := os.Getenv("AWS_KMS_KEY_ID")
+	if os.Getenv("AWS_BUCKET") == "" || os.Getenv("AWS_SECRET_KEY") == "" || os.Getenv("AWS_ACCESS_KEY") == "" || key == "" {
+		t.Logf("No aws credentials or AWS_KMS_KEY_ID, skipping")
+		t.Skip()
+		return
+	}
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     os.Getenv("AWS_BUCKET"),
+		TmpDir:     "/tmp/localcache/aws_kms",
+		Region:     "us-east-1",
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    os.Getenv("AWS_ACCESS_KEY"),
+			awss3.ConfKeyAccessSecret: os.Getenv("AWS_SECRET_KEY"),
+			awss3.ConfKeySSEKMSKeyID:  key,
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	ctx := context.Background()
+	defer store.Delete(ctx, "kms/a.csv")
+	defer store.Delete(ctx, "kms/b.csv")
+
+	wc, err := store.NewWriterWithContext(ctx, "kms/a.csv", nil)
+	assert.Equal(t, nil, err)
+	wc.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, wc.Close())
+	assert.Equal(t, nil, cloudstorage.CopyByName(ctx, store, "kms/a.csv", "kms/b.csv"))
+
+	client := store.Client().(*s3.S3)
+	for _, name := range []string{"kms/a.csv", "kms/b.csv"} {
+		head, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(conf.Bucket),
+			Key:    aws.String(name),
+		})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "aws:kms", aws.StringValue(head.ServerSideEncryption))
+		// the key id of the object is the arn of the key.
+		assert.NotEqual(t, "", aws.StringValue(head.SSEKMSKeyId))
+
+		// the etags of kms encrypted objects aren't md5s.
+		obj, err := store.Get(ctx, name)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 0, len(obj.MD5()))
+	}
+	resp, err := store.List(ctx, cloudstorage.NewQuery("kms/"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(resp.Objects))
+	for _, o := range resp.Objects {
+		assert.Equal(t, 0, len(o.MD5()))
+	}
+}
+
+// headTransport answers each request with the headers of a HEAD of a
+// single part object.
+type headTransport struct {
+	header http.Header
+}
+
+func (h *headTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     h.header,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// rangeTransport answers GETs of body with the range requested, recording
+// the ranges.
+type rangeTransport struct {
+	body   string
+	ranges []string
+}
+
+func (r *rangeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rng := req.Header.Get("Range")
+	r.ranges = append(r.ranges, rng)
+	var start, end int
+	fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+	if end >= len(r.body) {
+		end = len(r.body) - 1
+	}
+	part := r.body[start : end+1]
+	return &http.Response{
+		StatusCode: http.StatusPartialContent,
+		Header: http.Header{
+			"Content-Length": []string{fmt.Sprint(len(part))},
+			"Content-Range":  []string{fmt.Sprintf("bytes %d-%d/%d", start, end, len(r.body))},
+		},
+		Body:    ioutil.NopCloser(strings.NewReader(part)),
+		Request: req,
+	}, nil
+}
+
+func TestGetInline(t *testing.T) {
+	transport := &rangeTransport{body: "0123456789abcdef"}
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     "bucket",
+		TmpDir:     "/tmp/localcache/aws_inline",
+		HTTPClient: &http.Client{Transport: transport},
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	gi := store.(cloudstorage.StoreGetInline)
+
+	// over the threshold only the first threshold bytes are fetched.
+	obj, b, err := gi.GetInline(context.Background(), "a.csv", 4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "bytes=0-3", transport.ranges[len(transport.ranges)-1])
+	assert.Equal(t, int64(16), obj.Size())
+	assert.Equal(t, 0, len(b))
+
+	obj, b, err = gi.GetInline(context.Background(), "a.csv", 1024)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "bytes=0-1023", transport.ranges[len(transport.ranges)-1])
+	assert.Equal(t, int64(16), obj.Size())
+	assert.Equal(t, "0123456789abcdef", string(b))
+}
+
+func TestEncryptedMD5(t *testing.T) {
+	transport := &headTransport{header: http.Header{
+		"Etag":           []string{`"0bee89b07a248e27c83fc3d5951213c1"`},
+		"Content-Length": []string{"6"},
+	}}
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     "bucket",
+		TmpDir:     "/tmp/localcache/aws_md5",
+		HTTPClient: &http.Client{Transport: transport},
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+
+	obj, err := store.Get(context.Background(), "a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "0bee89b07a248e27c83fc3d5951213c1", fmt.Sprintf("%x", obj.MD5()))
+
+	for h, v := range map[string]string{
+		"x-amz-server-side-encryption":                    "aws:kms",
+		"x-amz-server-side-encryption-customer-algorithm": "AES256",
+	} {
+		transport.header = http.Header{
+			"Etag":           []string{`"0bee89b07a248e27c83fc3d5951213c1"`},
+			"Content-Length": []string{"6"},
+		}
+		transport.header.Set(h, v)
+		obj, err := store.Get(context.Background(), "a.csv")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 0, len(obj.MD5()), "encrypted by %s", h)
+	}
+}
+
+// payerHeadTransport answers as headTransport, recording the request payer
+// of each request.
+type payerHeadTransport struct {
+	headTransport
+	payers []string
+}
+
+func (p *payerHeadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.payers = append(p.payers, req.Header.Get("x-amz-request-payer"))
+	return p.headTransport.RoundTrip(req)
+}
+
+func TestOpenRequestPayer(t *testing.T) {
+	transport := &payerHeadTransport{headTransport: headTransport{header: http.Header{
+		"Content-Length": []string{"0"},
+	}}}
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     "bucket",
+		TmpDir:     "/tmp/localcache/aws_open_payer",
+		HTTPClient: &http.Client{Transport: transport},
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+
+	// the GET of an open, and the HEAD of a ranged download, are billed to
+	// the payer of the read options.
+	for _, opts := range []cloudstorage.ReadOptions{
+		{RequestPayer: "requester"},
+		{RequestPayer: "requester", DownloadConcurrency: 4},
+	} {
+		obj, err := store.Get(context.Background(), "a.csv")
+		assert.Equal(t, nil, err)
+		transport.payers = nil
+		_, err = obj.Open(cloudstorage.ReadOnly, opts)
+		assert.Equal(t, nil, err)
+		assert.NotEqual(t, 0, len(transport.payers))
+		for _, payer := range transport.payers {
+			assert.Equal(t, "requester", payer)
+		}
+		obj.Release()
+	}
+}
+
+// missingTransport answers as if the bucket is empty, recording the content
+// type of each PUT.
+type missingTransport struct {
+	types []string
+}
+
+func (m *missingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+	switch req.Method {
+	case http.MethodGet:
+		res.Body = ioutil.NopCloser(strings.NewReader("<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>"))
+	case http.MethodPut:
+		m.types = append(m.types, req.Header.Get("Content-Type"))
+		res.StatusCode = http.StatusOK
+	}
+	return res, nil
+}
+
+func TestContentType(t *testing.T) {
+	transport := &missingTransport{}
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     "bucket",
+		TmpDir:     "/tmp/localcache/aws_content_type",
+		HTTPClient: &http.Client{Transport: transport},
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+
+	// the content type of the metadata of a writer.
+	w, err := store.NewWriter("a.csv", map[string]string{cloudstorage.ContentTypeHeader: "application/gzip"})
+	assert.Equal(t, nil, err)
+	w.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, w.Close())
+	assert.Equal(t, []string{"application/gzip"}, transport.types)
+
+	// and of a new object opened and closed, from its name.
+	transport.types = nil
+	obj, err := store.NewObject("b.csv")
+	assert.Equal(t, nil, err)
+	f, err := obj.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, nil, err)
+	f.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, obj.Close())
+	assert.Equal(t, []string{"text/csv"}, transport.types)
+}
+
+func TestStoreRequestPayer(t *testing.T) {
+	transport := &payerHeadTransport{headTransport: headTransport{header: http.Header{
+		"Content-Length": []string{"0"},
+	}}}
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     "bucket",
+		TmpDir:     "/tmp/localcache/aws_store_payer",
+		HTTPClient: &http.Client{Transport: transport},
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+			awss3.ConfKeyRequestPayer: "requester",
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	ctx := context.Background()
+
+	// the uploads of Sync, the copies and the deletes are all billed to the
+	// store's payer.
+	obj, err := store.Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	dest, err := store.Get(ctx, "b.csv")
+	assert.Equal(t, nil, err)
+	_, err = obj.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, nil, err)
+	transport.payers = nil
+	obj.Sync()
+	obj.Release()
+	cloudstorage.Copy(ctx, store, obj, dest)
+	cloudstorage.UpdateMetadata(ctx, store, "a.csv", map[string]string{"a": "b"})
+	store.Delete(ctx, "a.csv")
+	cloudstorage.DeleteAll(ctx, store, []string{"a.csv", "b.csv"})
+	assert.NotEqual(t, 0, len(transport.payers))
+	for _, payer := range transport.payers {
+		assert.Equal(t, "requester", payer)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+		},
+	}
+	// no endpoint resolves to the aws endpoints.
+	_, sess, err := awss3.NewClient(conf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", aws.StringValue(sess.Config.Endpoint))
+	assert.Equal(t, false, aws.BoolValue(sess.Config.S3ForcePathStyle))
+
+	conf.Settings[awss3.ConfKeyEndpoint] = "minio.example.com:9000"
+	conf.Settings[awss3.ConfKeyDisableSSL] = true
+	conf.Settings[awss3.ConfKeyS3ForcePathStyle] = true
+	_, sess, err = awss3.NewClient(conf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "minio.example.com:9000", aws.StringValue(sess.Config.Endpoint))
+	assert.Equal(t, true, aws.BoolValue(sess.Config.DisableSSL))
+	assert.Equal(t, true, aws.BoolValue(sess.Config.S3ForcePathStyle))
+}
+
+func TestSignedURL(t *testing.T) {
+	conf := &cloudstorage.Config{
+		Type:       awss3.StoreType,
+		AuthMethod: awss3.AuthAccessKey,
+		Bucket:     "bucket",
+		TmpDir:     "/tmp/localcache/aws_signedurl",
+		Settings: gou.JsonHelper{
+			awss3.ConfKeyAccessKey:    "key",
+			awss3.ConfKeyAccessSecret: "secret",
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+
+	u, err := cloudstorage.SignedURL(context.Background(), store, "reports/a.csv", cloudstorage.SignedURLOptions{Expiry: time.Hour})
+	assert.Equal(t, nil, err)
+	parsed, err := url.Parse(u)
+	assert.Equal(t, nil, err)
+	// the bucket is the virtual host.
+	assert.Equal(t, "bucket.s3.amazonaws.com", parsed.Host)
+	assert.Equal(t, "/reports/a.csv", parsed.Path)
+	assert.Equal(t, "3600", parsed.Query().Get("X-Amz-Expires"))
+	assert.NotEqual(t, "", parsed.Query().Get("X-Amz-Signature"))
+
+	_, err = cloudstorage.SignedURL(context.Background(), store, "a.csv", cloudstorage.SignedURLOptions{Method: "DELETE"})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestSpaces(t *testing.T) {
+	conf := awss3.SpacesConfig("nyc3", "bucket", "key", "secret")
+	conf.TmpDir = "/tmp/localcache/spaces"
+	_, sess, err := awss3.NewClient(conf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "https://nyc3.digitaloceanspaces.com", aws.StringValue(sess.Config.Endpoint))
+	assert.Equal(t, true, aws.BoolValue(sess.Config.S3ForcePathStyle))
+
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	u, err := cloudstorage.SignedURL(context.Background(), store, "a.csv", cloudstorage.SignedURLOptions{})
+	assert.Equal(t, nil, err)
+	parsed, err := url.Parse(u)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "nyc3.digitaloceanspaces.com", parsed.Host)
+	assert.Equal(t, "/bucket/a.csv", parsed.Path)
+}
+
+/*
+
+# to use spaces tests ensure you have exported
+
+export SPACES_KEY="aaa"
+export SPACES_SECRET="bbb"
+export SPACES_REGION="nyc3"
+export SPACES_BUCKET="bucket"
+
+*/
+
+func TestSpacesAll(t *testing.T) {
+	if os.Getenv("SPACES_KEY") == "" || os.Getenv("SPACES_SECRET") == "" {
+		t.Logf("No spaces credentials, skipping")
+		t.Skip()
+		return
+	}
+	conf := awss3.SpacesConfig(os.Getenv("SPACES_REGION"), os.Getenv("SPACES_BUCKET"), os.Getenv("SPACES_KEY"), os.Getenv("SPACES_SECRET"))
+	conf.TmpDir = "/tmp/localcache/spaces"
+	store, err := cloudstorage.NewStore(conf)
+	if err != nil {
+		t.Fatalf("Could not create spaces store %v", err)
+	}
+	testutils.RunTests(t, store, conf)
+}
@@ -0,0 +1,46 @@
+package azure
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	az "github.com/Azure/azure-sdk-for-go/storage"
+	"golang.org/x/net/context"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// DefaultSignedURLExpiry is the expiry of signed urls without one.
+const DefaultSignedURLExpiry = 15 * time.Minute
+
+var _ cloudstorage.StoreSignedURL = (*FS)(nil)
+
+// SignedURL creates a service SAS url for blob name with the account key of
+// the store.  GET urls can read the blob, PUT urls create or overwrite it
+// (the PUT has to be sent with an x-ms-blob-type: BlockBlob header).  The
+// ContentType of opts isn't enforced, SAS urls can't require one.
+func (f *FS) SignedURL(ctx context.Context, name string, opts cloudstorage.SignedURLOptions) (string, error) {
+	expiry := opts.Expiry
+	if expiry <= 0 {
+		expiry = DefaultSignedURLExpiry
+	}
+	var perms az.BlobServiceSASPermissions
+	switch opts.Method {
+	case "", http.MethodGet:
+		perms.Read = true
+	case http.MethodPut:
+		perms.Create, perms.Write = true, true
+	default:
+		return "", fmt.Errorf("signed url method %q not supported", opts.Method)
+	}
+	blob := f.blob(name)
+	return blob.GetSASURI(az.BlobSASOptions{
+		BlobServiceSASPermissions: perms,
+		SASOptions: az.SASOptions{
+			APIVersion: tierAPIVersion,
+			Expiry:     time.Now().Add(expiry),
+			UseHTTPS:   true,
+		},
+	})
+}
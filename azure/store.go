@@ -2,10 +2,13 @@ package azure
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/md5"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"path"
 	"strings"
@@ -29,11 +32,26 @@ const (
 
 	// ConfKeyAuthKey config key name of the azure api key for auth
 	ConfKeyAuthKey = "azure_key"
+	// ConfKeyEndpoint config key name of the blob endpoint of an AuthKey
+	// store, ie https://<project>.blob.core.chinacloudapi.cn or the
+	// http://127.0.0.1:10000/devstoreaccount1 of the Azurite emulator.
+	// Defaults to https://<project>.blob.core.windows.net.
+	ConfKeyEndpoint = "azure_endpoint"
+	// ConfKeyConnectionString config key name of the storage account
+	// connection string of an AuthConnectionString store.
+	ConfKeyConnectionString = "azure_connection_string"
 
 	// Authentication Source's
 
 	// AuthKey is for using azure api key
 	AuthKey cloudstorage.AuthMethod = "azure_key"
+	// AuthConnectionString is for using a connection string, ie
+	// "UseDevelopmentStorage=true" for the emulator.
+	AuthConnectionString cloudstorage.AuthMethod = "azure_connection_string"
+
+	// emulatorHost of the blob service of the emulator, the sdk addresses it
+	// by the emulator's account name.
+	emulatorHost = "127.0.0.1:10000"
 )
 
 var (
@@ -48,6 +66,14 @@ var (
 	ErrNoAccessKey = fmt.Errorf("no settings.azure_key")
 	// ErrNoAuth error for no findable auth
 	ErrNoAuth = fmt.Errorf("No auth provided")
+	// ErrNoConnectionString error for no azure_connection_string
+	ErrNoConnectionString = fmt.Errorf("no settings.azure_connection_string")
+	// ErrContainerPrivate an Opts.PublicRead write to a container without
+	// public blob access, azure has no per blob ACL.
+	ErrContainerPrivate = fmt.Errorf("azure container doesn't allow public read access")
+
+	_ cloudstorage.ObjectOpenContext = (*object)(nil)
+	_ cloudstorage.ObjectRange       = (*object)(nil)
 )
 
 func init() {
@@ -72,6 +98,8 @@ type (
 		endpoint   string
 		bucket     string
 		cachepath  string
+		// separator of the folders in object names, the delimiter of Folders.
+		separator string
 	}
 
 	object struct {
@@ -87,6 +115,8 @@ type (
 		readonly  bool
 		opened    bool
 		cachepath string
+		// ctx of OpenWithContext, of the download and the upload of Sync.
+		ctx context.Context
 
 		//infoOnce sync.Once
 		infoErr error
@@ -97,22 +127,57 @@ type (
 // necessary config settings such as bucket, region, auth.
 func NewClient(conf *cloudstorage.Config) (*az.Client, *az.BlobStorageClient, error) {
 
+	var basicClient az.Client
+	var err error
 	switch conf.AuthMethod {
 	case AuthKey:
 		accessKey := conf.Settings.String(ConfKeyAuthKey)
 		if accessKey == "" {
 			return nil, nil, ErrNoAccessKey
 		}
-		basicClient, err := az.NewBasicClient(conf.Project, accessKey)
-		if err != nil {
-			gou.Warnf("could not get azure client %v", err)
-			return nil, nil, err
+		if endpoint := conf.Settings.String(ConfKeyEndpoint); endpoint != "" {
+			basicClient, err = newEndpointClient(conf.Project, accessKey, endpoint)
+		} else {
+			basicClient, err = az.NewBasicClient(conf.Project, accessKey)
+		}
+	case AuthConnectionString:
+		cs := conf.Settings.String(ConfKeyConnectionString)
+		if cs == "" {
+			return nil, nil, ErrNoConnectionString
 		}
-		client := basicClient.GetBlobService()
-		return &basicClient, &client, err
+		basicClient, err = az.NewClientFromConnectionString(cs)
+	default:
+		return nil, nil, ErrNoAuth
 	}
+	if err != nil {
+		gou.Warnf("could not get azure client %v", err)
+		return nil, nil, err
+	}
+	if conf.HTTPClient != nil {
+		basicClient.HTTPClient = conf.HTTPClient
+	}
+	client := basicClient.GetBlobService()
+	return &basicClient, &client, nil
+}
 
-	return nil, nil, ErrNoAuth
+// newEndpointClient of the account at blob endpoint, which is the emulator
+// or https://<account>.blob.<suffix>.
+func newEndpointClient(account, key, endpoint string) (az.Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return az.Client{}, fmt.Errorf("invalid settings.%s %q", ConfKeyEndpoint, endpoint)
+	}
+	if strings.Trim(u.Path, "/") == az.StorageEmulatorAccountName {
+		if u.Host != emulatorHost && u.Host != "localhost:10000" {
+			return az.Client{}, fmt.Errorf("settings.%s %q the emulator must be at %s", ConfKeyEndpoint, endpoint, emulatorHost)
+		}
+		return az.NewClient(az.StorageEmulatorAccountName, key, az.DefaultBaseURL, az.DefaultAPIVersion, false)
+	}
+	suffix := strings.TrimPrefix(u.Host, account+".blob.")
+	if account == "" || suffix == u.Host || strings.Trim(u.Path, "/") != "" {
+		return az.Client{}, fmt.Errorf("settings.%s %q isn't a blob endpoint of account %q", ConfKeyEndpoint, endpoint, account)
+	}
+	return az.NewClient(account, key, suffix, az.DefaultAPIVersion, u.Scheme != "http")
 }
 
 // NewStore Create AWS S3 storage client of type cloudstorage.Store
@@ -137,6 +202,7 @@ func NewStore(c *az.Client, blobClient *az.BlobStorageClient, conf *cloudstorage
 		cachepath:  conf.TmpDir,
 		ID:         uid,
 		PageSize:   10000,
+		separator:  conf.KeySeparator(),
 	}, nil
 }
 
@@ -150,11 +216,63 @@ func (f *FS) Client() interface{} {
 	return f.client
 }
 
+// ResolveKey is the blob name of object o, spaces are written as "+".
+func (f *FS) ResolveKey(o string) string {
+	return strings.Replace(o, " ", "+", -1)
+}
+
+// blob of object name, at its ResolveKey.
+func (f *FS) blob(name string) *az.Blob {
+	return f.client.GetContainerReference(f.bucket).GetBlobReference(f.ResolveKey(name))
+}
+
+// UpdateMetadata replaces the metadata of object o, and its content type if
+// metadata has a ContentTypeKey.
+func (f *FS) UpdateMetadata(ctx context.Context, o string, metadata map[string]string) error {
+	blob := f.blob(o)
+	if err := blob.GetProperties(nil); err != nil {
+		if strings.Contains(err.Error(), "404") {
+			return cloudstorage.ErrObjectNotFound
+		}
+		return err
+	}
+	if ctype := cloudstorage.MetadataContentType(metadata); ctype != "" {
+		blob.Properties.ContentType = ctype
+		if err := blob.SetProperties(nil); err != nil {
+			return err
+		}
+	}
+	blob.Metadata = blobMetadata(metadata)
+	return blob.SetMetadata(nil)
+}
+
+// blobMetadata is metadata less a ContentTypeHeader, which is set as the
+// blob's content type and isn't a valid metadata name (names are C#
+// identifiers).
+func blobMetadata(metadata map[string]string) map[string]string {
+	if _, ok := metadata[cloudstorage.ContentTypeHeader]; !ok {
+		return metadata
+	}
+	md := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if k != cloudstorage.ContentTypeHeader {
+			md[k] = v
+		}
+	}
+	return md
+}
+
 // String function to provide azure://..../file   path
 func (f *FS) String() string {
 	return fmt.Sprintf("azure://%s/", f.bucket)
 }
 
+// KeySeparator for cloudstorage.StoreSeparator, the separator of the folders
+// in object names.
+func (f *FS) KeySeparator() string {
+	return f.separator
+}
+
 // NewObject of Type azure.
 func (f *FS) NewObject(objectname string) (cloudstorage.Object, error) {
 	obj, err := f.Get(context.Background(), objectname)
@@ -177,7 +295,8 @@ func (f *FS) NewObject(objectname string) (cloudstorage.Object, error) {
 }
 
 // Get a single File Object
-func (f *FS) Get(ctx context.Context, objectpath string) (cloudstorage.Object, error) {
+func (f *FS) Get(ctx context.Context, objectpath string) (_ cloudstorage.Object, err error) {
+	defer cloudstorage.RecoverPanic("azure get", &err)
 
 	obj, err := f.getObject(ctx, objectpath)
 	if err != nil {
@@ -192,7 +311,7 @@ func (f *FS) Get(ctx context.Context, objectpath string) (cloudstorage.Object, e
 // get single object
 func (f *FS) getObject(ctx context.Context, objectname string) (*object, error) {
 
-	blob := f.client.GetContainerReference(f.bucket).GetBlobReference(objectname)
+	blob := f.blob(objectname)
 	err := blob.GetProperties(nil)
 	if err != nil {
 		if strings.Contains(err.Error(), "404") {
@@ -201,9 +320,10 @@ func (f *FS) getObject(ctx context.Context, objectname string) (*object, error)
 		return nil, err
 	}
 	o := &object{
-		name: objectname,
-		fs:   f,
-		o:    blob,
+		name:     objectname,
+		fs:       f,
+		o:        blob,
+		metadata: blob.Metadata,
 	}
 
 	o.o.Properties.Etag = cloudstorage.CleanETag(o.o.Properties.Etag)
@@ -215,9 +335,11 @@ func (f *FS) getObject(ctx context.Context, objectname string) (*object, error)
 }
 
 func (f *FS) getOpenObject(ctx context.Context, objectname string) (io.ReadCloser, error) {
-	rc, err := f.client.GetContainerReference(f.bucket).GetBlobReference(objectname).Get(nil)
+	rc, err := f.blob(objectname).Get(nil)
 	if err != nil && strings.Contains(err.Error(), "404") {
 		return nil, cloudstorage.ErrObjectNotFound
+	} else if err != nil && strings.Contains(err.Error(), "BlobArchived") {
+		return nil, cloudstorage.ErrObjectArchived
 	} else if err != nil {
 		return nil, err
 	}
@@ -238,7 +360,8 @@ func convertMetaData(m map[string]*string) (map[string]string, error) {
 }
 
 // List objects from this store.
-func (f *FS) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.ObjectsResponse, error) {
+func (f *FS) List(ctx context.Context, q cloudstorage.Query) (_ *cloudstorage.ObjectsResponse, err error) {
+	defer cloudstorage.RecoverPanic("azure list", &err)
 
 	itemLimit := uint(f.PageSize)
 	if q.PageSize > 0 {
@@ -250,19 +373,27 @@ func (f *FS) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.Obje
 		MaxResults: itemLimit,
 		Marker:     q.Marker,
 	}
+	if q.IncludeMetadata {
+		// content type and md5 are always in the listed properties
+		params.Include = &az.IncludeBlobDataset{Metadata: true}
+	}
 
 	blobs, err := f.client.GetContainerReference(f.bucket).ListBlobs(params)
 	if err != nil {
 		return nil, err
 	}
 	objResp := &cloudstorage.ObjectsResponse{
-		Objects: make(cloudstorage.Objects, len(blobs.Blobs)),
+		Objects: make(cloudstorage.Objects, 0, len(blobs.Blobs)),
 	}
 
-	for i, o := range blobs.Blobs {
-		objResp.Objects[i] = newObject(f, &o)
+	for i := range blobs.Blobs {
+		// markers are opaque, so StartAfter is applied client side
+		if q.After(blobs.Blobs[i].Name) {
+			objResp.Objects = append(objResp.Objects, newObject(f, &blobs.Blobs[i]))
+		}
 	}
 	objResp.NextMarker = blobs.NextMarker
+	objResp.HasMore = blobs.NextMarker != ""
 	q.Marker = blobs.NextMarker
 
 	return objResp, nil
@@ -275,9 +406,10 @@ func (f *FS) Objects(ctx context.Context, q cloudstorage.Query) (cloudstorage.Ob
 }
 
 // Folders get folders list.
-func (f *FS) Folders(ctx context.Context, q cloudstorage.Query) ([]string, error) {
+func (f *FS) Folders(ctx context.Context, q cloudstorage.Query) (_ []string, err error) {
+	defer cloudstorage.RecoverPanic("azure folders", &err)
 
-	q.Delimiter = "/"
+	q.Delimiter = f.separator
 
 	// Think we should just put 1 here right?
 	itemLimit := uint(f.PageSize)
@@ -288,7 +420,7 @@ func (f *FS) Folders(ctx context.Context, q cloudstorage.Query) ([]string, error
 	params := az.ListBlobsParameters{
 		Prefix:     q.Prefix,
 		MaxResults: itemLimit,
-		Delimiter:  "/",
+		Delimiter:  q.Delimiter,
 	}
 
 	for {
@@ -313,64 +445,108 @@ func (f *FS) Folders(ctx context.Context, q cloudstorage.Query) ([]string, error
 	}
 }
 
-/*
-// Copy from src to destination
-func (f *FS) Copy(ctx context.Context, src, des cloudstorage.Object) error {
-
-	so, ok := src.(*object)
-	if !ok {
-		return fmt.Errorf("Copy source file expected s3 but got %T", src)
-	}
-	do, ok := des.(*object)
-	if !ok {
-		return fmt.Errorf("Copy destination expected s3 but got %T", des)
+// ListLevel lists the objects and folders directly under prefix, paging
+// through a delimited listing until it has limit of them.
+func (f *FS) ListLevel(ctx context.Context, prefix string, limit int) (_ cloudstorage.Objects, _ []string, err error) {
+	defer cloudstorage.RecoverPanic("azure list level", &err)
+	params := az.ListBlobsParameters{
+		Prefix:     prefix,
+		MaxResults: uint(f.PageSize),
+		Delimiter:  f.separator,
 	}
 
-	oh := so.b.Object(so.name)
-	dh := do.b.Object(do.name)
-
-	_, err := dh.CopierFrom(oh).Run(ctx)
-	return err
+	objects := make(cloudstorage.Objects, 0)
+	folders := make([]string, 0)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		default:
+		}
+		blobs, err := f.client.GetContainerReference(f.bucket).ListBlobs(params)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range blobs.Blobs {
+			objects = append(objects, newObject(f, &blobs.Blobs[i]))
+		}
+		folders = append(folders, blobs.BlobPrefixes...)
+		if blobs.NextMarker == "" || (limit > 0 && len(objects)+len(folders) >= limit) {
+			objects, folders = cloudstorage.LimitLevel(objects, folders, limit)
+			return objects, folders, nil
+		}
+		params.Marker = blobs.NextMarker
+	}
 }
 
-// Move which is a Copy & Delete
-func (f *FS) Move(ctx context.Context, src, des cloudstorage.Object) error {
+// Copy from src to destination, server side keeping the metadata and
+// properties, waiting for the copy to complete.  The copy is checked against
+// the md5 of src if it has one.
+func (f *FS) Copy(ctx context.Context, src, des cloudstorage.Object) (err error) {
+	defer cloudstorage.RecoverPanic("azure copy", &err)
 
 	so, ok := src.(*object)
 	if !ok {
-		return fmt.Errorf("Move source file expected s3 but got %T", src)
+		return fmt.Errorf("Copy source file expected azure but got %T", src)
 	}
 	do, ok := des.(*object)
 	if !ok {
-		return fmt.Errorf("Move destination expected s3 but got %T", des)
+		return fmt.Errorf("Copy destination expected azure but got %T", des)
 	}
 
-	oh := so.b.Object(so.name)
-	dh := do.b.Object(des.name)
-
-	if _, err := dh.CopierFrom(oh).Run(ctx); err != nil {
+	srcBlob := f.blob(so.name)
+	dstBlob := f.blob(do.name)
+	if err := dstBlob.Copy(srcBlob.GetURL(), nil); err != nil {
+		if strings.Contains(err.Error(), "404") {
+			return cloudstorage.ErrObjectNotFound
+		}
 		return err
 	}
-
-	return oh.Delete(ctx)
+	return cloudstorage.VerifyCopy(ctx, f, src, do.name)
 }
-*/
+
 // NewReader create file reader.
 func (f *FS) NewReader(o string) (io.ReadCloser, error) {
 	return f.NewReaderWithContext(context.Background(), o)
 }
 
 // NewReaderWithContext create new File reader with context.
-func (f *FS) NewReaderWithContext(ctx context.Context, objectname string) (io.ReadCloser, error) {
-	ioc, err := f.client.GetContainerReference(f.bucket).GetBlobReference(objectname).Get(nil)
+func (f *FS) NewReaderWithContext(ctx context.Context, objectname string, opts ...cloudstorage.ReadOptions) (_ io.ReadCloser, err error) {
+	defer cloudstorage.RecoverPanic("azure read", &err)
+	blob := f.blob(objectname)
+	ioc, err := blob.Get(nil)
 	if err != nil {
 		// translate the string error to typed error
 		if strings.Contains(err.Error(), "404") {
 			return nil, cloudstorage.ErrObjectNotFound
+		} else if strings.Contains(err.Error(), "BlobArchived") {
+			return nil, cloudstorage.ErrObjectArchived
+		}
+		return nil, err
+	}
+	// the blob requests don't take a context
+	ioc = cloudstorage.NewContextReadCloser(ctx, ioc)
+	if len(opts) > 0 && opts[0].VerifyChecksum {
+		if ioc, err = verifyReader(blob, ioc); err != nil {
+			return nil, err
+		}
+	}
+	return cloudstorage.MaxBytesReader(ioc, opts), nil
+}
+
+// verifyReader checks rc against the blobs Content-MD5, or else a checksum
+// in its metadata.
+func verifyReader(blob *az.Blob, rc io.ReadCloser) (io.ReadCloser, error) {
+	if blob.Properties.ContentMD5 != "" {
+		if sum, err := base64.StdEncoding.DecodeString(blob.Properties.ContentMD5); err == nil {
+			return cloudstorage.NewChecksumReader(rc, md5.New(), sum), nil
 		}
+	}
+	if err := blob.GetMetadata(nil); err != nil {
+		rc.Close()
 		return nil, err
 	}
-	return ioc, nil
+	return cloudstorage.NewMetaDataChecksumReader(rc, blob.Metadata)
 }
 
 // NewWriter create Object Writer.
@@ -379,17 +555,51 @@ func (f *FS) NewWriter(objectName string, metadata map[string]string) (io.WriteC
 }
 
 // NewWriterWithContext create writer with provided context and metadata.
-func (f *FS) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
-	if len(opts) > 0 && opts[0].IfNotExists {
-		return nil, fmt.Errorf("options IfNotExists not supported for store type")
+func (f *FS) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...cloudstorage.Opts) (_ io.WriteCloser, err error) {
+	defer cloudstorage.RecoverPanic("azure write", &err)
+	if len(opts) > 0 && opts[0].IfGenerationMatch != 0 {
+		return nil, cloudstorage.ErrConditionNotSupported
+	}
+	if len(opts) > 0 && opts[0].PublicRead {
+		if err := f.checkPublicRead(); err != nil {
+			return nil, err
+		}
+	}
+	if cloudstorage.SkipsIfIdentical(opts) {
+		return cloudstorage.NewSkipIfIdenticalWriter(ctx, f, name, metadata, opts)
+	}
+	if cloudstorage.VerifiesOnClose(opts) {
+		return cloudstorage.NewVerifyOnCloseWriter(ctx, f, name, metadata, opts)
+	}
+	if cloudstorage.DetectsContentType(metadata, opts) {
+		return cloudstorage.NewContentTypeWriter(name, metadata, opts, func(md map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+			return f.NewWriterWithContext(ctx, name, md, opts...)
+		}), nil
 	}
-	name = strings.Replace(name, " ", "+", -1)
 	o := &object{name: name, metadata: metadata}
-	rwc := newAzureWriteCloser(ctx, f, o)
+	var wopts cloudstorage.Opts
+	if len(opts) > 0 {
+		wopts = opts[0]
+	}
+	rwc := newAzureWriteCloser(ctx, f, o, wopts)
 
 	return rwc, nil
 }
 
+// checkPublicRead the blobs of the container are readable anonymously, its
+// public access level is blob or container, else ErrContainerPrivate.  The
+// access level is a setting of the container, so it isn't changed here.
+func (f *FS) checkPublicRead() error {
+	perms, err := f.client.GetContainerReference(f.bucket).GetPermissions(nil)
+	if err != nil {
+		return err
+	}
+	if perms.AccessType == az.ContainerAccessTypePrivate {
+		return ErrContainerPrivate
+	}
+	return nil
+}
+
 // azureWriteCloser - manages data and go routines used to pipe data to azures, calling Close
 // will flush data to azures and block until all inflight data has been written or
 // we get an error.
@@ -402,16 +612,23 @@ type azureWriteCloser struct {
 
 // azureWriteCloser is a io.WriteCloser that manages the azure connection pipe and when Close is called
 // it blocks until all data is flushed to azure via a background go routine call to uploadMultiPart.
-func newAzureWriteCloser(ctx context.Context, f *FS, obj *object) io.WriteCloser {
+func newAzureWriteCloser(ctx context.Context, f *FS, obj *object, opts cloudstorage.Opts) io.WriteCloser {
 	pr, pw := io.Pipe()
 	bw := bufio.NewWriter(pw)
 
 	g, _ := errgroup.WithContext(ctx)
 
-	g.Go(func() error {
+	g.Go(func() (err error) {
+		defer func() {
+			if err != nil {
+				// unblock writes to the pipe
+				pr.CloseWithError(err)
+			}
+		}()
+		defer cloudstorage.RecoverPanic("azure upload", &err)
 		// Upload the file to azure.
 		// Do a multipart upload
-		err := f.uploadMultiPart(obj, pr)
+		err = f.uploadMultiPart(obj, pr, opts)
 		if err != nil {
 			gou.Warnf("could not upload %v", err)
 			return err
@@ -463,8 +680,10 @@ func makeBlockID(id uint64) string {
 	return base64.StdEncoding.EncodeToString(bytesID)
 }
 
-// uploadMultiPart start an upload
-func (f *FS) uploadMultiPart(o *object, r io.Reader) error {
+// uploadMultiPart start an upload, if contentMD5 is set the blocks are only
+// committed if the md5 of all bytes read from r matches it.
+func (f *FS) uploadMultiPart(o *object, r io.Reader, opts cloudstorage.Opts) error {
+	contentMD5 := opts.ContentMD5
 
 	//chunkSize, err := calcBlockSize(size)
 	// if err != nil {
@@ -475,7 +694,8 @@ func (f *FS) uploadMultiPart(o *object, r io.Reader) error {
 	var blocks []az.Block
 	var rawID uint64
 
-	blob := f.client.GetContainerReference(f.bucket).GetBlobReference(o.name)
+	blob := f.blob(o.name)
+	h := md5.New()
 
 	// TODO: performance improvement to mange uploads in separate
 	// go-routine than the reader
@@ -488,11 +708,24 @@ func (f *FS) uploadMultiPart(o *object, r io.Reader) error {
 			gou.Warnf("unknown err=%v", err)
 			return err
 		}
+		if n == 0 {
+			continue
+		}
 
 		blockID := makeBlockID(rawID)
 		chunk := buf[:n]
+		h.Write(chunk)
 
-		if err := blob.PutBlock(blockID, chunk, nil); err != nil {
+		var blockOpts *az.PutBlockOptions
+		if contentMD5 != nil {
+			// azure verifies each block against its md5
+			sum := md5.Sum(chunk)
+			blockOpts = &az.PutBlockOptions{ContentMD5: base64.StdEncoding.EncodeToString(sum[:])}
+		}
+		if err := blob.PutBlock(blockID, chunk, blockOpts); err != nil {
+			if strings.Contains(err.Error(), "Md5Mismatch") {
+				return cloudstorage.ErrChecksumMismatch
+			}
 			return err
 		}
 
@@ -503,7 +736,46 @@ func (f *FS) uploadMultiPart(o *object, r io.Reader) error {
 		rawID++
 	}
 
-	err := blob.PutBlockList(blocks, nil)
+	if contentMD5 != nil {
+		// uncommitted blocks are discarded, so the blob is left unchanged.
+		if !bytes.Equal(h.Sum(nil), contentMD5) {
+			return cloudstorage.ErrChecksumMismatch
+		}
+		blob.Properties.ContentMD5 = base64.StdEncoding.EncodeToString(contentMD5)
+	}
+
+	if ctype := cloudstorage.MetadataContentType(o.metadata); ctype != "" {
+		blob.Properties.ContentType = ctype
+	}
+
+	// the conditions are checked when the blocks are committed.
+	var ifMatch, ifNoneMatch string
+	if opts.IfMatch != "" {
+		ifMatch = `"` + opts.IfMatch + `"`
+	}
+	if opts.IfNotExists {
+		ifNoneMatch = "*"
+	}
+	conditional := ifMatch != "" || ifNoneMatch != ""
+	var err error
+	if len(blocks) == 0 {
+		// nothing was written, create the blob empty.
+		var blobOpts *az.PutBlobOptions
+		if conditional {
+			blobOpts = &az.PutBlobOptions{IfMatch: ifMatch, IfNoneMatch: ifNoneMatch}
+		}
+		err = blob.CreateBlockBlob(blobOpts)
+	} else {
+		var listOpts *az.PutBlockListOptions
+		if conditional {
+			listOpts = &az.PutBlockListOptions{IfMatch: ifMatch, IfNoneMatch: ifNoneMatch}
+		}
+		err = blob.PutBlockList(blocks, listOpts)
+	}
+	if err != nil && conditional && (strings.Contains(err.Error(), "412") ||
+		strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "409")) {
+		return cloudstorage.ErrPreconditionFailed
+	}
 	if err != nil {
 		gou.Warnf("could not put block list %v", err)
 		return err
@@ -515,7 +787,7 @@ func (f *FS) uploadMultiPart(o *object, r io.Reader) error {
 		return err
 	}
 
-	blob.Metadata = o.metadata
+	blob.Metadata = blobMetadata(o.metadata)
 
 	err = blob.SetMetadata(nil)
 	if err != nil {
@@ -526,8 +798,31 @@ func (f *FS) uploadMultiPart(o *object, r io.Reader) error {
 }
 
 // Delete requested object path string.
-func (f *FS) Delete(ctx context.Context, name string) error {
-	err := f.client.GetContainerReference(f.bucket).GetBlobReference(name).Delete(nil)
+func (f *FS) Delete(ctx context.Context, name string, opts ...cloudstorage.DeleteOptions) (err error) {
+	defer cloudstorage.RecoverPanic("azure delete", &err)
+	if err := f.delete(ctx, name, opts...); err != nil {
+		return err
+	}
+	if len(opts) > 0 && opts[0].WaitConsistent {
+		return cloudstorage.WaitDeleted(ctx, f, name)
+	}
+	return nil
+}
+
+func (f *FS) delete(ctx context.Context, name string, opts ...cloudstorage.DeleteOptions) error {
+	var delOpts *az.DeleteBlobOptions
+	if len(opts) > 0 {
+		if opts[0].IfGenerationMatch != 0 {
+			return cloudstorage.ErrConditionNotSupported
+		}
+		if opts[0].IfMatch != "" {
+			delOpts = &az.DeleteBlobOptions{IfMatch: `"` + opts[0].IfMatch + `"`}
+		}
+	}
+	err := f.blob(name).Delete(delOpts)
+	if err != nil && delOpts != nil && (strings.Contains(err.Error(), "412") || strings.Contains(err.Error(), "404")) {
+		return cloudstorage.ErrPreconditionFailed
+	}
 	if err != nil && strings.Contains(err.Error(), "404") {
 		return cloudstorage.ErrObjectNotFound
 	}
@@ -541,6 +836,7 @@ func newObject(f *FS, o *az.Blob) *object {
 		name:      o.Name,
 		bucket:    f.bucket,
 		cachepath: cloudstorage.CachePathObj(f.cachepath, o.Name, f.ID),
+		metadata:  o.Metadata,
 	}
 	obj.o.Properties.Etag = cloudstorage.CleanETag(obj.o.Properties.Etag)
 	return obj
@@ -574,6 +870,34 @@ func (o *object) String() string {
 func (o *object) Updated() time.Time {
 	return o.updated
 }
+func (o *object) ETag() string {
+	if o.o == nil {
+		return ""
+	}
+	return o.o.Properties.Etag
+}
+func (o *object) Size() int64 {
+	if o.o == nil {
+		return 0
+	}
+	return o.o.Properties.ContentLength
+}
+func (o *object) ContentType() string {
+	if o.o == nil {
+		return ""
+	}
+	return o.o.Properties.ContentType
+}
+func (o *object) MD5() []byte {
+	if o.o == nil || o.o.Properties.ContentMD5 == "" {
+		return nil
+	}
+	sum, err := base64.StdEncoding.DecodeString(o.o.Properties.ContentMD5)
+	if err != nil {
+		return nil
+	}
+	return sum
+}
 func (o *object) MetaData() map[string]string {
 	return o.metadata
 }
@@ -585,10 +909,41 @@ func (o *object) Delete() error {
 	return o.fs.Delete(context.Background(), o.name)
 }
 
-func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
+// Open the object, concurrent ReadOnly opens of the same object share one
+// download, see cloudstorage.SharedDownload.
+func (o *object) Open(accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+// OpenWithContext for cloudstorage.ObjectOpenContext.  The blob requests
+// don't take a context, so the download (and the upload of Sync) stops
+// between reads once ctx is done.
+func (o *object) OpenWithContext(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	if !accesslevel.Valid() {
+		return nil, cloudstorage.ErrInvalidAccessLevel
+	}
+	if !cloudstorage.SharesDownload(ctx, accesslevel) || o.opened {
+		return o.open(ctx, accesslevel, opts...)
+	}
+	f, shared, err := cloudstorage.SharedDownload(o.cachepath, opts, func() (*os.File, error) {
+		return o.open(ctx, accesslevel, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		o.cachedcopy = f
+		o.readonly = true
+		o.opened = true
+	}
+	return cloudstorage.VerifyOpened(o, f, opts)
+}
+
+func (o *object) open(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
 	if o.opened {
 		return nil, fmt.Errorf("the store object is already opened. %s", o.name)
 	}
+	o.ctx = ctx
 
 	var errs []error = make([]error, 0)
 	var cachedcopy *os.File = nil
@@ -611,11 +966,30 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 	}
 
 	for try := 0; try < Retries; try++ {
-		if o.rc == nil {
-			rc, err := o.fs.getOpenObject(context.Background(), o.name)
+		if err := ctx.Err(); err != nil {
+			return nil, o.abortOpen(cachedcopy, err)
+		}
+		ranged := false
+		if readonly && len(opts) > 0 && opts[0].DownloadConcurrency > 1 {
+			ranged, err = o.downloadRanges(ctx, cachedcopy, opts[0])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error downloading ranges err=%v", err))
+				if err := cachedcopy.Truncate(0); err != nil {
+					return nil, fmt.Errorf("error resetting the cachedcopy err=%v", err) //don't retry on local fs errors
+				}
+				cloudstorage.Backoff(try)
+				continue
+			}
+		}
+
+		if o.rc == nil && !ranged {
+			rc, err := o.fs.getOpenObject(ctx, o.name)
 			if err != nil {
 				if err == cloudstorage.ErrObjectNotFound {
 					// New, this is fine
+				} else if err == cloudstorage.ErrObjectArchived {
+					// needs a Restore, retrying won't help
+					return nil, err
 				} else {
 					// lets re-try
 					errs = append(errs, fmt.Errorf("error getting object err=%v", err))
@@ -637,7 +1011,7 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 				return nil, fmt.Errorf("error seeking to start of cachedcopy err=%v", err) //don't retry on local fs errors
 			}
 
-			_, err = io.Copy(cachedcopy, o.rc)
+			_, err = io.Copy(cachedcopy, cloudstorage.NewContextReader(ctx, o.rc))
 			if err != nil {
 				errs = append(errs, fmt.Errorf("error coping bytes. err=%v", err))
 				//recreate the cachedcopy file incase it has incomplete data
@@ -674,10 +1048,105 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 		o.opened = true
 		return o.cachedcopy, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, o.abortOpen(cachedcopy, err)
+	}
 
 	return nil, fmt.Errorf("fetch error retry cnt reached: obj=%s tfile=%v errs:[%v]", o.name, o.cachepath, errs)
 }
 
+// abortOpen removes the partial cachedcopy of an open cancelled by err.
+func (o *object) abortOpen(cachedcopy *os.File, err error) error {
+	cachedcopy.Close()
+	os.Remove(o.cachepath)
+	return err
+}
+
+// context of the open, Background unless opened by OpenWithContext.
+func (o *object) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// downloadRanges fetches the blob into cachedcopy as parallel ranges if it
+// is large enough, returning false if it should be read as a single stream.
+func (o *object) downloadRanges(ctx context.Context, cachedcopy *os.File, opts cloudstorage.ReadOptions) (bool, error) {
+	blob := o.fs.blob(o.name)
+	if err := blob.GetProperties(nil); err != nil {
+		if strings.Contains(err.Error(), "404") {
+			// New, this is fine
+			return false, nil
+		}
+		return false, err
+	}
+	size := blob.Properties.ContentLength
+	if !opts.ParallelDownload(size) {
+		return false, nil
+	}
+
+	// pin the ranges to the version we have properties for
+	etag := blob.Properties.Etag
+	err := cloudstorage.DownloadRanges(ctx, cachedcopy, size, opts,
+		func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+			return o.fs.blob(o.name).GetRange(&az.GetBlobRangeOptions{
+				Range:          &az.BlobRange{Start: uint64(offset), End: uint64(offset + length - 1)},
+				GetBlobOptions: &az.GetBlobOptions{IfMatch: etag},
+			})
+		})
+	if err != nil {
+		return true, err
+	}
+
+	if blob.Properties.ContentMD5 != "" {
+		if sum, err := base64.StdEncoding.DecodeString(blob.Properties.ContentMD5); err == nil {
+			return true, cloudstorage.VerifyFile(cachedcopy, md5.New(), sum)
+		}
+	}
+	if err := blob.GetMetadata(nil); err != nil {
+		return true, err
+	}
+	if h, expected, ok := cloudstorage.ChecksumFromMetaData(blob.Metadata); ok {
+		return true, cloudstorage.VerifyFile(cachedcopy, h, expected)
+	}
+	return true, nil
+}
+
+// OpenRange for cloudstorage.ObjectRange, a ranged get of the blob.  The blob
+// requests don't take a context, so ctx is only checked before the request.
+func (o *object) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// an End of 0 is the rest of the blob, so the range of the first byte
+	// is limited as it is read.
+	br := &az.BlobRange{Start: uint64(start)}
+	if length > 0 {
+		br.End = uint64(start + length - 1)
+	}
+	rc, err := o.fs.blob(o.name).GetRange(&az.GetBlobRangeOptions{Range: br})
+	if err != nil {
+		if strings.Contains(err.Error(), "404") {
+			return nil, cloudstorage.ErrObjectNotFound
+		}
+		if strings.Contains(err.Error(), "416") {
+			return nil, cloudstorage.ErrRangeNotSatisfiable
+		}
+		return nil, err
+	}
+	if length > 0 {
+		return &rangeReader{io.LimitReader(rc, length), rc}, nil
+	}
+	return rc, nil
+}
+
+// rangeReader reads the limited range of a blob, closing it on Close.
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
 func (o *object) File() *os.File {
 	return o.cachedcopy
 }
@@ -686,6 +1155,9 @@ func (o *object) Read(p []byte) (n int, err error) {
 	return o.cachedcopy.Read(p)
 }
 func (o *object) Write(p []byte) (n int, err error) {
+	if o.opened && o.readonly {
+		return 0, cloudstorage.ErrReadOnly
+	}
 	if o.cachedcopy == nil {
 		_, err := o.Open(cloudstorage.ReadWrite)
 		if err != nil {
@@ -701,7 +1173,7 @@ func (o *object) Sync() error {
 		return fmt.Errorf("object isn't opened object:%s", o.name)
 	}
 	if o.readonly {
-		return fmt.Errorf("trying to Sync a readonly object:%s", o.name)
+		return cloudstorage.ErrReadOnly
 	}
 
 	cachedcopy, err := os.OpenFile(o.cachepath, os.O_RDWR, 0664)
@@ -715,7 +1187,7 @@ func (o *object) Sync() error {
 	}
 
 	// Upload the file
-	if err = o.fs.uploadMultiPart(o, cachedcopy); err != nil {
+	if err = o.fs.uploadMultiPart(o, cloudstorage.NewContextReader(o.context(), cachedcopy), cloudstorage.Opts{}); err != nil {
 		gou.Warnf("could not upload %v", err)
 		return fmt.Errorf("failed to upload file, %v", err)
 	}
@@ -1,6 +1,8 @@
 package azure_test
 
 import (
+	"context"
+	"net/http"
 	"os"
 	"testing"
 
@@ -86,3 +88,115 @@ func TestAll(t *testing.T) {
 
 	testutils.RunTests(t, store, config)
 }
+
+func TestSharedHTTPClient(t *testing.T) {
+	shared := &http.Client{}
+	conf := &cloudstorage.Config{
+		Type:       azure.StoreType,
+		AuthMethod: azure.AuthKey,
+		Project:    "devaccount",
+		Settings:   gou.JsonHelper{azure.ConfKeyAuthKey: "YWJjZA=="},
+		HTTPClient: shared,
+	}
+	c1, _, err := azure.NewClient(conf)
+	assert.Equal(t, nil, err)
+	c2, _, err := azure.NewClient(conf)
+	assert.Equal(t, nil, err)
+	assert.True(t, shared == c1.HTTPClient)
+	assert.True(t, c1.HTTPClient == c2.HTTPClient)
+}
+
+func TestEndpoint(t *testing.T) {
+	conf := &cloudstorage.Config{
+		Type:       azure.StoreType,
+		AuthMethod: azure.AuthKey,
+		Project:    "devaccount",
+		Settings:   gou.JsonHelper{azure.ConfKeyAuthKey: "YWJjZA=="},
+	}
+	containerURL := func(endpoint string) (string, error) {
+		conf.Settings[azure.ConfKeyEndpoint] = endpoint
+		_, blobs, err := azure.NewClient(conf)
+		if err != nil {
+			return "", err
+		}
+		return blobs.GetContainerReference("c").GetURL(), nil
+	}
+
+	for endpoint, expected := range map[string]string{
+		"": "https://devaccount.blob.core.windows.net/c",
+		"https://devaccount.blob.core.chinacloudapi.cn": "https://devaccount.blob.core.chinacloudapi.cn/c",
+		"http://127.0.0.1:10000/devstoreaccount1":       "http://127.0.0.1:10000/devstoreaccount1/c",
+	} {
+		u, err := containerURL(endpoint)
+		assert.Equal(t, nil, err, endpoint)
+		assert.Equal(t, expected, u, endpoint)
+	}
+	for _, endpoint := range []string{"devaccount", "https://other.blob.core.windows.net", "http://127.0.0.1:10001/devstoreaccount1"} {
+		_, err := containerURL(endpoint)
+		assert.NotEqual(t, nil, err, endpoint)
+	}
+
+	conf = &cloudstorage.Config{
+		Type:       azure.StoreType,
+		AuthMethod: azure.AuthConnectionString,
+		Settings:   make(gou.JsonHelper),
+	}
+	_, _, err := azure.NewClient(conf)
+	assert.Equal(t, azure.ErrNoConnectionString, err)
+}
+
+// TestAzurite runs the store tests against the emulator, ie
+//
+//	docker run -p 10000:10000 mcr.microsoft.com/azure-storage/azurite azurite-blob --blobHost 0.0.0.0
+//	export AZURITE=true
+func TestAzurite(t *testing.T) {
+	if os.Getenv("AZURITE") == "" {
+		t.Logf("must provide AZURITE env var")
+		t.Skip()
+		return
+	}
+	conf := &cloudstorage.Config{
+		Type:       azure.StoreType,
+		AuthMethod: azure.AuthConnectionString,
+		Bucket:     "cloudstorageunittests",
+		TmpDir:     "/tmp/localcache/azurite",
+		Settings:   gou.JsonHelper{azure.ConfKeyConnectionString: "UseDevelopmentStorage=true"},
+	}
+	_, blobs, err := azure.NewClient(conf)
+	assert.Equal(t, nil, err)
+	_, err = blobs.GetContainerReference(conf.Bucket).CreateIfNotExists(nil)
+	assert.Equal(t, nil, err)
+
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	testutils.RunTests(t, store, conf)
+
+	// the endpoint of the emulator, with its well known key.
+	conf.AuthMethod = azure.AuthKey
+	conf.Settings = gou.JsonHelper{
+		azure.ConfKeyAuthKey:  "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==",
+		azure.ConfKeyEndpoint: "http://127.0.0.1:10000/devstoreaccount1",
+	}
+	store, err = cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	writer, err := store.NewWriter("azurite.csv", nil)
+	assert.Equal(t, nil, err)
+	writer.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, writer.Close())
+	_, err = store.Get(context.Background(), "azurite.csv")
+	assert.Equal(t, nil, err)
+
+	// a name with a space is read and deleted at the key it was written to.
+	writer, err = store.NewWriter("azurite a.csv", nil)
+	assert.Equal(t, nil, err)
+	writer.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, writer.Close())
+	_, err = store.Get(context.Background(), "azurite a.csv")
+	assert.Equal(t, nil, err)
+	rc, err := store.NewReader("azurite a.csv")
+	assert.Equal(t, nil, err)
+	rc.Close()
+	assert.Equal(t, nil, store.Delete(context.Background(), "azurite a.csv"))
+	_, err = store.Get(context.Background(), "azurite+a.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+}
@@ -0,0 +1,141 @@
+package azure
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	az "github.com/Azure/azure-sdk-for-go/storage"
+	"golang.org/x/net/context"
+
+	"github.com/lytics/cloudstorage"
+)
+
+const (
+	// TierHot access tier for frequently read blobs.
+	TierHot = "Hot"
+	// TierCool access tier for infrequently read blobs.
+	TierCool = "Cool"
+	// TierArchive access tier for blobs that are rarely read, they have to be
+	// rehydrated to Hot or Cool (see Restore) before they can be read.
+	TierArchive = "Archive"
+
+	// tierAPIVersion is the first storage api version with blob tiers, which
+	// the az sdk doesn't support so they are raw requests.
+	tierAPIVersion = "2017-04-17"
+)
+
+// TierStatus is the access tier of a blob and its rehydration progress.
+type TierStatus struct {
+	// Tier is the current access tier.
+	Tier string
+	// ArchiveStatus while an archived blob is being rehydrated, ie
+	// "rehydrate-pending-to-hot", empty otherwise.
+	ArchiveStatus string
+}
+
+// Rehydrating is true while the blob is being moved out of the archive tier.
+func (s TierStatus) Rehydrating() bool {
+	return strings.HasPrefix(s.ArchiveStatus, "rehydrate-pending")
+}
+
+// SetTier sets the access tier of blob name.  Setting an archived blob to Hot
+// or Cool starts rehydrating it, which can take hours, see RehydrateStatus.
+func (f *FS) SetTier(ctx context.Context, name, tier string) error {
+	res, err := f.blobRequest(ctx, http.MethodPut, name, url.Values{"comp": {"tier"}}, map[string]string{"x-ms-access-tier": tier})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// RehydrateStatus gets the access tier of blob name, to poll for it being
+// rehydrated after a SetTier.
+func (f *FS) RehydrateStatus(ctx context.Context, name string) (TierStatus, error) {
+	res, err := f.blobRequest(ctx, http.MethodHead, name, nil, nil)
+	if err != nil {
+		return TierStatus{}, err
+	}
+	res.Body.Close()
+	return TierStatus{
+		Tier:          res.Header.Get("x-ms-access-tier"),
+		ArchiveStatus: res.Header.Get("x-ms-archive-status"),
+	}, nil
+}
+
+// SetStorageClass for cloudstorage.StoreStorageClass, class is the access tier.
+func (f *FS) SetStorageClass(ctx context.Context, name, class string) error {
+	return f.SetTier(ctx, name, class)
+}
+
+// Restore for cloudstorage.StoreRestore, rehydrates blob name to the Hot tier.
+func (f *FS) Restore(ctx context.Context, name string) error {
+	return f.SetTier(ctx, name, TierHot)
+}
+
+// Restoring for cloudstorage.StoreRestore.
+func (f *FS) Restoring(ctx context.Context, name string) (bool, error) {
+	status, err := f.RehydrateStatus(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return status.Rehydrating(), nil
+}
+
+// blobRequest makes a raw request against blob name, authorized by a short
+// lived SAS.
+func (f *FS) blobRequest(ctx context.Context, method, name string, query url.Values, headers map[string]string) (*http.Response, error) {
+	blob := f.client.GetContainerReference(f.bucket).GetBlobReference(name)
+	sas, err := blob.GetSASURI(az.BlobSASOptions{
+		BlobServiceSASPermissions: az.BlobServiceSASPermissions{Read: true, Write: true},
+		SASOptions: az.SASOptions{
+			APIVersion: tierAPIVersion,
+			Expiry:     time.Now().Add(15 * time.Minute),
+			UseHTTPS:   true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(sas)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for k, v := range query {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("x-ms-version", tierAPIVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := http.DefaultClient
+	if f.baseClient != nil && f.baseClient.HTTPClient != nil {
+		client = f.baseClient.HTTPClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, cloudstorage.ErrObjectNotFound
+	} else if res.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("azure %s %s failed status=%d %s", method, name, res.StatusCode, b)
+	}
+	return res, nil
+}
@@ -0,0 +1,88 @@
+package azure_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	az "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/araddon/gou"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/azure"
+)
+
+// tierTransport fakes the blob service for one archived blob.
+type tierTransport struct {
+	tier    string
+	status  string
+	setTier string
+}
+
+func (t *tierTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}
+	switch {
+	case req.Method == http.MethodPut && req.URL.Query().Get("comp") == "tier":
+		t.setTier = req.Header.Get("x-ms-access-tier")
+		t.status = "rehydrate-pending-to-" + t.setTier
+	case req.Method == http.MethodHead:
+		res.Header.Set("x-ms-access-tier", t.tier)
+		res.Header.Set("x-ms-archive-status", t.status)
+	case req.Method == http.MethodGet && t.tier == azure.TierArchive:
+		res.StatusCode = http.StatusConflict
+		res.Header.Set("x-ms-error-code", "BlobArchived")
+		res.Header.Set("Content-Type", "application/xml")
+		res.Body = ioutil.NopCloser(bytes.NewBufferString(`<?xml version="1.0" encoding="utf-8"?>` +
+			`<Error><Code>BlobArchived</Code><Message>This operation is not permitted on an archived blob.</Message></Error>`))
+	}
+	return res, nil
+}
+
+func mockStore(t *testing.T, rt http.RoundTripper) *azure.FS {
+	c, err := az.NewBasicClient("devaccount", "YWJjZA==")
+	assert.Equal(t, nil, err)
+	c.HTTPClient = &http.Client{Transport: rt}
+	bsc := c.GetBlobService()
+	store, err := azure.NewStore(&c, &bsc, &cloudstorage.Config{
+		Type:     azure.StoreType,
+		Bucket:   "tiers",
+		TmpDir:   "/tmp/localcache/azuretier",
+		Settings: make(gou.JsonHelper),
+	})
+	assert.Equal(t, nil, err)
+	return store
+}
+
+func TestArchivedBlob(t *testing.T) {
+	rt := &tierTransport{tier: azure.TierArchive}
+	store := mockStore(t, rt)
+	ctx := context.Background()
+
+	_, err := store.NewReader("archived.csv")
+	assert.Equal(t, cloudstorage.ErrObjectArchived, err)
+
+	status, err := store.RehydrateStatus(ctx, "archived.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, azure.TierArchive, status.Tier)
+	assert.False(t, status.Rehydrating())
+
+	err = cloudstorage.Restore(ctx, store, "archived.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, azure.TierHot, rt.setTier)
+
+	restoring, err := cloudstorage.Restoring(ctx, store, "archived.csv")
+	assert.Equal(t, nil, err)
+	assert.True(t, restoring)
+
+	err = cloudstorage.SetStorageClass(ctx, store, "archived.csv", azure.TierCool)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, azure.TierCool, rt.setTier)
+}
@@ -0,0 +1,163 @@
+package b2
+
+import (
+	"fmt"
+
+	"github.com/araddon/gou"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/net/context"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/awss3"
+)
+
+const (
+	// StoreType = "b2" this is used to define the storage type to create
+	// from cloudstorage.NewStore(config)
+	StoreType = "b2"
+
+	// Configuration Keys.  These are the names of keys
+	// to look for in the json map[string]string to extract for config.
+
+	// ConfKeyKeyID config key name of the b2 application key id
+	ConfKeyKeyID = "key_id"
+	// ConfKeyApplicationKey config key name of the b2 application key
+	ConfKeyApplicationKey = "application_key"
+
+	// Authentication Source's
+
+	// AuthApplicationKey is for using b2 application key id/key pairs
+	AuthApplicationKey cloudstorage.AuthMethod = "b2_application_key"
+)
+
+var (
+	// ErrNoRegion error for no config.Region, ie "us-west-004" of the
+	// endpoint of the bucket.
+	ErrNoRegion = fmt.Errorf("no config.region of the b2 bucket")
+	// ErrNoKeyID error for no key_id
+	ErrNoKeyID = fmt.Errorf("no settings.key_id")
+	// ErrNoApplicationKey error for no application_key
+	ErrNoApplicationKey = fmt.Errorf("no settings.application_key")
+	// ErrNoAuth error for no findable auth
+	ErrNoAuth = fmt.Errorf("No auth provided")
+
+	_ cloudstorage.StoreCopy           = (*Store)(nil)
+	_ cloudstorage.StoreDeleteAll      = (*Store)(nil)
+	_ cloudstorage.StoreListLevel      = (*Store)(nil)
+	_ cloudstorage.StoreGetInline      = (*Store)(nil)
+	_ cloudstorage.StoreSignedURL      = (*Store)(nil)
+	_ cloudstorage.StoreUpdateMetadata = (*Store)(nil)
+)
+
+func init() {
+	// Register this Driver (b2) in cloudstorage driver registry.
+	cloudstorage.Register(StoreType, func(conf *cloudstorage.Config) (cloudstorage.Store, error) {
+		client, sess, err := NewClient(conf)
+		if err != nil {
+			return nil, err
+		}
+		return NewStore(client, sess, conf)
+	})
+}
+
+// Store is a Backblaze B2 bucket, through the S3 compatible api of its
+// region's endpoint https://s3.<region>.backblazeb2.com.  It is an s3 store
+// (its Settings are those of awss3) less S3 Batch Operations, which B2
+// hasn't, so bulk copies run in this process, see
+// cloudstorage.SubmitBulkCopy.
+type Store struct {
+	cloudstorage.Store
+	fs     *awss3.FS
+	bucket string
+}
+
+// s3Config of conf, the s3 store of the B2 endpoint.
+func s3Config(conf *cloudstorage.Config) (*cloudstorage.Config, error) {
+	if conf.AuthMethod != AuthApplicationKey {
+		return nil, ErrNoAuth
+	}
+	if conf.Region == "" {
+		return nil, ErrNoRegion
+	}
+	keyID := conf.Settings.String(ConfKeyKeyID)
+	if keyID == "" {
+		return nil, ErrNoKeyID
+	}
+	key := conf.Settings.String(ConfKeyApplicationKey)
+	if key == "" {
+		return nil, ErrNoApplicationKey
+	}
+	s3conf := *conf
+	s3conf.Type = awss3.StoreType
+	s3conf.AuthMethod = awss3.AuthAccessKey
+	s3conf.Settings = make(gou.JsonHelper, len(conf.Settings)+3)
+	for k, v := range conf.Settings {
+		s3conf.Settings[k] = v
+	}
+	s3conf.Settings[awss3.ConfKeyAccessKey] = keyID
+	s3conf.Settings[awss3.ConfKeyAccessSecret] = key
+	s3conf.Settings[awss3.ConfKeyEndpoint] = fmt.Sprintf("https://s3.%s.backblazeb2.com", conf.Region)
+	return &s3conf, nil
+}
+
+// NewClient create a new s3 client of the B2 endpoint of conf.Region.
+func NewClient(conf *cloudstorage.Config) (*s3.S3, *session.Session, error) {
+	s3conf, err := s3Config(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return awss3.NewClient(s3conf)
+}
+
+// NewStore Create B2 storage client of type cloudstorage.Store
+func NewStore(c *s3.S3, sess *session.Session, conf *cloudstorage.Config) (*Store, error) {
+	s3conf, err := s3Config(conf)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := awss3.NewStore(c, sess, s3conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Store: fs, fs: fs, bucket: conf.Bucket}, nil
+}
+
+// Type of store = "b2"
+func (s *Store) Type() string {
+	return StoreType
+}
+
+func (s *Store) String() string {
+	return fmt.Sprintf("b2://%s/", s.bucket)
+}
+
+// Copy from src to des with a server side copy.
+func (s *Store) Copy(ctx context.Context, src, des cloudstorage.Object) error {
+	return s.fs.Copy(ctx, src, des)
+}
+
+// DeleteAll names with batched deletes.
+func (s *Store) DeleteAll(ctx context.Context, names []string) []error {
+	return s.fs.DeleteAll(ctx, names)
+}
+
+// ListLevel the objects and folders directly under prefix.
+func (s *Store) ListLevel(ctx context.Context, prefix string, limit int) (cloudstorage.Objects, []string, error) {
+	return s.fs.ListLevel(ctx, prefix, limit)
+}
+
+// GetInline gets object o, and its contents if it is smaller than threshold.
+func (s *Store) GetInline(ctx context.Context, o string, threshold int64) (cloudstorage.Object, []byte, error) {
+	return s.fs.GetInline(ctx, o, threshold)
+}
+
+// SignedURL of object name, a presigned url of the B2 endpoint.
+func (s *Store) SignedURL(ctx context.Context, name string, opts cloudstorage.SignedURLOptions) (string, error) {
+	return s.fs.SignedURL(ctx, name, opts)
+}
+
+// UpdateMetadata replaces the metadata of object o.
+func (s *Store) UpdateMetadata(ctx context.Context, o string, metadata map[string]string) error {
+	return s.fs.UpdateMetadata(ctx, o, metadata)
+}
@@ -0,0 +1,85 @@
+package b2_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/araddon/gou"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/b2"
+	"github.com/lytics/cloudstorage/testutils"
+)
+
+/*
+
+# to use b2 tests ensure you have exported
+
+export B2_KEY_ID="aaa"
+export B2_APPLICATION_KEY="bbb"
+export B2_REGION="us-west-004"
+export B2_BUCKET="cloudstorageunittests"
+
+*/
+
+func TestConfig(t *testing.T) {
+	conf := &cloudstorage.Config{
+		Type:     b2.StoreType,
+		Bucket:   "bucket",
+		TmpDir:   "/tmp/localcache/b2",
+		Settings: make(gou.JsonHelper),
+	}
+	_, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, b2.ErrNoAuth, err)
+
+	conf.AuthMethod = b2.AuthApplicationKey
+	_, err = cloudstorage.NewStore(conf)
+	assert.Equal(t, b2.ErrNoRegion, err)
+
+	conf.Region = "us-west-004"
+	_, err = cloudstorage.NewStore(conf)
+	assert.Equal(t, b2.ErrNoKeyID, err)
+
+	conf.Settings[b2.ConfKeyKeyID] = "id"
+	_, err = cloudstorage.NewStore(conf)
+	assert.Equal(t, b2.ErrNoApplicationKey, err)
+
+	conf.Settings[b2.ConfKeyApplicationKey] = "key"
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, b2.StoreType, store.Type())
+	assert.Equal(t, "b2://bucket/", store.String())
+	// batch operations aren't promoted from the s3 store.
+	_, ok := store.(cloudstorage.StoreBulkCopy)
+	assert.False(t, ok)
+
+	url, err := cloudstorage.SignedURL(context.Background(), store, "a.csv", cloudstorage.SignedURLOptions{})
+	assert.Equal(t, nil, err)
+	assert.Contains(t, url, "https://bucket.s3.us-west-004.backblazeb2.com/a.csv")
+}
+
+func TestAll(t *testing.T) {
+	if os.Getenv("B2_KEY_ID") == "" || os.Getenv("B2_APPLICATION_KEY") == "" {
+		t.Logf("No b2 credentials, skipping")
+		t.Skip()
+		return
+	}
+	conf := &cloudstorage.Config{
+		Type:       b2.StoreType,
+		AuthMethod: b2.AuthApplicationKey,
+		Bucket:     os.Getenv("B2_BUCKET"),
+		Region:     os.Getenv("B2_REGION"),
+		TmpDir:     "/tmp/localcache/b2",
+		Settings: gou.JsonHelper{
+			b2.ConfKeyKeyID:          os.Getenv("B2_KEY_ID"),
+			b2.ConfKeyApplicationKey: os.Getenv("B2_APPLICATION_KEY"),
+		},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	if err != nil {
+		t.Fatalf("Could not create b2 store %v", err)
+	}
+	testutils.RunTests(t, store, conf)
+}
@@ -0,0 +1,565 @@
+package cloudstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// DefaultCopyConcurrency is the number of copies CopyPrefix runs at once
+// when CopyOptions.Concurrency isn't set.
+var DefaultCopyConcurrency = 8
+
+// DefaultBulkConcurrency is the number of requests GetAll and StatAll run at
+// once when BulkOptions.Concurrency isn't set.
+var DefaultBulkConcurrency = 8
+
+// ExistsListRatio is how many objects ExistsAll lists per name before
+// checking the names it hasn't found with Get instead.
+var ExistsListRatio = 100
+
+// BulkOptions are options for GetAll and StatAll.
+type BulkOptions struct {
+	// Concurrency is the number of objects fetched at once, defaults to
+	// DefaultBulkConcurrency.
+	Concurrency int
+	// IgnoreNotFound leaves names that don't exist out of the errors, they
+	// are returned as missing instead, so absent objects can be told apart
+	// from failed requests.
+	IgnoreNotFound bool
+}
+
+// CopyOptions are options for CopyPrefix.
+type CopyOptions struct {
+	// Concurrency is the number of objects copied at once, defaults to
+	// DefaultCopyConcurrency.
+	Concurrency int
+	// SkipExisting doesn't copy objects whose destination already exists, so
+	// a CopyPrefix that failed part way can be run again to finish it.
+	SkipExisting bool
+	// ComputeChecksum computes a sha256 of each object as it is copied and
+	// stores it in the copy's metadata under ChecksumSHA256Key, see
+	// CopyWithChecksum.  Server side copies are skipped for streamed ones.
+	ComputeChecksum bool
+}
+
+// CopyPrefix copies every object under srcPrefix to the same name under
+// dstPrefix, ie "raw/a.csv" to "archive/2024/a.csv" for a srcPrefix of "raw/"
+// and dstPrefix of "archive/2024/".  Copies use the store's server side copy
+// if it has one (see Copy) and keep the source metadata, including content
+// type.  The source objects are left in place.  Returns the number of objects
+// copied, on error the copies made so far are counted.
+func CopyPrefix(ctx context.Context, s Store, srcPrefix, dstPrefix string, opts *CopyOptions) (int, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	workers := opts.Concurrency
+	if workers < 1 {
+		workers = DefaultCopyConcurrency
+	}
+
+	iter, err := s.Objects(ctx, NewQuery(srcPrefix))
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var copied int64
+	srcs := make(chan Object)
+	errs := make(chan error, workers+1)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range srcs {
+				dstName := dstPrefix + strings.TrimPrefix(src.Name(), srcPrefix)
+				ok, err := copyObject(ctx, s, src, dstName, opts)
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				if ok {
+					atomic.AddInt64(&copied, 1)
+				}
+			}
+		}()
+	}
+
+	// a dstPrefix inside srcPrefix would otherwise list the copies too.
+	nested := strings.HasPrefix(dstPrefix, srcPrefix)
+produce:
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			errs <- err
+			break
+		}
+		if nested && strings.HasPrefix(o.Name(), dstPrefix) {
+			continue
+		}
+		select {
+		case srcs <- o:
+		case <-ctx.Done():
+			break produce
+		}
+	}
+	close(srcs)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return int(copied), err
+	default:
+	}
+	return int(copied), ctx.Err()
+}
+
+// CopyByName copies object src to dst in s, server side for the stores with
+// a copy (GCS, S3 and Azure, see StoreCopy) and streamed through this
+// process for the others (localfs, sftp), keeping the content type and
+// metadata of src.  dst is replaced if it exists.  Returns ErrObjectNotFound
+// if src doesn't exist.  It can't be a method of Store, as Copy there is
+// already StoreCopy's copy between objects.
+func CopyByName(ctx context.Context, s Store, src, dst string) error {
+	obj, err := s.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	_, err = copyObject(ctx, s, obj, dst, &CopyOptions{})
+	return err
+}
+
+// copyObject copies src to dstName in s, false if it was skipped as existing.
+func copyObject(ctx context.Context, s Store, src Object, dstName string, opts *CopyOptions) (bool, error) {
+	dst, err := s.NewObject(dstName)
+	if err == ErrObjectExists {
+		if opts.SkipExisting {
+			return false, nil
+		}
+		dst, err = s.Get(ctx, dstName)
+	}
+	if err != nil {
+		return false, err
+	}
+	if opts.ComputeChecksum {
+		err = CopyWithChecksum(ctx, s, src, dst)
+	} else {
+		err = Copy(ctx, s, src, dst)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CopyWithChecksum copies src to des like Copy, computing a sha256 of the
+// bytes copied and storing it hex encoded in the metadata of des under
+// ChecksumSHA256Key.  It always streams the bytes, even for stores with a
+// server side copy, as they have to be read to be hashed.  The source is
+// read once into a local temp file while hashing, then written to des.
+func CopyWithChecksum(ctx context.Context, s Store, src, des Object) error {
+	fin, err := s.NewReaderWithContext(ctx, src.Name())
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+
+	tmp, err := ioutil.TempFile("", "cloudstorage-copy")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), fin); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	md := make(map[string]string, len(src.MetaData())+1)
+	for k, v := range src.MetaData() {
+		md[k] = v
+	}
+	md[ChecksumSHA256Key] = hex.EncodeToString(h.Sum(nil))
+
+	fout, err := s.NewWriterWithContext(ctx, des.Name(), md)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fout, tmp); err != nil {
+		fout.Close()
+		return err
+	}
+	return fout.Close()
+}
+
+// CopyTransform copies object src to dst in s streaming its bytes through
+// transform, ie to convert line endings or strip a header, with bounded
+// memory.  The metadata of src is copied less its checksums
+// (ChecksumSHA256Key, ChecksumCRC32CKey), which the transform invalidates,
+// and with opts.DetectContentType less its content type, so it is detected
+// from the transformed bytes.  opts are the options of the write of dst and
+// may be nil.  dst is written as Upload does, so a failed copy leaves an
+// existing dst as it was.
+func CopyTransform(ctx context.Context, s Store, src, dst string, transform func(io.Reader) io.Reader, opts *WriteOptions) error {
+	if src == dst {
+		return fmt.Errorf("can't transform %q in place", src)
+	}
+	obj, err := s.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	md := make(map[string]string, len(obj.MetaData()))
+	for k, v := range obj.MetaData() {
+		md[k] = v
+	}
+	delete(md, ChecksumSHA256Key)
+	delete(md, ChecksumCRC32CKey)
+	var wopts []Opts
+	if opts != nil {
+		wopts = []Opts{*opts}
+		if opts.DetectContentType {
+			delete(md, ContentTypeKey)
+		}
+	}
+
+	rc, err := s.NewReaderWithContext(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	wc, err := newStagedWriter(ctx, s, dst, md, wopts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writerOnly{wc}, transform(rc)); err != nil {
+		wc.abort()
+		return err
+	}
+	return wc.Close()
+}
+
+// PrefixSize is the total bytes and count of the objects of q in s, summed
+// from the sizes of the listing without reading the objects.  q mustn't be
+// NamesOnly, as its sizes are unknown.
+func PrefixSize(ctx context.Context, s Store, q Query) (int64, int, error) {
+	iter, err := s.Objects(ctx, q)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer iter.Close()
+	var size int64
+	count := 0
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			return size, count, nil
+		} else if err != nil {
+			return 0, 0, err
+		}
+		size += o.Size()
+		count++
+	}
+}
+
+// StatAll gets the objects (without their contents) of names from s.  Names
+// whose Get fails are in errs, including ErrObjectNotFound unless
+// opts.IgnoreNotFound, which puts them in missing.
+func StatAll(ctx context.Context, s Store, names []string, opts *BulkOptions) (objects map[string]Object, errs map[string]error, missing []string) {
+	objects = make(map[string]Object, len(names))
+	mu := sync.Mutex{}
+	errs, missing = bulkDo(ctx, names, opts, func(name string) error {
+		o, err := s.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		objects[name] = o
+		mu.Unlock()
+		return nil
+	})
+	return objects, errs, missing
+}
+
+// GetAll reads the contents of names from s, errs and missing are as for
+// StatAll.
+func GetAll(ctx context.Context, s Store, names []string, opts *BulkOptions) (contents map[string][]byte, errs map[string]error, missing []string) {
+	contents = make(map[string][]byte, len(names))
+	mu := sync.Mutex{}
+	errs, missing = bulkDo(ctx, names, opts, func(name string) error {
+		rc, err := s.NewReaderWithContext(ctx, name)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		contents[name] = b
+		mu.Unlock()
+		return nil
+	})
+	return contents, errs, missing
+}
+
+// ExistsAll is whether each of names exists in s, ie to skip the objects of a
+// batch write that an earlier run already wrote.  If the names share a folder
+// it is listed, as a page of the listing costs about the same as a single
+// Get, up to ExistsListRatio objects per name so a large folder isn't listed
+// for a few names.  The names the listing didn't answer are checked with
+// DefaultBulkConcurrency Gets at once.  If some checks fail the names that
+// could be checked are returned with the error, the failed ones are left out
+// of the map.
+func ExistsAll(ctx context.Context, s Store, names []string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(names))
+	unknown := names
+	if folder := commonFolder(names); folder != "" && len(names) > 1 {
+		unknown = existsByListing(ctx, s, folder, names, exists)
+	}
+	if len(unknown) == 0 {
+		return exists, nil
+	}
+
+	mu := sync.Mutex{}
+	errs, missing := bulkDo(ctx, unknown, &BulkOptions{IgnoreNotFound: true}, func(name string) error {
+		if _, err := s.Get(ctx, name); err != nil {
+			return err
+		}
+		mu.Lock()
+		exists[name] = true
+		mu.Unlock()
+		return nil
+	})
+	for _, name := range missing {
+		exists[name] = false
+	}
+	if len(errs) > 0 {
+		failed := make([]string, 0, len(errs))
+		for name := range errs {
+			failed = append(failed, name)
+		}
+		sort.Strings(failed)
+		return exists, fmt.Errorf("%d of %d exists checks failed, %q: %v", len(errs), len(names), failed[0], errs[failed[0]])
+	}
+	return exists, nil
+}
+
+// DeleteAll deletes the objects of names from s, ie to clear a prefix of
+// thousands of objects.  Stores with a batch delete (S3, see StoreDeleteAll)
+// delete up to a thousand objects per request, for the others
+// DefaultBulkConcurrency Deletes are run at once.  Deletes with opts (ie
+// WaitConsistent) are always Deletes of each name, as the batch deletes take
+// no options.  A failed delete doesn't stop the others: errs has the error
+// of each name by index, nil for the objects deleted and those that didn't
+// exist, and err is set if any failed.
+func DeleteAll(ctx context.Context, s Store, names []string, opts ...DeleteOptions) (errs []error, err error) {
+	if bd, ok := s.(StoreDeleteAll); ok && len(opts) == 0 {
+		errs = bd.DeleteAll(ctx, names)
+	} else {
+		byName, _ := bulkDo(ctx, names, &BulkOptions{IgnoreNotFound: true}, func(name string) error {
+			return s.Delete(ctx, name, opts...)
+		})
+		errs = make([]error, len(names))
+		for i, name := range names {
+			errs[i] = byName[name]
+		}
+	}
+	failed, first := 0, -1
+	for i, err := range errs {
+		if err != nil {
+			if first < 0 {
+				first = i
+			}
+			failed++
+		}
+	}
+	if failed == 0 {
+		return errs, nil
+	}
+	return errs, fmt.Errorf("%d of %d deletes failed, %q: %v", failed, len(names), names[first], errs[first])
+}
+
+// deletePrefixBatch is the number of listed names DeletePrefix deletes at a
+// time, an S3 DeleteObjects request.
+const deletePrefixBatch = 1000
+
+// DeletePrefix deletes every object under prefix in s, including hidden
+// ones, returning the number deleted.  The prefix is listed a page at a time
+// and the names of each batch deleted with DeleteAll before listing on, so
+// a prefix of millions of objects isn't held in memory.  It stops at the
+// first batch with a failed delete, or once ctx is done, returning the error
+// with the count deleted so far.  opts are those of each delete, see
+// DeleteAll.
+func DeletePrefix(ctx context.Context, s Store, prefix string, opts ...DeleteOptions) (int, error) {
+	q := NewQuery(prefix)
+	q.ShowHidden = true
+	iter, err := s.Objects(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	deleted := 0
+	names := make([]string, 0, deletePrefixBatch)
+	deleteBatch := func() error {
+		errs, err := DeleteAll(ctx, s, names, opts...)
+		for _, err := range errs {
+			if err == nil {
+				deleted++
+			}
+		}
+		names = names[:0]
+		return err
+	}
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return deleted, err
+		}
+		if names = append(names, o.Name()); len(names) == deletePrefixBatch {
+			if err := deleteBatch(); err != nil {
+				return deleted, err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+	}
+	if err := deleteBatch(); err != nil {
+		return deleted, err
+	}
+	return deleted, nil
+}
+
+// existsByListing lists folder in s setting the names found in exists, and
+// if the listing finished those not found to false.  Returns the names that
+// are still unknown, all of them if the listing fails.
+func existsByListing(ctx context.Context, s Store, folder string, names []string, exists map[string]bool) []string {
+	want := make(map[string]bool, len(names))
+	for _, name := range names {
+		want[name] = true
+	}
+
+	q := NewQuery(folder)
+	q.ShowHidden = true
+	iter, err := s.Objects(ctx, q)
+	if err != nil {
+		return names
+	}
+	defer iter.Close()
+
+	found := 0
+	for listed := 0; found < len(want) && listed < ExistsListRatio*len(names); listed++ {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			// listed in full, the names not found don't exist.
+			for name := range want {
+				if !exists[name] {
+					exists[name] = false
+				}
+			}
+			return nil
+		} else if err != nil {
+			break
+		}
+		if want[o.Name()] && !exists[o.Name()] {
+			exists[o.Name()] = true
+			found++
+		}
+	}
+
+	var unknown []string
+	for _, name := range names {
+		if _, ok := exists[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+// commonFolder is the longest folder, ending in "/", that all names are in,
+// "" if there isn't one.
+func commonFolder(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	prefix := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+// bulkDo runs fn for each name on opts.Concurrency workers, collecting the
+// errors by name.
+func bulkDo(ctx context.Context, names []string, opts *BulkOptions, fn func(name string) error) (map[string]error, []string) {
+	if opts == nil {
+		opts = &BulkOptions{}
+	}
+	workers := opts.Concurrency
+	if workers < 1 {
+		workers = DefaultBulkConcurrency
+	}
+
+	errs := make(map[string]error)
+	var missing []string
+	mu := sync.Mutex{}
+	work := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				err := ctx.Err()
+				if err == nil {
+					err = fn(name)
+				}
+				if err == nil {
+					continue
+				}
+				mu.Lock()
+				if err == ErrObjectNotFound && opts.IgnoreNotFound {
+					missing = append(missing, name)
+				} else {
+					errs[name] = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+	sort.Strings(missing)
+	return errs, missing
+}
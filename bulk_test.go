@@ -0,0 +1,317 @@
+package cloudstorage_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestCopyPrefix(t *testing.T) {
+	store := newLocalStore(t, "copyprefix")
+	ctx := context.Background()
+	for _, name := range []string{"raw/a.csv", "raw/b.csv", "raw/sub/c.csv", "other/d.csv"} {
+		w, err := store.NewWriterWithContext(ctx, name, map[string]string{"src": name})
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(name))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+	}
+
+	n, err := cloudstorage.CopyPrefix(ctx, store, "raw/", "archive/2024/", &cloudstorage.CopyOptions{Concurrency: 2})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "raw/sub/c.csv", readAll(t, store, "archive/2024/sub/c.csv"))
+	obj, err := store.Get(ctx, "archive/2024/a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "raw/a.csv", obj.MetaData()["src"])
+
+	// sources are kept
+	_, err = store.Get(ctx, "raw/a.csv")
+	assert.Equal(t, nil, err)
+
+	n, err = cloudstorage.CopyPrefix(ctx, store, "raw/", "archive/2024/", &cloudstorage.CopyOptions{SkipExisting: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, n)
+
+	// copying into the source prefix doesn't copy the copies
+	n, err = cloudstorage.CopyPrefix(ctx, store, "raw/", "raw/copy/", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, n)
+	resp, err := store.List(ctx, cloudstorage.NewQuery("raw/"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 6, len(resp.Objects))
+}
+
+func TestCopyPrefixComputeChecksum(t *testing.T) {
+	store := newLocalStore(t, "copychecksum")
+	ctx := context.Background()
+	w, err := store.NewWriterWithContext(ctx, "raw/a.csv", map[string]string{"src": "raw/a.csv"})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("hello"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	n, err := cloudstorage.CopyPrefix(ctx, store, "raw/", "sums/", &cloudstorage.CopyOptions{ComputeChecksum: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, n)
+
+	obj, err := store.Get(ctx, "sums/a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "raw/a.csv", obj.MetaData()["src"])
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", obj.MetaData()[cloudstorage.ChecksumSHA256Key])
+
+	// the stored checksum verifies reads of the copy
+	rc, err := store.NewReaderWithContext(ctx, "sums/a.csv", cloudstorage.ReadOptions{VerifyChecksum: true})
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(rc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(b))
+	rc.Close()
+}
+
+func TestGetAll(t *testing.T) {
+	store := newLocalStore(t, "getall")
+	ctx := context.Background()
+	for _, name := range []string{"a.csv", "b.csv", "c.csv"} {
+		w, err := store.NewWriterWithContext(ctx, name, nil)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(name))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+	}
+	names := []string{"a.csv", "b.csv", "c.csv", "x.csv", "y.csv"}
+
+	contents, errs, missing := cloudstorage.GetAll(ctx, store, names, &cloudstorage.BulkOptions{Concurrency: 2})
+	assert.Equal(t, 3, len(contents))
+	assert.Equal(t, "b.csv", string(contents["b.csv"]))
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, errs["x.csv"])
+	assert.Equal(t, 0, len(missing))
+
+	objects, errs, missing := cloudstorage.StatAll(ctx, store, names, &cloudstorage.BulkOptions{IgnoreNotFound: true})
+	assert.Equal(t, 3, len(objects))
+	assert.Equal(t, "c.csv", objects["c.csv"].Name())
+	assert.Equal(t, 0, len(errs))
+	assert.Equal(t, []string{"x.csv", "y.csv"}, missing)
+}
+
+func TestExistsAll(t *testing.T) {
+	local := newLocalStore(t, "existsall")
+	store := &outageStore{Store: local}
+	ctx := context.Background()
+	for _, name := range []string{"batch/a.csv", "batch/b.csv", "other/c.csv"} {
+		writeObject(t, local, name, name)
+	}
+
+	// listed, the names share the batch/ folder.
+	exists, err := cloudstorage.ExistsAll(ctx, store, []string{"batch/a.csv", "batch/b.csv", "batch/x.csv"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]bool{"batch/a.csv": true, "batch/b.csv": true, "batch/x.csv": false}, exists)
+	assert.Equal(t, int32(0), store.gets)
+
+	// checked with Get.
+	exists, err = cloudstorage.ExistsAll(ctx, store, []string{"batch/a.csv", "other/c.csv", "y.csv"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]bool{"batch/a.csv": true, "other/c.csv": true, "y.csv": false}, exists)
+	assert.Equal(t, int32(3), store.gets)
+
+	// the listing stops early, the rest are checked with Get.
+	ratio := cloudstorage.ExistsListRatio
+	cloudstorage.ExistsListRatio = 0
+	defer func() { cloudstorage.ExistsListRatio = ratio }()
+	exists, err = cloudstorage.ExistsAll(ctx, store, []string{"batch/b.csv", "batch/x.csv"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[string]bool{"batch/b.csv": true, "batch/x.csv": false}, exists)
+	assert.Equal(t, int32(5), store.gets)
+}
+
+// getFailingStore fails the Gets of name.
+type getFailingStore struct {
+	cloudstorage.Store
+	name string
+}
+
+func (s *getFailingStore) Get(ctx context.Context, name string) (cloudstorage.Object, error) {
+	if name == s.name {
+		return nil, fmt.Errorf("store unavailable")
+	}
+	return s.Store.Get(ctx, name)
+}
+
+func TestExistsAllPartial(t *testing.T) {
+	local := newLocalStore(t, "existsall_partial")
+	writeObject(t, local, "a.csv", "a")
+	store := &getFailingStore{Store: local, name: "b.csv"}
+
+	exists, err := cloudstorage.ExistsAll(context.Background(), store, []string{"a.csv", "b.csv", "c.csv"})
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, map[string]bool{"a.csv": true, "c.csv": false}, exists)
+}
+
+// failAfter fails reads once n bytes are read.
+type failAfter struct {
+	r io.Reader
+	n int
+}
+
+func (f *failAfter) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, fmt.Errorf("transform failed")
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= n
+	return n, err
+}
+
+func TestCopyTransform(t *testing.T) {
+	store := newLocalStore(t, "copytransform")
+	ctx := context.Background()
+	w, err := store.NewWriterWithContext(ctx, "a.csv", map[string]string{"owner": "a", cloudstorage.ChecksumSHA256Key: "00"})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b\r\nc,d\r\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	crlf := func(r io.Reader) io.Reader {
+		b, _ := ioutil.ReadAll(r)
+		return bytes.NewReader(bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1))
+	}
+	assert.Equal(t, nil, cloudstorage.CopyTransform(ctx, store, "a.csv", "b.csv", crlf, nil))
+	assert.Equal(t, "a,b\nc,d\n", readAll(t, store, "b.csv"))
+	o, err := store.Get(ctx, "b.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a", o.MetaData()["owner"])
+	assert.Equal(t, "", o.MetaData()[cloudstorage.ChecksumSHA256Key])
+
+	// a failed transform doesn't leave a partial copy.
+	failing := func(r io.Reader) io.Reader { return &failAfter{r: r, n: 4} }
+	assert.NotEqual(t, nil, cloudstorage.CopyTransform(ctx, store, "a.csv", "c.csv", failing, nil))
+	_, err = store.Get(ctx, "c.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// a failed copy over an object leaves it as it was.
+	assert.NotEqual(t, nil, cloudstorage.CopyTransform(ctx, store, "a.csv", "b.csv", failing, nil))
+	assert.Equal(t, "a,b\nc,d\n", readAll(t, store, "b.csv"))
+
+	assert.NotEqual(t, nil, cloudstorage.CopyTransform(ctx, store, "a.csv", "a.csv", crlf, nil))
+}
+
+func TestCopyByName(t *testing.T) {
+	store := newLocalStore(t, "copybyname")
+	ctx := context.Background()
+	w, err := store.NewWriterWithContext(ctx, "a.json", map[string]string{"owner": "a", cloudstorage.ContentTypeKey: "application/json"})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte(`{"a":1}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	assert.Equal(t, nil, cloudstorage.CopyByName(ctx, store, "a.json", "copies/a.json"))
+	assert.Equal(t, `{"a":1}`, readAll(t, store, "copies/a.json"))
+	o, err := store.Get(ctx, "copies/a.json")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a", o.MetaData()["owner"])
+	assert.Equal(t, "application/json", o.MetaData()[cloudstorage.ContentTypeKey])
+
+	// replaces an existing destination.
+	writeObject(t, store, "b.json", `{"b":2}`)
+	assert.Equal(t, nil, cloudstorage.CopyByName(ctx, store, "b.json", "copies/a.json"))
+	assert.Equal(t, `{"b":2}`, readAll(t, store, "copies/a.json"))
+
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, cloudstorage.CopyByName(ctx, store, "missing.json", "copies/b.json"))
+}
+
+// deleteFailingStore fails the Deletes of name.
+type deleteFailingStore struct {
+	cloudstorage.Store
+	name string
+}
+
+func (s *deleteFailingStore) Delete(ctx context.Context, name string, opts ...cloudstorage.DeleteOptions) error {
+	if name == s.name {
+		return fmt.Errorf("store unavailable")
+	}
+	return s.Store.Delete(ctx, name, opts...)
+}
+
+func TestDeleteAll(t *testing.T) {
+	store := newLocalStore(t, "deleteall")
+	ctx := context.Background()
+	names := []string{"logs/a.csv", "logs/b.csv", "logs/c.csv"}
+	for _, name := range names {
+		writeObject(t, store, name, name)
+	}
+
+	// names that don't exist aren't errors.
+	errs, err := cloudstorage.DeleteAll(ctx, store, []string{"logs/a.csv", "logs/b.csv", "logs/missing.csv"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	_, err = store.Get(ctx, "logs/a.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// a failed delete doesn't stop the others.
+	writeObject(t, store, "logs/a.csv", "a")
+	failing := &deleteFailingStore{Store: store, name: "logs/b.csv"}
+	writeObject(t, store, "logs/b.csv", "b")
+	errs, err = cloudstorage.DeleteAll(ctx, failing, names)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 3, len(errs))
+	assert.Equal(t, nil, errs[0])
+	assert.NotEqual(t, nil, errs[1])
+	assert.Equal(t, nil, errs[2])
+	resp, err := store.List(ctx, cloudstorage.NewQuery("logs/"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(resp.Objects))
+	assert.Equal(t, "logs/b.csv", resp.Objects[0].Name())
+}
+
+// deleteOptsStore records the options of each Delete.
+type deleteOptsStore struct {
+	cloudstorage.Store
+	opts []cloudstorage.DeleteOptions
+}
+
+func (s *deleteOptsStore) Delete(ctx context.Context, name string, opts ...cloudstorage.DeleteOptions) error {
+	s.opts = append(s.opts, opts...)
+	return s.Store.Delete(ctx, name, opts...)
+}
+
+func TestDeletePrefix(t *testing.T) {
+	store := newLocalStore(t, "deleteprefix")
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		writeObject(t, store, fmt.Sprintf("tmp/%d.csv", i), "x")
+	}
+	writeObject(t, store, "tmp/sub/.hidden", "x")
+	writeObject(t, store, "keep/a.csv", "x")
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	n, err := cloudstorage.DeletePrefix(cctx, store, "tmp/")
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, n)
+
+	n, err = cloudstorage.DeletePrefix(ctx, store, "tmp/")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 6, n)
+	resp, err := store.List(ctx, cloudstorage.NewQueryAll())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(resp.Objects))
+	assert.Equal(t, "keep/a.csv", resp.Objects[0].Name())
+
+	// the options are those of each delete.
+	recording := &deleteOptsStore{Store: store}
+	n, err = cloudstorage.DeletePrefix(ctx, recording, "keep/", cloudstorage.DeleteOptions{WaitConsistent: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []cloudstorage.DeleteOptions{{WaitConsistent: true}}, recording.opts)
+}
@@ -0,0 +1,108 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// JobID identifies a bulk copy job, see SubmitBulkCopy.
+type JobID string
+
+// BulkJob is the status of a bulk copy job.
+type BulkJob struct {
+	ID JobID
+	// Done once the job has finished, successfully or not.
+	Done bool
+	// Copied and Failed are the objects copied, and that failed to copy, so
+	// far, where the provider reports them.
+	Copied int64
+	Failed int64
+	// Err is why the job failed, once Done.
+	Err error
+}
+
+// localJobPrefix is the prefix of the ids of jobs run in this process.
+const localJobPrefix = "local-"
+
+// LocalJobRetention is how long the status of a finished job run in this
+// process is kept for BulkJobStatus, after which it is ErrJobNotFound.
+var LocalJobRetention = time.Hour
+
+var localJobs = struct {
+	sync.Mutex
+	n    int
+	jobs map[JobID]*localJob
+}{jobs: make(map[JobID]*localJob)}
+
+type localJob struct {
+	BulkJob
+	finished time.Time
+}
+
+// pruneLocalJobs removes the jobs finished more than LocalJobRetention ago,
+// with localJobs locked.
+func pruneLocalJobs() {
+	for id, job := range localJobs.jobs {
+		if job.Done && time.Since(job.finished) > LocalJobRetention {
+			delete(localJobs.jobs, id)
+		}
+	}
+}
+
+// SubmitBulkCopy starts a job copying the objects under srcPrefix in s to
+// dstPrefix, whose progress is polled with BulkJobStatus.  The copies are
+// named as for CopyPrefix, srcPrefix replaced by dstPrefix.  Stores with a
+// provider managed batch service (see StoreBulkCopy) submit the job to it,
+// so it runs without this process.  Other stores, and those whose service
+// can't name the copies so (returning ErrFeatureNotSupported), run a
+// CopyPrefix in this process, cancelled with ctx.
+func SubmitBulkCopy(ctx context.Context, s Store, srcPrefix, dstPrefix string) (JobID, error) {
+	if bc, ok := s.(StoreBulkCopy); ok {
+		id, err := bc.SubmitBulkCopy(ctx, srcPrefix, dstPrefix)
+		if err != ErrFeatureNotSupported {
+			return id, err
+		}
+	}
+
+	localJobs.Lock()
+	pruneLocalJobs()
+	localJobs.n++
+	job := &localJob{BulkJob: BulkJob{ID: JobID(fmt.Sprintf("%s%d", localJobPrefix, localJobs.n))}}
+	localJobs.jobs[job.ID] = job
+	localJobs.Unlock()
+
+	go func() {
+		n, err := CopyPrefix(ctx, s, srcPrefix, dstPrefix, nil)
+		localJobs.Lock()
+		defer localJobs.Unlock()
+		job.Done = true
+		job.Copied = int64(n)
+		job.Err = err
+		job.finished = time.Now()
+	}()
+	return job.ID, nil
+}
+
+// BulkJobStatus is the status of the job id submitted by SubmitBulkCopy to s,
+// ErrJobNotFound if there isn't one.
+func BulkJobStatus(ctx context.Context, s Store, id JobID) (*BulkJob, error) {
+	if strings.HasPrefix(string(id), localJobPrefix) {
+		localJobs.Lock()
+		defer localJobs.Unlock()
+		pruneLocalJobs()
+		job, ok := localJobs.jobs[id]
+		if !ok {
+			return nil, ErrJobNotFound
+		}
+		status := job.BulkJob
+		return &status, nil
+	}
+	if bc, ok := s.(StoreBulkCopy); ok {
+		return bc.BulkJobStatus(ctx, id)
+	}
+	return nil, ErrJobNotFound
+}
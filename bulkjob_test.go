@@ -0,0 +1,83 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestSubmitBulkCopy(t *testing.T) {
+	store := newLocalStore(t, "bulkjob")
+	ctx := context.Background()
+	for _, name := range []string{"raw/a.csv", "raw/b/c.csv"} {
+		writeObject(t, store, name, name)
+	}
+
+	// localfs has no batch service, the copy runs here
+	id, err := cloudstorage.SubmitBulkCopy(ctx, store, "raw/", "archive/")
+	assert.Equal(t, nil, err)
+	var job *cloudstorage.BulkJob
+	for i := 0; i < 100; i++ {
+		job, err = cloudstorage.BulkJobStatus(ctx, store, id)
+		assert.Equal(t, nil, err)
+		if job.Done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, id, job.ID)
+	assert.True(t, job.Done)
+	assert.Equal(t, nil, job.Err)
+	assert.Equal(t, int64(2), job.Copied)
+	assert.Equal(t, "raw/b/c.csv", readAll(t, store, "archive/b/c.csv"))
+
+	_, err = cloudstorage.BulkJobStatus(ctx, store, "local-0")
+	assert.Equal(t, cloudstorage.ErrJobNotFound, err)
+
+	// finished jobs are kept for LocalJobRetention.
+	defer func(d time.Duration) { cloudstorage.LocalJobRetention = d }(cloudstorage.LocalJobRetention)
+	cloudstorage.LocalJobRetention = 0
+	time.Sleep(time.Millisecond)
+	_, err = cloudstorage.BulkJobStatus(ctx, store, id)
+	assert.Equal(t, cloudstorage.ErrJobNotFound, err)
+}
+
+// unnamingStore has a batch service that can't name the copies as
+// CopyPrefix does.
+type unnamingStore struct {
+	cloudstorage.Store
+}
+
+func (unnamingStore) SubmitBulkCopy(ctx context.Context, srcPrefix, dstPrefix string) (cloudstorage.JobID, error) {
+	return "", cloudstorage.ErrFeatureNotSupported
+}
+
+func (unnamingStore) BulkJobStatus(ctx context.Context, id cloudstorage.JobID) (*cloudstorage.BulkJob, error) {
+	return nil, cloudstorage.ErrJobNotFound
+}
+
+func TestSubmitBulkCopyUnsupported(t *testing.T) {
+	store := unnamingStore{newLocalStore(t, "bulkjob_unsupported")}
+	ctx := context.Background()
+	writeObject(t, store, "raw/a.csv", "a")
+
+	// runs here, with the copy named as for CopyPrefix
+	id, err := cloudstorage.SubmitBulkCopy(ctx, store, "raw/", "archive/")
+	assert.Equal(t, nil, err)
+	var job *cloudstorage.BulkJob
+	for i := 0; i < 100; i++ {
+		job, err = cloudstorage.BulkJobStatus(ctx, store, id)
+		assert.Equal(t, nil, err)
+		if job.Done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, job.Done)
+	assert.Equal(t, nil, job.Err)
+	assert.Equal(t, "a", readAll(t, store, "archive/a.csv"))
+}
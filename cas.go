@@ -0,0 +1,70 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"golang.org/x/net/context"
+)
+
+// CompareAndSwap writes new to the object name only if its current contents
+// are old, returning if the swap happened.  A nil old requires that the
+// object doesn't exist.  The write is conditional on the ETag the contents
+// were read at (Opts.IfMatch, or IfNotExists), so a concurrent change between
+// the read and the write isn't overwritten, the swap returns false.  It is
+// meant for small objects such as locks or leader records, the current
+// contents are read in full.
+func CompareAndSwap(ctx context.Context, s Store, name string, old, new []byte) (bool, error) {
+	opts := Opts{IfNotExists: true}
+	var metadata map[string]string
+	o, err := s.Get(ctx, name)
+	switch {
+	case err == ErrObjectNotFound:
+		if old != nil {
+			return false, nil
+		}
+	case err != nil:
+		return false, err
+	default:
+		if old == nil {
+			return false, nil
+		}
+		if o.ETag() == "" {
+			// without an etag the write can't be conditional.
+			return false, ErrFeatureNotSupported
+		}
+		rc, err := s.NewReaderWithContext(ctx, name)
+		if err == ErrObjectNotFound {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		current, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(current, old) {
+			return false, nil
+		}
+		opts = Opts{IfMatch: o.ETag()}
+		metadata = o.MetaData()
+	}
+
+	w, err := s.NewWriterWithContext(ctx, name, metadata, opts)
+	if err == ErrPreconditionFailed {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if _, err := w.Write(new); err != nil {
+		w.Close()
+		return false, err
+	}
+	if err := w.Close(); err == ErrPreconditionFailed {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
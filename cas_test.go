@@ -0,0 +1,43 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	store := newLocalStore(t, "cas")
+	ctx := context.Background()
+
+	// nil old creates the object only if it doesn't exist
+	swapped, err := cloudstorage.CompareAndSwap(ctx, store, "leader", nil, []byte("a"))
+	assert.Equal(t, nil, err)
+	assert.True(t, swapped)
+	swapped, err = cloudstorage.CompareAndSwap(ctx, store, "leader", nil, []byte("b"))
+	assert.Equal(t, nil, err)
+	assert.False(t, swapped)
+	assert.Equal(t, "a", readAll(t, store, "leader"))
+
+	swapped, err = cloudstorage.CompareAndSwap(ctx, store, "leader", []byte("x"), []byte("b"))
+	assert.Equal(t, nil, err)
+	assert.False(t, swapped)
+	swapped, err = cloudstorage.CompareAndSwap(ctx, store, "leader", []byte("a"), []byte("b"))
+	assert.Equal(t, nil, err)
+	assert.True(t, swapped)
+	assert.Equal(t, "b", readAll(t, store, "leader"))
+
+	swapped, err = cloudstorage.CompareAndSwap(ctx, store, "missing", []byte("a"), []byte("b"))
+	assert.Equal(t, nil, err)
+	assert.False(t, swapped)
+
+	// a write conditional on a stale etag fails
+	o, err := store.Get(ctx, "leader")
+	assert.Equal(t, nil, err)
+	writeObject(t, store, "leader", "changed")
+	_, err = store.NewWriterWithContext(ctx, "leader", nil, cloudstorage.Opts{IfMatch: o.ETag()})
+	assert.Equal(t, cloudstorage.ErrPreconditionFailed, err)
+}
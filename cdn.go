@@ -0,0 +1,144 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// CDNStore is a Store reading objects of a public bucket through a CDN in
+// front of it, ie for read heavy workloads served from the CDN's edges.
+// NewReader and PublicURL use the CDN, everything else (Get, List, writes,
+// and the downloads of Object.Open) goes to the wrapped store.  Object names
+// are appended to BaseURL, so it is the url of the bucket's root on the CDN.
+// The optional interfaces (StoreCopy, StoreListLevel, ...) are forwarded to
+// the package helpers of the wrapped store, so a store of NewStore with a
+// Config.ReadCDN has its fast paths.
+type CDNStore struct {
+	Store
+	// BaseURL of the bucket on the CDN, ie "https://cdn.example.com/bucket".
+	BaseURL string
+	// CacheBust appends the ETag of the object (or its Updated time if it
+	// has none) to its url as the "v" query param, so the CDN serves the
+	// current version rather than a cached older one.  It costs a Get of the
+	// wrapped store per read.
+	CacheBust bool
+	// HTTPClient the CDN is requested with, defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewCDNStore create a store reading s through the CDN at baseURL.
+func NewCDNStore(s Store, baseURL string) *CDNStore {
+	return &CDNStore{Store: s, BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// PublicURL is the CDN url of object name, with the cache busting param if
+// CacheBust.
+func (c *CDNStore) PublicURL(ctx context.Context, name string) (string, error) {
+	u := c.BaseURL + (&url.URL{Path: "/" + name}).EscapedPath()
+	if !c.CacheBust {
+		return u, nil
+	}
+	o, err := c.Store.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	v := o.ETag()
+	if v == "" {
+		v = strconv.FormatInt(o.Updated().UnixNano(), 10)
+	}
+	return u + "?v=" + url.QueryEscape(v), nil
+}
+
+// NewReader of an object from the CDN.
+func (c *CDNStore) NewReader(name string) (io.ReadCloser, error) {
+	return c.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of an object from the CDN.  Reads verifying their
+// checksum read from the wrapped store, which has the checksums.
+func (c *CDNStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	if len(opts) > 0 && opts[0].VerifyChecksum {
+		return c.Store.NewReaderWithContext(ctx, name, opts...)
+	}
+	u, err := c.PublicURL(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrObjectNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		resp.Body.Close()
+		return nil, fmt.Errorf("cdn read of %q failed: %s", name, resp.Status)
+	}
+	return MaxBytesReader(resp.Body, opts), nil
+}
+
+// Copy for StoreCopy, see Copy.
+func (c *CDNStore) Copy(ctx context.Context, src, des Object) error {
+	return Copy(ctx, c.Store, src, des)
+}
+
+// Move for StoreMove, see Move.
+func (c *CDNStore) Move(ctx context.Context, src, des Object) error {
+	return Move(ctx, c.Store, src, des)
+}
+
+// ListLevel for StoreListLevel, see ListLevel.
+func (c *CDNStore) ListLevel(ctx context.Context, prefix string, limit int) (Objects, []string, error) {
+	return ListLevel(ctx, c.Store, prefix, limit)
+}
+
+// SignedURL for StoreSignedURL, see SignedURL.
+func (c *CDNStore) SignedURL(ctx context.Context, name string, opts SignedURLOptions) (string, error) {
+	return SignedURL(ctx, c.Store, name, opts)
+}
+
+// SetStorageClass for StoreStorageClass, see SetStorageClass.
+func (c *CDNStore) SetStorageClass(ctx context.Context, o string, class string) error {
+	return SetStorageClass(ctx, c.Store, o, class)
+}
+
+// Restore for StoreRestore, see Restore.
+func (c *CDNStore) Restore(ctx context.Context, o string) error {
+	return Restore(ctx, c.Store, o)
+}
+
+// Restoring for StoreRestore, see Restoring.
+func (c *CDNStore) Restoring(ctx context.Context, o string) (bool, error) {
+	return Restoring(ctx, c.Store, o)
+}
+
+// DeleteAll for StoreDeleteAll, see DeleteAll.
+func (c *CDNStore) DeleteAll(ctx context.Context, names []string) []error {
+	errs, _ := DeleteAll(ctx, c.Store, names)
+	return errs
+}
+
+// KeySeparator for StoreSeparator, see Separator.
+func (c *CDNStore) KeySeparator() string {
+	return Separator(c.Store)
+}
+
+func (c *CDNStore) String() string {
+	return fmt.Sprintf("cdn(%s)", c.Store)
+}
@@ -0,0 +1,63 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestCDNStore(t *testing.T) {
+	origin := newLocalStore(t, "cdn")
+	ctx := context.Background()
+	writeObject(t, origin, "public/a b.csv", "a,b\n")
+
+	var requested []string
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.RequestURI())
+		rc, err := origin.NewReader(strings.TrimPrefix(r.URL.Path, "/bucket/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer rc.Close()
+		io.Copy(w, rc)
+	}))
+	defer cdn.Close()
+
+	store := cloudstorage.NewCDNStore(origin, cdn.URL+"/bucket/")
+	assert.Equal(t, "a,b\n", readAll(t, store, "public/a b.csv"))
+	assert.Equal(t, []string{"/bucket/public/a%20b.csv"}, requested)
+
+	_, err := store.NewReaderWithContext(ctx, "public/missing.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// the url changes with the object's version.
+	store.CacheBust = true
+	u, err := store.PublicURL(ctx, "public/a b.csv")
+	assert.Equal(t, nil, err)
+	o, err := origin.Get(ctx, "public/a b.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, cdn.URL+"/bucket/public/a%20b.csv?v="+o.ETag(), u)
+
+	// writes go to the origin.
+	writeObject(t, store, "public/c.csv", "c,d\n")
+	assert.Equal(t, "c,d\n", readAll(t, origin, "public/c.csv"))
+
+	// as do the optional interfaces of the origin.
+	var s cloudstorage.Store = store
+	_, ok := s.(cloudstorage.StoreMove)
+	assert.True(t, ok)
+	assert.Equal(t, nil, cloudstorage.MoveByName(ctx, s, "public/c.csv", "public/d.csv"))
+	assert.Equal(t, "c,d\n", readAll(t, origin, "public/d.csv"))
+	objs, folders, err := cloudstorage.ListLevel(ctx, s, "public/", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(objs))
+	assert.Equal(t, 0, len(folders))
+}
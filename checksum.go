@@ -0,0 +1,136 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// NewChecksumReader wraps rc so that the bytes read are hashed by h, once rc
+// returns io.EOF the sum is compared to expected and ErrChecksumMismatch is
+// returned in place of EOF if they differ.
+func NewChecksumReader(rc io.ReadCloser, h hash.Hash, expected []byte) io.ReadCloser {
+	return &checksumReader{rc: rc, h: h, expected: expected}
+}
+
+type checksumReader struct {
+	rc       io.ReadCloser
+	h        hash.Hash
+	expected []byte
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		r.h.Write(p[:n])
+	}
+	if err == io.EOF && !bytes.Equal(r.h.Sum(nil), r.expected) {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
+
+func (r *checksumReader) Close() error {
+	return r.rc.Close()
+}
+
+// NewChecksumWriter wraps wc so that the bytes written are hashed by h.  On
+// Close the sum is compared to expected, if they match wc is closed, if not
+// abort is called in place of Close so the write isn't committed and
+// ErrChecksumMismatch is returned.
+func NewChecksumWriter(wc io.WriteCloser, h hash.Hash, expected []byte, abort func() error) io.WriteCloser {
+	return &checksumWriter{wc: wc, h: h, expected: expected, abort: abort}
+}
+
+type checksumWriter struct {
+	wc       io.WriteCloser
+	h        hash.Hash
+	expected []byte
+	abort    func() error
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	n, err := w.wc.Write(p)
+	w.h.Write(p[:n])
+	return n, err
+}
+
+func (w *checksumWriter) Close() error {
+	if !bytes.Equal(w.h.Sum(nil), w.expected) {
+		if err := w.abort(); err != nil {
+			return err
+		}
+		return ErrChecksumMismatch
+	}
+	return w.wc.Close()
+}
+
+// ChecksumFromMetaData finds a checksum stored in the object metadata under
+// ChecksumSHA256Key or ChecksumCRC32CKey (hex encoded), returning a hash to
+// compute and the expected sum.  ok is false if there is no usable checksum.
+func ChecksumFromMetaData(md map[string]string) (h hash.Hash, expected []byte, ok bool) {
+	if v := md[ChecksumSHA256Key]; v != "" {
+		if sum, err := hex.DecodeString(v); err == nil {
+			return sha256.New(), sum, true
+		}
+	}
+	if v := md[ChecksumCRC32CKey]; v != "" {
+		if sum, err := hex.DecodeString(v); err == nil {
+			return crc32.New(crc32.MakeTable(crc32.Castagnoli)), sum, true
+		}
+	}
+	return nil, nil, false
+}
+
+// ObjectChecksumCRC32C is the crc32c (Castagnoli) of the object, from its
+// store if it computes one (ObjectCRC32C) otherwise from the
+// ChecksumCRC32CKey metadata.  ok is false if the object has neither, ie
+// for S3 and Azure, whose objects have an MD5 (see Object.MD5) or ETag.
+func ObjectChecksumCRC32C(o Object) (sum uint32, ok bool) {
+	if c, ok := o.(ObjectCRC32C); ok {
+		if sum := c.CRC32C(); sum != 0 {
+			return sum, true
+		}
+	}
+	b, err := hex.DecodeString(o.MetaData()[ChecksumCRC32CKey])
+	if err != nil || len(b) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b), true
+}
+
+// NewMetaDataChecksumReader wraps rc to verify against the checksum in the
+// objects metadata, see ChecksumFromMetaData.  If there is no checksum rc is
+// closed and ErrChecksumUnavailable returned.
+func NewMetaDataChecksumReader(rc io.ReadCloser, md map[string]string) (io.ReadCloser, error) {
+	h, expected, ok := ChecksumFromMetaData(md)
+	if !ok {
+		rc.Close()
+		return nil, ErrChecksumUnavailable
+	}
+	return NewChecksumReader(rc, h, expected), nil
+}
+
+// VerifyCopy checks the copy des of src in s has the md5 of src, returning
+// ErrChecksumMismatch if it doesn't.  Copies are only checked if both have an
+// md5 (see Object.MD5).
+func VerifyCopy(ctx context.Context, s Store, src Object, des string) error {
+	sum := src.MD5()
+	if sum == nil {
+		return nil
+	}
+	o, err := s.Get(ctx, des)
+	if err != nil {
+		return err
+	}
+	if dsum := o.MD5(); dsum != nil && !bytes.Equal(dsum, sum) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	store := newLocalStore(t, "checksum")
+	ctx := context.Background()
+	data := []byte("Year,Make,Model\n1997,Ford,E350\n")
+	sum := sha256.Sum256(data)
+
+	write := func(name string, md map[string]string) {
+		w, err := store.NewWriterWithContext(ctx, name, md)
+		assert.Equal(t, nil, err)
+		_, err = w.Write(data)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+	}
+	verify := cloudstorage.ReadOptions{VerifyChecksum: true}
+
+	write("good.csv", map[string]string{cloudstorage.ChecksumSHA256Key: hex.EncodeToString(sum[:])})
+	rc, err := store.NewReaderWithContext(ctx, "good.csv", verify)
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(rc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, data, b)
+	rc.Close()
+
+	bad := sha256.Sum256([]byte("not it"))
+	write("bad.csv", map[string]string{cloudstorage.ChecksumSHA256Key: hex.EncodeToString(bad[:])})
+	rc, err = store.NewReaderWithContext(ctx, "bad.csv", verify)
+	assert.Equal(t, nil, err)
+	_, err = ioutil.ReadAll(rc)
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, err)
+	rc.Close()
+
+	write("none.csv", nil)
+	_, err = store.NewReaderWithContext(ctx, "none.csv", verify)
+	assert.Equal(t, cloudstorage.ErrChecksumUnavailable, err)
+
+	// without verification the checksum isn't looked at
+	rc, err = store.NewReaderWithContext(ctx, "bad.csv")
+	assert.Equal(t, nil, err)
+	_, err = ioutil.ReadAll(rc)
+	assert.Equal(t, nil, err)
+	rc.Close()
+}
+
+func TestWriteContentMD5(t *testing.T) {
+	store := newLocalStore(t, "contentmd5")
+	ctx := context.Background()
+	data := []byte("Year,Make,Model\n1997,Ford,E350\n")
+	sum := md5.Sum(data)
+
+	write := func(name string, contentMD5 []byte) error {
+		w, err := store.NewWriterWithContext(ctx, name, nil, cloudstorage.WriteOptions{ContentMD5: contentMD5})
+		assert.Equal(t, nil, err)
+		_, err = w.Write(data)
+		assert.Equal(t, nil, err)
+		return w.Close()
+	}
+
+	assert.Equal(t, nil, write("good.csv", sum[:]))
+	_, err := store.Get(ctx, "good.csv")
+	assert.Equal(t, nil, err)
+
+	bad := md5.Sum([]byte("not it"))
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, write("bad.csv", bad[:]))
+	_, err = store.Get(ctx, "bad.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+}
+
+// md5Object reports the md5 of its contents, which localfs objects don't.
+type md5Object struct {
+	cloudstorage.Object
+	sum []byte
+}
+
+func (o *md5Object) MD5() []byte { return o.sum }
+
+// md5Store reports md5s for the objects it wrote.
+type md5Store struct {
+	cloudstorage.Store
+	sums map[string][]byte
+}
+
+func (s *md5Store) Get(ctx context.Context, name string) (cloudstorage.Object, error) {
+	o, err := s.Store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &md5Object{o, s.sums[name]}, nil
+}
+
+func TestVerifyCopy(t *testing.T) {
+	sum := md5.Sum([]byte("a,b\n"))
+	other := md5.Sum([]byte("c,d\n"))
+	store := &md5Store{newLocalStore(t, "verifycopy"), map[string][]byte{"src.csv": sum[:], "dst.csv": sum[:]}}
+	ctx := context.Background()
+	writeObject(t, store, "src.csv", "a,b\n")
+
+	src, err := store.Get(ctx, "src.csv")
+	assert.Equal(t, nil, err)
+	dst, err := store.NewObject("dst.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, cloudstorage.Copy(ctx, store, src, dst))
+	assert.Equal(t, "a,b\n", readAll(t, store, "dst.csv"))
+
+	store.sums["dst.csv"] = other[:]
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, cloudstorage.VerifyCopy(ctx, store, src, "dst.csv"))
+	// nothing to check against
+	delete(store.sums, "dst.csv")
+	assert.Equal(t, nil, cloudstorage.VerifyCopy(ctx, store, src, "dst.csv"))
+}
+
+type crc32cObject struct {
+	cloudstorage.Object
+	crc uint32
+}
+
+func (o *crc32cObject) CRC32C() uint32 { return o.crc }
+
+func TestObjectChecksumCRC32C(t *testing.T) {
+	store := newLocalStore(t, "checksumcrc32c")
+	ctx := context.Background()
+	data := []byte("Year,Make,Model\n1997,Ford,E350\n")
+	sum := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+
+	writeObject(t, store, "none.csv", string(data))
+	o, err := store.Get(ctx, "none.csv")
+	assert.Equal(t, nil, err)
+	_, ok := cloudstorage.ObjectChecksumCRC32C(o)
+	assert.Equal(t, false, ok)
+
+	// the store's crc32c is used first.
+	got, ok := cloudstorage.ObjectChecksumCRC32C(&crc32cObject{o, sum})
+	assert.Equal(t, true, ok)
+	assert.Equal(t, sum, got)
+
+	w, err := store.NewWriterWithContext(ctx, "meta.csv", map[string]string{
+		cloudstorage.ChecksumCRC32CKey: fmt.Sprintf("%08x", sum),
+	})
+	assert.Equal(t, nil, err)
+	_, err = w.Write(data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+	o, err = store.Get(ctx, "meta.csv")
+	assert.Equal(t, nil, err)
+	got, ok = cloudstorage.ObjectChecksumCRC32C(o)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, sum, got)
+}
+
+func TestOpenVerifyChecksum(t *testing.T) {
+	store := newLocalStore(t, "openchecksum")
+	ctx := context.Background()
+	data := []byte("Year,Make,Model\n1997,Ford,E350\n")
+	sum := sha256.Sum256(data)
+	bad := sha256.Sum256([]byte("not it"))
+
+	write := func(name string, md map[string]string) cloudstorage.Object {
+		w, err := store.NewWriterWithContext(ctx, name, md)
+		assert.Equal(t, nil, err)
+		_, err = w.Write(data)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+		o, err := store.Get(ctx, name)
+		assert.Equal(t, nil, err)
+		return o
+	}
+	verify := cloudstorage.ReadOptions{VerifyChecksum: true}
+
+	o := write("good.csv", map[string]string{cloudstorage.ChecksumSHA256Key: hex.EncodeToString(sum[:])})
+	f, err := o.Open(cloudstorage.ReadOnly, verify)
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(f)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, data, b)
+	assert.Equal(t, nil, o.Close())
+
+	// the bad copy is released, and can be opened again.
+	o = write("bad.csv", map[string]string{cloudstorage.ChecksumSHA256Key: hex.EncodeToString(bad[:])})
+	_, err = o.Open(cloudstorage.ReadOnly, verify)
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, err)
+	assert.True(t, o.File() == nil)
+	f, err = o.Open(cloudstorage.ReadOnly)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, nil, f)
+	assert.Equal(t, nil, o.Close())
+
+	o = write("none.csv", nil)
+	_, err = o.Open(cloudstorage.ReadOnly, verify)
+	assert.Equal(t, cloudstorage.ErrChecksumUnavailable, err)
+}
@@ -0,0 +1,151 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// CoalescingStore is a Store coalescing concurrent mutations of the same
+// object, ie by event handlers that received the same event.  Concurrent
+// Deletes of a name (with the same options) share a single Delete of the
+// wrapped store and all return its result.  Writers of a name are
+// serialized, NewWriterWithContext waits until the previous writer of the
+// name is Closed, so the writes don't race and the object has the bytes of
+// the last writer opened.  A goroutine mustn't open a second writer of a
+// name before closing the first.  Writes through objects (Open, Sync) aren't
+// coalesced.
+type CoalescingStore struct {
+	Store
+	mu      sync.Mutex
+	deletes map[deleteKey]*deleteCall
+	writes  map[string]*writeLock
+}
+
+// NewCoalescingStore create a store coalescing the mutations of s.
+func NewCoalescingStore(s Store) *CoalescingStore {
+	return &CoalescingStore{
+		Store:   s,
+		deletes: make(map[deleteKey]*deleteCall),
+		writes:  make(map[string]*writeLock),
+	}
+}
+
+type deleteKey struct {
+	name string
+	opts DeleteOptions
+}
+
+// deleteCall is a Delete in flight, err is set once done is closed.
+type deleteCall struct {
+	done chan struct{}
+	err  error
+}
+
+// writeLock is held by the open writer of a name, refs counts the writers
+// holding or waiting for it.
+type writeLock struct {
+	held chan struct{}
+	refs int
+}
+
+// Delete an object, sharing the Delete of concurrent calls for name.
+func (c *CoalescingStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	key := deleteKey{name: name}
+	if len(opts) > 0 {
+		key.opts = opts[0]
+	}
+	c.mu.Lock()
+	if call, ok := c.deletes[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &deleteCall{done: make(chan struct{})}
+	c.deletes[key] = call
+	c.mu.Unlock()
+
+	call.err = c.Store.Delete(ctx, name, opts...)
+	c.mu.Lock()
+	delete(c.deletes, key)
+	c.mu.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// NewWriter to an object, once the previous writer of name is closed.
+func (c *CoalescingStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return c.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to an object, once the previous writer of name is
+// closed or ctx is done.
+func (c *CoalescingStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	c.mu.Lock()
+	l, ok := c.writes[name]
+	if !ok {
+		l = &writeLock{held: make(chan struct{}, 1)}
+		c.writes[name] = l
+	}
+	l.refs++
+	c.mu.Unlock()
+
+	select {
+	case l.held <- struct{}{}:
+	case <-ctx.Done():
+		c.release(name, l, false)
+		return nil, ctx.Err()
+	}
+	wc, err := c.Store.NewWriterWithContext(ctx, name, metadata, opts...)
+	if err != nil {
+		c.release(name, l, true)
+		return nil, err
+	}
+	return &coalescedWriter{WriteCloser: wc, c: c, name: name, l: l}, nil
+}
+
+// release drops a reference to the write lock of name, unlocking it if it
+// was held.
+func (c *CoalescingStore) release(name string, l *writeLock, held bool) {
+	if held {
+		<-l.held
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(c.writes, name)
+	}
+}
+
+func (c *CoalescingStore) String() string {
+	return fmt.Sprintf("coalescing(%s)", c.Store)
+}
+
+type coalescedWriter struct {
+	io.WriteCloser
+	c      *CoalescingStore
+	name   string
+	l      *writeLock
+	closed bool
+}
+
+// Close the writer, letting the next writer of the name open.
+func (w *coalescedWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.c.release(w.name, w.l, true)
+	return w.WriteCloser.Close()
+}
+
+func (w *coalescedWriter) Resumed() int64 {
+	return Resumed(w.WriteCloser)
+}
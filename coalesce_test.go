@@ -0,0 +1,96 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// slowDeleteStore counts Deletes, which block until release is closed.
+type slowDeleteStore struct {
+	cloudstorage.Store
+	deletes int32
+	release chan struct{}
+}
+
+func (s *slowDeleteStore) Delete(ctx context.Context, name string, opts ...cloudstorage.DeleteOptions) error {
+	atomic.AddInt32(&s.deletes, 1)
+	<-s.release
+	return s.Store.Delete(ctx, name, opts...)
+}
+
+func TestCoalescingStoreDelete(t *testing.T) {
+	local := newLocalStore(t, "coalesce_delete")
+	writeObject(t, local, "a.csv", "a")
+	slow := &slowDeleteStore{Store: local, release: make(chan struct{})}
+	store := cloudstorage.NewCoalescingStore(slow)
+
+	errs := make([]error, 5)
+	wg := sync.WaitGroup{}
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = store.Delete(context.Background(), "a.csv")
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(slow.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), slow.deletes)
+	for _, err := range errs {
+		assert.Equal(t, nil, err)
+	}
+	_, err := local.Get(context.Background(), "a.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// later deletes aren't coalesced with finished ones.
+	store.Delete(context.Background(), "a.csv")
+	assert.Equal(t, int32(2), slow.deletes)
+}
+
+func TestCoalescingStoreWrite(t *testing.T) {
+	local := newLocalStore(t, "coalesce_write")
+	store := cloudstorage.NewCoalescingStore(local)
+	ctx := context.Background()
+
+	first, err := store.NewWriterWithContext(ctx, "a.csv", nil)
+	assert.Equal(t, nil, err)
+
+	opened := make(chan struct{})
+	go func() {
+		defer close(opened)
+		second, err := store.NewWriterWithContext(ctx, "a.csv", nil)
+		assert.Equal(t, nil, err)
+		_, err = second.Write([]byte("second"))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, second.Close())
+	}()
+
+	select {
+	case <-opened:
+		t.Fatal("second writer opened while the first is open")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = first.Write([]byte("first"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, first.Close())
+	<-opened
+	assert.Equal(t, "second", readAll(t, local, "a.csv"))
+
+	// a writer waiting on another gives up with its context.
+	held, err := store.NewWriterWithContext(ctx, "b.csv", nil)
+	assert.Equal(t, nil, err)
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = store.NewWriterWithContext(cctx, "b.csv", nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, nil, held.Close())
+}
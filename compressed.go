@@ -0,0 +1,286 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"golang.org/x/net/context"
+)
+
+// gzipCompression is the CompressionKey of gzipped objects.
+const gzipCompression = "gzip"
+
+// CompressedStore is a Store gzipping objects as they are written and
+// gunzipping them as they are read, ie for csvs several times smaller
+// compressed.  Objects it writes are marked with CompressionKey metadata,
+// reads of objects without it (written by something else, including .gz
+// files) pass through untouched.  Names and content types are kept, Size
+// and MD5 are of the compressed bytes in the store.  As compression is in
+// the metadata, reads and opens Get the object first, a HEAD request on the
+// object stores.
+type CompressedStore struct {
+	Store
+	// Level of the gzip compression, zero is gzip.DefaultCompression.
+	Level int
+}
+
+// NewCompressedStore create a store gzipping the objects written to s.
+func NewCompressedStore(s Store) *CompressedStore {
+	return &CompressedStore{Store: s}
+}
+
+// IsCompressed is true for the metadata of objects written by a
+// CompressedStore.
+func IsCompressed(metadata map[string]string) bool {
+	return metadata[CompressionKey] == gzipCompression
+}
+
+// Get an object, which decompresses when opened.
+func (c *CompressedStore) Get(ctx context.Context, name string) (Object, error) {
+	o, err := c.Store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedObject{Object: o, c: c}, nil
+}
+
+// Objects iterates objects, which decompress when opened.
+func (c *CompressedStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	iter, err := c.Store.Objects(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedIterator{ObjectIterator: iter, c: c}, nil
+}
+
+// List objects, which decompress when opened.
+func (c *CompressedStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	resp, err := c.Store.List(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for i, o := range resp.Objects {
+		resp.Objects[i] = &compressedObject{Object: o, c: c}
+	}
+	return resp, nil
+}
+
+// NewObject creates an object, compressed when synced.
+func (c *CompressedStore) NewObject(name string) (Object, error) {
+	o, err := c.Store.NewObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedObject{Object: o, c: c, created: true}, nil
+}
+
+// NewReader of an object, decompressing it if it is compressed.
+func (c *CompressedStore) NewReader(name string) (io.ReadCloser, error) {
+	return c.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of an object, decompressing it if it is compressed.
+// VerifyChecksum verifies the compressed bytes against the store's checksum,
+// MaxBytes caps the decompressed bytes.
+func (c *CompressedStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	o, err := c.Store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !IsCompressed(o.MetaData()) {
+		return c.Store.NewReaderWithContext(ctx, name, opts...)
+	}
+	var ropts []ReadOptions
+	if len(opts) > 0 {
+		ropts = []ReadOptions{opts[0]}
+		ropts[0].MaxBytes = 0
+	}
+	rc, err := c.Store.NewReaderWithContext(ctx, name, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("object %q isn't gzipped err=%v", name, err)
+	}
+	return MaxBytesReader(&gzipReader{Reader: gz, rc: rc}, opts), nil
+}
+
+// NewWriter to an object, compressing it.
+func (c *CompressedStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return c.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to an object, compressing it.  A ContentMD5 is of the
+// uncompressed bytes, and checked before the write is committed.
+// DetectContentType detects it from the uncompressed bytes.
+func (c *CompressedStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	if DetectsContentType(metadata, opts) {
+		return NewContentTypeWriter(name, metadata, opts, func(md map[string]string, opts ...Opts) (io.WriteCloser, error) {
+			return c.NewWriterWithContext(ctx, name, md, opts...)
+		}), nil
+	}
+	var contentMD5 []byte
+	if len(opts) > 0 {
+		// the compressed bytes can't be resumed part way, so the object is
+		// always written whole.
+		contentMD5 = opts[0].ContentMD5
+		opts = []Opts{opts[0]}
+		opts[0].ContentMD5 = nil
+		opts[0].Resume = false
+	}
+	md := make(map[string]string, len(metadata)+2)
+	for k, v := range metadata {
+		md[k] = v
+	}
+	// the content type of the name, not of a .gz.
+	EnsureContextType(name, md)
+	md[CompressionKey] = gzipCompression
+
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	wc, err := newStagedWriter(ctx, c.Store, name, md, opts)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewWriterLevel(wc, level)
+	if err != nil {
+		wc.abort()
+		return nil, err
+	}
+	w := &gzipWriter{Writer: gz, wc: wc}
+	if contentMD5 != nil {
+		w.md5, w.contentMD5 = md5.New(), contentMD5
+	}
+	return w, nil
+}
+
+func (c *CompressedStore) String() string {
+	return fmt.Sprintf("compressed(%s)", c.Store)
+}
+
+// gzipReader closes the object's reader with the gzip reader.
+type gzipReader struct {
+	*gzip.Reader
+	rc io.Closer
+}
+
+func (r *gzipReader) Close() error {
+	r.Reader.Close()
+	return r.rc.Close()
+}
+
+// gzipWriter compresses to wc, committing the write on Close unless the
+// uncompressed bytes don't match the ContentMD5.
+type gzipWriter struct {
+	*gzip.Writer
+	wc *stagedWriter
+	// md5 of the uncompressed bytes, checked against contentMD5 on Close.
+	md5        hash.Hash
+	contentMD5 []byte
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.md5 != nil {
+		w.md5.Write(p)
+	}
+	return w.Writer.Write(p)
+}
+
+func (w *gzipWriter) Close() error {
+	err := w.Writer.Close()
+	if err == nil && w.md5 != nil && !bytes.Equal(w.md5.Sum(nil), w.contentMD5) {
+		err = ErrChecksumMismatch
+	}
+	if err != nil {
+		w.wc.abort()
+		return err
+	}
+	return w.wc.Close()
+}
+
+// abort abandons the write, see stagedWriter.
+func (w *gzipWriter) abort() {
+	w.wc.abort()
+}
+
+type compressedIterator struct {
+	ObjectIterator
+	c *CompressedStore
+}
+
+func (it *compressedIterator) Next() (Object, error) {
+	o, err := it.ObjectIterator.Next()
+	if err != nil {
+		return nil, err
+	}
+	return &compressedObject{Object: o, c: it.c}, nil
+}
+
+// compressedObject opens to a local temp file of the decompressed bytes if
+// it is compressed, otherwise it is opened as the store's object is.
+type compressedObject struct {
+	Object
+	c *CompressedStore
+	// created by NewObject, so it is compressed.
+	created bool
+	// opened is the object reads and writes go to once opened.
+	opened Object
+}
+
+func (o *compressedObject) Open(accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+// OpenWithContext opens the object, decompressing it if it is compressed.
+// Listings may not have metadata, so the object is Got to see if it is.
+func (o *compressedObject) OpenWithContext(ctx context.Context, accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	if o.opened != nil {
+		return OpenWithContext(ctx, o.opened, accesslevel, opts...)
+	}
+	if !accesslevel.Valid() {
+		return nil, ErrInvalidAccessLevel
+	}
+	compressed := o.created || IsCompressed(o.MetaData())
+	if !compressed {
+		current, err := o.c.Store.Get(ctx, o.Name())
+		if err != nil {
+			return nil, err
+		}
+		compressed = IsCompressed(current.MetaData())
+	}
+	opened := o.Object
+	if compressed {
+		opened = &transcodedObject{Object: o.Object, s: o.c, created: o.created}
+	}
+	f, err := OpenWithContext(ctx, opened, accesslevel, opts...)
+	if err != nil {
+		return nil, err
+	}
+	o.opened = opened
+	return f, nil
+}
+
+// target is the object reads and writes go to.
+func (o *compressedObject) target() Object {
+	if o.opened != nil {
+		return o.opened
+	}
+	return o.Object
+}
+
+func (o *compressedObject) Release() error              { return o.target().Release() }
+func (o *compressedObject) Read(p []byte) (int, error)  { return o.target().Read(p) }
+func (o *compressedObject) Write(p []byte) (int, error) { return o.target().Write(p) }
+func (o *compressedObject) Sync() error                 { return o.target().Sync() }
+func (o *compressedObject) Close() error                { return o.target().Close() }
+func (o *compressedObject) File() *os.File              { return o.target().File() }
+func (o *compressedObject) Delete() error               { return o.target().Delete() }
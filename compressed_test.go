@@ -0,0 +1,106 @@
+package cloudstorage_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestCompressedStore(t *testing.T) {
+	local := newLocalStore(t, "compressed")
+	store := cloudstorage.NewCompressedStore(local)
+	ctx := context.Background()
+
+	body := strings.Repeat("a,b,c,d,e\n", 1000)
+	writeObject(t, store, "a.csv", body)
+	raw := readAll(t, local, "a.csv")
+	gz, err := gzip.NewReader(strings.NewReader(raw))
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(gz)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, body, string(b))
+	assert.Equal(t, body, readAll(t, store, "a.csv"))
+
+	// sizes are of the compressed object, it keeps the content type of its name.
+	obj, err := store.Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(len(raw)), obj.Size())
+	assert.True(t, cloudstorage.IsCompressed(obj.MetaData()))
+	assert.Equal(t, "text/csv; charset=utf-8", obj.ContentType())
+
+	// MaxBytes caps the decompressed bytes.
+	rc, err := store.NewReaderWithContext(ctx, "a.csv", cloudstorage.ReadOptions{MaxBytes: int64(len(raw))})
+	assert.Equal(t, nil, err)
+	_, err = ioutil.ReadAll(rc)
+	assert.Equal(t, cloudstorage.ErrObjectTooLarge, err)
+	rc.Close()
+
+	// objects not written by the store are read, and written back, as is.
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte("x"))
+	w.Close()
+	writeObject(t, local, "b.csv.gz", buf.String())
+	assert.Equal(t, buf.String(), readAll(t, store, "b.csv.gz"))
+	obj, err = store.Get(ctx, "b.csv.gz")
+	assert.Equal(t, nil, err)
+	f, err := obj.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, nil, err)
+	f.Seek(0, 2)
+	f.Write([]byte("y"))
+	assert.Equal(t, nil, obj.Close())
+	assert.Equal(t, buf.String()+"y", readAll(t, local, "b.csv.gz"))
+
+	// objects open to their decompressed bytes, and compress on Close.
+	obj, err = store.NewObject("c.csv")
+	assert.Equal(t, nil, err)
+	f, err = obj.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, nil, err)
+	f.Write([]byte(body))
+	assert.Equal(t, nil, obj.Close())
+	obj, err = local.Get(ctx, "c.csv")
+	assert.Equal(t, nil, err)
+	assert.True(t, obj.Size() < int64(len(body)))
+	// listed objects are Got to see if they are compressed.
+	resp, err := store.List(ctx, cloudstorage.NewQueryAll())
+	assert.Equal(t, nil, err)
+	for _, o := range resp.Objects {
+		if o.Name() == "c.csv" {
+			obj = o
+		}
+	}
+	f, err = obj.Open(cloudstorage.ReadOnly)
+	assert.Equal(t, nil, err)
+	b, err = ioutil.ReadAll(f)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, body, string(b))
+	assert.Equal(t, nil, obj.Close())
+
+	// a ContentMD5 is of the decompressed bytes.
+	sum := md5.Sum([]byte(body))
+	wc, err := store.NewWriterWithContext(ctx, "d.csv", nil, cloudstorage.Opts{ContentMD5: sum[:]})
+	assert.Equal(t, nil, err)
+	wc.Write([]byte(body))
+	assert.Equal(t, nil, wc.Close())
+	wc, err = store.NewWriterWithContext(ctx, "e.csv", nil, cloudstorage.Opts{ContentMD5: sum[:]})
+	assert.Equal(t, nil, err)
+	wc.Write([]byte("other"))
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, wc.Close())
+	_, err = local.Get(ctx, "e.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// nor does a mismatch over an object remove it.
+	wc, err = store.NewWriterWithContext(ctx, "d.csv", nil, cloudstorage.Opts{ContentMD5: sum[:]})
+	assert.Equal(t, nil, err)
+	wc.Write([]byte("other"))
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, wc.Close())
+	assert.Equal(t, body, readAll(t, store, "d.csv"))
+}
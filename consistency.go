@@ -0,0 +1,60 @@
+package cloudstorage
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// ConsistencyTimeout is how long WaitDeleted polls before giving up.
+	ConsistencyTimeout = 30 * time.Second
+	// ConsistencyPollInterval is the time between WaitDeleted polls.
+	ConsistencyPollInterval = 250 * time.Millisecond
+)
+
+// WaitDeleted polls s until the deleted object name is neither returned by Get
+// nor listed under its name, returning as soon as it is gone or
+// ErrNotConsistent if it is still there after ConsistencyTimeout.
+func WaitDeleted(ctx context.Context, s Store, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, ConsistencyTimeout)
+	defer cancel()
+	for {
+		gone, err := isDeleted(ctx, s, name)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrNotConsistent
+			}
+			return err
+		}
+		if gone {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrNotConsistent
+			}
+			return ctx.Err()
+		case <-time.After(ConsistencyPollInterval):
+		}
+	}
+}
+
+func isDeleted(ctx context.Context, s Store, name string) (bool, error) {
+	if _, err := s.Get(ctx, name); err == nil {
+		return false, nil
+	} else if err != ErrObjectNotFound {
+		return false, err
+	}
+	resp, err := s.List(ctx, NewQuery(name))
+	if err != nil {
+		return false, err
+	}
+	for _, o := range resp.Objects {
+		if o.Name() == name {
+			return false, nil
+		}
+	}
+	return true, nil
+}
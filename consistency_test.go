@@ -0,0 +1,49 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// laggingStore keeps listing deleted objects for a number of Lists.
+type laggingStore struct {
+	cloudstorage.Store
+	stale   cloudstorage.Objects
+	lagging int
+}
+
+func (s *laggingStore) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.ObjectsResponse, error) {
+	if s.lagging > 0 {
+		s.lagging--
+		return &cloudstorage.ObjectsResponse{Objects: s.stale}, nil
+	}
+	return s.Store.List(ctx, q)
+}
+
+func TestWaitDeleted(t *testing.T) {
+	local := newLocalStore(t, "consistency")
+	ctx := context.Background()
+	writeWithCustomTime(t, local, "lag.csv", time.Time{})
+	obj, err := local.Get(ctx, "lag.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, local.Delete(ctx, "lag.csv"))
+
+	defer func(interval, timeout time.Duration) {
+		cloudstorage.ConsistencyPollInterval = interval
+		cloudstorage.ConsistencyTimeout = timeout
+	}(cloudstorage.ConsistencyPollInterval, cloudstorage.ConsistencyTimeout)
+	cloudstorage.ConsistencyPollInterval = time.Millisecond
+
+	store := &laggingStore{Store: local, stale: cloudstorage.Objects{obj}, lagging: 3}
+	assert.Equal(t, nil, cloudstorage.WaitDeleted(ctx, store, "lag.csv"))
+	assert.Equal(t, 0, store.lagging)
+
+	cloudstorage.ConsistencyTimeout = 20 * time.Millisecond
+	store.lagging = 1 << 20
+	assert.Equal(t, cloudstorage.ErrNotConsistent, cloudstorage.WaitDeleted(ctx, store, "lag.csv"))
+}
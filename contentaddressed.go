@@ -0,0 +1,85 @@
+package cloudstorage
+
+import (
+	"crypto"
+	// register the hashes most used as content addresses
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/araddon/gou"
+	"golang.org/x/net/context"
+)
+
+// DefaultContentHash is the hash PutContentAddressed uses when none is given.
+var DefaultContentHash = crypto.SHA256
+
+// ContentHashName is the key prefix for objects addressed by hash h, ie
+// "sha256" for crypto.SHA256 or "sha1" for crypto.SHA1.
+func ContentHashName(h crypto.Hash) string {
+	return strings.ToLower(strings.Replace(h.String(), "-", "", -1))
+}
+
+// PutContentAddressed writes the contents of r to s under a key derived from
+// its hash, "<prefix>/<hash name>/<hex digest>", and returns the key.  The
+// contents are hashed while they are streamed to a temporary object, which
+// is then moved to its key, so r is only read once.  If the key already
+// exists the temporary object is discarded.  h of 0 is DefaultContentHash,
+// the hash has to be linked into the binary, ie by importing crypto/sha1.
+func PutContentAddressed(ctx context.Context, s Store, prefix string, r io.Reader, h crypto.Hash, opts ...Opts) (string, error) {
+	if h == 0 {
+		h = DefaultContentHash
+	}
+	if !h.Available() {
+		return "", fmt.Errorf("content hash %v is not linked into the binary", h)
+	}
+	dir := path.Join(prefix, ContentHashName(h))
+	tmpName := path.Join(dir, fmt.Sprintf(".tmp-%d", time.Now().UnixNano()))
+
+	hasher := h.New()
+	w, err := s.NewWriterWithContext(ctx, tmpName, nil, opts...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(io.MultiWriter(w, hasher), r); err != nil {
+		w.Close()
+		deleteObject(ctx, s, tmpName)
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		deleteObject(ctx, s, tmpName)
+		return "", err
+	}
+	key := path.Join(dir, hex.EncodeToString(hasher.Sum(nil)))
+
+	tmp, err := s.Get(ctx, tmpName)
+	if err != nil {
+		return "", err
+	}
+	dst, err := s.NewObject(key)
+	if err == ErrObjectExists {
+		// same contents are already stored
+		return key, s.Delete(ctx, tmpName)
+	} else if err != nil {
+		deleteObject(ctx, s, tmpName)
+		return "", err
+	}
+	if err := Move(ctx, s, tmp, dst); err != nil {
+		deleteObject(ctx, s, tmpName)
+		return "", err
+	}
+	return key, nil
+}
+
+// deleteObject best effort cleanup of a partially written object.
+func deleteObject(ctx context.Context, s Store, name string) {
+	if err := s.Delete(ctx, name); err != nil && err != ErrObjectNotFound {
+		gou.Warnf("could not delete %q err=%v", name, err)
+	}
+}
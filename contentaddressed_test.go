@@ -0,0 +1,36 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"crypto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestPutContentAddressed(t *testing.T) {
+	store := newLocalStore(t, "contentaddressed")
+	ctx := context.Background()
+
+	key, err := cloudstorage.PutContentAddressed(ctx, store, "cas", strings.NewReader("hello"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "cas/sha256/2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", key)
+	assert.Equal(t, "hello", readAll(t, store, key))
+
+	key, err = cloudstorage.PutContentAddressed(ctx, store, "cas", strings.NewReader("hello"), crypto.SHA1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "cas/sha1/aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", key)
+
+	// same contents again is deduplicated, and no temp objects are left
+	_, err = cloudstorage.PutContentAddressed(ctx, store, "cas", strings.NewReader("hello"), crypto.SHA256)
+	assert.Equal(t, nil, err)
+	objs, err := store.List(ctx, cloudstorage.NewQuery("cas/"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(objs.Objects))
+
+	_, err = cloudstorage.PutContentAddressed(ctx, store, "cas", strings.NewReader("hello"), crypto.BLAKE2b_256)
+	assert.NotEqual(t, nil, err)
+}
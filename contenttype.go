@@ -0,0 +1,82 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"io"
+)
+
+// sniffLen is the most bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// DetectsContentType is true if a write with metadata and opts should use a
+// NewContentTypeWriter.
+func DetectsContentType(metadata map[string]string, opts []Opts) bool {
+	return len(opts) > 0 && opts[0].DetectContentType && MetadataContentType(metadata) == ""
+}
+
+// NewContentTypeWriter buffers the first bytes written to detect the content
+// type of object name (see DetectContentType).  Once detected it is set on a
+// copy of metadata and newWriter called to open the writer for the object,
+// which then gets all bytes in the order written.  opts are passed through
+// to newWriter with DetectContentType turned off.
+func NewContentTypeWriter(name string, metadata map[string]string, opts []Opts,
+	newWriter func(metadata map[string]string, opts ...Opts) (io.WriteCloser, error)) io.WriteCloser {
+
+	md := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		md[k] = v
+	}
+	opts = append([]Opts(nil), opts...)
+	if len(opts) > 0 {
+		opts[0].DetectContentType = false
+	}
+	return &contentTypeWriter{name: name, metadata: md, opts: opts, newWriter: newWriter}
+}
+
+type contentTypeWriter struct {
+	name      string
+	metadata  map[string]string
+	opts      []Opts
+	newWriter func(metadata map[string]string, opts ...Opts) (io.WriteCloser, error)
+	buf       bytes.Buffer
+	wc        io.WriteCloser
+}
+
+func (w *contentTypeWriter) Write(p []byte) (int, error) {
+	if w.wc != nil {
+		return w.wc.Write(p)
+	}
+	w.buf.Write(p)
+	if w.buf.Len() < sniffLen {
+		return len(p), nil
+	}
+	if err := w.open(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// open detects the content type from the buffered bytes, opens the writer and
+// writes the buffered bytes to it.
+func (w *contentTypeWriter) open() error {
+	w.metadata[ContentTypeKey] = DetectContentType(w.name, w.buf.Bytes())
+	wc, err := w.newWriter(w.metadata, w.opts...)
+	if err != nil {
+		return err
+	}
+	w.wc = wc
+	if _, err := w.wc.Write(w.buf.Bytes()); err != nil {
+		return err
+	}
+	w.buf = bytes.Buffer{}
+	return nil
+}
+
+func (w *contentTypeWriter) Close() error {
+	if w.wc == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	return w.wc.Close()
+}
@@ -0,0 +1,52 @@
+package cloudstorage_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestWriteDetectContentType(t *testing.T) {
+	store := newLocalStore(t, "contenttype")
+	ctx := context.Background()
+	detect := cloudstorage.WriteOptions{DetectContentType: true}
+
+	write := func(name string, md map[string]string, chunks ...[]byte) {
+		w, err := store.NewWriterWithContext(ctx, name, md, detect)
+		assert.Equal(t, nil, err)
+		for _, c := range chunks {
+			_, err = w.Write(c)
+			assert.Equal(t, nil, err)
+		}
+		assert.Equal(t, nil, w.Close())
+	}
+	contentType := func(name string) string {
+		obj, err := store.Get(ctx, name)
+		assert.Equal(t, nil, err)
+		return obj.MetaData()[cloudstorage.ContentTypeKey]
+	}
+
+	// sniffed across several writes, bytes arrive unchanged
+	page := append([]byte("<html><body>"), bytes.Repeat([]byte("hello "), 200)...)
+	write("page", nil, page[:10], page[10:600], page[600:])
+	assert.Equal(t, "text/html; charset=utf-8", contentType("page"))
+	rc, err := store.NewReader("page")
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(rc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, page, b)
+	rc.Close()
+
+	// short objects are detected on Close
+	write("data.json", nil, []byte(`{"a":1}`))
+	assert.Equal(t, "application/json", contentType("data.json"))
+
+	// an explicit content type is kept
+	write("page.bin", map[string]string{cloudstorage.ContentTypeKey: "text/plain"}, page)
+	assert.Equal(t, "text/plain", contentType("page.bin"))
+}
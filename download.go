@@ -0,0 +1,358 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultDownloadPartSize is the range size for parallel downloads when
+// ReadOptions.PartSize isn't set.
+var DefaultDownloadPartSize int64 = 16 * 1024 * 1024
+
+// RangeFetcher opens a reader over length bytes of an object starting at offset.
+type RangeFetcher func(ctx context.Context, offset, length int64) (io.ReadCloser, error)
+
+func (o ReadOptions) partSize() int64 {
+	if o.PartSize > 0 {
+		return o.PartSize
+	}
+	return DefaultDownloadPartSize
+}
+
+// ParallelDownload is true if an object of size bytes should be fetched in
+// parallel ranges, see DownloadRanges.
+func (o ReadOptions) ParallelDownload(size int64) bool {
+	return o.DownloadConcurrency > 1 && size > o.partSize()
+}
+
+// DownloadRanges fetches the size bytes of an object into f, as PartSize
+// ranges fetched by DownloadConcurrency workers that each write their range
+// at its offset in f.  The first error cancels the remaining fetches.
+func DownloadRanges(ctx context.Context, f *os.File, size int64, opts ReadOptions, fetch RangeFetcher) error {
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	partSize := opts.partSize()
+	workers := opts.DownloadConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	offsets := make(chan int64)
+	errs := make(chan error, workers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for off := range offsets {
+				length := partSize
+				if off+length > size {
+					length = size - off
+				}
+				if err := fetchRange(ctx, f, off, length, fetch); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+produce:
+	for off := int64(0); off < size; off += partSize {
+		select {
+		case offsets <- off:
+		case <-ctx.Done():
+			break produce
+		}
+	}
+	close(offsets)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+func fetchRange(ctx context.Context, f *os.File, off, length int64, fetch RangeFetcher) error {
+	rc, err := fetch(ctx, off, length)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	n, err := io.Copy(&offsetWriter{f: f, off: off}, io.LimitReader(NewContextReader(ctx, rc), length))
+	if err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("short range read offset=%d expected=%d got=%d: %v", off, length, n, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// VerifyFile hashes the contents of f with h and returns ErrChecksumMismatch
+// if the sum isn't expected.  f is left positioned at the start.
+func VerifyFile(f *os.File, h hash.Hash, expected []byte) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// VerifyOpened checks the cached copy f that Open(ReadOnly) downloaded of o
+// when opts has ReadOptions.VerifyChecksum, returning f if it matches.  The
+// copy must have the Size of o, so incomplete downloads fail, and the sum of
+// the first checksum o has:  its MD5, the checksum of its metadata (see
+// ChecksumFromMetaData) or its store's crc32c (ObjectCRC32C).  Otherwise o is
+// Released, so the bad copy isn't reused, and ErrChecksumMismatch (or
+// ErrChecksumUnavailable if o has no checksum) returned.
+func VerifyOpened(o Object, f *os.File, opts []ReadOptions) (*os.File, error) {
+	if len(opts) == 0 || !opts[0].VerifyChecksum {
+		return f, nil
+	}
+	err := verifyCachedCopy(o, f)
+	if err != nil {
+		o.Release()
+		return nil, err
+	}
+	return f, nil
+}
+
+func verifyCachedCopy(o Object, f *os.File) error {
+	if size := o.Size(); size != UnknownSize {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() != size {
+			return ErrChecksumMismatch
+		}
+	}
+	if sum := o.MD5(); sum != nil {
+		return VerifyFile(f, md5.New(), sum)
+	}
+	if h, expected, ok := ChecksumFromMetaData(o.MetaData()); ok {
+		return VerifyFile(f, h, expected)
+	}
+	if sum, ok := ObjectChecksumCRC32C(o); ok {
+		expected := make([]byte, 4)
+		binary.BigEndian.PutUint32(expected, sum)
+		return VerifyFile(f, crc32.New(crc32.MakeTable(crc32.Castagnoli)), expected)
+	}
+	return ErrChecksumUnavailable
+}
+
+// OpenWithContext opens o as Open does, returning ctx.Err() if ctx is done
+// before the download finishes.  The objects of all the stores implement
+// ObjectOpenContext, so the download is aborted and its partial cached copy
+// removed.  For other objects Open is left to finish on its own and the
+// object then Released, so it doesn't keep a cached copy.
+func OpenWithContext(ctx context.Context, o Object, accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	if oc, ok := o.(ObjectOpenContext); ok {
+		return oc.OpenWithContext(ctx, accesslevel, opts...)
+	}
+	if ctx.Done() == nil {
+		return o.Open(accesslevel, opts...)
+	}
+	type opened struct {
+		f   *os.File
+		err error
+	}
+	done := make(chan opened, 1)
+	go func() {
+		f, err := o.Open(accesslevel, opts...)
+		done <- opened{f, err}
+	}()
+	select {
+	case r := <-done:
+		return r.f, r.err
+	case <-ctx.Done():
+		go func() {
+			<-done
+			o.Release()
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// NewContextReader is r returning ctx.Err() once ctx is done, for copying
+// from readers that don't take a context so the copy stops between reads.
+func NewContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// NewContextReadCloser is rc closed once ctx is done, which frees (and
+// unblocks a Read stuck on) the stream, with reads then ctx.Err().  For the
+// NewReaderWithContext of stores whose streams don't take a context.
+func NewContextReadCloser(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	if ctx.Done() == nil {
+		return rc
+	}
+	c := &contextReadCloser{ctx: ctx, rc: rc, closed: make(chan struct{})}
+	go c.watch()
+	return c
+}
+
+type contextReadCloser struct {
+	ctx    context.Context
+	rc     io.ReadCloser
+	once   sync.Once
+	err    error
+	closed chan struct{}
+}
+
+func (c *contextReadCloser) watch() {
+	select {
+	case <-c.ctx.Done():
+		c.Close()
+	case <-c.closed:
+	}
+}
+
+func (c *contextReadCloser) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.rc.Read(p)
+	if err != nil && c.ctx.Err() != nil {
+		// the read failed as the stream was closed under it
+		return n, c.ctx.Err()
+	}
+	return n, err
+}
+
+func (c *contextReadCloser) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.err = c.rc.Close()
+	})
+	return c.err
+}
+
+// SharesDownload is true if an Open with ctx should use SharedDownload, it
+// is read only and can't be cancelled.  Cancellable downloads aren't shared,
+// so the cancellation of one caller can't fail the others waiting on it.
+func SharesDownload(ctx context.Context, accesslevel AccessLevel) bool {
+	return accesslevel == ReadOnly && ctx.Done() == nil
+}
+
+// sharedDownloads are the in flight SharedDownloads by cache path.
+var sharedDownloads = struct {
+	sync.Mutex
+	calls map[string]*sharedDownload
+}{calls: make(map[string]*sharedDownload)}
+
+type sharedDownload struct {
+	opts    string
+	done    chan struct{}
+	waiters int
+	files   chan *os.File
+	err     error
+}
+
+// SharedDownload coalesces concurrent read only Opens of objects with the
+// same cache file.  The first caller runs download, which fills cachepath and
+// returns it opened.  Callers arriving while it runs with the same opts don't
+// download, they wait for it and get their own read only handle on cachepath
+// (shared is true), or its error.  Callers with other opts (ie another
+// RequestPayer, or VerifyChecksum) wait for it to finish and then download
+// as their opts say, as the download is into the same cache file.
+func SharedDownload(cachepath string, opts []ReadOptions, download func() (*os.File, error)) (f *os.File, shared bool, err error) {
+	key := ""
+	if len(opts) > 0 {
+		key = fmt.Sprintf("%+v", opts[0])
+	}
+	sharedDownloads.Lock()
+	for {
+		c, ok := sharedDownloads.calls[cachepath]
+		if !ok {
+			break
+		} else if c.opts != key {
+			sharedDownloads.Unlock()
+			<-c.done
+			sharedDownloads.Lock()
+			continue
+		}
+		c.waiters++
+		sharedDownloads.Unlock()
+		<-c.done
+		if c.err != nil {
+			return nil, true, c.err
+		}
+		f := <-c.files
+		if f == nil {
+			return nil, true, fmt.Errorf("could not open shared download cachepath=%s", cachepath)
+		}
+		return f, true, nil
+	}
+	c := &sharedDownload{opts: key, done: make(chan struct{})}
+	sharedDownloads.calls[cachepath] = c
+	sharedDownloads.Unlock()
+
+	f, err = download()
+
+	sharedDownloads.Lock()
+	delete(sharedDownloads.calls, cachepath)
+	sharedDownloads.Unlock()
+
+	// the waiters handles are opened before returning, so the cache file
+	// can't be removed by our caller's Close before they have them.
+	c.err = err
+	if err == nil {
+		c.files = make(chan *os.File, c.waiters)
+		for i := 0; i < c.waiters; i++ {
+			wf, _ := os.Open(cachepath)
+			c.files <- wf
+		}
+	}
+	close(c.done)
+	return f, false, err
+}
@@ -0,0 +1,245 @@
+package cloudstorage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestDownloadRanges(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	opts := cloudstorage.ReadOptions{DownloadConcurrency: 4, PartSize: 1000}
+	assert.True(t, opts.ParallelDownload(int64(len(data))))
+	assert.False(t, opts.ParallelDownload(999))
+	assert.False(t, cloudstorage.ReadOptions{PartSize: 1000}.ParallelDownload(int64(len(data))))
+
+	fetch := func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data[offset : offset+length])), nil
+	}
+
+	f, err := ioutil.TempFile("", "download_test")
+	assert.Equal(t, nil, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	err = cloudstorage.DownloadRanges(context.Background(), f, int64(len(data)), opts, fetch)
+	assert.Equal(t, nil, err)
+	got, err := ioutil.ReadFile(f.Name())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, data, got)
+
+	sum := md5.Sum(data)
+	assert.Equal(t, nil, cloudstorage.VerifyFile(f, md5.New(), sum[:]))
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, cloudstorage.VerifyFile(f, md5.New(), []byte("nope")))
+
+	// a failed range fails the download
+	failing := func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		if offset == 5000 {
+			return nil, fmt.Errorf("range unavailable")
+		}
+		return fetch(ctx, offset, length)
+	}
+	err = cloudstorage.DownloadRanges(context.Background(), f, int64(len(data)), opts, failing)
+	assert.NotEqual(t, nil, err)
+
+	// as does a short one
+	short := func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		return fetch(ctx, offset, length-1)
+	}
+	err = cloudstorage.DownloadRanges(context.Background(), f, int64(len(data)), opts, short)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestSharedDownload(t *testing.T) {
+	cachepath := filepath.Join(os.TempDir(), "cloudstorage_shareddownload.cache")
+	defer os.Remove(cachepath)
+
+	var downloads int32
+	release := make(chan struct{})
+	download := func() (*os.File, error) {
+		atomic.AddInt32(&downloads, 1)
+		<-release
+		if err := ioutil.WriteFile(cachepath, []byte("shared"), 0644); err != nil {
+			return nil, err
+		}
+		return os.Open(cachepath)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, _, err := cloudstorage.SharedDownload(cachepath, nil, download)
+			assert.Equal(t, nil, err)
+			b, err := ioutil.ReadAll(f)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, "shared", string(b))
+			f.Close()
+		}()
+	}
+	// let the opens queue up behind the first
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&downloads))
+
+	// errors go to every waiter
+	failed := fmt.Errorf("download failed")
+	release = make(chan struct{})
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, _, err := cloudstorage.SharedDownload(cachepath, nil, func() (*os.File, error) {
+				<-release
+				return nil, failed
+			})
+			errs <- err
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, failed, <-errs)
+	}
+
+	// an open with other options doesn't get a download it didn't ask for,
+	// it downloads itself once the first is done.
+	atomic.StoreInt32(&downloads, 0)
+	release = make(chan struct{})
+	opts := [][]cloudstorage.ReadOptions{nil, {{VerifyChecksum: true}}, nil}
+	shared := make(chan bool, len(opts))
+	for _, o := range opts {
+		go func(o []cloudstorage.ReadOptions) {
+			f, s, err := cloudstorage.SharedDownload(cachepath, o, download)
+			assert.Equal(t, nil, err)
+			f.Close()
+			shared <- s
+		}(o)
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(release)
+	sharedCount := 0
+	for range opts {
+		if <-shared {
+			sharedCount++
+		}
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&downloads))
+	assert.Equal(t, 1, sharedCount)
+}
+
+// slowObject is an object without OpenWithContext whose Open blocks until
+// release is closed.
+type slowObject struct {
+	cloudstorage.Object
+	release  chan struct{}
+	released chan struct{}
+}
+
+func (o *slowObject) Open(accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	<-o.release
+	return o.Object.Open(accesslevel, opts...)
+}
+
+func (o *slowObject) Release() error {
+	defer close(o.released)
+	return o.Object.Release()
+}
+
+func TestOpenWithContext(t *testing.T) {
+	store := newLocalStore(t, "openctx")
+	writeObject(t, store, "a.csv", "a,b,c\n")
+	o, err := store.Get(context.Background(), "a.csv")
+	assert.Equal(t, nil, err)
+
+	// a cancelled open leaves no cached copy behind.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cloudstorage.OpenWithContext(ctx, o, cloudstorage.ReadOnly)
+	assert.Equal(t, context.Canceled, err)
+	cached := 0
+	filepath.Walk("/tmp/localcache_openctx", func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			cached++
+		}
+		return nil
+	})
+	assert.Equal(t, 0, cached)
+
+	f, err := cloudstorage.OpenWithContext(context.Background(), o, cloudstorage.ReadOnly)
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(f)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a,b,c\n", string(b))
+	assert.Equal(t, nil, o.Release())
+
+	// objects without OpenWithContext are released once their Open finishes.
+	o, err = store.Get(context.Background(), "a.csv")
+	assert.Equal(t, nil, err)
+	slow := &slowObject{Object: o, release: make(chan struct{}), released: make(chan struct{})}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = cloudstorage.OpenWithContext(ctx, slow, cloudstorage.ReadOnly)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	close(slow.release)
+	select {
+	case <-slow.released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("object was not released")
+	}
+
+	r := cloudstorage.NewContextReader(ctx, bytes.NewReader([]byte("abc")))
+	_, err = r.Read(make([]byte, 3))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestNewContextReadCloser(t *testing.T) {
+	// a read stuck on the stream is unblocked once ctx is done.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	rc := cloudstorage.NewContextReadCloser(ctx, pr)
+	errs := make(chan error)
+	go func() {
+		_, err := rc.Read(make([]byte, 10))
+		errs <- err
+	}()
+	cancel()
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("read was not unblocked")
+	}
+	_, err := pw.Write([]byte("a"))
+	assert.Equal(t, io.ErrClosedPipe, err)
+	assert.Equal(t, nil, rc.Close())
+
+	// the readers of the stores stop once ctx is done.
+	store := newLocalStore(t, "readerctx")
+	writeObject(t, store, "a.csv", "a,b,c\n")
+	ctx, cancel = context.WithCancel(context.Background())
+	rc, err = store.NewReaderWithContext(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	b := make([]byte, 2)
+	_, err = rc.Read(b)
+	assert.Equal(t, nil, err)
+	cancel()
+	_, err = rc.Read(b)
+	assert.Equal(t, context.Canceled, err)
+	rc.Close()
+}
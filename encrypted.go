@@ -0,0 +1,387 @@
+package cloudstorage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// encryptedChunkSize is the plaintext bytes sealed per chunk, so objects
+	// are encrypted and decrypted streaming rather than in memory.
+	encryptedChunkSize = 64 * 1024
+	// encryptedTagSize is the GCM authentication tag of each chunk.
+	encryptedTagSize = 16
+	// encryptedMagic starts the objects of an EncryptedStore, with the format
+	// version.
+	encryptedMagic = "cse\x01"
+	// encryptedHeaderSize is the magic and the nonce the objects start with.
+	encryptedHeaderSize = len(encryptedMagic) + 12
+)
+
+// EncryptedStore is a Store encrypting objects client side with AES-256-GCM,
+// so their bytes never leave the process in the clear.  Writers (NewWriter,
+// and the Sync and Close of objects opened ReadWrite) encrypt, readers
+// (NewReader, Open) decrypt.  Objects are a header of a random nonce, then
+// chunks of 64KiB sealed with the nonce xored with the chunk index and a
+// last chunk flag, so a truncated or reordered object fails authentication
+// like a modified one, with ErrDecryptionFailed.  Names and metadata aren't
+// encrypted.  Object Size is the plaintext size, MD5 is nil as the store's
+// is of the ciphertext, and SkipIfIdentical writes always write as the
+// ciphertext differs every write.
+type EncryptedStore struct {
+	Store
+	aead cipher.AEAD
+}
+
+// NewEncryptedStore create a store encrypting the objects of s with the 32
+// byte AES-256 key.
+func NewEncryptedStore(s Store, key []byte) (*EncryptedStore, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedStore{Store: s, aead: aead}, nil
+}
+
+// Get an object, which decrypts when opened.
+func (e *EncryptedStore) Get(ctx context.Context, name string) (Object, error) {
+	o, err := e.Store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedObject(o, e, false), nil
+}
+
+// Objects iterates objects, which decrypt when opened.
+func (e *EncryptedStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	iter, err := e.Store.Objects(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedIterator{ObjectIterator: iter, e: e}, nil
+}
+
+// List objects, which decrypt when opened.
+func (e *EncryptedStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	resp, err := e.Store.List(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for i, o := range resp.Objects {
+		resp.Objects[i] = newEncryptedObject(o, e, false)
+	}
+	return resp, nil
+}
+
+// NewObject creates an object, encrypted when synced.
+func (e *EncryptedStore) NewObject(name string) (Object, error) {
+	o, err := e.Store.NewObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedObject(o, e, true), nil
+}
+
+// NewReader of an object, decrypting it.
+func (e *EncryptedStore) NewReader(name string) (io.ReadCloser, error) {
+	return e.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of an object, decrypting it.  VerifyChecksum verifies
+// the ciphertext against the store's checksum, MaxBytes caps the plaintext.
+func (e *EncryptedStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	var ropts []ReadOptions
+	if len(opts) > 0 {
+		ropts = []ReadOptions{opts[0]}
+		ropts[0].MaxBytes = 0
+	}
+	rc, err := e.Store.NewReaderWithContext(ctx, name, ropts...)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := e.newDecryptingReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return MaxBytesReader(dr, opts), nil
+}
+
+// NewWriter to an object, encrypting it.
+func (e *EncryptedStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return e.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to an object, encrypting it.  A ContentMD5 is of the
+// plaintext, and checked before the write is committed.  DetectContentType
+// detects it from the plaintext.
+func (e *EncryptedStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	if DetectsContentType(metadata, opts) {
+		return NewContentTypeWriter(name, metadata, opts, func(md map[string]string, opts ...Opts) (io.WriteCloser, error) {
+			return e.NewWriterWithContext(ctx, name, md, opts...)
+		}), nil
+	}
+	var contentMD5 []byte
+	if len(opts) > 0 {
+		// the ciphertext can't be resumed part way, so the object is always
+		// written whole.
+		contentMD5 = opts[0].ContentMD5
+		opts = []Opts{opts[0]}
+		opts[0].ContentMD5 = nil
+		opts[0].Resume = false
+	}
+	wc, err := newStagedWriter(ctx, e.Store, name, metadata, opts)
+	if err != nil {
+		return nil, err
+	}
+	w := &encryptingWriter{
+		wc:     wc,
+		aead:   e.aead,
+		header: make([]byte, encryptedHeaderSize),
+		buf:    make([]byte, 0, encryptedChunkSize),
+	}
+	copy(w.header, encryptedMagic)
+	if _, err := rand.Read(w.header[len(encryptedMagic):]); err != nil {
+		wc.abort()
+		return nil, err
+	}
+	if contentMD5 != nil {
+		w.md5, w.contentMD5 = md5.New(), contentMD5
+	}
+	return w, nil
+}
+
+func (e *EncryptedStore) String() string {
+	return fmt.Sprintf("encrypted(%s)", e.Store)
+}
+
+// chunkNonce is the nonce of chunk n of an object, the header's nonce xored
+// with the index and whether it is the last chunk.
+func chunkNonce(header []byte, n uint64, last bool) []byte {
+	nonce := make([]byte, len(header)-len(encryptedMagic))
+	copy(nonce, header[len(encryptedMagic):])
+	x := n << 1
+	if last {
+		x |= 1
+	}
+	tail := binary.BigEndian.Uint64(nonce[len(nonce)-8:]) ^ x
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], tail)
+	return nonce
+}
+
+// plaintextSize is the size of the plaintext of an encrypted object of size
+// bytes.
+func plaintextSize(size int64) int64 {
+	n := size - int64(encryptedHeaderSize)
+	if n < encryptedTagSize {
+		// new, not encrypted or UnknownSize.
+		return size
+	}
+	chunks := (n + encryptedChunkSize + encryptedTagSize - 1) / (encryptedChunkSize + encryptedTagSize)
+	return n - chunks*encryptedTagSize
+}
+
+// encryptingWriter seals chunks of the bytes written to wc.  The last chunk
+// is sealed on Close, so a full chunk is only sealed once more bytes follow.
+type encryptingWriter struct {
+	wc     *stagedWriter
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	out    []byte
+	n      uint64
+	// md5 of the plaintext, checked against contentMD5 on Close.
+	md5        hash.Hash
+	contentMD5 []byte
+	err        error
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.md5 != nil {
+		w.md5.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == encryptedChunkSize {
+			if w.err = w.seal(false); w.err != nil {
+				return written, w.err
+			}
+		}
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// seal writes the buffered bytes as the next chunk, after the header if it
+// is the first.
+func (w *encryptingWriter) seal(last bool) error {
+	if w.n == 0 {
+		if _, err := w.wc.Write(w.header); err != nil {
+			return err
+		}
+	}
+	w.out = w.aead.Seal(w.out[:0], chunkNonce(w.header, w.n, last), w.buf, w.header)
+	w.buf = w.buf[:0]
+	w.n++
+	_, err := w.wc.Write(w.out)
+	return err
+}
+
+// Close seals the last chunk and commits the write, unless the plaintext
+// doesn't match the ContentMD5.
+func (w *encryptingWriter) Close() error {
+	if w.err == nil {
+		w.err = w.seal(true)
+	}
+	if w.err == nil && w.md5 != nil && !bytes.Equal(w.md5.Sum(nil), w.contentMD5) {
+		w.err = ErrChecksumMismatch
+	}
+	if w.err != nil {
+		w.wc.abort()
+		return w.err
+	}
+	return w.wc.Close()
+}
+
+// abort abandons the write, see stagedWriter.
+func (w *encryptingWriter) abort() {
+	w.wc.abort()
+}
+
+// newDecryptingReader reads the header of the object rc, failing with
+// ErrDecryptionFailed if it isn't one of an encrypted object.
+func (e *EncryptedStore) newDecryptingReader(rc io.ReadCloser) (*decryptingReader, error) {
+	r := bufio.NewReaderSize(rc, encryptedChunkSize+encryptedTagSize)
+	header := make([]byte, encryptedHeaderSize)
+	if _, err := io.ReadFull(r, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrDecryptionFailed
+	} else if err != nil {
+		return nil, err
+	}
+	if string(header[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, ErrDecryptionFailed
+	}
+	return &decryptingReader{
+		rc:     rc,
+		r:      r,
+		aead:   e.aead,
+		header: header,
+		chunk:  make([]byte, encryptedChunkSize+encryptedTagSize),
+	}, nil
+}
+
+// decryptingReader opens the chunks of an encrypted object as they are read.
+type decryptingReader struct {
+	rc     io.Closer
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	chunk  []byte
+	plain  []byte
+	n      uint64
+	done   bool
+	err    error
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and opens the next chunk, the last is the one the object ends
+// after.  An object ending without its last chunk was truncated.
+func (d *decryptingReader) open() error {
+	if d.done {
+		return io.EOF
+	}
+	k, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch err {
+	case nil:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		return ErrDecryptionFailed
+	default:
+		return err
+	}
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.header, d.n, last), d.chunk[:k], d.header)
+	if err != nil {
+		return ErrDecryptionFailed
+	}
+	d.plain = plain
+	d.n++
+	d.done = last
+	return nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.rc.Close()
+}
+
+type encryptedIterator struct {
+	ObjectIterator
+	e *EncryptedStore
+}
+
+func (it *encryptedIterator) Next() (Object, error) {
+	o, err := it.ObjectIterator.Next()
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedObject(o, it.e, false), nil
+}
+
+// encryptedObject opens to a local temp file of the plaintext.
+type encryptedObject struct {
+	*transcodedObject
+}
+
+func newEncryptedObject(o Object, e *EncryptedStore, created bool) *encryptedObject {
+	return &encryptedObject{&transcodedObject{Object: o, s: e, created: created}}
+}
+
+// Size of the plaintext.
+func (o *encryptedObject) Size() int64 {
+	return plaintextSize(o.Object.Size())
+}
+
+// MD5 is nil, the store's is of the ciphertext.
+func (o *encryptedObject) MD5() []byte {
+	return nil
+}
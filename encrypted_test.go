@@ -0,0 +1,119 @@
+package cloudstorage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	local := newLocalStore(t, "encrypted")
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{7}, 32)
+
+	_, err := cloudstorage.NewEncryptedStore(local, key[:16])
+	assert.NotEqual(t, nil, err)
+	store, err := cloudstorage.NewEncryptedStore(local, key)
+	assert.Equal(t, nil, err)
+
+	// empty, a single chunk, exactly a chunk and several chunks.
+	for _, size := range []int{0, 10, 64 * 1024, 200*1024 + 3} {
+		body := bytes.Repeat([]byte("secret"), size/6+1)[:size]
+		w, err := store.NewWriterWithContext(ctx, "a.csv", nil)
+		assert.Equal(t, nil, err)
+		_, err = w.Write(body)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+
+		raw := []byte(readAll(t, local, "a.csv"))
+		assert.Equal(t, "cse\x01", string(raw[:4]))
+		if size > 0 {
+			assert.False(t, bytes.Contains(raw, []byte("secret")))
+		}
+		assert.Equal(t, string(body), readAll(t, store, "a.csv"))
+		obj, err := store.Get(ctx, "a.csv")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, int64(size), obj.Size())
+	}
+
+	// objects open to their plaintext, and are encrypted back on Close.
+	obj, err := store.NewObject("b.csv")
+	assert.Equal(t, nil, err)
+	f, err := obj.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, nil, err)
+	_, err = f.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, obj.Close())
+	assert.NotEqual(t, "a,b,c\n", readAll(t, local, "b.csv"))
+	obj, err = store.Get(ctx, "b.csv")
+	assert.Equal(t, nil, err)
+	f, err = obj.Open(cloudstorage.ReadOnly)
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(f)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a,b,c\n", string(b))
+	_, err = obj.Write([]byte("d"))
+	assert.Equal(t, cloudstorage.ErrReadOnly, err)
+	assert.Equal(t, nil, obj.Close())
+
+	// a ContentMD5 is of the plaintext.
+	sum := md5.Sum([]byte("hello"))
+	w, err := store.NewWriterWithContext(ctx, "c.csv", nil, cloudstorage.Opts{ContentMD5: sum[:]})
+	assert.Equal(t, nil, err)
+	w.Write([]byte("hello"))
+	assert.Equal(t, nil, w.Close())
+	w, err = store.NewWriterWithContext(ctx, "d.csv", nil, cloudstorage.Opts{ContentMD5: sum[:]})
+	assert.Equal(t, nil, err)
+	w.Write([]byte("goodbye"))
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, w.Close())
+	_, err = local.Get(ctx, "d.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// nor does a mismatch over an object remove it.
+	w, err = store.NewWriterWithContext(ctx, "c.csv", nil, cloudstorage.Opts{ContentMD5: sum[:]})
+	assert.Equal(t, nil, err)
+	w.Write([]byte("goodbye"))
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, w.Close())
+	assert.Equal(t, "hello", readAll(t, store, "c.csv"))
+}
+
+func TestEncryptedStoreAuthentication(t *testing.T) {
+	local := newLocalStore(t, "encrypted_auth")
+	ctx := context.Background()
+	store, err := cloudstorage.NewEncryptedStore(local, bytes.Repeat([]byte{7}, 32))
+	assert.Equal(t, nil, err)
+	writeObject(t, store, "a.csv", string(bytes.Repeat([]byte("x"), 100*1024)))
+	raw := []byte(readAll(t, local, "a.csv"))
+
+	readErr := func(s cloudstorage.Store, name string) error {
+		rc, err := s.NewReaderWithContext(ctx, name)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = ioutil.ReadAll(rc)
+		return err
+	}
+
+	other, err := cloudstorage.NewEncryptedStore(local, bytes.Repeat([]byte{8}, 32))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, cloudstorage.ErrDecryptionFailed, readErr(other, "a.csv"))
+
+	modified := append([]byte{}, raw...)
+	modified[len(modified)-100] ^= 1
+	writeObject(t, local, "modified.csv", string(modified))
+	assert.Equal(t, cloudstorage.ErrDecryptionFailed, readErr(store, "modified.csv"))
+
+	// dropping the last chunk leaves a whole chunk that isn't the last.
+	writeObject(t, local, "truncated.csv", string(raw[:16+64*1024+16]))
+	assert.Equal(t, cloudstorage.ErrDecryptionFailed, readErr(store, "truncated.csv"))
+
+	writeObject(t, local, "plain.csv", "a,b,c\n")
+	assert.Equal(t, cloudstorage.ErrDecryptionFailed, readErr(store, "plain.csv"))
+}
@@ -0,0 +1,185 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// ErrorTrackingStore is a Store remembering the last error of its
+// operations, ie for a health endpoint to show the last failure.  Errors of
+// the store, readers, writers and iterators are tracked, ErrObjectNotFound
+// and context.Canceled aren't as they aren't failures of the store.  A
+// successful operation clears the error, so LastError is only set while the
+// store is failing.  Operations on objects (Open, Sync ...) aren't tracked.
+type ErrorTrackingStore struct {
+	Store
+	mu        sync.Mutex
+	lastErr   error
+	lastErrAt time.Time
+	lastOK    time.Time
+}
+
+// NewErrorTrackingStore create a store tracking the errors of s.
+func NewErrorTrackingStore(s Store) *ErrorTrackingStore {
+	return &ErrorTrackingStore{Store: s}
+}
+
+// LastError is the error of the last operation, and when it failed, nil if
+// it succeeded.
+func (t *ErrorTrackingStore) LastError() (error, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastErr, t.lastErrAt
+}
+
+// LastSuccess is when an operation last succeeded, zero if none has, to tell
+// a store that never worked from intermittent failures.
+func (t *ErrorTrackingStore) LastSuccess() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastOK
+}
+
+// track records the result err of an operation, returning it.
+func (t *ErrorTrackingStore) track(err error) error {
+	if err == context.Canceled {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil || err == ErrObjectNotFound || err == iterator.Done || err == io.EOF {
+		t.lastErr = nil
+		t.lastOK = time.Now()
+	} else {
+		t.lastErr = err
+		t.lastErrAt = time.Now()
+	}
+	return err
+}
+
+// Get an object, tracking errors.
+func (t *ErrorTrackingStore) Get(ctx context.Context, name string) (Object, error) {
+	o, err := t.Store.Get(ctx, name)
+	return o, t.track(err)
+}
+
+// Objects iterates objects, tracking errors.
+func (t *ErrorTrackingStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	iter, err := t.Store.Objects(ctx, q)
+	if t.track(err) != nil {
+		return nil, err
+	}
+	return &trackingIterator{iter, t}, nil
+}
+
+// List objects, tracking errors.
+func (t *ErrorTrackingStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	resp, err := t.Store.List(ctx, q)
+	return resp, t.track(err)
+}
+
+// Folders lists folders, tracking errors.
+func (t *ErrorTrackingStore) Folders(ctx context.Context, q Query) ([]string, error) {
+	folders, err := t.Store.Folders(ctx, q)
+	return folders, t.track(err)
+}
+
+// NewReader of an object, tracking errors.
+func (t *ErrorTrackingStore) NewReader(name string) (io.ReadCloser, error) {
+	return t.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of an object, tracking errors.
+func (t *ErrorTrackingStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	rc, err := t.Store.NewReaderWithContext(ctx, name, opts...)
+	if t.track(err) != nil {
+		return nil, err
+	}
+	return &trackingReader{rc, t}, nil
+}
+
+// NewWriter to an object, tracking errors.
+func (t *ErrorTrackingStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return t.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to an object, tracking errors including of Close.
+func (t *ErrorTrackingStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	wc, err := t.Store.NewWriterWithContext(ctx, name, metadata, opts...)
+	if t.track(err) != nil {
+		return nil, err
+	}
+	return &trackingWriter{wc, t}, nil
+}
+
+// NewObject creates an object, tracking errors.
+func (t *ErrorTrackingStore) NewObject(name string) (Object, error) {
+	o, err := t.Store.NewObject(name)
+	if err == ErrObjectExists {
+		// the store answered
+		t.track(nil)
+		return o, err
+	}
+	return o, t.track(err)
+}
+
+// Delete an object, tracking errors.
+func (t *ErrorTrackingStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	return t.track(t.Store.Delete(ctx, name, opts...))
+}
+
+func (t *ErrorTrackingStore) String() string {
+	return fmt.Sprintf("tracked(%s)", t.Store)
+}
+
+type trackingIterator struct {
+	ObjectIterator
+	t *ErrorTrackingStore
+}
+
+func (it *trackingIterator) Next() (Object, error) {
+	o, err := it.ObjectIterator.Next()
+	if err != nil {
+		it.t.track(err)
+	}
+	return o, err
+}
+
+type trackingReader struct {
+	io.ReadCloser
+	t *ErrorTrackingStore
+}
+
+func (r *trackingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.t.track(err)
+	}
+	return n, err
+}
+
+type trackingWriter struct {
+	io.WriteCloser
+	t *ErrorTrackingStore
+}
+
+func (w *trackingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	if err != nil {
+		w.t.track(err)
+	}
+	return n, err
+}
+
+func (w *trackingWriter) Close() error {
+	return w.t.track(w.WriteCloser.Close())
+}
+
+func (w *trackingWriter) Resumed() int64 {
+	return Resumed(w.WriteCloser)
+}
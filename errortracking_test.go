@@ -0,0 +1,36 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestErrorTrackingStore(t *testing.T) {
+	store := cloudstorage.NewErrorTrackingStore(&failingStore{newLocalStore(t, "errortracking")})
+	ctx := context.Background()
+
+	err, _ := store.LastError()
+	assert.Equal(t, nil, err)
+	assert.True(t, store.LastSuccess().IsZero())
+
+	// not found isn't a failure
+	_, err = store.Get(ctx, "missing.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	assert.False(t, store.LastSuccess().IsZero())
+
+	assert.NotEqual(t, nil, store.Delete(ctx, "missing.csv"))
+	err, at := store.LastError()
+	assert.Equal(t, "replica unavailable", err.Error())
+	assert.False(t, at.IsZero())
+	assert.True(t, store.LastSuccess().Before(at))
+
+	// cleared on the next success
+	_, err = store.List(ctx, cloudstorage.NewQuery(""))
+	assert.Equal(t, nil, err)
+	err, _ = store.LastError()
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,189 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultHedgeDelay is how long a FastestReadStore waits for a mirror before
+// also asking the next one.
+const DefaultHedgeDelay = 50 * time.Millisecond
+
+// FastestReadStore is a Store reading from whichever of several mirrors
+// answers first, ie to cut tail latency of reads from geo distributed
+// buckets.  Reads are hedged, the first mirror is asked, and if it hasn't
+// answered within HedgeDelay (or has failed) the next one is asked too, and
+// so on, the first successful response wins and the others are cancelled.
+// Objects and readers are those of the mirror that answered.  Listing,
+// writes and deletes are on the first mirror, the primary, unless WriteAll.
+type FastestReadStore struct {
+	Store
+	mirrors []Store
+	// HedgeDelay before asking the next mirror, defaults to DefaultHedgeDelay.
+	HedgeDelay time.Duration
+	// WriteAll mirrors writes and deletes to all mirrors, as a
+	// ReplicatedStore of the primary would.
+	WriteAll bool
+	// Policy for mirror write failures if WriteAll.
+	Policy ReplicaPolicy
+}
+
+// NewFastestReadStore create a store reading from the fastest of primary and
+// mirrors, asked in that order.
+func NewFastestReadStore(primary Store, mirrors ...Store) *FastestReadStore {
+	return &FastestReadStore{Store: primary, mirrors: append([]Store{primary}, mirrors...)}
+}
+
+// Get an object from the fastest mirror that has it.
+func (f *FastestReadStore) Get(ctx context.Context, name string) (Object, error) {
+	v, cancel, err := f.race(ctx, func(ctx context.Context, s Store) (interface{}, error) {
+		return s.Get(ctx, name)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	cancel()
+	return v.(Object), nil
+}
+
+// NewReader of the object on the fastest mirror that has it.
+func (f *FastestReadStore) NewReader(name string) (io.ReadCloser, error) {
+	return f.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of the object on the fastest mirror that has it.
+func (f *FastestReadStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	v, cancel, err := f.race(ctx, func(ctx context.Context, s Store) (interface{}, error) {
+		return s.NewReaderWithContext(ctx, name, opts...)
+	}, func(v interface{}) {
+		v.(io.ReadCloser).Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cancelReader{v.(io.ReadCloser), cancel}, nil
+}
+
+// NewWriter to the primary, or all mirrors if WriteAll.
+func (f *FastestReadStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return f.writes().NewWriter(name, metadata)
+}
+
+// NewWriterWithContext to the primary, or all mirrors if WriteAll.
+func (f *FastestReadStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	return f.writes().NewWriterWithContext(ctx, name, metadata, opts...)
+}
+
+// NewObject on the primary, or all mirrors if WriteAll.
+func (f *FastestReadStore) NewObject(name string) (Object, error) {
+	return f.writes().NewObject(name)
+}
+
+// Delete from the primary, or all mirrors if WriteAll.
+func (f *FastestReadStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	return f.writes().Delete(ctx, name, opts...)
+}
+
+func (f *FastestReadStore) String() string {
+	return fmt.Sprintf("fastest(%s)", f.Store)
+}
+
+func (f *FastestReadStore) writes() Store {
+	if !f.WriteAll {
+		return f.Store
+	}
+	r := NewReplicatedStore(f.Store, f.mirrors[1:]...)
+	r.Policy = f.Policy
+	return r
+}
+
+type hedgeResult struct {
+	i   int
+	v   interface{}
+	err error
+}
+
+// race calls read on the mirrors, hedged, returning the first success and
+// the cancel of its context, which the caller must call once done with it.
+// Later successes are passed to release.  If all mirrors fail the first
+// error is returned.
+func (f *FastestReadStore) race(ctx context.Context, read func(ctx context.Context, s Store) (interface{}, error),
+	release func(v interface{})) (interface{}, context.CancelFunc, error) {
+
+	delay := f.HedgeDelay
+	if delay <= 0 {
+		delay = DefaultHedgeDelay
+	}
+	results := make(chan hedgeResult, len(f.mirrors))
+	cancels := make([]context.CancelFunc, 0, len(f.mirrors))
+	var hedge <-chan time.Time
+	launch := func() {
+		i := len(cancels)
+		mctx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			v, err := read(mctx, f.mirrors[i])
+			results <- hedgeResult{i, v, err}
+		}()
+		hedge = nil
+		if len(cancels) < len(f.mirrors) {
+			hedge = time.After(delay)
+		}
+	}
+	// cancel the others, releasing any that still succeed
+	abandon := func(winner, pending int) {
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+		go func() {
+			for ; pending > 0; pending-- {
+				if res := <-results; res.err == nil && release != nil {
+					release(res.v)
+				}
+			}
+		}()
+	}
+
+	launch()
+	var firstErr error
+	for done := 0; done < len(f.mirrors); {
+		select {
+		case <-hedge:
+			launch()
+		case res := <-results:
+			done++
+			if res.err == nil {
+				abandon(res.i, len(cancels)-done)
+				return res.v, cancels[res.i], nil
+			}
+			cancels[res.i]()
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if done == len(cancels) && done < len(f.mirrors) {
+				// nothing in flight, don't wait to ask the next one
+				launch()
+			}
+		case <-ctx.Done():
+			abandon(-1, len(cancels)-done)
+			return nil, nil, ctx.Err()
+		}
+	}
+	return nil, nil, firstErr
+}
+
+// cancelReader cancels the context of the read on Close.
+type cancelReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
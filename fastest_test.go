@@ -0,0 +1,100 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// slowStore delays reads, counting those cancelled while waiting.
+type slowStore struct {
+	cloudstorage.Store
+	delay     time.Duration
+	cancelled int32
+}
+
+func (s *slowStore) wait(ctx context.Context) error {
+	select {
+	case <-time.After(s.delay):
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt32(&s.cancelled, 1)
+		return ctx.Err()
+	}
+}
+
+func (s *slowStore) Get(ctx context.Context, name string) (cloudstorage.Object, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(ctx, name)
+}
+
+func (s *slowStore) NewReaderWithContext(ctx context.Context, name string, opts ...cloudstorage.ReadOptions) (io.ReadCloser, error) {
+	if err := s.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.NewReaderWithContext(ctx, name, opts...)
+}
+
+func writeObject(t *testing.T, s cloudstorage.Store, name, body string) {
+	w, err := s.NewWriterWithContext(context.Background(), name, nil)
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte(body))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+}
+
+func TestFastestReadStore(t *testing.T) {
+	primary := &slowStore{Store: newLocalStore(t, "fastest_primary"), delay: time.Second}
+	mirror := newLocalStore(t, "fastest_mirror")
+	ctx := context.Background()
+	for _, s := range []cloudstorage.Store{primary.Store, mirror} {
+		writeObject(t, s, "both.txt", "both")
+	}
+	writeObject(t, mirror, "mirrored.txt", "mirror")
+
+	f := cloudstorage.NewFastestReadStore(primary, mirror)
+	f.HedgeDelay = 10 * time.Millisecond
+
+	// the slow primary is hedged, then cancelled
+	start := time.Now()
+	assert.Equal(t, "both", readAll(t, f, "both.txt"))
+	obj, err := f.Get(ctx, "both.txt")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "both.txt", obj.Name())
+	assert.True(t, time.Since(start) < primary.delay)
+	for i := 0; i < 100 && atomic.LoadInt32(&primary.cancelled) < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&primary.cancelled))
+
+	// only on a mirror
+	primary.delay = 0
+	assert.Equal(t, "mirror", readAll(t, f, "mirrored.txt"))
+	_, err = f.Get(ctx, "missing.txt")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// writes go to the primary, or all mirrors
+	writeObject(t, f, "primary.txt", "primary")
+	_, err = mirror.Get(ctx, "primary.txt")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	f.WriteAll = true
+	writeObject(t, f, "all.txt", "all")
+	assert.Equal(t, "all", readAll(t, mirror, "all.txt"))
+	assert.Equal(t, nil, f.Delete(ctx, "all.txt"))
+	_, err = mirror.Get(ctx, "all.txt")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// a primary without mirrors is just the primary
+	single := cloudstorage.NewFastestReadStore(mirror)
+	assert.Equal(t, "mirror", readAll(t, single, "mirrored.txt"))
+	writeObject(t, single, "single.txt", "single")
+	assert.Equal(t, "single", readAll(t, mirror, "single.txt"))
+}
@@ -3,6 +3,7 @@ package cloudstorage
 import (
 	"fmt"
 	"mime"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -48,10 +49,30 @@ func ContentType(name string) string {
 	return contenttype
 }
 
+// DetectContentType of an object from the extension of its name, or if that
+// isn't a known type by sniffing the first (up to 512) bytes of data with
+// http.DetectContentType.
+func DetectContentType(name string, data []byte) string {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype
+	}
+	return http.DetectContentType(data)
+}
+
+// MetadataContentType is the content type set in metadata md by its
+// ContentTypeKey, or else its ContentTypeHeader, empty if it has neither.
+func MetadataContentType(md map[string]string) string {
+	if ctype := md[ContentTypeKey]; ctype != "" {
+		return ctype
+	}
+	return md[ContentTypeHeader]
+}
+
 // EnsureContextType read Type of metadata
 func EnsureContextType(o string, md map[string]string) string {
 	ctype, ok := md[ContentTypeKey]
 	if !ok {
+		ctype = md[ContentTypeHeader]
 		ext := filepath.Ext(o)
 		if ctype == "" {
 			ctype = mime.TypeByExtension(ext)
@@ -17,3 +17,21 @@ func TestContentType(t *testing.T) {
 	assert.Equal(t, "application/json", ContentType("data.json"))
 	assert.Equal(t, "application/octet-stream", ContentType("data.unknown"))
 }
+func TestDetectContentType(t *testing.T) {
+	assert.Equal(t, "text/csv; charset=utf-8", DetectContentType("data.csv", []byte("a,b,c\n")))
+	assert.Equal(t, "image/png", DetectContentType("image", []byte("\x89PNG\x0D\x0A\x1A\x0A")))
+	assert.Equal(t, "text/html; charset=utf-8", DetectContentType("page", []byte("<html><body>hi</body></html>")))
+	assert.Equal(t, "application/octet-stream", DetectContentType("blob", []byte{0x00, 0x01, 0x02}))
+}
+func TestMetadataContentType(t *testing.T) {
+	assert.Equal(t, "", MetadataContentType(nil))
+	assert.Equal(t, "text/csv", MetadataContentType(map[string]string{ContentTypeHeader: "text/csv"}))
+	assert.Equal(t, "application/json", MetadataContentType(map[string]string{
+		ContentTypeKey:    "application/json",
+		ContentTypeHeader: "text/csv",
+	}))
+
+	md := map[string]string{ContentTypeHeader: "application/gzip"}
+	assert.Equal(t, "application/gzip", EnsureContextType("data.csv", md))
+	assert.Equal(t, "application/gzip", md[ContentTypeKey])
+}
@@ -0,0 +1,1003 @@
+package gdrive
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+	drive "google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/google"
+)
+
+const (
+	// StoreType = "gdrive" this is used to define the storage type to create
+	// from cloudstorage.NewStore(config)
+	StoreType = "gdrive"
+
+	// folderMimeType is the mime type of Drive folders.
+	folderMimeType = "application/vnd.google-apps.folder"
+	// appsMimeTypePrefix of the Docs, Sheets ... files, which have no bytes
+	// to download, only exports.
+	appsMimeTypePrefix = "application/vnd.google-apps."
+
+	fileFields = "id, name, mimeType, size, createdTime, modifiedTime, md5Checksum, version, appProperties"
+)
+
+var (
+	// ErrAmbiguousName more than one Drive file (or folder) has a name of
+	// the path, Drive names aren't unique within a folder.  The store
+	// doesn't pick one, rename or remove the duplicates in Drive.
+	ErrAmbiguousName = fmt.Errorf("more than one google drive file has the name")
+	// ErrNotDownloadable the file is a Docs, Sheets etc file, which can only
+	// be exported.
+	ErrNotDownloadable = fmt.Errorf("google drive apps files can't be downloaded")
+
+	_ cloudstorage.ObjectOpenContext = (*object)(nil)
+	_ cloudstorage.ObjectRange       = (*object)(nil)
+	_ cloudstorage.StoreListLevel    = (*FS)(nil)
+)
+
+func init() {
+	// Register this Driver (gdrive) in cloudstorage driver registry.
+	cloudstorage.Register(StoreType, func(conf *cloudstorage.Config) (cloudstorage.Store, error) {
+		service, err := NewClient(conf)
+		if err != nil {
+			return nil, err
+		}
+		return NewStore(service, conf)
+	})
+}
+
+// FS is a Google Drive folder as a store.  The folders of object names ("/"
+// delimited) are Drive folders under the Config.Bucket folder (the id of a
+// folder, or "root" of the users My Drive), and the last segment is the name
+// of a file in that folder.
+//
+// Drive isn't an object store, so:  Drive names aren't unique, a path with
+// more than one file or folder of a name is ErrAmbiguousName, rather than
+// one being picked.  Folders are created as objects are written under them,
+// not removed with their last object.  Creating a file or folder of a name
+// is serialized in this process, and the duplicates of concurrent creates by
+// other processes are removed, keeping the first created.  Listings walk the
+// folders under the prefix (Drive has no prefix listing), so are a request
+// per folder and page, and partial names (prefixes not ending in "/") are
+// filtered client side.  Writes and deletes can't be conditional, and
+// metadata is written as Drive appProperties, which are limited to 124 bytes
+// per key and value.
+type FS struct {
+	PageSize  int
+	ID        string
+	service   *drive.Service
+	root      string
+	cachepath string
+
+	mu sync.Mutex
+	// folders are the ids of found folders by path ("a/b"), root is "".
+	folders map[string]string
+}
+
+type object struct {
+	fs       *FS
+	id       string
+	name     string
+	mimeType string
+	size     int64
+	updated  time.Time
+	md5      []byte
+	version  int64
+	metadata map[string]string
+
+	cachepath  string
+	cachedcopy *os.File
+	readonly   bool
+	opened     bool
+	// ctx of OpenWithContext, of the download and the upload of Sync.
+	ctx context.Context
+}
+
+// NewClient create a Drive service of the google auth of conf, with the
+// drive scope unless it is a JwtConf with its Scopes.
+func NewClient(conf *cloudstorage.Config) (*drive.Service, error) {
+	var client google.GoogleOAuthClient
+	var err error
+	switch conf.AuthMethod {
+	case google.AuthGCEDefaultOAuthToken:
+		client, err = google.BuildDefaultGoogleTransporter(drive.DriveScope)
+	case google.AuthGoogleJWTKeySource:
+		scope := conf.Scope
+		if scope == "" {
+			scope = drive.DriveScope
+		}
+		client, err = google.BuildGoogleFileJWTTransporter(conf.JwtFile, scope)
+	default:
+		client, err = google.NewGoogleClient(conf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return drive.NewService(context.Background(), option.WithHTTPClient(client.Client()))
+}
+
+// NewStore Create Google Drive storage client of type cloudstorage.Store
+func NewStore(service *drive.Service, conf *cloudstorage.Config) (*FS, error) {
+	if conf.TmpDir == "" {
+		return nil, fmt.Errorf("unable to create cachepath. config.tmpdir=%q", conf.TmpDir)
+	}
+	if err := os.MkdirAll(conf.TmpDir, 0775); err != nil {
+		return nil, fmt.Errorf("unable to create cachepath. config.tmpdir=%q err=%v", conf.TmpDir, err)
+	}
+	root := conf.Bucket
+	if root == "" {
+		root = "root"
+	}
+
+	uid := uuid.NewUUID().String()
+	uid = strings.Replace(uid, "-", "", -1)
+
+	return &FS{
+		PageSize:  cloudstorage.MaxResults,
+		ID:        uid,
+		service:   service,
+		root:      root,
+		cachepath: conf.TmpDir,
+		folders:   map[string]string{"": root},
+	}, nil
+}
+
+// Type of store = "gdrive"
+func (f *FS) Type() string {
+	return StoreType
+}
+
+// Client gets access to the underlying Drive service.
+func (f *FS) Client() interface{} {
+	return f.service
+}
+
+// ResolveKey is the name, the folders and name of the file in Drive.
+func (f *FS) ResolveKey(o string) string {
+	return o
+}
+
+func (f *FS) String() string {
+	return fmt.Sprintf("gdrive://%s/", f.root)
+}
+
+// escape a name for a files.list query string.
+var escape = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace
+
+func isNotFound(err error) bool {
+	if e, ok := err.(*googleapi.Error); ok {
+		return e.Code == http.StatusNotFound
+	}
+	return false
+}
+
+// find the files (folders if folder) named name in the folder parent.
+func (f *FS) find(ctx context.Context, parent, name string, folder bool) ([]*drive.File, error) {
+	op := "!="
+	if folder {
+		op = "="
+	}
+	q := fmt.Sprintf("'%s' in parents and name = '%s' and mimeType %s '%s' and trashed = false", escape(parent), escape(name), op, folderMimeType)
+	res, err := f.service.Files.List().Q(q).Fields("files(" + fileFields + ")").
+		SupportsAllDrives(true).IncludeItemsFromAllDrives(true).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return res.Files, nil
+}
+
+// creating are the locks of the names being created in a folder, so that
+// writers in this process don't each create a file (or folder) of a new
+// name.  Creates by other processes aren't locked out, the duplicates they
+// make are resolved by created.
+var creating = struct {
+	sync.Mutex
+	locks map[string]*createLock
+}{locks: make(map[string]*createLock)}
+
+type createLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockCreate locks the creation of name (a folder if folder) in the folder
+// parent, returning its unlock.
+func lockCreate(parent, name string, folder bool) func() {
+	key := parent + "/" + name
+	if folder {
+		key += "/"
+	}
+	creating.Lock()
+	cl, ok := creating.locks[key]
+	if !ok {
+		cl = &createLock{}
+		creating.locks[key] = cl
+	}
+	cl.refs++
+	creating.Unlock()
+
+	cl.Lock()
+	return func() {
+		cl.Unlock()
+		creating.Lock()
+		cl.refs--
+		if cl.refs == 0 {
+			delete(creating.locks, key)
+		}
+		creating.Unlock()
+	}
+}
+
+// created resolves the file (folder if folder) id just created of name in
+// parent, with the files of the name found after creating it.  If another
+// writer created one too, the first created is kept and the id of it
+// returned, the others being removed by their writers.
+func (f *FS) created(ctx context.Context, parent, name string, folder bool, id string) (string, error) {
+	files, err := f.find(ctx, parent, name, folder)
+	if err != nil {
+		return "", err
+	}
+	first := id
+	firstCreated := ""
+	for _, file := range files {
+		if file.Id == id {
+			firstCreated = file.CreatedTime
+		}
+	}
+	for _, file := range files {
+		if file.CreatedTime < firstCreated || (file.CreatedTime == firstCreated && file.Id < first) {
+			first, firstCreated = file.Id, file.CreatedTime
+		}
+	}
+	if first != id {
+		err := f.service.Files.Delete(id).SupportsAllDrives(true).Context(ctx).Do()
+		if err != nil && !isNotFound(err) {
+			return "", err
+		}
+	}
+	return first, nil
+}
+
+// folder is the id of the folder of path dir, created (with its parents) if
+// create and it doesn't exist, otherwise ErrObjectNotFound.
+func (f *FS) folder(ctx context.Context, dir string, create bool) (string, error) {
+	dir = strings.Trim(dir, "/")
+	f.mu.Lock()
+	id, ok := f.folders[dir]
+	f.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	parentDir, name := split(dir)
+	parent, err := f.folder(ctx, parentDir, create)
+	if err != nil {
+		return "", err
+	}
+	folders, err := f.find(ctx, parent, name, true)
+	if err != nil {
+		return "", err
+	}
+	if len(folders) == 0 && create {
+		id, err = f.createFolder(ctx, parent, name)
+		if err != nil {
+			return "", err
+		}
+		folders = []*drive.File{{Id: id}}
+	}
+	switch len(folders) {
+	case 0:
+		return "", cloudstorage.ErrObjectNotFound
+	case 1:
+		id = folders[0].Id
+	default:
+		return "", ErrAmbiguousName
+	}
+	f.mu.Lock()
+	f.folders[dir] = id
+	f.mu.Unlock()
+	return id, nil
+}
+
+// createFolder name in parent, unless a writer created it since it wasn't
+// found, returning its id.
+func (f *FS) createFolder(ctx context.Context, parent, name string) (string, error) {
+	defer lockCreate(parent, name, true)()
+	folders, err := f.find(ctx, parent, name, true)
+	if err != nil {
+		return "", err
+	}
+	switch len(folders) {
+	case 0:
+	case 1:
+		return folders[0].Id, nil
+	default:
+		return "", ErrAmbiguousName
+	}
+	created, err := f.service.Files.Create(&drive.File{Name: name, MimeType: folderMimeType, Parents: []string{parent}}).
+		Fields("id").SupportsAllDrives(true).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return f.created(ctx, parent, name, true, created.Id)
+}
+
+// file of object name, ErrObjectNotFound if it (or its folder) doesn't
+// exist.
+func (f *FS) file(ctx context.Context, name string) (*drive.File, error) {
+	if name == "" || strings.HasSuffix(name, "/") {
+		// folders are Drive folders, never objects.
+		return nil, cloudstorage.ErrObjectNotFound
+	}
+	dir, base := split(name)
+	parent, err := f.folder(ctx, dir, false)
+	if err != nil {
+		return nil, err
+	}
+	files, err := f.find(ctx, parent, base, false)
+	if err != nil {
+		return nil, err
+	}
+	switch len(files) {
+	case 0:
+		return nil, cloudstorage.ErrObjectNotFound
+	case 1:
+		return files[0], nil
+	}
+	return nil, ErrAmbiguousName
+}
+
+func (f *FS) newObject(name string, file *drive.File) *object {
+	o := &object{
+		fs:        f,
+		name:      name,
+		cachepath: cloudstorage.CachePathObj(f.cachepath, name, f.ID),
+	}
+	if file != nil {
+		o.id = file.Id
+		o.mimeType = file.MimeType
+		o.size = file.Size
+		o.updated, _ = time.Parse(time.RFC3339, file.ModifiedTime)
+		o.md5, _ = hex.DecodeString(file.Md5Checksum)
+		o.version = file.Version
+		o.metadata = file.AppProperties
+	}
+	return o
+}
+
+// NewObject of name, ErrObjectExists if there is one.
+func (f *FS) NewObject(name string) (cloudstorage.Object, error) {
+	_, err := f.file(context.Background(), name)
+	if err == nil {
+		return nil, cloudstorage.ErrObjectExists
+	} else if err != cloudstorage.ErrObjectNotFound {
+		return nil, err
+	}
+	return f.newObject(name, nil), nil
+}
+
+// Get the object name, from the attributes of its Drive file.
+func (f *FS) Get(ctx context.Context, name string) (_ cloudstorage.Object, err error) {
+	defer cloudstorage.RecoverPanic("gdrive get", &err)
+	file, err := f.file(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(name, file), nil
+}
+
+// children of folder id, the files and folders in it.
+func (f *FS) children(ctx context.Context, id string) ([]*drive.File, error) {
+	var files []*drive.File
+	q := fmt.Sprintf("'%s' in parents and trashed = false", escape(id))
+	err := f.service.Files.List().Q(q).Fields("nextPageToken, files("+fileFields+")").PageSize(1000).
+		SupportsAllDrives(true).IncludeItemsFromAllDrives(true).Context(ctx).
+		Pages(ctx, func(res *drive.FileList) error {
+			files = append(files, res.Files...)
+			return nil
+		})
+	return files, err
+}
+
+// split a name (or prefix) into its folder and the rest, the name in it.
+func split(prefix string) (dir, start string) {
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		return prefix[:i], prefix[i+1:]
+	}
+	return "", prefix
+}
+
+// walk the objects under prefix, in name order.  A path of more than one
+// file, or folder, is ErrAmbiguousName.
+func (f *FS) walk(ctx context.Context, prefix string) ([]*object, error) {
+	dir, _ := split(prefix)
+	id, err := f.folder(ctx, dir, false)
+	if err == cloudstorage.ErrObjectNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var objects []*object
+	// seen paths, folders with a trailing "/".
+	seen := make(map[string]bool)
+	folders := []struct{ id, path string }{{id, dir}}
+	for len(folders) > 0 {
+		folder := folders[0]
+		folders = folders[1:]
+		files, err := f.children(ctx, folder.id)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			name := file.Name
+			if folder.path != "" {
+				name = folder.path + "/" + file.Name
+			}
+			if file.MimeType == folderMimeType {
+				if strings.HasPrefix(name+"/", prefix) || strings.HasPrefix(prefix, name+"/") {
+					if seen[name+"/"] {
+						return nil, ErrAmbiguousName
+					}
+					seen[name+"/"] = true
+					folders = append(folders, struct{ id, path string }{file.Id, name})
+				}
+				continue
+			}
+			if strings.HasPrefix(name, prefix) {
+				if seen[name] {
+					return nil, ErrAmbiguousName
+				}
+				seen[name] = true
+				objects = append(objects, f.newObject(name, file))
+			}
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].name < objects[j].name })
+	return objects, nil
+}
+
+// List objects under the Query Prefix in name order, a page of PageSize
+// objects after the Marker.  Each List walks the folders under the prefix,
+// iterate with Objects to walk them once.
+func (f *FS) List(ctx context.Context, q cloudstorage.Query) (_ *cloudstorage.ObjectsResponse, err error) {
+	defer cloudstorage.RecoverPanic("gdrive list", &err)
+	objects, err := f.walk(ctx, q.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if q.PageSize <= 0 {
+		q.PageSize = f.PageSize
+	}
+	all := make(cloudstorage.Objects, len(objects))
+	for i, o := range objects {
+		all[i] = o
+	}
+	resp := cloudstorage.PageObjects(all, q)
+	resp.Objects = q.ApplyFilters(resp.Objects)
+	return resp, nil
+}
+
+// Objects returns an iterator over the objects that match the Query q, the
+// folders under the prefix are walked once for all of its pages.
+func (f *FS) Objects(ctx context.Context, q cloudstorage.Query) (cloudstorage.ObjectIterator, error) {
+	return cloudstorage.NewObjectPageIterator(ctx, &walkedPages{FS: f}, q), nil
+}
+
+// walkedPages lists the pages of an Objects iteration from one walk.
+type walkedPages struct {
+	*FS
+	objects cloudstorage.Objects
+	walked  bool
+}
+
+func (p *walkedPages) List(ctx context.Context, q cloudstorage.Query) (_ *cloudstorage.ObjectsResponse, err error) {
+	defer cloudstorage.RecoverPanic("gdrive list", &err)
+	if !p.walked {
+		objects, err := p.walk(ctx, q.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		p.objects = make(cloudstorage.Objects, len(objects))
+		for i, o := range objects {
+			p.objects[i] = o
+		}
+		p.walked = true
+	}
+	if q.PageSize <= 0 {
+		q.PageSize = p.PageSize
+	}
+	resp := cloudstorage.PageObjects(p.objects, q)
+	resp.Objects = q.ApplyFilters(resp.Objects)
+	return resp, nil
+}
+
+// Folders are the Drive folders in the folder of the Query Prefix whose
+// names start with the rest of it.
+func (f *FS) Folders(ctx context.Context, q cloudstorage.Query) (_ []string, err error) {
+	defer cloudstorage.RecoverPanic("gdrive folders", &err)
+	_, folders, err := f.ListLevel(ctx, q.Prefix, 0)
+	return folders, err
+}
+
+// ListLevel the files and folders in the folder of prefix (partial names are
+// filtered), with a request per page of the folder.  A name of more than one
+// file, or folder, is ErrAmbiguousName.
+func (f *FS) ListLevel(ctx context.Context, prefix string, limit int) (cloudstorage.Objects, []string, error) {
+	dir, start := split(prefix)
+	id, err := f.folder(ctx, dir, false)
+	if err == cloudstorage.ErrObjectNotFound {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	files, err := f.children(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	base := ""
+	if dir != "" {
+		base = dir + "/"
+	}
+	var objects cloudstorage.Objects
+	folders := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name, start) {
+			continue
+		}
+		name := base + file.Name
+		if file.MimeType == folderMimeType {
+			name += "/"
+		}
+		if seen[name] {
+			return nil, nil, ErrAmbiguousName
+		}
+		seen[name] = true
+		if file.MimeType == folderMimeType {
+			folders = append(folders, name)
+		} else {
+			objects = append(objects, f.newObject(name, file))
+		}
+	}
+	sort.Strings(folders)
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name() < objects[j].Name() })
+	objects, folders = cloudstorage.LimitLevel(objects, folders, limit)
+	return objects, folders, nil
+}
+
+// download the bytes of file id, of the range header if not empty.
+func (f *FS) download(ctx context.Context, id, mimeType, rangeHeader string) (io.ReadCloser, error) {
+	if strings.HasPrefix(mimeType, appsMimeTypePrefix) {
+		return nil, ErrNotDownloadable
+	}
+	call := f.service.Files.Get(id).SupportsAllDrives(true).Context(ctx)
+	if rangeHeader != "" {
+		call.Header().Set("Range", rangeHeader)
+	}
+	res, err := call.Download()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, cloudstorage.ErrObjectNotFound
+		}
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusRequestedRangeNotSatisfiable {
+			return nil, cloudstorage.ErrRangeNotSatisfiable
+		}
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// NewReader of object name.
+func (f *FS) NewReader(name string) (io.ReadCloser, error) {
+	return f.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of object name.
+func (f *FS) NewReaderWithContext(ctx context.Context, name string, opts ...cloudstorage.ReadOptions) (_ io.ReadCloser, err error) {
+	defer cloudstorage.RecoverPanic("gdrive read", &err)
+	file, err := f.file(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.download(ctx, file.Id, file.MimeType, "")
+	if err != nil {
+		return nil, err
+	}
+	rc = cloudstorage.NewContextReadCloser(ctx, rc)
+	if len(opts) > 0 && opts[0].VerifyChecksum {
+		sum, err := hex.DecodeString(file.Md5Checksum)
+		if err != nil || len(sum) == 0 {
+			rc.Close()
+			return nil, cloudstorage.ErrChecksumUnavailable
+		}
+		rc = cloudstorage.NewChecksumReader(rc, md5.New(), sum)
+	}
+	return cloudstorage.MaxBytesReader(rc, opts), nil
+}
+
+// NewWriter to object name.
+func (f *FS) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return f.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext uploads as the bytes are written, the file is
+// created (or the existing file of the name replaced) on Close.  Drive has
+// no conditional writes, Opts conditions are ErrConditionNotSupported, and
+// files are shared by the permissions of their folders, so PublicRead is
+// ErrFeatureNotSupported.
+func (f *FS) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...cloudstorage.Opts) (_ io.WriteCloser, err error) {
+	defer cloudstorage.RecoverPanic("gdrive write", &err)
+	if len(opts) > 0 && (opts[0].IfNotExists || opts[0].IfMatch != "" || opts[0].IfGenerationMatch != 0) {
+		return nil, cloudstorage.ErrConditionNotSupported
+	}
+	if len(opts) > 0 && opts[0].PublicRead {
+		return nil, cloudstorage.ErrFeatureNotSupported
+	}
+	if cloudstorage.SkipsIfIdentical(opts) {
+		return cloudstorage.NewSkipIfIdenticalWriter(ctx, f, name, metadata, opts)
+	}
+	if cloudstorage.VerifiesOnClose(opts) {
+		return cloudstorage.NewVerifyOnCloseWriter(ctx, f, name, metadata, opts)
+	}
+	if cloudstorage.DetectsContentType(metadata, opts) {
+		return cloudstorage.NewContentTypeWriter(name, metadata, opts, func(md map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+			return f.NewWriterWithContext(ctx, name, md, opts...)
+		}), nil
+	}
+	if name == "" || strings.HasSuffix(name, "/") {
+		return nil, fmt.Errorf("gdrive can't store folder marker objects name=%q", name)
+	}
+
+	dir, base := split(name)
+	parent, err := f.folder(ctx, dir, true)
+	if err != nil {
+		return nil, err
+	}
+	files, err := f.find(ctx, parent, base, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 1 {
+		return nil, ErrAmbiguousName
+	}
+
+	file := &drive.File{
+		MimeType:      cloudstorage.EnsureContextType(name, metadata),
+		AppProperties: appProperties(metadata),
+	}
+	pr, pw := io.Pipe()
+	g, _ := errgroup.WithContext(ctx)
+	g.Go(func() (err error) {
+		defer func() {
+			// unblock writes to the pipe
+			pr.CloseWithError(err)
+		}()
+		defer cloudstorage.RecoverPanic("gdrive upload", &err)
+		if len(files) == 0 {
+			// writers of a new name upload one after another, those after
+			// the first replacing the file it created.
+			defer lockCreate(parent, base, false)()
+			files, err = f.find(ctx, parent, base, false)
+			if err != nil {
+				return err
+			}
+			if len(files) > 1 {
+				return ErrAmbiguousName
+			}
+		}
+		if len(files) == 1 {
+			_, err = f.service.Files.Update(files[0].Id, file).Media(pr).Fields("id").
+				SupportsAllDrives(true).Context(ctx).Do()
+			return err
+		}
+		file.Name = base
+		file.Parents = []string{parent}
+		created, err := f.service.Files.Create(file).Media(pr).Fields("id").
+			SupportsAllDrives(true).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		_, err = f.created(ctx, parent, base, false, created.Id)
+		return err
+	})
+	w := &writer{pw: pw, g: g}
+	if len(opts) > 0 && opts[0].ContentMD5 != nil {
+		// an upload whose body fails isn't committed.
+		return cloudstorage.NewChecksumWriter(w, md5.New(), opts[0].ContentMD5, w.abort), nil
+	}
+	return w, nil
+}
+
+// appProperties of metadata, less the content type which is the files mime
+// type.
+func appProperties(metadata map[string]string) map[string]string {
+	props := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if k != cloudstorage.ContentTypeKey && k != cloudstorage.ContentTypeHeader {
+			props[k] = v
+		}
+	}
+	return props
+}
+
+// Delete the file of object name, deleting a missing object isn't an error.
+// Drive has no conditional deletes, DeleteOptions conditions are
+// ErrConditionNotSupported.
+func (f *FS) Delete(ctx context.Context, name string, opts ...cloudstorage.DeleteOptions) (err error) {
+	defer cloudstorage.RecoverPanic("gdrive delete", &err)
+	if len(opts) > 0 && (opts[0].IfMatch != "" || opts[0].IfGenerationMatch != 0) {
+		return cloudstorage.ErrConditionNotSupported
+	}
+	file, err := f.file(ctx, name)
+	if err == cloudstorage.ErrObjectNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = f.service.Files.Delete(file.Id).SupportsAllDrives(true).Context(ctx).Do()
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// writer is the body of an upload running in g.
+type writer struct {
+	pw *io.PipeWriter
+	g  *errgroup.Group
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close and block until the upload has completed.
+func (w *writer) Close() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return w.g.Wait()
+}
+
+// abort the upload, so the file isn't created or replaced.
+func (w *writer) abort() error {
+	w.pw.CloseWithError(cloudstorage.ErrChecksumMismatch)
+	w.g.Wait()
+	return nil
+}
+
+func (o *object) StorageSource() string {
+	return StoreType
+}
+func (o *object) Name() string {
+	return o.name
+}
+func (o *object) String() string {
+	return o.name
+}
+func (o *object) Updated() time.Time {
+	return o.updated
+}
+
+// ETag is the Drive version of the file, which increases with every change.
+func (o *object) ETag() string {
+	if o.id == "" {
+		return ""
+	}
+	return strconv.FormatInt(o.version, 10)
+}
+func (o *object) Size() int64 {
+	return o.size
+}
+func (o *object) ContentType() string {
+	return o.mimeType
+}
+func (o *object) MD5() []byte {
+	return o.md5
+}
+func (o *object) MetaData() map[string]string {
+	return o.metadata
+}
+func (o *object) SetMetaData(meta map[string]string) {
+	o.metadata = meta
+}
+
+func (o *object) Delete() error {
+	if err := o.Release(); err != nil {
+		return err
+	}
+	return o.fs.Delete(context.Background(), o.name)
+}
+
+func (o *object) Open(accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+// OpenWithContext downloads the file to the cached copy, cancelled with ctx.
+// As for the other stores objects, ctx is kept for the upload of the Sync or
+// Close of a ReadWrite open too, so it should last until the object is
+// closed.
+func (o *object) OpenWithContext(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	if !accesslevel.Valid() {
+		return nil, cloudstorage.ErrInvalidAccessLevel
+	}
+	if o.opened {
+		return nil, fmt.Errorf("the store object is already opened. %s", o.name)
+	}
+	readonly := accesslevel == cloudstorage.ReadOnly
+
+	if err := cloudstorage.EnsureDir(o.cachepath); err != nil {
+		return nil, fmt.Errorf("gdrive: cachepath=%s could not create cachedcopy dir err=%v", o.cachepath, err)
+	}
+	cachedcopy, err := os.Create(o.cachepath)
+	if err != nil {
+		return nil, fmt.Errorf("gdrive: cachepath=%s could not create cachedcopy err=%v", o.cachepath, err)
+	}
+
+	if o.id != "" {
+		rc, err := o.fs.download(ctx, o.id, o.mimeType, "")
+		if err != nil {
+			cachedcopy.Close()
+			os.Remove(o.cachepath)
+			return nil, err
+		}
+		_, err = io.Copy(cachedcopy, cloudstorage.MaxBytesReader(cloudstorage.NewContextReadCloser(ctx, rc), opts))
+		rc.Close()
+		if err != nil {
+			cachedcopy.Close()
+			os.Remove(o.cachepath)
+			return nil, fmt.Errorf("gdrive: name=%s could not copy from store to cache err=%v", o.name, err)
+		}
+	}
+
+	if readonly {
+		cachedcopy.Close()
+		cachedcopy, err = os.Open(o.cachepath)
+		if err != nil {
+			return nil, fmt.Errorf("gdrive: name=%s cachedcopy=%v could not opencache err=%v", o.name, o.cachepath, err)
+		}
+	} else if _, err := cachedcopy.Seek(0, io.SeekStart); err != nil {
+		cachedcopy.Close()
+		return nil, fmt.Errorf("error seeking to start of cachedcopy err=%v", err)
+	}
+
+	o.cachedcopy = cachedcopy
+	o.readonly = readonly
+	o.opened = true
+	o.ctx = ctx
+	if readonly {
+		return cloudstorage.VerifyOpened(o, o.cachedcopy, opts)
+	}
+	return o.cachedcopy, nil
+}
+
+// OpenRange for cloudstorage.ObjectRange, a ranged download of the file.
+func (o *object) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	if o.id == "" {
+		return nil, cloudstorage.ErrObjectNotFound
+	}
+	if err := cloudstorage.CheckRange(start, length, o.size); err != nil {
+		return nil, err
+	}
+	if start == o.size {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	rc, err := o.fs.download(ctx, o.id, o.mimeType, cloudstorage.RangeHeader(start, length))
+	if err != nil {
+		return nil, err
+	}
+	return cloudstorage.NewContextReadCloser(ctx, rc), nil
+}
+
+func (o *object) File() *os.File {
+	return o.cachedcopy
+}
+func (o *object) Read(p []byte) (n int, err error) {
+	return o.cachedcopy.Read(p)
+}
+
+// Write the given bytes to object.  Won't be writen until Close() or Sync()
+// called.
+func (o *object) Write(p []byte) (n int, err error) {
+	if o.opened && o.readonly {
+		return 0, cloudstorage.ErrReadOnly
+	}
+	if o.cachedcopy == nil {
+		if _, err := o.Open(cloudstorage.ReadWrite); err != nil {
+			return 0, err
+		}
+	}
+	return o.cachedcopy.Write(p)
+}
+
+// Sync uploads the cached copy as the file, cancelled with the ctx of
+// OpenWithContext.
+func (o *object) Sync() error {
+	if !o.opened {
+		return fmt.Errorf("object isn't opened %s", o.name)
+	}
+	if o.readonly {
+		return cloudstorage.ErrReadOnly
+	}
+	ctx := o.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cachedcopy, err := os.Open(o.cachepath)
+	if err != nil {
+		return err
+	}
+	defer cachedcopy.Close()
+	md := o.metadata
+	if o.mimeType != "" && cloudstorage.MetadataContentType(md) == "" {
+		md = make(map[string]string, len(o.metadata)+1)
+		for k, v := range o.metadata {
+			md[k] = v
+		}
+		md[cloudstorage.ContentTypeKey] = o.mimeType
+	}
+	wc, err := o.fs.NewWriterWithContext(ctx, o.name, md)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(wc, cachedcopy); err != nil {
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+func (o *object) Close() error {
+	if !o.opened {
+		return nil
+	}
+	defer func() {
+		os.Remove(o.cachepath)
+		o.cachedcopy = nil
+		o.opened = false
+	}()
+
+	if !o.readonly {
+		if err := o.cachedcopy.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := o.cachedcopy.Close(); err != nil {
+		if !strings.Contains(err.Error(), os.ErrClosed.Error()) {
+			return err
+		}
+	}
+	if !o.readonly {
+		return o.Sync()
+	}
+	return nil
+}
+
+func (o *object) Release() error {
+	if o.cachedcopy != nil {
+		o.cachedcopy.Close()
+		o.cachedcopy = nil
+		o.opened = false
+	}
+	// most likely this doesn't exist so don't return error
+	os.Remove(o.cachepath)
+	return nil
+}
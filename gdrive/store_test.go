@@ -0,0 +1,513 @@
+package gdrive_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	drive "google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/gdrive"
+	"github.com/lytics/cloudstorage/google"
+)
+
+/*
+
+# to use Google Drive tests create a folder for them, shared with the
+# default credentials (a service account of GOOGLE_APPLICATION_CREDENTIALS)
+
+export CS_GDRIVE_FOLDER="<id of the folder>"
+
+*/
+
+func newStore(t *testing.T) *gdrive.FS {
+	folder := os.Getenv("CS_GDRIVE_FOLDER")
+	if folder == "" {
+		t.Skip("Not testing no CS_GDRIVE_FOLDER env var")
+	}
+	conf := &cloudstorage.Config{
+		Type:       gdrive.StoreType,
+		AuthMethod: google.AuthGCEDefaultOAuthToken,
+		Bucket:     folder,
+		TmpDir:     "/tmp/localcache/gdrive",
+	}
+	store, err := cloudstorage.NewStore(conf)
+	if err != nil {
+		t.Fatalf("Could not create store: config=%+v  err=%v", conf, err)
+	}
+	return store.(*gdrive.FS)
+}
+
+func write(t *testing.T, store cloudstorage.Store, name, data string) {
+	wc, err := store.NewWriterWithContext(context.Background(), name, nil)
+	if err != nil {
+		t.Fatalf("Could not create writer name=%s err=%v", name, err)
+	}
+	if _, err := wc.Write([]byte(data)); err != nil {
+		t.Fatalf("Could not write name=%s err=%v", name, err)
+	}
+	if err := wc.Close(); err != nil {
+		t.Fatalf("Could not close writer name=%s err=%v", name, err)
+	}
+}
+
+func TestAll(t *testing.T) {
+	store := newStore(t)
+	ctx := context.Background()
+	prefix := "cloudstorage-test/"
+	for _, name := range []string{"a.csv", "b/c.csv", "b/d/e.csv"} {
+		write(t, store, prefix+name, name)
+	}
+	defer func() {
+		for _, name := range []string{"a.csv", "b/c.csv", "b/d/e.csv"} {
+			store.Delete(ctx, prefix+name)
+		}
+	}()
+
+	// replacing keeps a single file of the name.
+	write(t, store, prefix+"a.csv", "a,b,c\n")
+	obj, err := store.Get(ctx, prefix+"a.csv")
+	if err != nil {
+		t.Fatalf("Could not get err=%v", err)
+	}
+	if obj.Size() != 6 || obj.ContentType() != "text/csv" {
+		t.Fatalf("Unexpected object size=%d content_type=%q", obj.Size(), obj.ContentType())
+	}
+	rc, err := store.NewReader(prefix + "a.csv")
+	if err != nil {
+		t.Fatalf("Could not create reader err=%v", err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "a,b,c\n" {
+		t.Fatalf("Unexpected read %q err=%v", data, err)
+	}
+	rc, err = cloudstorage.OpenRange(ctx, obj, 2, 3)
+	if err != nil {
+		t.Fatalf("Could not open range err=%v", err)
+	}
+	data, err = ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "b,c" {
+		t.Fatalf("Unexpected range read %q err=%v", data, err)
+	}
+
+	if _, err := store.Get(ctx, prefix+"missing.csv"); err != cloudstorage.ErrObjectNotFound {
+		t.Fatalf("Expected ErrObjectNotFound got %v", err)
+	}
+	if _, err := store.Get(ctx, prefix+"missing/a.csv"); err != cloudstorage.ErrObjectNotFound {
+		t.Fatalf("Expected ErrObjectNotFound got %v", err)
+	}
+	if _, err := store.NewObject(prefix + "a.csv"); err != cloudstorage.ErrObjectExists {
+		t.Fatalf("Expected ErrObjectExists got %v", err)
+	}
+
+	var names []string
+	iter, _ := store.Objects(ctx, cloudstorage.Query{Prefix: prefix + "b"})
+	objs, err := cloudstorage.ObjectsAll(iter)
+	if err != nil {
+		t.Fatalf("Could not list err=%v", err)
+	}
+	for _, o := range objs {
+		names = append(names, o.Name())
+	}
+	if strings.Join(names, ",") != prefix+"b/c.csv,"+prefix+"b/d/e.csv" {
+		t.Fatalf("Unexpected objects %v", names)
+	}
+
+	folders, err := store.Folders(ctx, cloudstorage.Query{Prefix: prefix})
+	if err != nil || strings.Join(folders, ",") != prefix+"b/" {
+		t.Fatalf("Unexpected folders %v err=%v", folders, err)
+	}
+
+	if err := store.Delete(ctx, prefix+"b/c.csv"); err != nil {
+		t.Fatalf("Could not delete err=%v", err)
+	}
+	if _, err := store.Get(ctx, prefix+"b/c.csv"); err != cloudstorage.ErrObjectNotFound {
+		t.Fatalf("Expected ErrObjectNotFound after delete got %v", err)
+	}
+	if err := store.Delete(ctx, prefix+"b/c.csv"); err != nil {
+		t.Fatalf("Expected deleting a missing object to succeed err=%v", err)
+	}
+}
+
+func TestAmbiguousName(t *testing.T) {
+	store := newStore(t)
+	ctx := context.Background()
+	name := "cloudstorage-test-dup.csv"
+	write(t, store, name, "1")
+	defer store.Delete(ctx, name)
+
+	// a second file of the name in the same folder, as Drive allows.
+	service := store.Client().(*drive.Service)
+	dup, err := service.Files.Create(&drive.File{Name: name, Parents: []string{os.Getenv("CS_GDRIVE_FOLDER")}}).
+		Media(strings.NewReader("2")).SupportsAllDrives(true).Do()
+	if err != nil {
+		t.Fatalf("Could not create duplicate err=%v", err)
+	}
+	defer service.Files.Delete(dup.Id).SupportsAllDrives(true).Do()
+
+	if _, err := store.Get(ctx, name); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName got %v", err)
+	}
+	if _, err := store.NewReader(name); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName got %v", err)
+	}
+	if _, err := store.NewWriter(name, nil); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName got %v", err)
+	}
+	if err := store.Delete(ctx, name); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName got %v", err)
+	}
+	iter, _ := store.Objects(ctx, cloudstorage.Query{Prefix: "cloudstorage-test-dup"})
+	if _, err := cloudstorage.ObjectsAll(iter); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName listing got %v", err)
+	}
+	if _, _, err := store.ListLevel(ctx, "cloudstorage-test-dup", 0); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName listing level got %v", err)
+	}
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	store := newStore(t)
+	ctx := context.Background()
+	name := "cloudstorage-test-concurrent/a/b.csv"
+	defer store.Delete(ctx, name)
+
+	// writers of a new name in a new folder create one of each.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wc, err := store.NewWriterWithContext(ctx, name, nil)
+			if err == nil {
+				if _, err = wc.Write([]byte("a,b,c\n")); err == nil {
+					err = wc.Close()
+				}
+			}
+			if err != nil {
+				t.Errorf("Could not write name=%s err=%v", name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := store.Get(ctx, name); err != nil {
+		t.Fatalf("Could not get err=%v", err)
+	}
+	iter, _ := store.Objects(ctx, cloudstorage.Query{Prefix: "cloudstorage-test-concurrent/"})
+	objs, err := cloudstorage.ObjectsAll(iter)
+	if err != nil || len(objs) != 1 {
+		t.Fatalf("Unexpected objects %v err=%v", objs, err)
+	}
+}
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+// fakeFile is a file (or folder) of a fakeDrive.
+type fakeFile struct {
+	id, name, mimeType, parent, created string
+	data                                []byte
+	version                             int64
+}
+
+// fakeDrive answers the files requests of the store as Drive does, for the
+// files of files.
+type fakeDrive struct {
+	mu      sync.Mutex
+	files   map[string]*fakeFile
+	next    int
+	creates int
+	// rivals are names another writer creates too, just before each create
+	// of them.
+	rivals map[string]bool
+}
+
+func newFakeDrive() *fakeDrive {
+	return &fakeDrive{files: make(map[string]*fakeFile), rivals: make(map[string]bool)}
+}
+
+// add a file to parent, created after those added before it.
+func (d *fakeDrive) add(parent, name, mimeType string, data []byte) *fakeFile {
+	d.next++
+	f := &fakeFile{
+		id:       fmt.Sprintf("id%d", d.next),
+		name:     name,
+		mimeType: mimeType,
+		parent:   parent,
+		created:  time.Unix(int64(d.next), 0).UTC().Format(time.RFC3339),
+		data:     data,
+		version:  1,
+	}
+	d.files[f.id] = f
+	return f
+}
+
+// named are the files of name in parent.
+func (d *fakeDrive) named(parent, name string) []*fakeFile {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var files []*fakeFile
+	for _, f := range d.files {
+		if f.parent == parent && f.name == name {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+var (
+	fakeQuery    = regexp.MustCompile(`^'((?:[^'\\]|\\.)*)' in parents(?: and name = '((?:[^'\\]|\\.)*)' and mimeType (=|!=) '[^']*')? and trashed = false$`)
+	fakeUnescape = strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace
+)
+
+func (d *fakeDrive) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	d.serve(rec, req)
+	res := rec.Result()
+	res.Request = req
+	return res, nil
+}
+
+func (d *fakeDrive) serve(w http.ResponseWriter, req *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := ""
+	if i := strings.Index(req.URL.Path, "/files/"); i >= 0 {
+		id = req.URL.Path[i+len("/files/"):]
+	}
+	upload := strings.HasPrefix(req.URL.Path, "/upload/")
+	switch {
+	case req.Method == http.MethodGet && id == "":
+		m := fakeQuery.FindStringSubmatch(req.URL.Query().Get("q"))
+		if m == nil {
+			http.Error(w, "unexpected query "+req.URL.Query().Get("q"), http.StatusBadRequest)
+			return
+		}
+		files := make([]map[string]interface{}, 0)
+		for _, f := range d.files {
+			if f.parent != fakeUnescape(m[1]) {
+				continue
+			}
+			if m[2] != "" && (f.name != fakeUnescape(m[2]) || (f.mimeType == folderMimeType) != (m[3] == "=")) {
+				continue
+			}
+			files = append(files, f.json())
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"files": files})
+	case req.Method == http.MethodGet:
+		f, ok := d.files[id]
+		if !ok || req.URL.Query().Get("alt") != "media" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write(f.data)
+	case req.Method == http.MethodPost:
+		var meta struct {
+			Name     string   `json:"name"`
+			MimeType string   `json:"mimeType"`
+			Parents  []string `json:"parents"`
+		}
+		data, err := readUpload(req, &meta, upload)
+		if err != nil || len(meta.Parents) != 1 {
+			http.Error(w, fmt.Sprintf("bad create err=%v", err), http.StatusBadRequest)
+			return
+		}
+		if d.rivals[meta.Name] {
+			d.add(meta.Parents[0], meta.Name, meta.MimeType, nil)
+		}
+		d.creates++
+		f := d.add(meta.Parents[0], meta.Name, meta.MimeType, data)
+		json.NewEncoder(w).Encode(f.json())
+	case req.Method == http.MethodPatch:
+		f, ok := d.files[id]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		var meta struct{}
+		data, err := readUpload(req, &meta, upload)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad update err=%v", err), http.StatusBadRequest)
+			return
+		}
+		f.data = data
+		f.version++
+		json.NewEncoder(w).Encode(f.json())
+	case req.Method == http.MethodDelete:
+		if _, ok := d.files[id]; !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		delete(d.files, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+// readUpload decodes the file metadata of req into meta, returning the media
+// of a multipart upload.
+func readUpload(req *http.Request, meta interface{}, upload bool) ([]byte, error) {
+	if !upload {
+		return nil, json.NewDecoder(req.Body).Decode(meta)
+	}
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	mr := multipart.NewReader(req.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewDecoder(part).Decode(meta); err != nil {
+		return nil, err
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(part)
+}
+
+func (f *fakeFile) json() map[string]interface{} {
+	file := map[string]interface{}{
+		"id":           f.id,
+		"name":         f.name,
+		"mimeType":     f.mimeType,
+		"parents":      []string{f.parent},
+		"createdTime":  f.created,
+		"modifiedTime": f.created,
+		"version":      strconv.FormatInt(f.version, 10),
+	}
+	if f.mimeType != folderMimeType {
+		file["size"] = strconv.Itoa(len(f.data))
+	}
+	return file
+}
+
+func newFakeStore(t *testing.T, d *fakeDrive) *gdrive.FS {
+	service, err := drive.NewService(context.Background(), option.WithHTTPClient(&http.Client{Transport: d}))
+	if err != nil {
+		t.Fatalf("Could not create service err=%v", err)
+	}
+	store, err := gdrive.NewStore(service, &cloudstorage.Config{
+		Type:   gdrive.StoreType,
+		Bucket: "root",
+		TmpDir: "/tmp/localcache/gdrive_fake",
+	})
+	if err != nil {
+		t.Fatalf("Could not create store err=%v", err)
+	}
+	return store
+}
+
+func TestFakeAmbiguousName(t *testing.T) {
+	d := newFakeDrive()
+	d.add("root", "a.csv", "text/csv", []byte("1"))
+	d.add("root", "a.csv", "text/csv", []byte("2"))
+	for i := 0; i < 2; i++ {
+		b := d.add("root", "b", folderMimeType, nil)
+		d.add(b.id, "c.csv", "text/csv", []byte("3"))
+	}
+	store := newFakeStore(t, d)
+	ctx := context.Background()
+
+	if _, err := store.Get(ctx, "a.csv"); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName got %v", err)
+	}
+	if _, err := store.NewWriter("a.csv", nil); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName got %v", err)
+	}
+	if _, err := store.Get(ctx, "b/c.csv"); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName of the folder got %v", err)
+	}
+	if _, _, err := store.ListLevel(ctx, "", 0); err != gdrive.ErrAmbiguousName {
+		t.Fatalf("Expected ErrAmbiguousName listing level got %v", err)
+	}
+	// the walk finds the two paths of a.csv, and the two folders b, even a
+	// page apart.
+	for _, q := range []cloudstorage.Query{{Prefix: "a"}, {Prefix: ""}, {PageSize: 1}} {
+		iter, _ := store.Objects(ctx, q)
+		if _, err := cloudstorage.ObjectsAll(iter); err != gdrive.ErrAmbiguousName {
+			t.Fatalf("Expected ErrAmbiguousName listing %+v got %v", q, err)
+		}
+	}
+}
+
+func TestFakeConcurrentCreate(t *testing.T) {
+	d := newFakeDrive()
+	store := newFakeStore(t, d)
+	ctx := context.Background()
+
+	// writers of a new name in new folders create one of each, the rest
+	// replace the file created.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			wc, err := store.NewWriterWithContext(ctx, "a/b/c.csv", nil)
+			if err == nil {
+				if _, err = wc.Write([]byte(strconv.Itoa(i))); err == nil {
+					err = wc.Close()
+				}
+			}
+			if err != nil {
+				t.Errorf("Could not write err=%v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if d.creates != 3 {
+		t.Fatalf("Expected a create of each of a, b and c.csv got %d", d.creates)
+	}
+	a := d.named("root", "a")
+	if len(a) != 1 {
+		t.Fatalf("Unexpected folders a %v", a)
+	}
+	b := d.named(a[0].id, "b")
+	if len(b) != 1 || len(d.named(b[0].id, "c.csv")) != 1 {
+		t.Fatalf("Unexpected folders b %v", b)
+	}
+	if _, err := store.Get(ctx, "a/b/c.csv"); err != nil {
+		t.Fatalf("Could not get err=%v", err)
+	}
+}
+
+func TestFakeRacedCreate(t *testing.T) {
+	d := newFakeDrive()
+	d.rivals["a"] = true
+	d.rivals["b.csv"] = true
+	store := newFakeStore(t, d)
+
+	// another writer creates a and b.csv first, theirs are kept and ours
+	// removed.
+	write(t, store, "a/b.csv", "a,b,c\n")
+	a := d.named("root", "a")
+	if len(a) != 1 || a[0].id != "id1" {
+		t.Fatalf("Expected the first created folder got %v", a)
+	}
+	files := d.named(a[0].id, "b.csv")
+	if len(files) != 1 || len(files[0].data) != 0 {
+		t.Fatalf("Expected the first created file got %v", files)
+	}
+	if _, err := store.Get(context.Background(), "a/b.csv"); err != nil {
+		t.Fatalf("Could not get err=%v", err)
+	}
+}
@@ -52,6 +52,21 @@ func gcsCommonClient(client *http.Client, conf *cloudstorage.Config) (cloudstora
 	if err != nil {
 		return nil, err
 	}
+	store.httpclient = client
+	store.project = conf.Project
+	store.separator = conf.KeySeparator()
+	store.userProject = conf.Settings.String(ConfKeyUserProject)
+	store.kmsKeyName = conf.Settings.String(ConfKeyKMSKeyName)
+	store.upload = newParallelUpload(conf)
+	store.SignerServiceAccount = conf.Settings.String(ConfKeySignerServiceAccount)
+	if conf.JwtConf != nil && conf.JwtConf.PrivateKey != "" {
+		key, err := conf.JwtConf.KeyBytes()
+		if err != nil {
+			return nil, err
+		}
+		store.privateKey = key
+		store.accessID = conf.JwtConf.ClientEmail
+	}
 	return store, nil
 }
 
@@ -187,5 +202,12 @@ func NewGoogleClient(conf *cloudstorage.Config) (client GoogleOAuthClient, err e
 		return nil, fmt.Errorf("bad AuthMethod: %v", conf.AuthMethod)
 	}
 
+	if conf.HTTPClient != nil {
+		// share the connection pool of the configs client underneath the auth.
+		if t, ok := client.Client().Transport.(*oauth2.Transport); ok {
+			t.Base = conf.HTTPClient.Transport
+		}
+	}
+
 	return client, err
 }
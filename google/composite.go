@@ -0,0 +1,292 @@
+package google
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/araddon/gou"
+	"github.com/pborman/uuid"
+	"golang.org/x/net/context"
+
+	"github.com/lytics/cloudstorage"
+)
+
+const (
+	// maxComposeSources is the most objects one compose request concatenates.
+	maxComposeSources = 32
+	// defaultComposePartSize is the part size of the composite uploads when
+	// cloudstorage.Config.UploadPartSize isn't set.
+	defaultComposePartSize int64 = 32 * 1024 * 1024
+)
+
+// parallelUpload is the part size and concurrency of the composite uploads
+// of a store, see cloudstorage.Config.UploadConcurrency.  Objects larger than
+// a part are uploaded as concurrency parts at a time, each a temporary object
+// named for the object with a ".part-" suffix, and composed into the object.
+// Composite objects have a crc32c but no md5, so writes with a ContentMD5 or
+// conditions are always a single upload.
+type parallelUpload struct {
+	partSize    int64
+	concurrency int
+}
+
+func newParallelUpload(conf *cloudstorage.Config) parallelUpload {
+	p := parallelUpload{partSize: conf.UploadPartSize, concurrency: conf.UploadConcurrency}
+	if p.partSize <= 0 {
+		p.partSize = defaultComposePartSize
+	}
+	return p
+}
+
+// composes is true if an object of size bytes, cloudstorage.UnknownSize for
+// a stream, may be uploaded in parts.
+func (p parallelUpload) composes(size int64) bool {
+	return p.concurrency > 1 && (size == cloudstorage.UnknownSize || size > p.partSize)
+}
+
+// compositeUpload uploads the parts of an object as temporary objects and
+// composes them into it.  A failed part cancels the parts in flight, and the
+// temporary objects are deleted whether or not the compose succeeds.
+type compositeUpload struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	bucket     *storage.BucketHandle
+	name       string
+	kmsKeyName string
+	prefix     string
+	slots      chan struct{}
+	wg         sync.WaitGroup
+
+	// parts in order, and the intermediate composes of more than
+	// maxComposeSources parts.
+	parts []string
+	temps []string
+
+	mu  sync.Mutex
+	err error
+}
+
+func newCompositeUpload(ctx context.Context, bucket *storage.BucketHandle, name, kmsKeyName string, concurrency int) *compositeUpload {
+	ctx, cancel := context.WithCancel(ctx)
+	uid := strings.Replace(uuid.NewUUID().String(), "-", "", -1)
+	return &compositeUpload{
+		ctx:        ctx,
+		cancel:     cancel,
+		bucket:     bucket,
+		name:       name,
+		kmsKeyName: kmsKeyName,
+		prefix:     name + ".part-" + uid,
+		slots:      make(chan struct{}, concurrency),
+	}
+}
+
+// upload starts the upload of the next part from r once fewer than
+// concurrency parts are in flight.
+func (u *compositeUpload) upload(r io.Reader) error {
+	select {
+	case u.slots <- struct{}{}:
+	case <-u.ctx.Done():
+		return u.failure()
+	}
+	name := fmt.Sprintf("%s-%05d", u.prefix, len(u.parts))
+	u.parts = append(u.parts, name)
+	u.wg.Add(1)
+	go func() {
+		defer func() {
+			<-u.slots
+			u.wg.Done()
+		}()
+		wc := u.bucket.Object(name).NewWriter(u.ctx)
+		wc.KMSKeyName = u.kmsKeyName
+		_, err := io.Copy(wc, r)
+		if err == nil {
+			err = wc.Close()
+		}
+		if err != nil {
+			u.fail(fmt.Errorf("could not upload part %s err=%v", name, err))
+		}
+	}()
+	return nil
+}
+
+func (u *compositeUpload) fail(err error) {
+	u.mu.Lock()
+	if u.err == nil {
+		u.err = err
+	}
+	u.mu.Unlock()
+	u.cancel()
+}
+
+// failure is the error of the first failed part, or of the upload's context.
+func (u *compositeUpload) failure() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.err != nil {
+		return u.err
+	}
+	return u.ctx.Err()
+}
+
+// finish waits for the parts and composes them into the object with attrs,
+// in rounds of maxComposeSources.
+func (u *compositeUpload) finish(attrs storage.ObjectAttrs) error {
+	u.wg.Wait()
+	defer u.cleanup()
+	if err := u.failure(); err != nil {
+		return err
+	}
+	sources := u.parts
+	for round := 0; len(sources) > maxComposeSources; round++ {
+		var next []string
+		for i := 0; i < len(sources); i += maxComposeSources {
+			end := i + maxComposeSources
+			if end > len(sources) {
+				end = len(sources)
+			}
+			name := fmt.Sprintf("%s-c%d-%05d", u.prefix, round, len(next))
+			u.temps = append(u.temps, name)
+			if err := u.compose(name, sources[i:end], storage.ObjectAttrs{}); err != nil {
+				return err
+			}
+			next = append(next, name)
+		}
+		sources = next
+	}
+	return u.compose(u.name, sources, attrs)
+}
+
+func (u *compositeUpload) compose(name string, sources []string, attrs storage.ObjectAttrs) error {
+	srcs := make([]*storage.ObjectHandle, len(sources))
+	for i, s := range sources {
+		srcs[i] = u.bucket.Object(s)
+	}
+	c := u.bucket.Object(name).ComposerFrom(srcs...)
+	c.ObjectAttrs = attrs
+	c.KMSKeyName = u.kmsKeyName
+	if _, err := c.Run(u.ctx); err != nil {
+		return fmt.Errorf("could not compose %s err=%v", name, err)
+	}
+	return nil
+}
+
+// cleanup deletes the temporary objects, with a context of its own as the
+// upload's is canceled once it is done.
+func (u *compositeUpload) cleanup() {
+	u.cancel()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for _, name := range append(u.parts, u.temps...) {
+		u.slots <- struct{}{}
+		wg.Add(1)
+		go func(name string) {
+			defer func() {
+				<-u.slots
+				wg.Done()
+			}()
+			err := u.bucket.Object(name).Delete(ctx)
+			if err != nil && err != storage.ErrObjectNotExist {
+				gou.Warnf("could not delete composite upload part %s err=%v", name, err)
+			}
+		}(name)
+	}
+	wg.Wait()
+}
+
+// compositeWriter buffers writes into parts of a compositeUpload.  Writes of
+// up to a part are uploaded as a single object on Close, otherwise as many as
+// concurrency parts are buffered and uploading at once.
+type compositeWriter struct {
+	u        *compositeUpload
+	attrs    storage.ObjectAttrs
+	partSize int64
+	buf      []byte
+	closed   bool
+}
+
+func (g *GcsFS) newCompositeWriter(ctx context.Context, bucket *storage.BucketHandle, name string, metadata map[string]string, predefinedACL string) *compositeWriter {
+	w := &compositeWriter{
+		u:        newCompositeUpload(ctx, bucket, name, g.kmsKeyName, g.upload.concurrency),
+		attrs:    objectAttrs(name, metadata),
+		partSize: g.upload.partSize,
+		buf:      make([]byte, 0, g.upload.partSize),
+	}
+	// only the composed object has it, the parts are private.
+	w.attrs.PredefinedACL = predefinedACL
+	return w
+}
+
+func (w *compositeWriter) Write(p []byte) (int, error) {
+	if err := w.u.failure(); err != nil {
+		return 0, err
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			// a part is uploaded once there is more, so a write of a
+			// single part isn't composed.
+			if err := w.u.upload(bytes.NewReader(w.buf)); err != nil {
+				return written, err
+			}
+			w.buf = make([]byte, 0, w.partSize)
+		}
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *compositeWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if len(w.u.parts) == 0 {
+		defer w.u.cancel()
+		wc := w.u.bucket.Object(w.u.name).NewWriter(w.u.ctx)
+		wc.KMSKeyName = w.u.kmsKeyName
+		wc.Metadata = w.attrs.Metadata
+		wc.ContentType = w.attrs.ContentType
+		wc.CustomTime = w.attrs.CustomTime
+		wc.PredefinedACL = w.attrs.PredefinedACL
+		if _, err := wc.Write(w.buf); err != nil {
+			return err
+		}
+		return wc.Close()
+	}
+	if len(w.buf) > 0 {
+		// a failed upload is the failure of finish.
+		w.u.upload(bytes.NewReader(w.buf))
+	}
+	return w.u.finish(w.attrs)
+}
+
+// composeFile uploads size bytes of f to the object name in parts.
+func (o *object) composeFile(f *os.File, size int64) error {
+	u := newCompositeUpload(o.context(), o.gcsb, o.name, o.kmsKeyName, o.upload.concurrency)
+	for off := int64(0); off < size; off += o.upload.partSize {
+		if err := u.upload(io.NewSectionReader(f, off, o.upload.partSize)); err != nil {
+			break
+		}
+	}
+	return u.finish(objectAttrs(o.name, o.metadata))
+}
+
+// objectAttrs are the attrs of an object name written with metadata.
+func objectAttrs(name string, metadata map[string]string) storage.ObjectAttrs {
+	var attrs storage.ObjectAttrs
+	if metadata != nil {
+		attrs.Metadata = metadata
+		//contenttype is only used for viewing the file in a browser. (i.e. the GCS Object browser).
+		attrs.ContentType = cloudstorage.EnsureContextType(name, metadata)
+		setCustomTime(&attrs, metadata)
+	}
+	return attrs
+}
@@ -1,15 +1,21 @@
 package google_test
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
 
 	"cloud.google.com/go/storage"
+	"github.com/araddon/gou"
 	"github.com/lytics/cloudstorage"
 	"github.com/lytics/cloudstorage/google"
 	"github.com/lytics/cloudstorage/testutils"
+	"google.golang.org/api/option"
 )
 
 /*
@@ -52,6 +58,228 @@ func TestAll(t *testing.T) {
 	testutils.RunTests(t, store, config)
 }
 
+func TestKMSKeyName(t *testing.T) {
+	jwtVal, key := os.Getenv("CS_GCS_JWTKEY"), os.Getenv("CS_GCS_KMS_KEY")
+	if jwtVal == "" || key == "" {
+		t.Skip("Not testing no CS_GCS_JWTKEY or CS_GCS_KMS_KEY env var")
+		return
+	}
+	jc := &cloudstorage.JwtConf{}
+	if err := json.Unmarshal([]byte(jwtVal), jc); err != nil {
+		t.Fatalf("Could not read CS_GCS_JWTKEY %v", err)
+	}
+	conf := *config
+	conf.Project = jc.ProjectID
+	conf.JwtConf = jc
+	conf.Settings = gou.JsonHelper{google.ConfKeyKMSKeyName: key}
+	store, err := cloudstorage.NewStore(&conf)
+	if err != nil {
+		t.Fatalf("Could not create store: config=%+v  err=%v", conf, err)
+	}
+
+	ctx := context.Background()
+	wc, err := store.NewWriterWithContext(ctx, "kms/a.csv", nil)
+	if err != nil {
+		t.Fatalf("Could not create writer err=%v", err)
+	}
+	wc.Write([]byte("a,b,c\n"))
+	if err := wc.Close(); err != nil {
+		t.Fatalf("Could not write err=%v", err)
+	}
+	defer store.Delete(ctx, "kms/a.csv")
+
+	gcs := store.Client().(*storage.Client)
+	attrs, err := gcs.Bucket(conf.Bucket).Object("kms/a.csv").Attrs(ctx)
+	if err != nil {
+		t.Fatalf("Could not get attrs err=%v", err)
+	}
+	// the key name of the object has the key version appended.
+	if !strings.HasPrefix(attrs.KMSKeyName, key) {
+		t.Fatalf("expected object encrypted with %q got %q", key, attrs.KMSKeyName)
+	}
+}
+
+func TestCompositeUpload(t *testing.T) {
+	jwtVal := os.Getenv("CS_GCS_JWTKEY")
+	if jwtVal == "" {
+		t.Skip("Not testing no CS_GCS_JWTKEY env var")
+		return
+	}
+	jc := &cloudstorage.JwtConf{}
+	if err := json.Unmarshal([]byte(jwtVal), jc); err != nil {
+		t.Fatalf("Could not read CS_GCS_JWTKEY %v", err)
+	}
+	conf := *config
+	conf.Project = jc.ProjectID
+	conf.JwtConf = jc
+	conf.UploadPartSize = 1024
+	conf.UploadConcurrency = 4
+	store, err := cloudstorage.NewStore(&conf)
+	if err != nil {
+		t.Fatalf("Could not create store: config=%+v  err=%v", conf, err)
+	}
+
+	// more parts than one compose takes, and a single part.
+	ctx := context.Background()
+	for name, size := range map[string]int{"composite/a.csv": 40*1024 + 7, "composite/b.csv": 1024} {
+		body := strings.Repeat("a,b,c\n", size/6+1)[:size]
+		wc, err := store.NewWriterWithContext(ctx, name, map[string]string{"owner": "ingest"})
+		if err != nil {
+			t.Fatalf("Could not create writer err=%v", err)
+		}
+		wc.Write([]byte(body))
+		if err := wc.Close(); err != nil {
+			t.Fatalf("Could not write %s err=%v", name, err)
+		}
+		defer store.Delete(ctx, name)
+
+		rc, err := store.NewReaderWithContext(ctx, name, cloudstorage.ReadOptions{VerifyChecksum: true})
+		if err != nil {
+			t.Fatalf("Could not read %s err=%v", name, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(b) != body {
+			t.Fatalf("expected %s to be read back, got %d bytes err=%v", name, len(b), err)
+		}
+		obj, err := store.Get(ctx, name)
+		if err != nil {
+			t.Fatalf("Could not get %s err=%v", name, err)
+		}
+		if obj.MetaData()["owner"] != "ingest" {
+			t.Fatalf("expected the metadata of %s, got %v", name, obj.MetaData())
+		}
+	}
+
+	// the parts are deleted once composed.
+	resp, err := store.List(ctx, cloudstorage.NewQuery("composite/"))
+	if err != nil {
+		t.Fatalf("Could not list err=%v", err)
+	}
+	if len(resp.Objects) != 2 {
+		t.Fatalf("expected only the composed objects, got %v", resp.Objects)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	jwtVal := os.Getenv("CS_GCS_JWTKEY")
+	if jwtVal == "" {
+		t.Skip("Not testing no CS_GCS_JWTKEY env var")
+		return
+	}
+	jc := &cloudstorage.JwtConf{}
+	if err := json.Unmarshal([]byte(jwtVal), jc); err != nil {
+		t.Fatalf("Could not read CS_GCS_JWTKEY %v", err)
+	}
+	conf := *config
+	conf.Project = jc.ProjectID
+	conf.JwtConf = jc
+	store, err := cloudstorage.NewStore(&conf)
+	if err != nil {
+		t.Fatalf("Could not create store: config=%+v  err=%v", conf, err)
+	}
+	ctx := context.Background()
+	defer store.Delete(ctx, "resume/a.csv")
+
+	// the first writer dies after a chunk of 8MiB is sent.
+	chunk := 8 * 1024 * 1024
+	body := strings.Repeat("a,b,c\n", (chunk+1000)/6)
+	wc, err := store.NewWriterWithContext(ctx, "resume/a.csv", nil, cloudstorage.Opts{Resume: true})
+	if err != nil {
+		t.Fatalf("Could not create writer err=%v", err)
+	}
+	if n := cloudstorage.Resumed(wc); n != 0 {
+		t.Fatalf("expected a new upload, resumed %d", n)
+	}
+	if _, err := wc.Write([]byte(body[:chunk+10])); err != nil {
+		t.Fatalf("Could not write err=%v", err)
+	}
+
+	wc, err = store.NewWriterWithContext(ctx, "resume/a.csv", nil, cloudstorage.Opts{Resume: true})
+	if err != nil {
+		t.Fatalf("Could not create writer err=%v", err)
+	}
+	n := cloudstorage.Resumed(wc)
+	if n != int64(chunk) {
+		t.Fatalf("expected %d bytes resumed got %d", chunk, n)
+	}
+	wc.Write([]byte(body[n:]))
+	if err := wc.Close(); err != nil {
+		t.Fatalf("Could not write err=%v", err)
+	}
+
+	rc, err := store.NewReaderWithContext(ctx, "resume/a.csv", cloudstorage.ReadOptions{VerifyChecksum: true})
+	if err != nil {
+		t.Fatalf("Could not read err=%v", err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(b) != body {
+		t.Fatalf("expected the object read back, got %d bytes err=%v", len(b), err)
+	}
+}
+
+// projectTransport records the project billed for each request, answering
+// them as for an object a.csv of body.
+type projectTransport struct {
+	body     string
+	projects []string
+}
+
+func (p *projectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	project := req.URL.Query().Get("userProject")
+	if project == "" {
+		project = req.Header.Get("X-Goog-User-Project")
+	}
+	p.projects = append(p.projects, project)
+	header := http.Header{}
+	body := p.body
+	if strings.HasPrefix(req.URL.Path, "/storage/v1/") && req.URL.Query().Get("alt") != "media" {
+		header.Set("Content-Type", "application/json")
+		body = fmt.Sprintf(`{"bucket":"bucket","name":"a.csv","size":"%d","generation":"1"}`, len(p.body))
+	}
+	header.Set("Content-Length", fmt.Sprint(len(body)))
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func TestOpenUserProject(t *testing.T) {
+	transport := &projectTransport{body: "a,b,c\n"}
+	gcs, err := storage.NewClient(context.Background(), option.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		t.Fatalf("Could not create client err=%v", err)
+	}
+	store, err := google.NewGCSStore(gcs, "bucket", "/tmp/localcache/google_user_project", cloudstorage.MaxResults)
+	if err != nil {
+		t.Fatalf("Could not create store err=%v", err)
+	}
+	obj, err := store.Get(context.Background(), "a.csv")
+	if err != nil {
+		t.Fatalf("Could not get err=%v", err)
+	}
+	defer obj.Release()
+
+	// the attrs and the download of an open are billed to the project of the
+	// read options.
+	transport.projects = nil
+	if _, err := obj.Open(cloudstorage.ReadOnly, cloudstorage.ReadOptions{UserProject: "billed"}); err != nil {
+		t.Fatalf("Could not open err=%v", err)
+	}
+	if len(transport.projects) == 0 {
+		t.Fatalf("expected requests from the open")
+	}
+	for _, project := range transport.projects {
+		if project != "billed" {
+			t.Fatalf("expected requests billed to the read options project, got %q", project)
+		}
+	}
+}
+
 func TestConfigValidation(t *testing.T) {
 
 	// VALIDATE errors for AuthJWTKeySource
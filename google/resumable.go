@@ -0,0 +1,257 @@
+package google
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/lytics/cloudstorage"
+)
+
+const (
+	// gcsUploadURL is the json api endpoint resumable upload sessions are
+	// started at.
+	gcsUploadURL = "https://storage.googleapis.com/upload/storage/v1/b/"
+	// resumableChunkSize is the size of the chunks of a resumable upload,
+	// a multiple of the 256KiB the sessions take.
+	resumableChunkSize = 8 * 1024 * 1024
+)
+
+// errSessionGone is a persisted upload session gcs no longer has, a new one
+// is started.
+var errSessionGone = fmt.Errorf("upload session expired or completed")
+
+// uploadSession is the persisted state of a resumable upload, the session
+// uri has everything else.
+type uploadSession struct {
+	URI string `json:"uri"`
+}
+
+// resumableWriter uploads the bytes written in chunks to a resumable upload
+// session of the json api, see cloudstorage.Opts.Resume.  The storage client
+// doesn't expose its session uri, so the requests are made with the store's
+// authorized http client.
+type resumableWriter struct {
+	ctx     context.Context
+	client  *http.Client
+	path    string
+	uri     string
+	offset  int64
+	resumed int64
+	buf     []byte
+	closed  bool
+}
+
+func (g *GcsFS) newResumableWriter(ctx context.Context, name string, metadata map[string]string, opts cloudstorage.Opts) (io.WriteCloser, error) {
+	if g.httpclient == nil {
+		return nil, fmt.Errorf("resumable uploads need the store's http client, see NewStore")
+	}
+	w := &resumableWriter{
+		ctx:    ctx,
+		client: g.httpclient,
+		path:   cloudstorage.UploadSessionPath(g.cachepath, StoreType, g.bucket, name),
+	}
+	var sess uploadSession
+	ok, err := cloudstorage.LoadUploadSession(w.path, &sess)
+	if err != nil {
+		return nil, err
+	}
+	if ok && sess.URI != "" {
+		n, err := w.status(sess.URI)
+		if err == nil {
+			w.uri, w.offset, w.resumed = sess.URI, n, n
+			return w, nil
+		}
+		if err != errSessionGone {
+			return nil, err
+		}
+	}
+
+	userProject := opts.UserProject
+	if userProject == "" {
+		userProject = g.userProject
+	}
+	uri, err := w.start(g.bucket, name, metadata, userProject, g.kmsKeyName, predefinedACL([]cloudstorage.Opts{opts}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cloudstorage.SaveUploadSession(w.path, uploadSession{URI: uri}); err != nil {
+		return nil, err
+	}
+	w.uri = uri
+	return w, nil
+}
+
+// start a session for the object, with its attrs.
+func (w *resumableWriter) start(bucket, name string, metadata map[string]string, userProject, kmsKeyName, predefinedACL string) (string, error) {
+	attrs := objectAttrs(name, metadata)
+	body := map[string]interface{}{"name": name}
+	if attrs.ContentType != "" {
+		body["contentType"] = attrs.ContentType
+	}
+	if len(attrs.Metadata) > 0 {
+		body["metadata"] = attrs.Metadata
+	}
+	if !attrs.CustomTime.IsZero() {
+		body["customTime"] = attrs.CustomTime.Format(time.RFC3339Nano)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{"uploadType": {"resumable"}, "name": {name}}
+	if userProject != "" {
+		q.Set("userProject", userProject)
+	}
+	if kmsKeyName != "" {
+		q.Set("kmsKeyName", kmsKeyName)
+	}
+	if predefinedACL != "" {
+		q.Set("predefinedAcl", predefinedACL)
+	}
+	req, err := http.NewRequest("POST", gcsUploadURL+url.PathEscape(bucket)+"/o?"+q.Encode(), bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	if attrs.ContentType != "" {
+		req.Header.Set("X-Upload-Content-Type", attrs.ContentType)
+	}
+	resp, err := w.do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not start upload session of %s status=%s", name, resp.Status)
+	}
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return "", fmt.Errorf("no upload session uri for %s", name)
+	}
+	return uri, nil
+}
+
+// status is the number of bytes the session uri has.
+func (w *resumableWriter) status(uri string) (int64, error) {
+	req, err := http.NewRequest("PUT", uri, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Range", "bytes */*")
+	resp, err := w.do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPermanentRedirect:
+		return persisted(resp)
+	case http.StatusOK, http.StatusCreated, http.StatusNotFound, http.StatusGone:
+		return 0, errSessionGone
+	}
+	return 0, fmt.Errorf("could not get upload session status=%s", resp.Status)
+}
+
+// persisted is the number of bytes of the Range of an incomplete upload.
+func persisted(resp *http.Response) (int64, error) {
+	r := resp.Header.Get("Range")
+	if r == "" {
+		return 0, nil
+	}
+	i := strings.LastIndex(r, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("invalid upload session range %q", r)
+	}
+	last, err := strconv.ParseInt(r[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upload session range %q", r)
+	}
+	return last + 1, nil
+}
+
+func (w *resumableWriter) do(req *http.Request) (*http.Response, error) {
+	return w.client.Do(req.WithContext(w.ctx))
+}
+
+// put sends chunk from the offset, the last chunk of the object if final, and
+// returns the number of bytes the session has.
+func (w *resumableWriter) put(chunk []byte, final bool) (int64, error) {
+	req, err := http.NewRequest("PUT", w.uri, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, err
+	}
+	end := w.offset + int64(len(chunk))
+	total := "*"
+	if final {
+		total = strconv.FormatInt(end, 10)
+	}
+	if len(chunk) == 0 {
+		req.Header.Set("Content-Range", "bytes */"+total)
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", w.offset, end-1, total))
+	}
+	resp, err := w.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case !final && resp.StatusCode == http.StatusPermanentRedirect:
+		return persisted(resp)
+	case final && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated):
+		return end, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return 0, fmt.Errorf("could not upload to session status=%s %s", resp.Status, msg)
+}
+
+// Resumed for cloudstorage.WriterResume.
+func (w *resumableWriter) Resumed() int64 {
+	return w.resumed
+}
+
+func (w *resumableWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to a closed resumable upload")
+	}
+	w.buf = append(w.buf, p...)
+	// the last chunk is sent on Close, with the size of the object.
+	for len(w.buf) > resumableChunkSize {
+		n, err := w.put(w.buf[:resumableChunkSize], false)
+		if err != nil {
+			return 0, err
+		}
+		if n < w.offset || n > w.offset+resumableChunkSize {
+			return 0, fmt.Errorf("upload session has %d bytes, sent to %d", n, w.offset+resumableChunkSize)
+		}
+		// the session may not have kept all the chunk, the rest is resent.
+		w.buf = append(w.buf[:0], w.buf[n-w.offset:]...)
+		w.offset = n
+	}
+	return len(p), nil
+}
+
+// Close sends the rest of the object, completing the upload.  A failed Close
+// leaves the session to be resumed.
+func (w *resumableWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	if _, err := w.put(w.buf, true); err != nil {
+		return err
+	}
+	w.closed = true
+	cloudstorage.RemoveUploadSession(w.path)
+	return nil
+}
@@ -2,8 +2,13 @@ package google
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -13,6 +18,7 @@ import (
 	"github.com/araddon/gou"
 	"github.com/pborman/uuid"
 	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 
 	"github.com/lytics/cloudstorage"
@@ -32,12 +38,26 @@ func provider(conf *cloudstorage.Config) (cloudstorage.Store, error) {
 // StoreType = "gcs"
 const StoreType = "gcs"
 
+// ConfKeyUserProject config Settings key of the project billed for the
+// requests to a requester pays bucket, ReadOptions.UserProject and
+// Opts.UserProject override it per call.
+const ConfKeyUserProject = "user_project"
+
+// ConfKeyKMSKeyName config Settings key of the Cloud KMS key objects are
+// written encrypted with, ie
+// "projects/p/locations/global/keyRings/r/cryptoKeys/k".  Empty uses the
+// bucket's default encryption.
+const ConfKeyKMSKeyName = "kms_key_name"
+
 var (
 	// GCSRetries number of times to retry for GCS.
 	GCSRetries int = 55
 
 	// Ensure we implement ObjectIterator
-	_ cloudstorage.ObjectIterator = (*objectIterator)(nil)
+	_ cloudstorage.ObjectIterator    = (*objectIterator)(nil)
+	_ cloudstorage.ObjectCRC32C      = (*object)(nil)
+	_ cloudstorage.ObjectOpenContext = (*object)(nil)
+	_ cloudstorage.ObjectRange       = (*object)(nil)
 )
 
 // GcsFS Simple wrapper for accessing smaller GCS files, it doesn't currently implement a
@@ -48,6 +68,25 @@ type GcsFS struct {
 	cachepath string
 	PageSize  int
 	Id        string
+	// SignerServiceAccount is the service account email SignedURL signs
+	// with through the IAM credentials api when there is no private key.
+	SignerServiceAccount string
+	// httpclient is the authorized client for IAM signBlob requests.
+	httpclient *http.Client
+	// privateKey and accessID of the JwtConf, if there was one.
+	privateKey []byte
+	accessID   string
+	// project of the bucket, for the Storage Transfer Service.
+	project string
+	// separator of the folders in object names, the delimiter of Folders.
+	separator string
+	// userProject is billed for the requests to a requester pays bucket,
+	// see ConfKeyUserProject.
+	userProject string
+	// kmsKeyName objects are written with, see ConfKeyKMSKeyName.
+	kmsKeyName string
+	// upload is the composite upload of large objects, see parallelUpload.
+	upload parallelUpload
 }
 
 // NewGCSStore Create Google Cloud Storage Store.
@@ -66,6 +105,7 @@ func NewGCSStore(gcs *storage.Client, bucket, cachepath string, pagesize int) (*
 		cachepath: cachepath,
 		Id:        uid,
 		PageSize:  pagesize,
+		separator: cloudstorage.DefaultSeparator,
 	}, nil
 }
 
@@ -79,13 +119,51 @@ func (g *GcsFS) Client() interface{} {
 	return g.gcs
 }
 
+// ResolveKey is the gcs object name of o, names are used as is.
+func (g *GcsFS) ResolveKey(o string) string {
+	return o
+}
+
+// UpdateMetadata replaces the metadata of object o, and its content type if
+// metadata has a ContentTypeKey.
+func (g *GcsFS) UpdateMetadata(ctx context.Context, o string, metadata map[string]string) error {
+	attrs := storage.ObjectAttrsToUpdate{Metadata: metadata}
+	if ctype := cloudstorage.MetadataContentType(metadata); ctype != "" {
+		attrs.ContentType = ctype
+	}
+	_, err := g.gcsb().Object(o).Update(ctx, attrs)
+	if err == storage.ErrObjectNotExist {
+		return cloudstorage.ErrObjectNotFound
+	}
+	return err
+}
+
 // String function to provide gs://..../file   path
 func (g *GcsFS) String() string {
 	return fmt.Sprintf("gs://%s/", g.bucket)
 }
 
+// KeySeparator for cloudstorage.StoreSeparator, the separator of the folders
+// in object names.
+func (g *GcsFS) KeySeparator() string {
+	return g.separator
+}
+
 func (g *GcsFS) gcsb() *storage.BucketHandle {
-	return g.gcs.Bucket(g.bucket)
+	return g.billedBucket("")
+}
+
+// billedBucket is the bucket with requests billed to userProject, or if it's
+// empty the store's userProject.
+func (g *GcsFS) billedBucket(userProject string) *storage.BucketHandle {
+	b := g.gcs.Bucket(g.bucket)
+	if userProject == "" {
+		userProject = g.userProject
+	}
+	if userProject != "" {
+		b = b.UserProject(userProject)
+	}
+	return b
 }
 
 // NewObject of Type GCS.
@@ -102,15 +180,19 @@ func (g *GcsFS) NewObject(objectname string) (cloudstorage.Object, error) {
 	return &object{
 		name:       objectname,
 		metadata:   map[string]string{cloudstorage.ContentTypeKey: cloudstorage.ContentType(objectname)},
+		g:          g,
 		gcsb:       g.gcsb(),
 		bucket:     g.bucket,
+		kmsKeyName: g.kmsKeyName,
+		upload:     g.upload,
 		cachedcopy: nil,
 		cachepath:  cf,
 	}, nil
 }
 
 // Get Gets a single File Object
-func (g *GcsFS) Get(ctx context.Context, objectpath string) (cloudstorage.Object, error) {
+func (g *GcsFS) Get(ctx context.Context, objectpath string) (_ cloudstorage.Object, err error) {
+	defer cloudstorage.RecoverPanic("gcs get", &err)
 
 	gobj, err := g.gcsb().Object(objectpath).Attrs(context.Background()) // .Objects(context.Background(), q)
 	if err != nil {
@@ -130,24 +212,52 @@ func (g *GcsFS) Get(ctx context.Context, objectpath string) (cloudstorage.Object
 // Objects returns an iterator over the objects in the google bucket that match the Query q.
 // If q is nil, no filtering is done.
 func (g *GcsFS) Objects(ctx context.Context, csq cloudstorage.Query) (cloudstorage.ObjectIterator, error) {
-	var q = &storage.Query{Prefix: csq.Prefix}
+	if csq.Buffered() {
+		// List returns the sorted full result set.
+		return cloudstorage.NewObjectPageIterator(ctx, g, csq), nil
+	}
+	return g.objects(ctx, csq), nil
+}
+
+func (g *GcsFS) objects(ctx context.Context, csq cloudstorage.Query) *objectIterator {
+	// StartOffset is inclusive, the iterator skips StartAfter itself.
+	var q = &storage.Query{Prefix: csq.Prefix, StartOffset: csq.StartAfter}
+	if csq.NamesOnly {
+		// the size and time filters need the sizes and times.
+		q.SetAttrSelection([]string{"Name", "Size", "Updated"})
+	}
 	iter := g.gcsb().Objects(ctx, q)
-	return &objectIterator{g, ctx, iter}, nil
+	if csq.Limit > 0 && !csq.Filtered() {
+		// pages of at most Limit objects, GCS caps them at 1000.
+		iter.PageInfo().MaxSize = csq.Limit
+	}
+	return &objectIterator{g: g, ctx: ctx, iter: iter, q: csq}
 }
 
 // Objects returns an iterator over the objects in the google bucket that match the Query q.
 // If q is nil, no filtering is done.
-func (g *GcsFS) List(ctx context.Context, csq cloudstorage.Query) (*cloudstorage.ObjectsResponse, error) {
-	iter, err := g.Objects(ctx, csq)
+func (g *GcsFS) List(ctx context.Context, csq cloudstorage.Query) (_ *cloudstorage.ObjectsResponse, err error) {
+	defer cloudstorage.RecoverPanic("gcs list", &err)
+	// Limit is of the Objects iterator, List has a page of the full result
+	// set, after the Marker.
+	csq.Limit = 0
+	if csq.Marker > csq.StartAfter {
+		csq.StartAfter = csq.Marker
+	}
+	iter := g.objects(ctx, csq)
+	defer iter.Close()
+	resp, err := cloudstorage.PageFromIter(iter, csq.PageSize)
 	if err != nil {
 		return nil, err
 	}
-	return cloudstorage.ObjectResponseFromIter(iter)
+	resp.Objects = csq.ApplyFilters(resp.Objects)
+	return resp, nil
 }
 
 // Folders get folders list.
-func (g *GcsFS) Folders(ctx context.Context, csq cloudstorage.Query) ([]string, error) {
-	var q = &storage.Query{Delimiter: csq.Delimiter, Prefix: csq.Prefix}
+func (g *GcsFS) Folders(ctx context.Context, csq cloudstorage.Query) (_ []string, err error) {
+	defer cloudstorage.RecoverPanic("gcs folders", &err)
+	var q = &storage.Query{Delimiter: g.separator, Prefix: csq.Prefix}
 	iter := g.gcsb().Objects(ctx, q)
 	folders := make([]string, 0)
 	for {
@@ -171,8 +281,41 @@ func (g *GcsFS) Folders(ctx context.Context, csq cloudstorage.Query) ([]string,
 	}
 }
 
+// ListLevel lists the objects and folders directly under prefix in a single
+// delimited listing, stopping at the end of the page with limit of them.
+func (g *GcsFS) ListLevel(ctx context.Context, prefix string, limit int) (_ cloudstorage.Objects, _ []string, err error) {
+	defer cloudstorage.RecoverPanic("gcs list level", &err)
+	iter := g.gcsb().Objects(ctx, &storage.Query{Delimiter: g.separator, Prefix: prefix})
+	if limit > 0 {
+		iter.PageInfo().MaxSize = limit
+	}
+	objects := make(cloudstorage.Objects, 0)
+	folders := make([]string, 0)
+	for {
+		// a page has its objects before its prefixes, so the first limit
+		// names may be anywhere in it.
+		if limit > 0 && len(objects)+len(folders) >= limit && iter.PageInfo().Remaining() == 0 {
+			objects, folders = cloudstorage.LimitLevel(objects, folders, limit)
+			return objects, folders, nil
+		}
+		o, err := iter.Next()
+		if err == iterator.Done {
+			objects, folders = cloudstorage.LimitLevel(objects, folders, limit)
+			return objects, folders, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if o.Prefix != "" {
+			folders = append(folders, o.Prefix)
+		} else {
+			objects = append(objects, newObject(g, o))
+		}
+	}
+}
+
 // Copy from src to destination
-func (g *GcsFS) Copy(ctx context.Context, src, des cloudstorage.Object) error {
+func (g *GcsFS) Copy(ctx context.Context, src, des cloudstorage.Object) (err error) {
+	defer cloudstorage.RecoverPanic("gcs copy", &err)
 
 	srcgcs, ok := src.(*object)
 	if !ok {
@@ -183,15 +326,34 @@ func (g *GcsFS) Copy(ctx context.Context, src, des cloudstorage.Object) error {
 		return fmt.Errorf("Copy destination expected GCS but got %T", des)
 	}
 
-	oh := srcgcs.gcsb.Object(srcgcs.name)
-	dh := desgcs.gcsb.Object(desgcs.name)
+	return copyObject(ctx, srcgcs, desgcs)
+}
 
-	_, err := dh.CopierFrom(oh).Run(ctx)
-	return err
+// copyObject copies src to des server side, which keeps the metadata and
+// checksums, and checks the copy has the checksums of src.
+func copyObject(ctx context.Context, src, des *object) error {
+	oh := src.gcsb.Object(src.name)
+	dh := des.gcsb.Object(des.name)
+
+	copier := dh.CopierFrom(oh)
+	copier.DestinationKMSKeyName = des.kmsKeyName
+	attrs, err := copier.Run(ctx)
+	if err != nil {
+		return err
+	}
+	if src.md5 != nil && len(attrs.MD5) > 0 && !bytes.Equal(src.md5, attrs.MD5) {
+		return cloudstorage.ErrChecksumMismatch
+	}
+	// the crc32c is zero for objects without attrs, ie from NewObject
+	if src.crc32c != 0 && src.crc32c != attrs.CRC32C {
+		return cloudstorage.ErrChecksumMismatch
+	}
+	return nil
 }
 
 // Move which is a Copy & Delete
-func (g *GcsFS) Move(ctx context.Context, src, des cloudstorage.Object) error {
+func (g *GcsFS) Move(ctx context.Context, src, des cloudstorage.Object) (err error) {
+	defer cloudstorage.RecoverPanic("gcs move", &err)
 
 	srcgcs, ok := src.(*object)
 	if !ok {
@@ -202,14 +364,11 @@ func (g *GcsFS) Move(ctx context.Context, src, des cloudstorage.Object) error {
 		return fmt.Errorf("Move destination expected GCS but got %T", des)
 	}
 
-	oh := srcgcs.gcsb.Object(srcgcs.name)
-	dh := desgcs.gcsb.Object(desgcs.name)
-
-	if _, err := dh.CopierFrom(oh).Run(ctx); err != nil {
+	if err := copyObject(ctx, srcgcs, desgcs); err != nil {
 		return err
 	}
 
-	return oh.Delete(ctx)
+	return srcgcs.gcsb.Object(srcgcs.name).Delete(ctx)
 }
 
 // NewReader create GCS file reader.
@@ -218,12 +377,38 @@ func (g *GcsFS) NewReader(o string) (io.ReadCloser, error) {
 }
 
 // NewReaderWithContext create new GCS File reader with context.
-func (g *GcsFS) NewReaderWithContext(ctx context.Context, o string) (io.ReadCloser, error) {
-	rc, err := g.gcsb().Object(o).NewReader(ctx)
+func (g *GcsFS) NewReaderWithContext(ctx context.Context, o string, opts ...cloudstorage.ReadOptions) (_ io.ReadCloser, err error) {
+	defer cloudstorage.RecoverPanic("gcs read", &err)
+	bucket := g.gcsb()
+	if len(opts) > 0 {
+		bucket = g.billedBucket(opts[0].UserProject)
+	}
+	rc, err := bucket.Object(o).NewReader(ctx)
 	if err == storage.ErrObjectNotExist {
 		return rc, cloudstorage.ErrObjectNotFound
 	}
-	return rc, err
+	if err != nil {
+		return rc, err
+	}
+	if len(opts) > 0 && opts[0].VerifyChecksum {
+		// the storage client already validates the crc32c of whole object reads,
+		// we only need to surface its failure as our error.
+		return cloudstorage.MaxBytesReader(&crcReader{rc}, opts), nil
+	}
+	// gzip encoded objects are transcoded, so MaxBytes caps the decompressed bytes.
+	return cloudstorage.MaxBytesReader(rc, opts), nil
+}
+
+type crcReader struct {
+	*storage.Reader
+}
+
+func (r *crcReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && strings.Contains(err.Error(), "bad CRC") {
+		return n, cloudstorage.ErrChecksumMismatch
+	}
+	return n, err
 }
 
 // NewWriter create GCS Object Writer.
@@ -232,42 +417,178 @@ func (g *GcsFS) NewWriter(o string, metadata map[string]string) (io.WriteCloser,
 }
 
 // NewWriterWithContext create writer with provided context and metadata.
-func (g *GcsFS) NewWriterWithContext(ctx context.Context, o string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
-	obj := g.gcsb().Object(o)
+func (g *GcsFS) NewWriterWithContext(ctx context.Context, o string, metadata map[string]string, opts ...cloudstorage.Opts) (_ io.WriteCloser, err error) {
+	defer cloudstorage.RecoverPanic("gcs write", &err)
+	if resumes, err := cloudstorage.Resumes(metadata, opts); err != nil {
+		return nil, err
+	} else if resumes {
+		return g.newResumableWriter(ctx, o, metadata, opts[0])
+	}
+	if cloudstorage.SkipsIfIdentical(opts) {
+		return cloudstorage.NewSkipIfIdenticalWriter(ctx, g, o, metadata, opts)
+	}
+	if cloudstorage.VerifiesOnClose(opts) {
+		return cloudstorage.NewVerifyOnCloseWriter(ctx, g, o, metadata, opts)
+	}
+	if cloudstorage.DetectsContentType(metadata, opts) {
+		return cloudstorage.NewContentTypeWriter(o, metadata, opts, func(md map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+			return g.NewWriterWithContext(ctx, o, md, opts...)
+		}), nil
+	}
+	bucket := g.gcsb()
+	if len(opts) > 0 {
+		bucket = g.billedBucket(opts[0].UserProject)
+	}
+	obj := bucket.Object(o)
+	conditional := len(opts) > 0 && (opts[0].IfNotExists || opts[0].IfMatch != "" || opts[0].IfGenerationMatch != 0)
+	if g.upload.composes(cloudstorage.UnknownSize) && !conditional && (len(opts) == 0 || opts[0].ContentMD5 == nil) {
+		return g.newCompositeWriter(ctx, bucket, o, metadata, predefinedACL(opts)), nil
+	}
 	if len(opts) > 0 && opts[0].IfNotExists {
 		obj = obj.If(storage.Conditions{DoesNotExist: true})
+	} else if len(opts) > 0 && opts[0].IfGenerationMatch != 0 {
+		obj = obj.If(storage.Conditions{GenerationMatch: opts[0].IfGenerationMatch})
+	} else if len(opts) > 0 && opts[0].IfMatch != "" {
+		// as for delete, match the etag to a generation to write on.
+		attrs, err := obj.Attrs(ctx)
+		if err == storage.ErrObjectNotExist {
+			return nil, cloudstorage.ErrPreconditionFailed
+		} else if err != nil {
+			return nil, err
+		}
+		if cloudstorage.CleanETag(attrs.Etag) != opts[0].IfMatch {
+			return nil, cloudstorage.ErrPreconditionFailed
+		}
+		obj = obj.If(storage.Conditions{GenerationMatch: attrs.Generation})
 	}
 	wc := obj.NewWriter(ctx)
+	wc.KMSKeyName = g.kmsKeyName
+	wc.PredefinedACL = predefinedACL(opts)
 	if metadata != nil {
 		wc.Metadata = metadata
 		//contenttype is only used for viewing the file in a browser. (i.e. the GCS Object browser).
 		ctype := cloudstorage.EnsureContextType(o, metadata)
 		wc.ContentType = ctype
+		setCustomTime(&wc.ObjectAttrs, metadata)
+	}
+	if len(opts) > 0 && opts[0].ContentMD5 != nil {
+		// GCS rejects the upload if the md5 of the received bytes differs.
+		wc.MD5 = opts[0].ContentMD5
+		if conditional {
+			return &conditionalWriter{&md5Writer{wc}}, nil
+		}
+		return &md5Writer{wc}, nil
+	}
+	if conditional {
+		return &conditionalWriter{wc}, nil
 	}
 	return wc, nil
 }
 
+// predefinedACL of a write, "publicRead" for Opts.PublicRead otherwise empty
+// for the bucket's default object ACL.
+func predefinedACL(opts []cloudstorage.Opts) string {
+	if len(opts) > 0 && opts[0].PublicRead {
+		return "publicRead"
+	}
+	return ""
+}
+
+// conditionalWriter translates the upload being rejected for its conditions
+// to ErrPreconditionFailed.
+type conditionalWriter struct {
+	io.WriteCloser
+}
+
+func (w *conditionalWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if isPreconditionFailed(err) {
+		return cloudstorage.ErrPreconditionFailed
+	}
+	return err
+}
+
+// md5Writer translates the upload being rejected for its md5 to
+// ErrChecksumMismatch.
+type md5Writer struct {
+	*storage.Writer
+}
+
+func (w *md5Writer) Close() error {
+	err := w.Writer.Close()
+	if err != nil && strings.Contains(err.Error(), "MD5") {
+		return cloudstorage.ErrChecksumMismatch
+	}
+	return err
+}
+
 // Delete requested object path string.
-func (g *GcsFS) Delete(ctx context.Context, obj string) error {
-	err := g.gcsb().Object(obj).Delete(ctx)
+func (g *GcsFS) Delete(ctx context.Context, obj string, opts ...cloudstorage.DeleteOptions) (err error) {
+	defer cloudstorage.RecoverPanic("gcs delete", &err)
+	if err := g.delete(ctx, obj, opts...); err != nil {
+		return err
+	}
+	if len(opts) > 0 && opts[0].WaitConsistent {
+		return cloudstorage.WaitDeleted(ctx, g, obj)
+	}
+	return nil
+}
+
+func (g *GcsFS) delete(ctx context.Context, obj string, opts ...cloudstorage.DeleteOptions) error {
+	oh := g.gcsb().Object(obj)
+	if len(opts) > 0 && (opts[0].IfMatch != "" || opts[0].IfGenerationMatch != 0) {
+		generation := opts[0].IfGenerationMatch
+		if opts[0].IfMatch != "" {
+			// GCS has no etag precondition, so match the etag to a
+			// generation and make the delete conditional on that.
+			attrs, err := oh.Attrs(ctx)
+			if err == storage.ErrObjectNotExist {
+				return cloudstorage.ErrPreconditionFailed
+			} else if err != nil {
+				return err
+			}
+			if cloudstorage.CleanETag(attrs.Etag) != opts[0].IfMatch {
+				return cloudstorage.ErrPreconditionFailed
+			}
+			if generation != 0 && generation != attrs.Generation {
+				return cloudstorage.ErrPreconditionFailed
+			}
+			generation = attrs.Generation
+		}
+		err := oh.If(storage.Conditions{GenerationMatch: generation}).Delete(ctx)
+		if err == storage.ErrObjectNotExist || isPreconditionFailed(err) {
+			return cloudstorage.ErrPreconditionFailed
+		}
+		return err
+	}
+	err := oh.Delete(ctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// isPreconditionFailed is GCS rejecting a request for its conditions.
+func isPreconditionFailed(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && gerr.Code == http.StatusPreconditionFailed
+}
+
 // objectIterator iterator to match store interface for iterating
 // through all GcsObjects that matched query.
 type objectIterator struct {
-	g    *GcsFS
-	ctx  context.Context
-	iter *storage.ObjectIterator
+	g     *GcsFS
+	ctx   context.Context
+	iter  *storage.ObjectIterator
+	q     cloudstorage.Query
+	count int
 }
 
 func (*objectIterator) Close() {}
 
 // Next iterator to go to next object or else returns error for done.
-func (it *objectIterator) Next() (cloudstorage.Object, error) {
+func (it *objectIterator) Next() (_ cloudstorage.Object, err error) {
+	defer cloudstorage.RecoverPanic("gcs list", &err)
 	retryCt := 0
 	for {
 		select {
@@ -275,9 +596,16 @@ func (it *objectIterator) Next() (cloudstorage.Object, error) {
 			// If has been closed
 			return nil, it.ctx.Err()
 		default:
+			if it.q.Limit > 0 && it.count >= it.q.Limit {
+				return nil, iterator.Done
+			}
 			o, err := it.iter.Next()
 			if err == nil {
-				return newObject(it.g, o), nil
+				if !it.q.After(o.Name) || !it.q.InSizeRange(o.Size) || !it.q.InTimeRange(o.Updated) || !it.q.NameMatches(o.Name) {
+					continue
+				}
+				it.count++
+				return it.q.NameOnly(newObject(it.g, o)), nil
 			} else if err == iterator.Done {
 				return nil, err
 			} else if err == context.Canceled || err == context.DeadlineExceeded {
@@ -294,27 +622,63 @@ func (it *objectIterator) Next() (cloudstorage.Object, error) {
 	}
 }
 
+// setCustomTime sets the native Custom-Time from CustomTimeKey metadata.
+func setCustomTime(attrs *storage.ObjectAttrs, metadata map[string]string) {
+	v, ok := metadata[cloudstorage.CustomTimeKey]
+	if !ok {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		gou.Warnf("invalid %s=%q err=%v", cloudstorage.CustomTimeKey, v, err)
+		return
+	}
+	attrs.CustomTime = t
+}
+
 type object struct {
 	name         string
 	updated      time.Time
+	customTime   time.Time
+	etag         string
+	size         int64
+	generation   int64
+	contentType  string
+	md5          []byte
+	crc32c       uint32
 	metadata     map[string]string
 	googleObject *storage.ObjectAttrs
+	g            *GcsFS
 	gcsb         *storage.BucketHandle
 	bucket       string
+	kmsKeyName   string
+	upload       parallelUpload
 	cachedcopy   *os.File
 	readonly     bool
 	opened       bool
 	cachepath    string
+	// ctx of OpenWithContext, of the download and the upload of Sync.
+	ctx context.Context
 }
 
 func newObject(g *GcsFS, o *storage.ObjectAttrs) *object {
 	return &object{
-		name:      o.Name,
-		updated:   o.Updated,
-		metadata:  o.Metadata,
-		gcsb:      g.gcsb(),
-		bucket:    g.bucket,
-		cachepath: cloudstorage.CachePathObj(g.cachepath, o.Name, g.Id),
+		name:        o.Name,
+		updated:     o.Updated,
+		customTime:  o.CustomTime,
+		etag:        cloudstorage.CleanETag(o.Etag),
+		size:        o.Size,
+		generation:  o.Generation,
+		contentType: o.ContentType,
+		md5:         o.MD5,
+		crc32c:      o.CRC32C,
+		metadata:    o.Metadata,
+		g:           g,
+		gcsb:        g.gcsb(),
+		bucket:      g.bucket,
+		kmsKeyName:  g.kmsKeyName,
+		upload:      g.upload,
+		cachepath:   cloudstorage.CachePathObj(g.cachepath, o.Name, g.Id),
 	}
 }
 func (o *object) StorageSource() string {
@@ -329,6 +693,35 @@ func (o *object) String() string {
 func (o *object) Updated() time.Time {
 	return o.updated
 }
+
+func (o *object) ETag() string {
+	return o.etag
+}
+func (o *object) Size() int64 {
+	return o.size
+}
+func (o *object) ContentType() string {
+	return o.contentType
+}
+func (o *object) MD5() []byte {
+	return o.md5
+}
+
+// Generation of the object version, see DeleteOptions.IfGenerationMatch.
+func (o *object) Generation() int64 {
+	return o.generation
+}
+
+// CRC32C GCS computed of the object, zero for objects without attrs (ie
+// from NewObject).
+func (o *object) CRC32C() uint32 {
+	return o.crc32c
+}
+
+// CustomTime is the native GCS Custom-Time of the object.
+func (o *object) CustomTime() time.Time {
+	return o.customTime
+}
 func (o *object) MetaData() map[string]string {
 	return o.metadata
 }
@@ -341,15 +734,50 @@ func (o *object) Delete() error {
 	return o.gcsb.Object(o.name).Delete(context.Background())
 }
 
-func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
+// Open the object, concurrent ReadOnly opens of the same object share one
+// download, see cloudstorage.SharedDownload.
+func (o *object) Open(accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+// OpenWithContext for cloudstorage.ObjectOpenContext, the download (and the
+// upload of Sync) is aborted once ctx is done.
+func (o *object) OpenWithContext(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	if !accesslevel.Valid() {
+		return nil, cloudstorage.ErrInvalidAccessLevel
+	}
+	if !cloudstorage.SharesDownload(ctx, accesslevel) || o.opened {
+		return o.open(ctx, accesslevel, opts...)
+	}
+	f, shared, err := cloudstorage.SharedDownload(o.cachepath, opts, func() (*os.File, error) {
+		return o.open(ctx, accesslevel, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		o.cachedcopy = f
+		o.readonly = true
+		o.opened = true
+	}
+	return cloudstorage.VerifyOpened(o, f, opts)
+}
+
+func (o *object) open(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (_ *os.File, err error) {
+	defer cloudstorage.RecoverPanic("gcs open", &err)
 	if o.opened {
 		return nil, fmt.Errorf("the store object is already opened. %s", o.name)
 	}
+	o.ctx = ctx
 
 	var errs []error = make([]error, 0)
 	var cachedcopy *os.File = nil
-	var err error
 	var readonly = accesslevel == cloudstorage.ReadOnly
+	var userProject string
+	if len(opts) > 0 {
+		userProject = opts[0].UserProject
+	}
+	var bucket = o.readBucket(userProject)
 
 	err = os.MkdirAll(path.Dir(o.cachepath), 0775)
 	if err != nil {
@@ -370,8 +798,11 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 	}
 
 	for try := 0; try < GCSRetries; try++ {
+		if err := ctx.Err(); err != nil {
+			return nil, o.abortOpen(cachedcopy, err)
+		}
 		if o.googleObject == nil {
-			gobj, err := o.gcsb.Object(o.name).Attrs(context.Background())
+			gobj, err := bucket.Object(o.name).Attrs(ctx)
 			if err != nil {
 				if strings.Contains(err.Error(), "doesn't exist") {
 					// New, this is fine
@@ -387,9 +818,18 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 			}
 		}
 
-		if o.googleObject != nil {
+		if o.googleObject != nil && readonly && len(opts) > 0 && opts[0].ParallelDownload(o.googleObject.Size) {
+			if err := o.downloadRanges(ctx, cachedcopy, opts[0]); err != nil {
+				errs = append(errs, fmt.Errorf("error downloading ranges err=%v", err))
+				if err := cachedcopy.Truncate(0); err != nil {
+					return nil, fmt.Errorf("error resetting the cachedcopy err=%v", err) //don't retry on local fs errors
+				}
+				cloudstorage.Backoff(try)
+				continue
+			}
+		} else if o.googleObject != nil {
 			//we have a preexisting object, so lets download it..
-			rc, err := o.gcsb.Object(o.name).NewReader(context.Background())
+			rc, err := bucket.Object(o.name).NewReader(ctx)
 			if err != nil {
 				errs = append(errs, fmt.Errorf("error storage.NewReader err=%v", err))
 				cloudstorage.Backoff(try)
@@ -438,10 +878,79 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 		o.opened = true
 		return o.cachedcopy, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, o.abortOpen(cachedcopy, err)
+	}
 
 	return nil, fmt.Errorf("fetch error retry cnt reached: obj=%s tfile=%v errs:[%v]", o.name, o.cachepath, errs)
 }
 
+// readBucket is the bucket of the reads of an open, billed to userProject
+// (of the ReadOptions) if it's set.
+func (o *object) readBucket(userProject string) *storage.BucketHandle {
+	if userProject != "" {
+		return o.g.billedBucket(userProject)
+	}
+	return o.gcsb
+}
+
+// abortOpen removes the partial cachedcopy of an open cancelled by err.
+func (o *object) abortOpen(cachedcopy *os.File, err error) error {
+	cachedcopy.Close()
+	os.Remove(o.cachepath)
+	return err
+}
+
+// context of the open, Background unless opened by OpenWithContext.
+func (o *object) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// downloadRanges fetches the object into cachedcopy as parallel ranges, pinned
+// to the generation we have attrs for, and verifies it against the md5 (or
+// crc32c for composite objects).
+func (o *object) downloadRanges(ctx context.Context, cachedcopy *os.File, opts cloudstorage.ReadOptions) error {
+	attrs := o.googleObject
+	oh := o.readBucket(opts.UserProject).Object(o.name).Generation(attrs.Generation)
+	err := cloudstorage.DownloadRanges(ctx, cachedcopy, attrs.Size, opts,
+		func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+			return oh.NewRangeReader(ctx, offset, length)
+		})
+	if err != nil {
+		return err
+	}
+	if len(attrs.MD5) > 0 {
+		return cloudstorage.VerifyFile(cachedcopy, md5.New(), attrs.MD5)
+	}
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, attrs.CRC32C)
+	return cloudstorage.VerifyFile(cachedcopy, crc32.New(crc32.MakeTable(crc32.Castagnoli)), sum)
+}
+
+// OpenRange for cloudstorage.ObjectRange, a range reader of the object,
+// pinned to the generation we have attrs for.
+func (o *object) OpenRange(ctx context.Context, start, length int64) (_ io.ReadCloser, err error) {
+	defer cloudstorage.RecoverPanic("gcs read", &err)
+	oh := o.gcsb.Object(o.name)
+	if o.generation != 0 {
+		oh = oh.Generation(o.generation)
+	}
+	rc, err := oh.NewRangeReader(ctx, start, length)
+	if err == storage.ErrObjectNotExist {
+		return nil, cloudstorage.ErrObjectNotFound
+	}
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusRequestedRangeNotSatisfiable {
+		return nil, cloudstorage.ErrRangeNotSatisfiable
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
 func (o *object) File() *os.File {
 	return o.cachedcopy
 }
@@ -449,6 +958,9 @@ func (o *object) Read(p []byte) (n int, err error) {
 	return o.cachedcopy.Read(p)
 }
 func (o *object) Write(p []byte) (n int, err error) {
+	if o.opened && o.readonly {
+		return 0, cloudstorage.ErrReadOnly
+	}
 	if o.cachedcopy == nil {
 		_, err := o.Open(cloudstorage.ReadWrite)
 		if err != nil {
@@ -458,13 +970,14 @@ func (o *object) Write(p []byte) (n int, err error) {
 	return o.cachedcopy.Write(p)
 }
 
-func (o *object) Sync() error {
+func (o *object) Sync() (err error) {
+	defer cloudstorage.RecoverPanic("gcs sync", &err)
 
 	if !o.opened {
 		return fmt.Errorf("object isn't opened object:%s", o.name)
 	}
 	if o.readonly {
-		return fmt.Errorf("trying to Sync a readonly object:%s", o.name)
+		return cloudstorage.ErrReadOnly
 	}
 
 	var errs = make([]string, 0)
@@ -476,19 +989,28 @@ func (o *object) Sync() error {
 	}
 	defer cachedcopy.Close()
 
+	if fi, err := cachedcopy.Stat(); err == nil && o.upload.composes(fi.Size()) {
+		return o.composeFile(cachedcopy, fi.Size())
+	}
+
 	for try := 0; try < GCSRetries; try++ {
+		if err := o.context().Err(); err != nil {
+			return err
+		}
 		if _, err := cachedcopy.Seek(0, os.SEEK_SET); err != nil {
 			return fmt.Errorf("error seeking to start of cachedcopy err=%v", err) //don't retry on local filesystem errors
 		}
 		rd := bufio.NewReader(cachedcopy)
 
-		wc := o.gcsb.Object(o.name).NewWriter(context.Background())
+		wc := o.gcsb.Object(o.name).NewWriter(o.context())
+		wc.KMSKeyName = o.kmsKeyName
 
 		if o.metadata != nil {
 			wc.Metadata = o.metadata
 			//contenttype is only used for viewing the file in a browser. (i.e. the GCS Object browser).
 			ctype := cloudstorage.EnsureContextType(o.name, o.metadata)
 			wc.ContentType = ctype
+			setCustomTime(&wc.ObjectAttrs, o.metadata)
 		}
 
 		if _, err = io.Copy(wc, rd); err != nil {
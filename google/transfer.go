@@ -0,0 +1,95 @@
+package google
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/storagetransfer/v1"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// SubmitBulkCopy creates a Storage Transfer Service job, run once now,
+// copying the objects under srcPrefix to dstPrefix in the bucket.  The
+// prefixes are folders so must end in "/", objects keep their name relative
+// to srcPrefix as with CopyPrefix.  The store credentials need the
+// storagetransfer.jobs.create permission in the store's Project, and the
+// projects transfer service account access to the bucket.
+func (g *GcsFS) SubmitBulkCopy(ctx context.Context, srcPrefix, dstPrefix string) (cloudstorage.JobID, error) {
+	if !strings.HasSuffix(srcPrefix, "/") || !strings.HasSuffix(dstPrefix, "/") {
+		return "", fmt.Errorf("gcs transfer prefixes must be folders ending in '/' src=%q dst=%q", srcPrefix, dstPrefix)
+	}
+	svc, err := storagetransfer.New(g.httpclient)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	today := &storagetransfer.Date{Year: int64(now.Year()), Month: int64(now.Month()), Day: int64(now.Day())}
+	job, err := svc.TransferJobs.Create(&storagetransfer.TransferJob{
+		ProjectId:   g.project,
+		Description: fmt.Sprintf("cloudstorage copy gs://%s/%s to %s", g.bucket, srcPrefix, dstPrefix),
+		Status:      "ENABLED",
+		// starting and ending today runs the job once, immediately.
+		Schedule: &storagetransfer.Schedule{ScheduleStartDate: today, ScheduleEndDate: today},
+		TransferSpec: &storagetransfer.TransferSpec{
+			GcsDataSource: &storagetransfer.GcsData{BucketName: g.bucket, Path: srcPrefix},
+			GcsDataSink:   &storagetransfer.GcsData{BucketName: g.bucket, Path: dstPrefix},
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return cloudstorage.JobID(job.Name), nil
+}
+
+// BulkJobStatus of a Storage Transfer Service job created by SubmitBulkCopy,
+// from its latest transfer operation.
+func (g *GcsFS) BulkJobStatus(ctx context.Context, id cloudstorage.JobID) (*cloudstorage.BulkJob, error) {
+	svc, err := storagetransfer.New(g.httpclient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := svc.TransferJobs.Get(string(id), g.project).Context(ctx).Do(); err != nil {
+		if strings.Contains(err.Error(), "404") {
+			return nil, cloudstorage.ErrJobNotFound
+		}
+		return nil, err
+	}
+	filter, err := json.Marshal(map[string]interface{}{
+		"projectId": g.project,
+		"jobNames":  []string{string(id)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ops, err := svc.TransferOperations.List("transferOperations", string(filter)).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	job := &cloudstorage.BulkJob{ID: id}
+	if len(ops.Operations) == 0 {
+		// not started yet
+		return job, nil
+	}
+	op := ops.Operations[len(ops.Operations)-1]
+	var meta storagetransfer.TransferOperation
+	if len(op.Metadata) > 0 {
+		if err := json.Unmarshal(op.Metadata, &meta); err != nil {
+			return nil, err
+		}
+	}
+	if meta.Counters != nil {
+		job.Copied = meta.Counters.ObjectsCopiedToSink
+		job.Failed = meta.Counters.ObjectsFromSourceFailed
+	}
+	job.Done = op.Done
+	if op.Error != nil {
+		job.Err = fmt.Errorf("gcs transfer %s failed: %s", id, op.Error.Message)
+	} else if op.Done && meta.Status != "SUCCESS" {
+		job.Err = fmt.Errorf("gcs transfer %s finished with status %s", id, meta.Status)
+	}
+	return job, nil
+}
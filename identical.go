@@ -0,0 +1,118 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/net/context"
+)
+
+// SkipsIfIdentical is true if a write with opts should use a
+// NewSkipIfIdenticalWriter.
+func SkipsIfIdentical(opts []Opts) bool {
+	return len(opts) > 0 && opts[0].SkipIfIdentical
+}
+
+// NewSkipIfIdenticalWriter opens a writer for object name in s that buffers
+// the bytes written to a local file, and on Close only writes them to s if
+// the object there doesn't have the same size and md5, see
+// Opts.SkipIfIdentical.  opts are passed through to s with SkipIfIdentical
+// turned off.
+func NewSkipIfIdenticalWriter(ctx context.Context, s Store, name string, metadata map[string]string, opts []Opts) (io.WriteCloser, error) {
+	return newIdenticalWriter(ctx, s, name, metadata, opts)
+}
+
+func newIdenticalWriter(ctx context.Context, s Store, name string, metadata map[string]string, opts []Opts) (*identicalWriter, error) {
+	opts = append([]Opts(nil), opts...)
+	if len(opts) > 0 {
+		opts[0].SkipIfIdentical = false
+	}
+	tmp, err := ioutil.TempFile("", "cloudstorage-identical")
+	if err != nil {
+		return nil, err
+	}
+	return &identicalWriter{ctx: ctx, s: s, name: name, metadata: metadata, opts: opts, tmp: tmp, h: md5.New()}, nil
+}
+
+// WriteIfDifferent writes the bytes of r to object name in s unless s already
+// has them, returning false if the write was skipped as identical.  See
+// Opts.SkipIfIdentical for the cost of the check.
+func WriteIfDifferent(ctx context.Context, s Store, name string, r io.Reader, metadata map[string]string, opts ...Opts) (bool, error) {
+	w, err := newIdenticalWriter(ctx, s, name, metadata, opts)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.release()
+		return false, err
+	}
+	if err := w.Close(); err != nil {
+		return false, err
+	}
+	return !w.skipped, nil
+}
+
+type identicalWriter struct {
+	ctx      context.Context
+	s        Store
+	name     string
+	metadata map[string]string
+	opts     []Opts
+	tmp      *os.File
+	h        hash.Hash
+	n        int64
+	skipped  bool
+}
+
+func (w *identicalWriter) Write(p []byte) (int, error) {
+	n, err := w.tmp.Write(p)
+	w.h.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *identicalWriter) release() {
+	w.tmp.Close()
+	os.Remove(w.tmp.Name())
+}
+
+// identical is true if the object in the store has the bytes written.
+func (w *identicalWriter) identical() (bool, error) {
+	obj, err := w.s.Get(w.ctx, w.name)
+	if err == ErrObjectNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	sum := obj.MD5()
+	return sum != nil && obj.Size() == w.n && bytes.Equal(sum, w.h.Sum(nil)), nil
+}
+
+func (w *identicalWriter) Close() error {
+	defer w.release()
+
+	same, err := w.identical()
+	if err != nil {
+		return err
+	}
+	if same {
+		w.skipped = true
+		return nil
+	}
+	if _, err := w.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	wc, err := w.s.NewWriterWithContext(w.ctx, w.name, w.metadata, w.opts...)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(wc, w.tmp); err != nil {
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
@@ -0,0 +1,52 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"crypto/md5"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestWriteIfDifferent(t *testing.T) {
+	sum := md5.Sum([]byte("a,b\n"))
+	local := newLocalStore(t, "identical")
+	counted := &outageStore{Store: local, writes: 10}
+	store := &md5Store{counted, map[string][]byte{"a.csv": sum[:]}}
+	ctx := context.Background()
+	writeObject(t, local, "a.csv", "a,b\n")
+
+	written, err := cloudstorage.WriteIfDifferent(ctx, store, "a.csv", strings.NewReader("a,b\n"), nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, written)
+	assert.Equal(t, int32(10), counted.writes)
+
+	written, err = cloudstorage.WriteIfDifferent(ctx, store, "a.csv", strings.NewReader("c,d\n"), nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, written)
+	assert.Equal(t, int32(9), counted.writes)
+	assert.Equal(t, "c,d\n", readAll(t, local, "a.csv"))
+
+	// new objects are written.
+	written, err = cloudstorage.WriteIfDifferent(ctx, store, "b.csv", strings.NewReader("a,b\n"), nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, written)
+}
+
+func TestSkipIfIdentical(t *testing.T) {
+	store := newLocalStore(t, "skipidentical")
+	ctx := context.Background()
+	writeObject(t, store, "a.csv", "a,b\n")
+
+	// localfs has no md5s to compare, so the write isn't skipped.
+	w, err := store.NewWriterWithContext(ctx, "a.csv", nil, cloudstorage.Opts{SkipIfIdentical: true})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("c,d\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a,b\n", readAll(t, store, "a.csv"))
+	assert.Equal(t, nil, w.Close())
+	assert.Equal(t, "c,d\n", readAll(t, store, "a.csv"))
+}
@@ -0,0 +1,267 @@
+//go:build go1.16
+// +build go1.16
+
+package cloudstorage
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// NewFS is a read only fs.FS over the objects of store under root, for use
+// with fs.WalkDir, fs.Glob, http.FS etc.  Objects are files, the prefixes
+// ("/" delimited) of their names are directories.  It implements
+// fs.ReadDirFS and fs.StatFS, its files are io.Seekers.
+func NewFS(store Store, root string) fs.FS {
+	return &storeFS{store: store, root: strings.Trim(root, "/")}
+}
+
+var (
+	_ fs.ReadDirFS = (*storeFS)(nil)
+	_ fs.StatFS    = (*storeFS)(nil)
+)
+
+type storeFS struct {
+	store Store
+	root  string
+}
+
+// key is the object name of fs path name.
+func (f *storeFS) key(name string) string {
+	if name == "." {
+		return f.root
+	}
+	if f.root == "" {
+		return name
+	}
+	return f.root + "/" + name
+}
+
+// prefix is the listing prefix of directory name.
+func (f *storeFS) prefix(name string) string {
+	if k := f.key(name); k != "" {
+		return k + "/"
+	}
+	return ""
+}
+
+func (f *storeFS) Open(name string) (fs.File, error) {
+	fi, err := f.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &storeDir{fs: f, name: name, info: fi}, nil
+	}
+	return &storeFile{fs: f, info: fi}, nil
+}
+
+func (f *storeFS) Stat(name string) (fs.FileInfo, error) {
+	return f.stat("stat", name)
+}
+
+func (f *storeFS) stat(op, name string) (*fileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	ctx := context.Background()
+	if name == "." {
+		return &fileInfo{name: ".", dir: true}, nil
+	}
+	o, err := f.store.Get(ctx, f.key(name))
+	if err == nil {
+		return newFileInfo(o), nil
+	} else if err != ErrObjectNotFound {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	// a directory is a prefix with objects under it
+	q := NewQuery(f.prefix(name))
+	q.PageSize = 1
+	resp, err := f.store.List(ctx, q)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if len(resp.Objects) == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{name: path.Base(name), dir: true}, nil
+}
+
+func (f *storeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fi, err := f.stat("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return f.readDir(name)
+}
+
+// readDir lists directory name, sorted by name.
+func (f *storeFS) readDir(name string) ([]fs.DirEntry, error) {
+	prefix := f.prefix(name)
+	objects, folders, err := ListLevel(context.Background(), f.store, prefix, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	entries := make([]fs.DirEntry, 0, len(objects)+len(folders))
+	for _, o := range objects {
+		if o.Name() == prefix {
+			// a directory placeholder object
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(newFileInfo(o)))
+	}
+	for _, folder := range folders {
+		dir := strings.TrimSuffix(strings.TrimPrefix(folder, prefix), "/")
+		entries = append(entries, fs.FileInfoToDirEntry(&fileInfo{name: dir, dir: true}))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// fileInfo is the fs.FileInfo of an object or prefix.
+type fileInfo struct {
+	name    string
+	size    int64
+	updated time.Time
+	dir     bool
+	o       Object
+}
+
+func newFileInfo(o Object) *fileInfo {
+	return &fileInfo{name: path.Base(o.Name()), size: o.Size(), updated: o.Updated(), o: o}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.updated }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+
+// Sys is the Object of files, nil for directories.
+func (fi *fileInfo) Sys() interface{} { return fi.o }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// storeFile reads an object, seeking reopens the object at the offset (see
+// OpenRange) on the next Read.
+type storeFile struct {
+	fs     *storeFS
+	info   *fileInfo
+	rc     io.ReadCloser
+	offset int64
+	closed bool
+}
+
+func (f *storeFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *storeFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.info.o.Name(), Err: fs.ErrClosed}
+	}
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.rc == nil {
+		var rc io.ReadCloser
+		var err error
+		if f.offset > 0 {
+			rc, err = OpenRange(context.Background(), f.info.o, f.offset, -1)
+		} else {
+			rc, err = f.fs.store.NewReaderWithContext(context.Background(), f.info.o.Name())
+		}
+		if err != nil {
+			return 0, err
+		}
+		f.rc = rc
+	}
+	n, err := f.rc.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *storeFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.o.Name(), Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.info.o.Name(), Err: fs.ErrInvalid}
+	}
+	if offset != f.offset && f.rc != nil {
+		f.rc.Close()
+		f.rc = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *storeFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.info.o.Name(), Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
+
+// storeDir is an open directory, its entries are listed on the first ReadDir.
+type storeDir struct {
+	fs      *storeFS
+	name    string
+	info    *fileInfo
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *storeDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *storeDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *storeDir) Close() error { return nil }
+
+func (d *storeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fs.readDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.listed = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,70 @@
+//go:build go1.16
+// +build go1.16
+
+package cloudstorage_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestNewFS(t *testing.T) {
+	store := newLocalStore(t, "iofs")
+	ctx := context.Background()
+	for _, name := range []string{"site/index.html", "site/css/main.css", "site/js/app/main.js", "other/x.csv"} {
+		w, err := store.NewWriterWithContext(ctx, name, nil)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte("contents of " + name))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+	}
+
+	fsys := cloudstorage.NewFS(store, "site")
+	if err := fstest.TestFS(fsys, "index.html", "css/main.css", "js/app/main.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := fs.ReadFile(fsys, "js/app/main.js")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "contents of site/js/app/main.js", string(b))
+
+	matches, err := fs.Glob(fsys, "*/*.css")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"css/main.css"}, matches)
+
+	_, err = fs.Stat(fsys, "missing.html")
+	assert.True(t, err != nil && errors.Is(err, fs.ErrNotExist))
+
+	entries, err := fs.ReadDir(cloudstorage.NewFS(store, ""), ".")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(entries))
+}
+
+func TestNewFSFileServer(t *testing.T) {
+	store := newLocalStore(t, "iofs_http")
+	writeObject(t, store, "site/a.txt", "0123456789")
+
+	srv := httptest.NewServer(http.FileServer(http.FS(cloudstorage.NewFS(store, "site"))))
+	defer srv.Close()
+	req, err := http.NewRequest("GET", srv.URL+"/a.txt", nil)
+	assert.Equal(t, nil, err)
+	// ranges are read from their offset.
+	req.Header.Set("Range", "bytes=4-7")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "4567", string(b))
+}
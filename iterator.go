@@ -1,6 +1,7 @@
 package cloudstorage
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
 	"time"
@@ -9,7 +10,41 @@ import (
 	"google.golang.org/api/iterator"
 )
 
-// ObjectsAll get all objects for an iterator.
+// SkipRemaining returned by the fn of Walk stops the walk, without Walk
+// returning an error.
+var SkipRemaining = fmt.Errorf("skip the remaining objects")
+
+// Walk calls fn with each object matching q, as the pages of the listing
+// arrive, so unlike ObjectsAll the memory used doesn't grow with the number
+// of objects.  If fn returns SkipRemaining the walk stops and Walk returns
+// nil, any other error stops it and is returned.  Stores implementing
+// StoreWalk walk themselves, for the rest it is an Objects iteration.
+func Walk(ctx context.Context, s Store, q Query, fn func(Object) error) error {
+	if sw, ok := s.(StoreWalk); ok {
+		return sw.Walk(ctx, q, fn)
+	}
+	iter, err := s.Objects(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(o); err == SkipRemaining {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// ObjectsAll get all objects for an iterator, see Walk for listings too
+// large to hold.
 func ObjectsAll(iter ObjectIterator) (Objects, error) {
 	objs := make(Objects, 0)
 	for {
@@ -33,6 +68,27 @@ func ObjectResponseFromIter(iter ObjectIterator) (*ObjectsResponse, error) {
 	return &ObjectsResponse{Objects: objs}, nil
 }
 
+// PageFromIter gets a page of at most pageSize objects (all if zero) of an
+// iterator in name order, with HasMore and the NextMarker set if there are
+// more.
+func PageFromIter(iter ObjectIterator, pageSize int) (*ObjectsResponse, error) {
+	resp := NewObjectsResponse()
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			return resp, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if pageSize > 0 && len(resp.Objects) == pageSize {
+			resp.NextMarker = resp.Objects[len(resp.Objects)-1].Name()
+			resp.HasMore = true
+			return resp, nil
+		}
+		resp.Objects = append(resp.Objects, o)
+	}
+}
+
 // ObjectPageIterator iterator to facilitate easy paging through store.List() method
 // to read all Objects that matched query.
 type ObjectPageIterator struct {
@@ -42,6 +98,7 @@ type ObjectPageIterator struct {
 	q      Query
 	cursor int
 	page   Objects
+	count  int
 }
 
 // NewObjectPageIterator create an iterator that wraps the store List interface.
@@ -57,6 +114,7 @@ func NewObjectPageIterator(ctx context.Context, s Store, q Query) ObjectIterator
 }
 func (it *ObjectPageIterator) returnPageNext() (Object, error) {
 	it.cursor++
+	it.count++
 	return it.page[it.cursor-1], nil
 }
 
@@ -73,42 +131,89 @@ func (it *ObjectPageIterator) Close() {
 
 // Next iterator to go to next object or else returns error for done.
 func (it *ObjectPageIterator) Next() (Object, error) {
-	retryCt := 0
-
 	select {
 	case <-it.ctx.Done():
 		// If iterator has been closed
 		return nil, it.ctx.Err()
 	default:
+		if it.q.Limit > 0 && it.count >= it.q.Limit {
+			return nil, iterator.Done
+		}
 		if it.cursor < len(it.page) {
 			return it.returnPageNext()
 		} else if it.cursor > 0 && it.q.Marker == "" {
 			// no new page, lets return
 			return nil, iterator.Done
 		}
+		if it.q.Buffered() {
+			// stores only list in name order, so we have to read every page
+			// before we can return the first object.
+			return it.bufferAllNext()
+		}
 		for {
-			resp, err := it.s.List(it.ctx, it.q)
-			if err == nil {
-				it.page = resp.Objects
-				it.cursor = 0
-				it.q.Marker = resp.NextMarker
-				if len(it.page) == 0 {
-					return nil, iterator.Done
-				}
-				return it.returnPageNext()
-			} else if err == iterator.Done {
-				return nil, err
-			} else if err == context.Canceled || err == context.DeadlineExceeded {
-				// Return to user
+			// the objects left only shrink, so the page size can be narrowed.
+			it.q.PageSize = it.q.limitedPageSize(it.count)
+			resp, err := it.fetchPage()
+			if err != nil {
 				return nil, err
 			}
-			if retryCt < 5 {
-				Backoff(retryCt)
-			} else {
-				return nil, err
+			it.page = resp.Objects
+			it.cursor = 0
+			it.q.Marker = resp.NextMarker
+			if len(it.page) > 0 {
+				return it.returnPageNext()
+			} else if it.q.Marker == "" {
+				return nil, iterator.Done
 			}
-			retryCt++
+			// an empty page, ie all filtered out, isn't necessarily the last
+		}
+	}
+}
+
+// bufferAllNext reads all remaining pages into a single page sorted per the
+// query SortBy and Reverse.
+func (it *ObjectPageIterator) bufferAllNext() (Object, error) {
+	objs := make(Objects, 0)
+	for {
+		resp, err := it.fetchPage()
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, resp.Objects...)
+		it.q.Marker = resp.NextMarker
+		if it.q.Marker == "" {
+			break
+		}
+	}
+	it.page = sortObjects(objs, &it.q)
+	it.cursor = 0
+	if len(it.page) == 0 {
+		return nil, iterator.Done
+	}
+	return it.returnPageNext()
+}
+
+// fetchPage lists the page at current marker, with retries.
+func (it *ObjectPageIterator) fetchPage() (*ObjectsResponse, error) {
+	retryCt := 0
+	for {
+		resp, err := it.s.List(it.ctx, it.q)
+		if err == nil {
+			// not every store's List applies them.
+			resp.Objects = it.q.namesOnly(it.q.listFilter(resp.Objects))
+			return resp, nil
+		} else if err == iterator.Done {
+			return nil, err
+		} else if err == context.Canceled || err == context.DeadlineExceeded || err == ErrRateLimited {
+			// Return to user
+			return nil, err
+		}
+		if retryCt < 5 {
+			Backoff(retryCt)
+		} else {
+			return nil, err
 		}
+		retryCt++
 	}
 }
 
@@ -0,0 +1,83 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestWalk(t *testing.T) {
+	store := newLocalStore(t, "walk")
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		writeObject(t, store, fmt.Sprintf("walk/%d.csv", i), "a,b,c\n")
+	}
+	writeObject(t, store, "other/a.csv", "a,b,c\n")
+
+	// pages of 2 are walked as one listing.
+	var names []string
+	err := cloudstorage.Walk(ctx, store, cloudstorage.Query{Prefix: "walk/", PageSize: 2}, func(o cloudstorage.Object) error {
+		names = append(names, o.Name())
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	sort.Strings(names)
+	assert.Equal(t, []string{"walk/0.csv", "walk/1.csv", "walk/2.csv", "walk/3.csv", "walk/4.csv"}, names)
+
+	names = nil
+	err = cloudstorage.Walk(ctx, store, cloudstorage.Query{Prefix: "walk/"}, func(o cloudstorage.Object) error {
+		names = append(names, o.Name())
+		if len(names) == 2 {
+			return cloudstorage.SkipRemaining
+		}
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(names))
+
+	failed := fmt.Errorf("failed")
+	calls := 0
+	err = cloudstorage.Walk(ctx, store, cloudstorage.Query{Prefix: "walk/"}, func(o cloudstorage.Object) error {
+		calls++
+		return failed
+	})
+	assert.Equal(t, failed, err)
+	assert.Equal(t, 1, calls)
+
+	// an empty prefix calls fn for nothing.
+	err = cloudstorage.Walk(ctx, store, cloudstorage.Query{Prefix: "missing/"}, func(o cloudstorage.Object) error {
+		t.Errorf("unexpected object %s", o.Name())
+		return nil
+	})
+	assert.Equal(t, nil, err)
+}
+
+// walkStore walks its objects itself, counting the walks.
+type walkStore struct {
+	cloudstorage.Store
+	walks int
+}
+
+func (w *walkStore) Walk(ctx context.Context, q cloudstorage.Query, fn func(cloudstorage.Object) error) error {
+	w.walks++
+	return cloudstorage.Walk(ctx, w.Store, q, fn)
+}
+
+func TestStoreWalk(t *testing.T) {
+	store := &walkStore{Store: newLocalStore(t, "storewalk")}
+	writeObject(t, store, "storewalk/a.csv", "a,b,c\n")
+
+	var names []string
+	err := cloudstorage.Walk(context.Background(), store, cloudstorage.Query{Prefix: "storewalk/"}, func(o cloudstorage.Object) error {
+		names = append(names, o.Name())
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"storewalk/a.csv"}, names)
+	assert.Equal(t, 1, store.walks)
+}
@@ -0,0 +1,78 @@
+package cloudstorage
+
+import (
+	"time"
+
+	"github.com/araddon/gou"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// CustomTime returns the user supplied event-time of the object.  Stores that
+// support it natively are used first (ObjectCustomTime) otherwise it is read
+// from the CustomTimeKey metadata.  Zero time is returned if the object
+// doesn't have one.
+func CustomTime(o Object) time.Time {
+	if ct, ok := o.(ObjectCustomTime); ok {
+		if t := ct.CustomTime(); !t.IsZero() {
+			return t
+		}
+	}
+	md := o.MetaData()
+	if md == nil {
+		return time.Time{}
+	}
+	v, ok := md[CustomTimeKey]
+	if !ok || v == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		gou.Warnf("invalid %s=%q on object %s err=%v", CustomTimeKey, v, o.Name(), err)
+		return time.Time{}
+	}
+	return t
+}
+
+// PruneByCustomTime deletes the objects under prefix whose CustomTime is older
+// than olderThan, returning the count of deleted objects.  Objects without a
+// custom time are never deleted.  Objects are visited in custom time order
+// so if an error stops the prune the oldest objects will have been removed.
+func PruneByCustomTime(ctx context.Context, s Store, prefix string, olderThan time.Duration) (int, error) {
+	q := NewQuery(prefix)
+	q.SortBy = SortByCustomTime
+	iter, err := s.Objects(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	cutoff := time.Now().Add(-olderThan)
+	names := make([]string, 0)
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		ct := CustomTime(o)
+		if ct.IsZero() {
+			continue
+		}
+		if !ct.Before(cutoff) {
+			// sorted, so everything after this is newer
+			break
+		}
+		names = append(names, o.Name())
+	}
+
+	deleted := 0
+	for _, name := range names {
+		if err := s.Delete(ctx, name); err != nil && err != ErrObjectNotFound {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
@@ -0,0 +1,77 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/localfs"
+)
+
+func newLocalStore(t *testing.T, name string) cloudstorage.Store {
+	// own dirs, the package tests may run in parallel with TestAll's
+	os.RemoveAll("/tmp/mockcloud_" + name)
+	conf := &cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_" + name,
+		TmpDir:     "/tmp/localcache_" + name,
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	return store
+}
+
+func writeWithCustomTime(t *testing.T, s cloudstorage.Store, name string, ct time.Time) {
+	md := map[string]string{}
+	if !ct.IsZero() {
+		md[cloudstorage.CustomTimeKey] = ct.Format(time.RFC3339Nano)
+	}
+	w, err := s.NewWriterWithContext(context.Background(), name, md)
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte(name))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+}
+
+func TestSortByCustomTime(t *testing.T) {
+	store := newLocalStore(t, "customtime")
+	now := time.Now()
+
+	writeWithCustomTime(t, store, "events/a.csv", now.Add(-time.Hour))
+	writeWithCustomTime(t, store, "events/b.csv", now.Add(-3*time.Hour))
+	writeWithCustomTime(t, store, "events/c.csv", now.Add(-2*time.Hour))
+	writeWithCustomTime(t, store, "events/d.csv", time.Time{})
+
+	obj, err := store.Get(context.Background(), "events/b.csv")
+	assert.Equal(t, nil, err)
+	assert.True(t, cloudstorage.CustomTime(obj).Equal(now.Add(-3*time.Hour)))
+
+	q := cloudstorage.NewQuery("events/")
+	q.SortBy = cloudstorage.SortByCustomTime
+	iter, err := store.Objects(context.Background(), q)
+	assert.Equal(t, nil, err)
+	objs, err := cloudstorage.ObjectsAll(iter)
+	assert.Equal(t, nil, err)
+	names := make([]string, 0, len(objs))
+	for _, o := range objs {
+		names = append(names, o.Name())
+	}
+	assert.Equal(t, []string{"events/d.csv", "events/b.csv", "events/c.csv", "events/a.csv"}, names)
+
+	deleted, err := cloudstorage.PruneByCustomTime(context.Background(), store, "events/", 90*time.Minute)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, deleted)
+
+	q = cloudstorage.NewQuery("events/")
+	q.Sorted()
+	resp, err := store.List(context.Background(), q)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(resp.Objects))
+	assert.Equal(t, "events/a.csv", resp.Objects[0].Name())
+	assert.Equal(t, "events/d.csv", resp.Objects[1].Name())
+}
@@ -0,0 +1,37 @@
+package cloudstorage
+
+import (
+	"io"
+)
+
+// MaxBytesReader caps rc at the ReadOptions.MaxBytes of opts, reads past the
+// cap return ErrObjectTooLarge.  rc is returned as is without a cap.
+func MaxBytesReader(rc io.ReadCloser, opts []ReadOptions) io.ReadCloser {
+	if len(opts) == 0 || opts[0].MaxBytes <= 0 {
+		return rc
+	}
+	return &maxBytesReader{ReadCloser: rc, remaining: opts[0].MaxBytes}
+}
+
+type maxBytesReader struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		// an object of exactly MaxBytes is fine, check there is no more
+		var b [1]byte
+		n, err := io.ReadFull(r.ReadCloser, b[:])
+		if n > 0 {
+			return 0, ErrObjectTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
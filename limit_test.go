@@ -0,0 +1,39 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestReadMaxBytes(t *testing.T) {
+	store := newLocalStore(t, "maxbytes")
+	ctx := context.Background()
+	w, err := store.NewWriterWithContext(ctx, "ten.txt", nil)
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("0123456789"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	for _, tc := range []struct {
+		max  int64
+		want string
+		err  error
+	}{
+		{0, "0123456789", nil},
+		{10, "0123456789", nil},
+		{100, "0123456789", nil},
+		{4, "0123", cloudstorage.ErrObjectTooLarge},
+	} {
+		rc, err := store.NewReaderWithContext(ctx, "ten.txt", cloudstorage.ReadOptions{MaxBytes: tc.max})
+		assert.Equal(t, nil, err)
+		b, err := ioutil.ReadAll(rc)
+		assert.Equal(t, tc.err, err, "max=%d", tc.max)
+		assert.Equal(t, tc.want, string(b), "max=%d", tc.max)
+		assert.Equal(t, nil, rc.Close())
+	}
+}
@@ -0,0 +1,433 @@
+package cloudstorage
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// ListingCacheStore is a Store caching listings on disk, ie for browsing
+// large rarely changing buckets.  Objects and List queries with a MaxStale
+// are served from cached pages of the listing, each page (of the Prefix,
+// Marker, PageSize and StartAfter of the query) cached when listed and
+// listed again once it is older than MaxStale.  The query filters and
+// ordering are applied to the cached pages.  Writes and deletes through the
+// store invalidate the pages of the prefixes they are under, changes made
+// elsewhere are only seen once the pages are stale (or Refresh'd).  Queries
+// with a Delimiter, ShowHidden or IncludeMetadata aren't cached.  Listed
+// objects have the names, sizes, times, checksums and metadata as listed,
+// they are fetched with Get when opened.
+type ListingCacheStore struct {
+	Store
+	dir string
+	mu  sync.Mutex
+	// gen counts the invalidations, a listing started before one isn't
+	// cached as it may not have the invalidating change.
+	gen uint64
+}
+
+// NewListingCacheStore create a store caching listings of s in dir.
+func NewListingCacheStore(s Store, dir string) (*ListingCacheStore, error) {
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return nil, fmt.Errorf("unable to create listing cache dir=%q err=%v", dir, err)
+	}
+	return &ListingCacheStore{Store: s, dir: dir}, nil
+}
+
+// listingEntry is a cached page of the listing of a prefix.
+type listingEntry struct {
+	Prefix     string          `json:"prefix"`
+	Key        string          `json:"key"`
+	Listed     time.Time       `json:"listed"`
+	Objects    []listingObject `json:"objects"`
+	NextMarker string          `json:"next_marker,omitempty"`
+	HasMore    bool            `json:"has_more,omitempty"`
+}
+
+type listingObject struct {
+	Name        string            `json:"name"`
+	Size        int64             `json:"size"`
+	Updated     time.Time         `json:"updated"`
+	ETag        string            `json:"etag,omitempty"`
+	MD5         []byte            `json:"md5,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Source      string            `json:"source"`
+}
+
+// Objects iterates objects, from the cache per the query MaxStale.
+func (c *ListingCacheStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	if !cacheable(q) {
+		return c.Store.Objects(ctx, q)
+	}
+	return NewObjectPageIterator(ctx, c, q), nil
+}
+
+// List a page of objects, from the cache per the query MaxStale.
+func (c *ListingCacheStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	if !cacheable(q) {
+		return c.Store.List(ctx, q)
+	}
+	lq := listingQuery(q)
+	entry, ok := c.load(lq)
+	if !ok || time.Since(entry.Listed) > q.MaxStale {
+		var err error
+		if entry, err = c.refresh(ctx, lq); err != nil {
+			return nil, err
+		}
+	}
+	objs := make(Objects, len(entry.Objects))
+	for i := range entry.Objects {
+		objs[i] = &listingCacheObject{listingObject: entry.Objects[i], c: c}
+	}
+	return &ObjectsResponse{Objects: q.ApplyFilters(objs), NextMarker: entry.NextMarker, HasMore: entry.HasMore}, nil
+}
+
+// Refresh lists prefix and caches its pages, regardless of the age of its
+// cached pages.
+func (c *ListingCacheStore) Refresh(ctx context.Context, prefix string) error {
+	c.invalidatePrefix(prefix)
+	lq := NewQuery(prefix)
+	for {
+		entry, err := c.refresh(ctx, lq)
+		if err != nil {
+			return err
+		}
+		if !entry.HasMore {
+			return nil
+		}
+		lq.Marker = entry.NextMarker
+	}
+}
+
+// NewWriter to an object, invalidating the cached listings it is in on Close.
+func (c *ListingCacheStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return c.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to an object, invalidating the cached listings it is in
+// on Close.
+func (c *ListingCacheStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	wc, err := c.Store.NewWriterWithContext(ctx, name, metadata, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingWriter{wc, c, name}, nil
+}
+
+// NewObject creates an object, invalidating the cached listings it is in when
+// it is Sync'd, Closed or Deleted.
+func (c *ListingCacheStore) NewObject(name string) (Object, error) {
+	o, err := c.Store.NewObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingObject{o, c}, nil
+}
+
+// Delete an object, invalidating the cached listings it is in.
+func (c *ListingCacheStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	err := c.Store.Delete(ctx, name, opts...)
+	c.invalidate(name)
+	return err
+}
+
+func (c *ListingCacheStore) String() string {
+	return fmt.Sprintf("listingcache(%s)", c.Store)
+}
+
+// cacheable is true if q can be served from a cached listing of its prefix.
+func cacheable(q Query) bool {
+	return q.MaxStale > 0 && q.Delimiter == "" && !q.ShowHidden && !q.IncludeMetadata
+}
+
+// listingQuery is the query of the page of the store listing of q, without
+// the filters and ordering applied to it.
+func listingQuery(q Query) Query {
+	return Query{Prefix: q.Prefix, Marker: q.Marker, PageSize: q.PageSize, StartAfter: q.StartAfter}
+}
+
+// entryKey identifies the page of listing query lq.
+func entryKey(lq Query) string {
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%s", lq.Prefix, lq.Marker, lq.PageSize, lq.StartAfter)
+}
+
+// refresh lists the page of listing query lq and caches it, unless the
+// cache was invalidated while listing.
+func (c *ListingCacheStore) refresh(ctx context.Context, lq Query) (*listingEntry, error) {
+	c.mu.Lock()
+	gen := c.gen
+	c.mu.Unlock()
+
+	resp, err := c.Store.List(ctx, lq)
+	if err != nil {
+		return nil, err
+	}
+	entry := &listingEntry{
+		Prefix:     lq.Prefix,
+		Key:        entryKey(lq),
+		Listed:     time.Now(),
+		NextMarker: resp.NextMarker,
+		HasMore:    resp.HasMore,
+	}
+	for _, o := range resp.Objects {
+		entry.Objects = append(entry.Objects, listingObject{
+			Name:        o.Name(),
+			Size:        o.Size(),
+			Updated:     o.Updated(),
+			ETag:        o.ETag(),
+			MD5:         o.MD5(),
+			ContentType: o.ContentType(),
+			Metadata:    o.MetaData(),
+			Source:      o.StorageSource(),
+		})
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return entry, nil
+	}
+	if err := ioutil.WriteFile(c.entryPath(lq), b, 0664); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (c *ListingCacheStore) load(lq Query) (*listingEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := ioutil.ReadFile(c.entryPath(lq))
+	if err != nil {
+		return nil, false
+	}
+	entry := &listingEntry{}
+	if err := json.Unmarshal(b, entry); err != nil || entry.Key != entryKey(lq) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// invalidate removes the cached pages of the prefixes of name.
+func (c *ListingCacheStore) invalidate(name string) {
+	c.remove(func(prefix string) bool { return strings.HasPrefix(name, prefix) })
+}
+
+// invalidatePrefix removes the cached pages of prefix.
+func (c *ListingCacheStore) invalidatePrefix(prefix string) {
+	c.remove(func(p string) bool { return p == prefix })
+}
+
+func (c *ListingCacheStore) remove(match func(prefix string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range files {
+		i := strings.Index(fi.Name(), ",")
+		if i < 0 || !strings.HasSuffix(fi.Name(), ".listing") {
+			continue
+		}
+		prefix, err := url.QueryUnescape(fi.Name()[:i])
+		if err != nil {
+			continue
+		}
+		if match(prefix) {
+			os.Remove(filepath.Join(c.dir, fi.Name()))
+		}
+	}
+}
+
+// entryPath of the page of lq, the escaped prefix (which has no ",") and a
+// hash of the page.
+func (c *ListingCacheStore) entryPath(lq Query) string {
+	sum := sha1.Sum([]byte(entryKey(lq)))
+	return filepath.Join(c.dir, fmt.Sprintf("%s,%x.listing", url.QueryEscape(lq.Prefix), sum[:8]))
+}
+
+type listingIterator struct {
+	objs   Objects
+	cursor int
+}
+
+func (it *listingIterator) Next() (Object, error) {
+	if it.cursor >= len(it.objs) {
+		return nil, iterator.Done
+	}
+	it.cursor++
+	return it.objs[it.cursor-1], nil
+}
+
+func (it *listingIterator) Close() {}
+
+// listingCacheObject is an object of a cached listing, it is fetched from the
+// store when opened.
+type listingCacheObject struct {
+	listingObject
+	c   *ListingCacheStore
+	obj Object
+}
+
+func (o *listingCacheObject) Name() string          { return o.listingObject.Name }
+func (o *listingCacheObject) String() string        { return o.listingObject.Name }
+func (o *listingCacheObject) Updated() time.Time    { return o.listingObject.Updated }
+func (o *listingCacheObject) ETag() string          { return o.listingObject.ETag }
+func (o *listingCacheObject) Size() int64           { return o.listingObject.Size }
+func (o *listingCacheObject) ContentType() string   { return o.listingObject.ContentType }
+func (o *listingCacheObject) MD5() []byte           { return o.listingObject.MD5 }
+func (o *listingCacheObject) StorageSource() string { return o.Source }
+
+func (o *listingCacheObject) MetaData() map[string]string {
+	if o.obj != nil {
+		return o.obj.MetaData()
+	}
+	return o.Metadata
+}
+
+func (o *listingCacheObject) SetMetaData(meta map[string]string) {
+	o.Metadata = meta
+	if o.obj != nil {
+		o.obj.SetMetaData(meta)
+	}
+}
+
+func (o *listingCacheObject) Open(accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+func (o *listingCacheObject) OpenWithContext(ctx context.Context, accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return OpenWithContext(ctx, obj, accesslevel, opts...)
+}
+
+func (o *listingCacheObject) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	obj, err := o.object(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return OpenRange(ctx, obj, start, length)
+}
+
+// object is the store's object of the listing, fetched on first use.
+func (o *listingCacheObject) object(ctx context.Context) (Object, error) {
+	if o.obj == nil {
+		obj, err := o.c.Get(ctx, o.listingObject.Name)
+		if err != nil {
+			return nil, err
+		}
+		o.obj = &invalidatingObject{obj, o.c}
+	}
+	return o.obj, nil
+}
+
+func (o *listingCacheObject) Release() error {
+	if o.obj == nil {
+		return nil
+	}
+	return o.obj.Release()
+}
+
+func (o *listingCacheObject) Read(p []byte) (int, error) {
+	if o.obj == nil {
+		return 0, fmt.Errorf("object %q is not opened", o.listingObject.Name)
+	}
+	return o.obj.Read(p)
+}
+
+func (o *listingCacheObject) Write(p []byte) (int, error) {
+	if o.obj == nil {
+		return 0, fmt.Errorf("object %q is not opened", o.listingObject.Name)
+	}
+	return o.obj.Write(p)
+}
+
+func (o *listingCacheObject) Sync() error {
+	if o.obj == nil {
+		return fmt.Errorf("object %q is not opened", o.listingObject.Name)
+	}
+	return o.obj.Sync()
+}
+
+func (o *listingCacheObject) Close() error {
+	if o.obj == nil {
+		return nil
+	}
+	return o.obj.Close()
+}
+
+func (o *listingCacheObject) File() *os.File {
+	if o.obj == nil {
+		return nil
+	}
+	return o.obj.File()
+}
+
+func (o *listingCacheObject) Delete() error {
+	return o.c.Delete(context.Background(), o.listingObject.Name)
+}
+
+// invalidatingWriter invalidates the cached listings of name once written.
+type invalidatingWriter struct {
+	io.WriteCloser
+	c    *ListingCacheStore
+	name string
+}
+
+func (w *invalidatingWriter) Close() error {
+	defer w.c.invalidate(w.name)
+	return w.WriteCloser.Close()
+}
+
+func (w *invalidatingWriter) Resumed() int64 {
+	return Resumed(w.WriteCloser)
+}
+
+// invalidatingObject invalidates the cached listings of the object when it
+// is written back or deleted.
+type invalidatingObject struct {
+	Object
+	c *ListingCacheStore
+}
+
+func (o *invalidatingObject) Sync() error {
+	defer o.c.invalidate(o.Name())
+	return o.Object.Sync()
+}
+
+func (o *invalidatingObject) Close() error {
+	defer o.c.invalidate(o.Name())
+	return o.Object.Close()
+}
+
+func (o *invalidatingObject) Delete() error {
+	defer o.c.invalidate(o.Name())
+	return o.Object.Delete()
+}
+
+func (o *invalidatingObject) OpenWithContext(ctx context.Context, accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	return OpenWithContext(ctx, o.Object, accesslevel, opts...)
+}
+
+func (o *invalidatingObject) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	return OpenRange(ctx, o.Object, start, length)
+}
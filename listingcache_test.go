@@ -0,0 +1,156 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func listNames(t *testing.T, s cloudstorage.Store, q cloudstorage.Query) []string {
+	iter, err := s.Objects(context.Background(), q)
+	assert.Equal(t, nil, err)
+	defer iter.Close()
+	names := []string{}
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		assert.Equal(t, nil, err)
+		names = append(names, o.Name())
+	}
+	return names
+}
+
+func TestListingCacheStore(t *testing.T) {
+	backing := newLocalStore(t, "listingcache")
+	os.RemoveAll("/tmp/listingcache_entries")
+	store, err := cloudstorage.NewListingCacheStore(backing, "/tmp/listingcache_entries")
+	assert.Equal(t, nil, err)
+	ctx := context.Background()
+
+	writeObject(t, store, "cached/a.txt", "a")
+	writeObject(t, store, "cached/b.txt", "b")
+
+	q := cloudstorage.NewQuery("cached/")
+	q.MaxStale = time.Hour
+	assert.Equal(t, []string{"cached/a.txt", "cached/b.txt"}, listNames(t, store, q))
+
+	// changes behind the cache aren't seen until refreshed
+	writeObject(t, backing, "cached/c.txt", "c")
+	assert.Equal(t, []string{"cached/a.txt", "cached/b.txt"}, listNames(t, store, q))
+	assert.Equal(t, nil, store.Refresh(ctx, "cached/"))
+	assert.Equal(t, []string{"cached/a.txt", "cached/b.txt", "cached/c.txt"}, listNames(t, store, q))
+
+	// writes and deletes through the store invalidate
+	writeObject(t, store, "cached/d.txt", "d")
+	assert.Equal(t, []string{"cached/a.txt", "cached/b.txt", "cached/c.txt", "cached/d.txt"}, listNames(t, store, q))
+	assert.Equal(t, nil, store.Delete(ctx, "cached/a.txt"))
+	assert.Equal(t, []string{"cached/b.txt", "cached/c.txt", "cached/d.txt"}, listNames(t, store, q))
+
+	q.Reverse, q.Limit = true, 2
+	assert.Equal(t, []string{"cached/d.txt", "cached/c.txt"}, listNames(t, store, q))
+
+	// cached objects are readable and listed with their sizes
+	resp, err := store.List(ctx, q)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), resp.Objects[0].Size())
+	assert.Equal(t, "d", readAll(t, store, resp.Objects[0].Name()))
+	f, err := resp.Objects[0].Open(cloudstorage.ReadOnly)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, nil, f)
+	assert.Equal(t, nil, resp.Objects[0].Close())
+}
+
+func TestListingCachePages(t *testing.T) {
+	backing := newLocalStore(t, "listingcache_pages")
+	os.RemoveAll("/tmp/listingcache_pages_entries")
+	store, err := cloudstorage.NewListingCacheStore(backing, "/tmp/listingcache_pages_entries")
+	assert.Equal(t, nil, err)
+	ctx := context.Background()
+	for _, n := range []string{"a", "b", "c", "d", "e"} {
+		writeObject(t, store, "paged/"+n+".txt", n)
+	}
+
+	// each page is cached on its own, and paging by marker visits them all.
+	q := cloudstorage.NewQuery("paged/")
+	q.MaxStale = time.Hour
+	q.PageSize = 2
+	var names []string
+	for i := 0; i < 5; i++ {
+		resp, err := store.List(ctx, q)
+		assert.Equal(t, nil, err)
+		for _, o := range resp.Objects {
+			names = append(names, o.Name())
+		}
+		if !resp.HasMore {
+			break
+		}
+		q.Marker = resp.NextMarker
+	}
+	assert.Equal(t, []string{"paged/a.txt", "paged/b.txt", "paged/c.txt", "paged/d.txt", "paged/e.txt"}, names)
+
+	q.Marker = ""
+	writeObject(t, backing, "paged/0.txt", "0")
+	resp, err := store.List(ctx, q)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(resp.Objects))
+	assert.Equal(t, "paged/a.txt", resp.Objects[0].Name())
+	// another page size is another page
+	q.PageSize = 3
+	resp, err = store.List(ctx, q)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "paged/0.txt", resp.Objects[0].Name())
+}
+
+// blockingListStore blocks List until release is closed.
+type blockingListStore struct {
+	cloudstorage.Store
+	listing chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingListStore) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.ObjectsResponse, error) {
+	close(s.listing)
+	<-s.release
+	return s.Store.List(ctx, q)
+}
+
+func TestListingCacheInvalidatedRefresh(t *testing.T) {
+	backing := &blockingListStore{
+		Store:   newLocalStore(t, "listingcache_gen"),
+		listing: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	os.RemoveAll("/tmp/listingcache_gen_entries")
+	store, err := cloudstorage.NewListingCacheStore(backing, "/tmp/listingcache_gen_entries")
+	assert.Equal(t, nil, err)
+	ctx := context.Background()
+	q := cloudstorage.NewQuery("gen/")
+	q.MaxStale = time.Hour
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := store.List(ctx, q)
+		assert.Equal(t, nil, err)
+	}()
+	// a write invalidating while the listing is in flight
+	<-backing.listing
+	writeObject(t, backing.Store, "gen/a.txt", "a")
+	assert.Equal(t, nil, store.Delete(ctx, "gen/missing.txt"))
+	close(backing.release)
+	<-done
+
+	// the listing from before the invalidation wasn't cached.
+	backing.listing = make(chan struct{})
+	resp, err := store.List(ctx, q)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(resp.Objects))
+}
@@ -0,0 +1,90 @@
+package cloudstorage
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// ListLevel returns the objects directly under prefix, and the folders (sub
+// prefixes, ending in the store's Separator) directly under it.  Objects
+// inside the folders are not included, so this is one level of a file
+// browser.  Stores implementing StoreListLevel list both in a single
+// delimited listing, for the rest it is a Folders call plus an Objects
+// listing filtered to the level.  A limit above 0 caps the objects and
+// folders together, see LimitLevel.
+func ListLevel(ctx context.Context, s Store, prefix string, limit int) (objects Objects, folders []string, err error) {
+	if ll, ok := s.(StoreListLevel); ok {
+		return ll.ListLevel(ctx, prefix, limit)
+	}
+
+	folders, err = s.Folders(ctx, NewQueryForFolders(prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iter, err := s.Objects(ctx, NewQuery(prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+
+	sep := Separator(s)
+	objects = make(Objects, 0)
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if strings.Contains(strings.TrimPrefix(o.Name(), prefix), sep) {
+			// in a sub-folder
+			continue
+		}
+		objects = append(objects, o)
+	}
+	objects, folders = LimitLevel(objects, folders, limit)
+	return objects, folders, nil
+}
+
+// LimitLevel keeps the first limit names of a level, objects and folders
+// together in name order, as a delimited listing of limit keys would.  A
+// limit of 0 keeps them all.
+func LimitLevel(objects Objects, folders []string, limit int) (Objects, []string) {
+	if limit <= 0 || len(objects)+len(folders) <= limit {
+		return objects, folders
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name() < objects[j].Name() })
+	sort.Strings(folders)
+	i, j := 0, 0
+	for i+j < limit {
+		if j == len(folders) || (i < len(objects) && objects[i].Name() < folders[j]) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return objects[:i], folders[:j]
+}
+
+// GetFolder checks folder name is a folder of s, a prefix with objects under
+// it or a folder marker object, returning its prefix ending in the store's
+// Separator.  Returns ErrObjectNotFound if there are no objects under it.
+// Unlike Get it doesn't matter whether name ends in the separator.
+func GetFolder(ctx context.Context, s Store, name string) (string, error) {
+	sep := Separator(s)
+	prefix := strings.TrimSuffix(name, sep) + sep
+	q := NewQuery(prefix)
+	q.PageSize = 1
+	resp, err := s.List(ctx, q)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Objects) == 0 {
+		return "", ErrObjectNotFound
+	}
+	return prefix, nil
+}
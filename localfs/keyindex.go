@@ -0,0 +1,133 @@
+package localfs
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// keyIndexFile is the name of the on-disk key index kept in the root of
+// the store path.
+const keyIndexFile = ".cloudstorage.keyindex"
+
+// keyIndex records the key exactly as written for each object.  On case
+// preserving but insensitive filesystems (macOS, windows) writing "Foo.csv"
+// over "foo.csv" keeps the original file name on disk, so the name has to
+// come from the index for the store to behave like the (case sensitive)
+// cloud stores.  A nil *keyIndex is a disabled index.
+type keyIndex struct {
+	mu   sync.Mutex
+	file string
+	keys map[string]string // folded key -> key as written
+}
+
+// checkCaseSensitive is caseSensitive, a var so the tests can exercise the
+// index on the case sensitive filesystems they run on.
+var checkCaseSensitive = caseSensitive
+
+// caseSensitive checks if the filesystem at dir treats names differing only
+// by case as different files.
+func caseSensitive(dir string) (bool, error) {
+	f, err := ioutil.TempFile(dir, ".casecheck")
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	_, err = os.Stat(path.Join(path.Dir(f.Name()), strings.ToUpper(path.Base(f.Name()))))
+	return os.IsNotExist(err), nil
+}
+
+func loadKeyIndex(storepath string) (*keyIndex, error) {
+	k := &keyIndex{
+		file: path.Join(storepath, keyIndexFile),
+		keys: make(map[string]string),
+	}
+	if !cloudstorage.Exists(k.file) {
+		return k, nil
+	}
+	b, err := ioutil.ReadFile(k.file)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &k.keys); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// record key as the canonical casing for its file.
+func (k *keyIndex) record(key string) error {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys[strings.ToLower(key)] == key {
+		return nil
+	}
+	k.keys[strings.ToLower(key)] = key
+	return k.save()
+}
+
+func (k *keyIndex) remove(key string) error {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[strings.ToLower(key)]; !ok {
+		return nil
+	}
+	delete(k.keys, strings.ToLower(key))
+	return k.save()
+}
+
+// canonical returns the key as written for a filesystem name, or the name
+// itself if it was never written through the store.
+func (k *keyIndex) canonical(name string) string {
+	if k == nil {
+		return name
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[strings.ToLower(name)]; ok {
+		return key
+	}
+	return name
+}
+
+// matches is false if name refers to an indexed object written with
+// different casing.
+func (k *keyIndex) matches(name string) bool {
+	return k.canonical(name) == name
+}
+
+// indexedWriter records key in the index once the write is committed, so a
+// failed write doesn't change the casing of the key.
+type indexedWriter struct {
+	io.WriteCloser
+	index *keyIndex
+	key   string
+}
+
+func (w *indexedWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.index.record(w.key)
+}
+
+func (k *keyIndex) save() error {
+	b, err := json.Marshal(k.keys)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(k.file, b, 0664)
+}
@@ -0,0 +1,65 @@
+package localfs
+
+import (
+	"context"
+	"crypto/md5"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// newIndexedStore is a store with the key index, as on a case insensitive
+// filesystem.
+func newIndexedStore(t *testing.T) *LocalStore {
+	os.RemoveAll("/tmp/mockcloud_keyindex_int")
+	store, err := NewLocalStore("/tmp/mockcloud_keyindex_int", "/tmp/localcache_keyindex_int")
+	assert.Equal(t, nil, err)
+	checkCaseSensitive = func(string) (bool, error) { return false, nil }
+	defer func() { checkCaseSensitive = caseSensitive }()
+	assert.Equal(t, nil, store.EnableKeyIndex())
+	assert.NotEqual(t, nil, store.index)
+	return store
+}
+
+func write(t *testing.T, store *LocalStore, name string, opts ...cloudstorage.Opts) error {
+	w, err := store.NewWriterWithContext(context.Background(), name, nil, opts...)
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, err)
+	return w.Close()
+}
+
+func TestKeyIndexCasing(t *testing.T) {
+	store := newIndexedStore(t)
+	ctx := context.Background()
+	assert.Equal(t, nil, write(t, store, "Folder/MixedCase.CSV"))
+
+	// a case preserving filesystem keeps the name the file was first
+	// created with, ie of an earlier write as "Folder/mixedcase.csv".
+	dir := path.Join(store.storepath, "Folder")
+	assert.Equal(t, nil, os.Rename(path.Join(dir, "MixedCase.CSV"), path.Join(dir, "mixedcase.csv")))
+	assert.Equal(t, nil, os.Rename(path.Join(dir, "MixedCase.CSV.metadata"), path.Join(dir, "mixedcase.csv.metadata")))
+
+	resp, err := store.List(ctx, cloudstorage.NewQueryAll())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(resp.Objects))
+	assert.Equal(t, "Folder/MixedCase.CSV", resp.Objects[0].Name())
+	// the file exists by that name, but its key is the written casing.
+	_, err = store.Get(ctx, "Folder/mixedcase.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+}
+
+func TestKeyIndexFailedWrite(t *testing.T) {
+	store := newIndexedStore(t)
+	sum := md5.Sum([]byte("other"))
+	err := write(t, store, "Failed.csv", cloudstorage.Opts{ContentMD5: sum[:]})
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, err)
+	assert.Equal(t, "failed.csv", store.index.canonical("failed.csv"))
+
+	assert.Equal(t, nil, write(t, store, "Written.csv"))
+	assert.Equal(t, "Written.csv", store.index.canonical("written.csv"))
+}
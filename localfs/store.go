@@ -1,6 +1,7 @@
 package localfs
 
 import (
+	"crypto/md5"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,7 +9,9 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/araddon/gou"
@@ -27,12 +30,29 @@ func localProvider(conf *cloudstorage.Config) (cloudstorage.Store, error) {
 	if err != nil {
 		return nil, err
 	}
+	store.Separator = conf.KeySeparator()
+	if mode := conf.Settings.String(ConfKeyFileMode); mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("localfs: invalid %s=%q err=%v", ConfKeyFileMode, mode, err)
+		}
+		store.FileMode = os.FileMode(perm)
+	}
+	if conf.Settings.Bool(ConfKeyCaseIndex) {
+		if err := store.EnableKeyIndex(); err != nil {
+			return nil, err
+		}
+	}
 	return store, nil
 }
 
 var (
 	// Ensure Our LocalStore implement CloudStorage interfaces
-	_ cloudstorage.StoreReader = (*LocalStore)(nil)
+	_ cloudstorage.StoreReader       = (*LocalStore)(nil)
+	_ cloudstorage.StoreMove         = (*LocalStore)(nil)
+	_ cloudstorage.StoreListLevel    = (*LocalStore)(nil)
+	_ cloudstorage.ObjectOpenContext = (*object)(nil)
+	_ cloudstorage.ObjectRange       = (*object)(nil)
 )
 
 const (
@@ -41,6 +61,17 @@ const (
 
 	// StoreType name of our Local Storage provider = "localfs"
 	StoreType = "localfs"
+
+	// ConfKeyCaseIndex config key name of the flag to keep an on-disk index of
+	// object keys as written, so names keep the callers exact casing on case
+	// insensitive filesystems.  See EnableKeyIndex.
+	ConfKeyCaseIndex = "case_index"
+	// ConfKeyFileMode config key name of the default permissions of written
+	// files, in octal ie "0640".  See LocalStore.FileMode.
+	ConfKeyFileMode = "file_mode"
+
+	// DefaultFileMode is the default permissions of written files.
+	DefaultFileMode os.FileMode = 0665
 )
 
 // LocalStore is client to local-filesystem store.
@@ -49,6 +80,13 @@ type LocalStore struct {
 	pathCleaned string // cleaned removing  ./ = "tables"
 	cachepath   string
 	Id          string
+	index       *keyIndex
+	// FileMode of written files, less the umask, defaults to DefaultFileMode.
+	// Opts.FileMode overrides it per write.
+	FileMode os.FileMode
+	// Separator of the folders in object names, which are directories on
+	// disk, defaults to "/".  See cloudstorage.Config.KeySeparator.
+	Separator string
 }
 
 // NewLocalStore create local store from storage path on local filesystem, and cachepath.
@@ -85,6 +123,27 @@ func NewLocalStore(storepath, cachepath string) (*LocalStore, error) {
 	}, nil
 }
 
+// EnableKeyIndex makes the store keep an index of object keys exactly as
+// written.  Listing and Get then always use the casing of the write, and Get
+// of an object with different casing is ErrObjectNotFound, the same as the
+// case sensitive cloud stores regardless of the filesystem.  This is a no-op
+// on case sensitive filesystems.
+func (l *LocalStore) EnableKeyIndex() error {
+	sensitive, err := checkCaseSensitive(l.storepath)
+	if err != nil {
+		return fmt.Errorf("localfs: could not check filesystem case sensitivity. path=%s err=%v", l.storepath, err)
+	}
+	if sensitive {
+		return nil
+	}
+	index, err := loadKeyIndex(l.storepath)
+	if err != nil {
+		return fmt.Errorf("localfs: could not load key index. path=%s err=%v", l.storepath, err)
+	}
+	l.index = index
+	return nil
+}
+
 // Type is store type = "localfs"
 func (l *LocalStore) Type() string {
 	return StoreType
@@ -93,6 +152,23 @@ func (l *LocalStore) Client() interface{} {
 	return l
 }
 
+// ResolveKey is the path of the file of object o.
+func (l *LocalStore) ResolveKey(o string) string {
+	return path.Join(l.storepath, cloudstorage.KeyToPath(o, l.Separator))
+}
+
+// UpdateMetadata replaces the metadata file of object o.
+func (l *LocalStore) UpdateMetadata(ctx context.Context, o string, metadata map[string]string) error {
+	fo := l.ResolveKey(o)
+	if !cloudstorage.Exists(fo) || !l.index.matches(o) {
+		return cloudstorage.ErrObjectNotFound
+	}
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	return writemeta(fo+".metadata", metadata)
+}
+
 // NewObject create new object of given name.
 func (l *LocalStore) NewObject(objectname string) (cloudstorage.Object, error) {
 	obj, err := l.Get(context.Background(), objectname)
@@ -102,7 +178,7 @@ func (l *LocalStore) NewObject(objectname string) (cloudstorage.Object, error) {
 		return nil, cloudstorage.ErrObjectExists
 	}
 
-	of := path.Join(l.storepath, objectname)
+	of := l.ResolveKey(objectname)
 	err = cloudstorage.EnsureDir(of)
 	if err != nil {
 		return nil, err
@@ -114,6 +190,8 @@ func (l *LocalStore) NewObject(objectname string) (cloudstorage.Object, error) {
 		name:      objectname,
 		storepath: of,
 		cachepath: cf,
+		index:     l.index,
+		mode:      l.fileMode(),
 	}, nil
 }
 
@@ -124,7 +202,7 @@ func (l *LocalStore) List(ctx context.Context, query cloudstorage.Query) (*cloud
 	objects := make(map[string]*object)
 	metadatas := make(map[string]map[string]string)
 
-	spath := path.Join(l.storepath, query.Prefix)
+	spath := l.ResolveKey(query.Prefix)
 	if !cloudstorage.Exists(spath) {
 		return resp, nil
 	}
@@ -136,9 +214,12 @@ func (l *LocalStore) List(ctx context.Context, query cloudstorage.Query) (*cloud
 
 		obj := strings.Replace(fo, l.pathCleaned, "", 1)
 
-		if f.IsDir() {
+		if f.IsDir() || f.Name() == keyIndexFile || filepath.Ext(f.Name()) == partialExt {
 			return nil
 		} else if filepath.Ext(f.Name()) == ".metadata" {
+			if query.NamesOnly {
+				return nil
+			}
 			b, err := ioutil.ReadFile(fo)
 			if err != nil {
 				return err
@@ -153,12 +234,16 @@ func (l *LocalStore) List(ctx context.Context, query cloudstorage.Query) (*cloud
 			metadatas[mdkey] = md
 		} else {
 
-			oname := strings.TrimPrefix(obj, "/")
+			oname := l.index.canonical(cloudstorage.PathToKey(strings.TrimPrefix(obj, "/"), l.Separator))
 			objects[obj] = &object{
 				name:      oname,
 				updated:   f.ModTime(),
+				etag:      fileETag(f),
+				size:      f.Size(),
 				storepath: fo,
 				cachepath: cloudstorage.CachePathObj(l.cachepath, oname, l.Id),
+				index:     l.index,
+				mode:      l.fileMode(),
 			}
 		}
 		return err
@@ -168,13 +253,15 @@ func (l *LocalStore) List(ctx context.Context, query cloudstorage.Query) (*cloud
 		return nil, fmt.Errorf("localfile: error occurred listing files. searchpath=%v err=%v", spath, err)
 	}
 
+	all := make(cloudstorage.Objects, 0, len(objects))
 	for objname, obj := range objects {
 		if md, ok := metadatas[objname]; ok {
 			obj.metadata = md
 		}
-		resp.Objects = append(resp.Objects, obj)
+		all = append(all, obj)
 	}
 
+	resp = cloudstorage.PageObjects(all, query)
 	resp.Objects = query.ApplyFilters(resp.Objects)
 
 	return resp, nil
@@ -183,16 +270,21 @@ func (l *LocalStore) List(ctx context.Context, query cloudstorage.Query) (*cloud
 // Objects returns an iterator over the objects in the local folder that match the Query q.
 // If q is nil, no filtering is done.
 func (l *LocalStore) Objects(ctx context.Context, csq cloudstorage.Query) (cloudstorage.ObjectIterator, error) {
+	// the iterator has the whole listing as a single page.
+	csq.PageSize = 0
 	resp, err := l.List(ctx, csq)
 	if err != nil {
 		return nil, err
 	}
+	if csq.Limit > 0 && len(resp.Objects) > csq.Limit {
+		resp.Objects = resp.Objects[:csq.Limit]
+	}
 	return &objectIterator{objects: resp.Objects}, nil
 }
 
 // Folders list of folders for given path query.
 func (l *LocalStore) Folders(ctx context.Context, csq cloudstorage.Query) ([]string, error) {
-	spath := path.Join(l.storepath, csq.Prefix)
+	spath := l.ResolveKey(csq.Prefix)
 	if !cloudstorage.Exists(spath) {
 		return nil, fmt.Errorf("That folder %q does not exist", spath)
 	}
@@ -205,85 +297,416 @@ func (l *LocalStore) Folders(ctx context.Context, csq cloudstorage.Query) ([]str
 	folders := make([]string, 0)
 	files, _ := ioutil.ReadDir(spath)
 	for _, f := range files {
-		if f.IsDir() {
-			folders = append(folders, fmt.Sprintf("%s/", path.Join(csq.Prefix, f.Name())))
+		if !f.IsDir() {
+			continue
+		}
+		if csq.ImplicitFolders && !hasObjects(path.Join(spath, f.Name())) {
+			continue
 		}
+		folder := path.Join(cloudstorage.KeyToPath(csq.Prefix, l.Separator), f.Name())
+		folders = append(folders, cloudstorage.PathToKey(folder, l.Separator)+l.KeySeparator())
 	}
 	return folders, nil
 }
 
+// ListLevel for cloudstorage.StoreListLevel, reading only the directory of
+// prefix rather than walking the tree under it.  Folders are every
+// directory, as for Folders.
+func (l *LocalStore) ListLevel(ctx context.Context, prefix string, limit int) (cloudstorage.Objects, []string, error) {
+	spath := l.ResolveKey(prefix)
+	if !cloudstorage.Exists(spath) {
+		return nil, nil, fmt.Errorf("That folder %q does not exist", spath)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	default:
+	}
+
+	files, err := ioutil.ReadDir(spath)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects := make(cloudstorage.Objects, 0)
+	folders := make([]string, 0)
+	dir := cloudstorage.KeyToPath(prefix, l.Separator)
+	for _, f := range files {
+		key := cloudstorage.PathToKey(path.Join(dir, f.Name()), l.Separator)
+		if f.IsDir() {
+			folders = append(folders, key+l.KeySeparator())
+			continue
+		} else if f.Name() == keyIndexFile || filepath.Ext(f.Name()) == ".metadata" || filepath.Ext(f.Name()) == partialExt {
+			continue
+		}
+		fo := path.Join(spath, f.Name())
+		metadata, err := readmeta(fo + ".metadata")
+		if err != nil {
+			return nil, nil, err
+		}
+		oname := l.index.canonical(key)
+		objects = append(objects, &object{
+			name:      oname,
+			updated:   f.ModTime(),
+			etag:      fileETag(f),
+			size:      f.Size(),
+			metadata:  metadata,
+			storepath: fo,
+			cachepath: cloudstorage.CachePathObj(l.cachepath, oname, l.Id),
+			index:     l.index,
+			mode:      l.fileMode(),
+		})
+	}
+	objects, folders = cloudstorage.LimitLevel(objects, folders, limit)
+	return objects, folders, nil
+}
+
+// partialExt is the extension of the files objects are written to before
+// being renamed into place, they aren't objects.
+const partialExt = ".partial"
+
+// partialWriter writes an object to a partial file beside it, which Close
+// renames to the object so a failed write leaves the previous object.
+type partialWriter struct {
+	io.WriteCloser
+	partial   string
+	fo        string
+	metadata  map[string]string
+	exclusive bool
+	ifMatch   string
+}
+
+func (w *partialWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		os.Remove(w.partial)
+		return err
+	}
+	defer lockPath(w.fo)()
+	if w.ifMatch != "" && !etagMatches(w.fo, w.ifMatch) {
+		os.Remove(w.partial)
+		return cloudstorage.ErrPreconditionFailed
+	}
+	if w.exclusive {
+		// unlike a rename the link fails if the object was created since
+		// the writer was opened.
+		err := os.Link(w.partial, w.fo)
+		os.Remove(w.partial)
+		if os.IsExist(err) {
+			return cloudstorage.ErrPreconditionFailed
+		} else if err != nil {
+			return err
+		}
+	} else if err := os.Rename(w.partial, w.fo); err != nil {
+		os.Remove(w.partial)
+		return err
+	}
+	return writemeta(w.fo+".metadata", w.metadata)
+}
+
+func (w *partialWriter) abort() error {
+	w.WriteCloser.Close()
+	return os.Remove(w.partial)
+}
+
+// KeySeparator for cloudstorage.StoreSeparator, the separator of the folders
+// in object names.
+func (l *LocalStore) KeySeparator() string {
+	if l.Separator == "" {
+		return cloudstorage.DefaultSeparator
+	}
+	return l.Separator
+}
+
+// fileMode of created files, FileMode or else DefaultFileMode.
+func (l *LocalStore) fileMode() os.FileMode {
+	if l.FileMode == 0 {
+		return DefaultFileMode
+	}
+	return l.FileMode
+}
+
+// hasObjects is true if there is an object file anywhere under dir.
+func hasObjects(dir string) bool {
+	found := false
+	filepath.Walk(dir, func(fo string, f os.FileInfo, err error) error {
+		if err != nil || found {
+			return filepath.SkipDir
+		}
+		if !f.IsDir() && f.Name() != keyIndexFile && filepath.Ext(f.Name()) != ".metadata" && filepath.Ext(f.Name()) != partialExt {
+			found = true
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return found
+}
+
 // NewReader create local file-system store reader.
 func (l *LocalStore) NewReader(o string) (io.ReadCloser, error) {
 	return l.NewReaderWithContext(context.Background(), o)
 }
-func (l *LocalStore) NewReaderWithContext(ctx context.Context, o string) (io.ReadCloser, error) {
-	fo := path.Join(l.storepath, o)
-	if !cloudstorage.Exists(fo) {
+func (l *LocalStore) NewReaderWithContext(ctx context.Context, o string, opts ...cloudstorage.ReadOptions) (io.ReadCloser, error) {
+	fo := l.ResolveKey(o)
+	if !cloudstorage.Exists(fo) || !l.index.matches(o) {
 		return nil, cloudstorage.ErrObjectNotFound
 	}
-	return csbufio.OpenReader(fo)
+	rc, err := csbufio.OpenReader(fo)
+	if err != nil {
+		return nil, err
+	}
+	rc = cloudstorage.NewContextReadCloser(ctx, rc)
+	if len(opts) > 0 && opts[0].VerifyChecksum {
+		md, err := readmeta(fo + ".metadata")
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		if rc, err = cloudstorage.NewMetaDataChecksumReader(rc, md); err != nil {
+			return nil, err
+		}
+	}
+	return cloudstorage.MaxBytesReader(rc, opts), nil
 }
 
 func (l *LocalStore) NewWriter(o string, metadata map[string]string) (io.WriteCloser, error) {
 	return l.NewWriterWithContext(context.Background(), o, metadata)
 }
 func (l *LocalStore) NewWriterWithContext(ctx context.Context, o string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+	if len(opts) > 0 && opts[0].IfGenerationMatch != 0 {
+		return nil, cloudstorage.ErrConditionNotSupported
+	}
+	if len(opts) > 0 && opts[0].PublicRead {
+		return nil, cloudstorage.ErrFeatureNotSupported
+	}
+	if cloudstorage.SkipsIfIdentical(opts) {
+		return cloudstorage.NewSkipIfIdenticalWriter(ctx, l, o, metadata, opts)
+	}
+	if cloudstorage.VerifiesOnClose(opts) {
+		return cloudstorage.NewVerifyOnCloseWriter(ctx, l, o, metadata, opts)
+	}
+	if cloudstorage.DetectsContentType(metadata, opts) {
+		return cloudstorage.NewContentTypeWriter(o, metadata, opts, func(md map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+			return l.NewWriterWithContext(ctx, o, md, opts...)
+		}), nil
+	}
 
-	fo := path.Join(l.storepath, o)
+	if strings.HasSuffix(o, l.KeySeparator()) {
+		return nil, fmt.Errorf("localfs can't store folder marker objects name=%q", o)
+	}
+	fo := l.ResolveKey(o)
+	ifMatch := ""
+	if len(opts) > 0 && opts[0].IfMatch != "" {
+		// the filesystem has no conditional writes, the file is checked as
+		// it is opened, to fail early, and again as it is renamed into place.
+		ifMatch = opts[0].IfMatch
+		if !etagMatches(fo, ifMatch) {
+			return nil, cloudstorage.ErrPreconditionFailed
+		}
+	}
+
+	exclusive := len(opts) > 0 && opts[0].IfNotExists
+	if exclusive && cloudstorage.Exists(fo) {
+		return nil, cloudstorage.ErrPreconditionFailed
+	}
 
 	err := cloudstorage.EnsureDir(fo)
 	if err != nil {
 		return nil, err
 	}
 
-	if metadata != nil && len(metadata) > 0 {
+	if metadata == nil {
 		metadata = make(map[string]string)
 	}
 
-	fmd := fo + ".metadata"
-	if err := writemeta(fmd, metadata); err != nil {
+	mode := l.fileMode()
+	partial := fo + "." + uuid.NewUUID().String() + partialExt
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
 		return nil, err
 	}
+	if len(opts) > 0 {
+		if err := chown(f, opts[0]); err != nil {
+			f.Close()
+			os.Remove(partial)
+			return nil, err
+		}
+	}
+	pw := &partialWriter{
+		WriteCloser: csbufio.NewWriter(f),
+		partial:     partial,
+		fo:          fo,
+		metadata:    metadata,
+		exclusive:   exclusive,
+		ifMatch:     ifMatch,
+	}
+	var wc io.WriteCloser = pw
+	if len(opts) > 0 && opts[0].ContentMD5 != nil {
+		// a mismatch removes the partial file, keeping the previous object.
+		wc = cloudstorage.NewChecksumWriter(pw, md5.New(), opts[0].ContentMD5, pw.abort)
+	}
+	if l.index != nil {
+		wc = &indexedWriter{WriteCloser: wc, index: l.index, key: o}
+	}
+	return wc, nil
+}
 
-	flag := os.O_RDWR | os.O_CREATE | os.O_TRUNC
-	if len(opts) > 0 && opts[0].IfNotExists {
-		flag = flag | os.O_EXCL
+// chown sets the permissions and owner of f from opts.
+func chown(f *os.File, opts cloudstorage.Opts) error {
+	if opts.FileMode != 0 {
+		// unlike create an explicit mode isn't masked
+		if err := f.Chmod(opts.FileMode); err != nil {
+			return err
+		}
 	}
-	f, err := os.OpenFile(fo, flag, 0665)
-	if err != nil {
-		return nil, err
+	if opts.UID == 0 && opts.GID == 0 {
+		return nil
+	}
+	uid, gid := opts.UID, opts.GID
+	if uid == 0 {
+		uid = -1
+	}
+	if gid == 0 {
+		gid = -1
 	}
-	return csbufio.NewWriter(f), nil
+	return f.Chown(uid, gid)
 }
 
 func (l *LocalStore) Get(ctx context.Context, o string) (cloudstorage.Object, error) {
-	fo := path.Join(l.storepath, o)
+	if strings.HasSuffix(o, l.KeySeparator()) {
+		// files can't have folder names, so there are no folder markers.
+		return nil, cloudstorage.ErrObjectNotFound
+	}
+	fo := l.ResolveKey(o)
 
-	if !cloudstorage.Exists(fo) {
+	if !cloudstorage.Exists(fo) || !l.index.matches(o) {
 		return nil, cloudstorage.ErrObjectNotFound
 	}
 	var updated time.Time
+	var etag string
+	var size int64
 	if stat, err := os.Stat(fo); err == nil {
+		if stat.IsDir() {
+			// directories are prefixes, not objects.
+			return nil, cloudstorage.ErrObjectNotFound
+		}
 		updated = stat.ModTime()
+		etag = fileETag(stat)
+		size = stat.Size()
+	}
+
+	metadata, err := readmeta(fo + ".metadata")
+	if err != nil {
+		return nil, err
 	}
 
 	return &object{
 		name:      o,
 		updated:   updated,
+		etag:      etag,
+		size:      size,
+		metadata:  metadata,
 		storepath: fo,
 		cachepath: cloudstorage.CachePathObj(l.cachepath, o, l.Id),
+		index:     l.index,
+		mode:      l.fileMode(),
 	}, nil
 }
 
+// Move renames the file of src (and its metadata file) to des, atomically
+// on the same filesystem.
+func (l *LocalStore) Move(ctx context.Context, src, des cloudstorage.Object) error {
+	sf := l.ResolveKey(src.Name())
+	if !cloudstorage.Exists(sf) || !l.index.matches(src.Name()) {
+		return cloudstorage.ErrObjectNotFound
+	}
+	df := l.ResolveKey(des.Name())
+	if err := cloudstorage.EnsureDir(df); err != nil {
+		return err
+	}
+	if err := os.Rename(sf, df); err != nil {
+		return err
+	}
+	if cloudstorage.Exists(sf + ".metadata") {
+		if err := os.Rename(sf+".metadata", df+".metadata"); err != nil {
+			return err
+		}
+	} else {
+		os.Remove(df + ".metadata")
+	}
+	if err := l.index.remove(src.Name()); err != nil {
+		return err
+	}
+	return l.index.record(des.Name())
+}
+
 // Delete the object from underlying store.
-func (l *LocalStore) Delete(ctx context.Context, obj string) error {
-	fo := path.Join(l.storepath, obj)
+func (l *LocalStore) Delete(ctx context.Context, obj string, opts ...cloudstorage.DeleteOptions) error {
+	if !l.index.matches(obj) {
+		// a differently cased name for another object, don't remove its file.
+		return nil
+	}
+	fo := l.ResolveKey(obj)
+	if len(opts) > 0 && opts[0].IfGenerationMatch != 0 {
+		return cloudstorage.ErrConditionNotSupported
+	}
+	defer lockPath(fo)()
+	if len(opts) > 0 && opts[0].IfMatch != "" && !etagMatches(fo, opts[0].IfMatch) {
+		return cloudstorage.ErrPreconditionFailed
+	}
 	os.Remove(fo)
 	mf := fo + ".metadata"
 	if cloudstorage.Exists(mf) {
 		os.Remove(mf)
 	}
-	return nil
+	return l.index.remove(obj)
+}
+
+// fileETag is a version tag for a file from its modified time and size, as
+// the filesystem has no native ETag.
+func fileETag(fi os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// etagMatches is true if file fo exists with the fileETag etag.
+func etagMatches(fo, etag string) bool {
+	stat, err := os.Stat(fo)
+	return err == nil && fileETag(stat) == etag
+}
+
+// pathLocks are the locks of the files being replaced or removed, so the
+// IfMatch check of a write or delete and its rename or remove are atomic to
+// the other writes and deletes of the path in this process.  Other
+// processes writing the same files aren't locked out.
+var pathLocks = struct {
+	sync.Mutex
+	locks map[string]*pathLock
+}{locks: make(map[string]*pathLock)}
+
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockPath locks fo, returning its unlock.
+func lockPath(fo string) func() {
+	pathLocks.Lock()
+	pl, ok := pathLocks.locks[fo]
+	if !ok {
+		pl = &pathLock{}
+		pathLocks.locks[fo] = pl
+	}
+	pl.refs++
+	pathLocks.Unlock()
+
+	pl.Lock()
+	return func() {
+		pl.Unlock()
+		pathLocks.Lock()
+		pl.refs--
+		if pl.refs == 0 {
+			delete(pathLocks.locks, fo)
+		}
+		pathLocks.Unlock()
+	}
 }
 
 func (l *LocalStore) String() string {
@@ -312,10 +735,15 @@ func (l *objectIterator) Close() {}
 type object struct {
 	name     string
 	updated  time.Time
+	etag     string
+	size     int64
 	metadata map[string]string
 
 	storepath string
 	cachepath string
+	index     *keyIndex
+	// mode of the file, if it is created.
+	mode os.FileMode
 
 	cachedcopy *os.File
 	readonly   bool
@@ -334,6 +762,20 @@ func (o *object) String() string {
 func (o *object) Updated() time.Time {
 	return o.updated
 }
+func (o *object) ETag() string {
+	return o.etag
+}
+func (o *object) Size() int64 {
+	return o.size
+}
+func (o *object) ContentType() string {
+	return cloudstorage.MetadataContentType(o.metadata)
+}
+
+// MD5 is nil, local files have no stored md5.
+func (o *object) MD5() []byte {
+	return nil
+}
 func (o *object) MetaData() map[string]string {
 	return o.metadata
 }
@@ -354,17 +796,26 @@ func (o *object) Delete() error {
 			return err
 		}
 	}
-	return nil
+	return o.index.remove(o.name)
 }
 
-func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
+func (o *object) Open(accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+// OpenWithContext for cloudstorage.ObjectOpenContext, the copy to the cache
+// stops once ctx is done.
+func (o *object) OpenWithContext(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	if !accesslevel.Valid() {
+		return nil, cloudstorage.ErrInvalidAccessLevel
+	}
 	if o.opened {
 		return nil, fmt.Errorf("the store object is already opened. %s", o.storepath)
 	}
 
 	var readonly = accesslevel == cloudstorage.ReadOnly
 
-	storecopy, err := os.OpenFile(o.storepath, os.O_RDWR|os.O_CREATE, 0665)
+	storecopy, err := os.OpenFile(o.storepath, os.O_RDWR|os.O_CREATE, o.mode)
 	if err != nil {
 		return nil, fmt.Errorf("localfs: local=%q could not create storecopy err=%v", o.storepath, err)
 	}
@@ -380,8 +831,13 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 		return nil, fmt.Errorf("localfs: cachepath=%s could not create cachedcopy err=%v", o.cachepath, err)
 	}
 
-	_, err = io.Copy(cachedcopy, storecopy)
+	_, err = io.Copy(cachedcopy, cloudstorage.NewContextReader(ctx, storecopy))
 	if err != nil {
+		cachedcopy.Close()
+		os.Remove(o.cachepath)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		return nil, fmt.Errorf("localfs: storepath=%s cachedcopy=%v could not copy from store to cache err=%v", o.storepath, cachedcopy.Name(), err)
 	}
 
@@ -400,6 +856,9 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 	o.cachedcopy = cachedcopy
 	o.readonly = readonly
 	o.opened = true
+	if readonly {
+		return cloudstorage.VerifyOpened(o, o.cachedcopy, opts)
+	}
 	return o.cachedcopy, nil
 }
 
@@ -412,6 +871,9 @@ func (o *object) Read(p []byte) (n int, err error) {
 
 // Write the given bytes to object.  Won't be writen until Close() or Sync() called.
 func (o *object) Write(p []byte) (n int, err error) {
+	if o.opened && o.readonly {
+		return 0, cloudstorage.ErrReadOnly
+	}
 	if o.cachedcopy == nil {
 		_, err := o.Open(cloudstorage.ReadWrite)
 		if err != nil {
@@ -426,7 +888,7 @@ func (o *object) Sync() error {
 		return fmt.Errorf("object isn't opened %s", o.name)
 	}
 	if o.readonly {
-		return fmt.Errorf("trying to Sync a readonly object %s", o.name)
+		return cloudstorage.ErrReadOnly
 	}
 
 	cachedcopy, err := os.OpenFile(o.cachepath, os.O_RDONLY, 0664)
@@ -435,7 +897,7 @@ func (o *object) Sync() error {
 	}
 	defer cachedcopy.Close()
 
-	storecopy, err := os.OpenFile(o.storepath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0664)
+	storecopy, err := os.OpenFile(o.storepath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, o.mode)
 	if err != nil {
 		return err
 	}
@@ -446,12 +908,30 @@ func (o *object) Sync() error {
 		return err
 	}
 
-	if o.metadata != nil && len(o.metadata) > 0 {
+	if o.metadata == nil {
 		o.metadata = make(map[string]string)
 	}
 
 	fmd := o.storepath + ".metadata"
-	return writemeta(fmd, o.metadata)
+	if err := writemeta(fmd, o.metadata); err != nil {
+		return err
+	}
+	return o.index.record(o.name)
+}
+
+func readmeta(filename string) (map[string]string, error) {
+	if !cloudstorage.Exists(filename) {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	md := make(map[string]string)
+	if err := json.Unmarshal(b, &md); err != nil {
+		return nil, err
+	}
+	return md, nil
 }
 
 func writemeta(filename string, meta map[string]string) error {
@@ -506,6 +986,18 @@ func (o *object) Close() error {
 	return nil
 }
 
+// OpenRange for cloudstorage.ObjectRange, reads the range of the store file
+// without a cached copy.
+func (o *object) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(o.storepath)
+	if os.IsNotExist(err) {
+		return nil, cloudstorage.ErrObjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return cloudstorage.NewFileRange(f, start, length)
+}
+
 func (o *object) Release() error {
 	if o.cachedcopy != nil {
 		o.cachedcopy.Close()
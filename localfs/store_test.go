@@ -1,9 +1,14 @@
 package localfs_test
 
 import (
+	"context"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
 	"os"
 	"testing"
 
+	"github.com/araddon/gou"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/lytics/cloudstorage"
@@ -51,3 +56,381 @@ func TestAll(t *testing.T) {
 	assert.NotEqual(t, nil, err)
 	assert.Equal(t, nil, store)
 }
+
+func TestKeyIndex(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_keyindex")
+
+	conf := &cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_keyindex",
+		TmpDir:     "/tmp/localcache_keyindex",
+		Settings:   gou.JsonHelper{localfs.ConfKeyCaseIndex: true},
+	}
+	store, err := cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+
+	ctx := context.Background()
+	w, err := store.NewWriterWithContext(ctx, "Folder/MixedCase.CSV", nil)
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	obj, err := store.Get(ctx, "Folder/MixedCase.CSV")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "Folder/MixedCase.CSV", obj.Name())
+
+	// other casings are different keys, as in the cloud stores
+	_, err = store.Get(ctx, "folder/mixedcase.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	assert.Equal(t, nil, store.Delete(ctx, "Folder/mixedcase.csv"))
+
+	resp, err := store.List(ctx, cloudstorage.NewQueryAll())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(resp.Objects))
+	assert.Equal(t, "Folder/MixedCase.CSV", resp.Objects[0].Name())
+
+	// index survives a new store on the same path
+	store, err = cloudstorage.NewStore(conf)
+	assert.Equal(t, nil, err)
+	_, err = store.Get(ctx, "folder/mixedcase.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	assert.Equal(t, nil, store.Delete(ctx, "Folder/MixedCase.CSV"))
+	_, err = store.Get(ctx, "Folder/MixedCase.CSV")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+}
+
+func TestConditionalDelete(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_conddelete")
+
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_conddelete",
+		TmpDir:     "/tmp/localcache_conddelete",
+	})
+	assert.Equal(t, nil, err)
+
+	ctx := context.Background()
+	write := func(data string) {
+		w, err := store.NewWriterWithContext(ctx, "cond.csv", nil)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(data))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+	}
+
+	write("a,b,c\n")
+	obj, err := store.Get(ctx, "cond.csv")
+	assert.Equal(t, nil, err)
+	etag := obj.ETag()
+	assert.NotEqual(t, "", etag)
+
+	// updated since it was read
+	write("a,b,c\nd,e,f\n")
+	err = store.Delete(ctx, "cond.csv", cloudstorage.DeleteOptions{IfMatch: etag})
+	assert.Equal(t, cloudstorage.ErrPreconditionFailed, err)
+	_, err = store.Get(ctx, "cond.csv")
+	assert.Equal(t, nil, err)
+
+	obj, err = store.Get(ctx, "cond.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, store.Delete(ctx, "cond.csv", cloudstorage.DeleteOptions{IfMatch: obj.ETag()}))
+	_, err = store.Get(ctx, "cond.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	err = store.Delete(ctx, "cond.csv", cloudstorage.DeleteOptions{IfMatch: etag})
+	assert.Equal(t, cloudstorage.ErrPreconditionFailed, err)
+
+	// files have no generations.
+	err = store.Delete(ctx, "cond.csv", cloudstorage.DeleteOptions{IfGenerationMatch: 1})
+	assert.Equal(t, cloudstorage.ErrConditionNotSupported, err)
+	_, err = store.NewWriterWithContext(ctx, "cond.csv", nil, cloudstorage.Opts{IfGenerationMatch: 1})
+	assert.Equal(t, cloudstorage.ErrConditionNotSupported, err)
+}
+
+func TestChecksumMismatchKeepsObject(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_checksum")
+
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_checksum",
+		TmpDir:     "/tmp/localcache_checksum",
+	})
+	assert.Equal(t, nil, err)
+
+	ctx := context.Background()
+	write := func(data string, md map[string]string, opts ...cloudstorage.Opts) error {
+		w, err := store.NewWriterWithContext(ctx, "sum.csv", md, opts...)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(data))
+		assert.Equal(t, nil, err)
+		return w.Close()
+	}
+
+	assert.Equal(t, nil, write("a,b,c\n", map[string]string{"version": "1"}))
+	sum := md5.Sum([]byte("other"))
+	err = write("d,e,f\n", map[string]string{"version": "2"}, cloudstorage.Opts{ContentMD5: sum[:]})
+	assert.Equal(t, cloudstorage.ErrChecksumMismatch, err)
+
+	// the previous object, and nothing of the failed write.
+	obj, err := store.Get(ctx, "sum.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "1", obj.MetaData()["version"])
+	rc, err := store.NewReader("sum.csv")
+	assert.Equal(t, nil, err)
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a,b,c\n", string(data))
+	files, err := ioutil.ReadDir("/tmp/mockcloud_checksum")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(files), "expected the object and its metadata %v", files)
+
+	sum = md5.Sum([]byte("d,e,f\n"))
+	assert.Equal(t, nil, write("d,e,f\n", map[string]string{"version": "2"}, cloudstorage.Opts{ContentMD5: sum[:]}))
+	obj, err = store.Get(ctx, "sum.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "2", obj.MetaData()["version"])
+
+	_, err = store.NewWriterWithContext(ctx, "sum.csv", nil, cloudstorage.Opts{IfNotExists: true})
+	assert.Equal(t, cloudstorage.ErrPreconditionFailed, err)
+	// created between the open and the close of an exclusive write.
+	w, err := store.NewWriterWithContext(ctx, "new.csv", nil, cloudstorage.Opts{IfNotExists: true})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, ioutil.WriteFile("/tmp/mockcloud_checksum/new.csv", []byte("x"), 0644))
+	assert.Equal(t, cloudstorage.ErrPreconditionFailed, w.Close())
+}
+
+func TestConditionalWriteRace(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_condrace")
+
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_condrace",
+		TmpDir:     "/tmp/localcache_condrace",
+	})
+	assert.Equal(t, nil, err)
+	ctx := context.Background()
+	w, err := store.NewWriterWithContext(ctx, "race.csv", nil)
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+	obj, err := store.Get(ctx, "race.csv")
+	assert.Equal(t, nil, err)
+
+	// writers that all read the same version, only the first to close wins.
+	writers := make([]io.WriteCloser, 10)
+	for i := range writers {
+		writers[i], err = store.NewWriterWithContext(ctx, "race.csv", nil, cloudstorage.Opts{IfMatch: obj.ETag()})
+		assert.Equal(t, nil, err)
+		_, err = writers[i].Write([]byte("a,b,c\n"))
+		assert.Equal(t, nil, err)
+	}
+	errs := make(chan error, len(writers))
+	for _, w := range writers {
+		go func(w io.WriteCloser) { errs <- w.Close() }(w)
+	}
+	won := 0
+	for range writers {
+		if err := <-errs; err == nil {
+			won++
+		} else {
+			assert.Equal(t, cloudstorage.ErrPreconditionFailed, err)
+		}
+	}
+	assert.Equal(t, 1, won)
+}
+
+func TestResolveKey(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_resolvekey")
+
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_resolvekey",
+		TmpDir:     "/tmp/localcache_resolvekey",
+	})
+	assert.Equal(t, nil, err)
+
+	w, err := store.NewWriterWithContext(context.Background(), "dir/a.csv", nil)
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	key := store.ResolveKey("dir/a.csv")
+	assert.Equal(t, "/tmp/mockcloud_resolvekey/dir/a.csv", key)
+	b, err := ioutil.ReadFile(key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a,b,c\n", string(b))
+}
+
+func TestFilePermissions(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_perms")
+
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_perms",
+		TmpDir:     "/tmp/localcache_perms",
+		Settings:   gou.JsonHelper{localfs.ConfKeyFileMode: "0600"},
+	})
+	assert.Equal(t, nil, err)
+
+	write := func(name string, opts ...cloudstorage.Opts) os.FileInfo {
+		w, err := store.NewWriterWithContext(context.Background(), name, nil, opts...)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte("a,b,c\n"))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+		fi, err := os.Stat(store.ResolveKey(name))
+		assert.Equal(t, nil, err)
+		return fi
+	}
+
+	// the configured default
+	assert.Equal(t, os.FileMode(0600), write("default.csv").Mode().Perm())
+
+	// explicit modes aren't masked by the umask
+	fi := write("mode.csv", cloudstorage.Opts{FileMode: 0664, UID: os.Getuid(), GID: os.Getgid()})
+	assert.Equal(t, os.FileMode(0664), fi.Mode().Perm())
+
+	// objects created by opening a new object have the default too.
+	obj, err := store.NewObject("object.csv")
+	assert.Equal(t, nil, err)
+	f, err := obj.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, nil, err)
+	_, err = f.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, obj.Close())
+	fi, err = os.Stat(store.ResolveKey("object.csv"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	_, err = cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_perms",
+		TmpDir:     "/tmp/localcache_perms",
+		Settings:   gou.JsonHelper{localfs.ConfKeyFileMode: "rw"},
+	})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestPublicRead(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_public")
+
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_public",
+		TmpDir:     "/tmp/localcache_public",
+	})
+	assert.Equal(t, nil, err)
+
+	// files have no public acl, the write fails rather than being private.
+	_, err = store.NewWriterWithContext(context.Background(), "public.csv", nil, cloudstorage.Opts{PublicRead: true})
+	assert.Equal(t, cloudstorage.ErrFeatureNotSupported, err)
+	_, err = store.Get(context.Background(), "public.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+}
+
+func TestImplicitFolders(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_folders")
+
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_folders",
+		TmpDir:     "/tmp/localcache_folders",
+	})
+	assert.Equal(t, nil, err)
+
+	w, err := store.NewWriter("dirs/full/nested/a.csv", nil)
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+	assert.Equal(t, nil, os.MkdirAll("/tmp/mockcloud_folders/dirs/empty/nested", 0755))
+
+	q := cloudstorage.NewQueryForFolders("dirs/")
+	folders, err := store.Folders(context.Background(), q)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"dirs/empty/", "dirs/full/"}, folders)
+
+	q.ImplicitFolders = true
+	folders, err = store.Folders(context.Background(), q)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"dirs/full/"}, folders)
+}
+
+func TestSeparator(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_separator")
+
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_separator",
+		TmpDir:     "/tmp/localcache_separator",
+		Separator:  ":",
+	})
+	assert.Equal(t, nil, err)
+
+	w, err := store.NewWriter("data:2024:a.csv", nil)
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	// folders are directories on disk.
+	_, err = os.Stat("/tmp/mockcloud_separator/data/2024/a.csv")
+	assert.Equal(t, nil, err)
+
+	resp, err := store.List(context.Background(), cloudstorage.NewQuery("data:"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(resp.Objects))
+	assert.Equal(t, "data:2024:a.csv", resp.Objects[0].Name())
+
+	o, err := store.Get(context.Background(), "data:2024:a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "data:2024:a.csv", o.Name())
+
+	folders, err := store.Folders(context.Background(), cloudstorage.NewQueryForFolders("data:"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"data:2024:"}, folders)
+
+	objs, folders, err := cloudstorage.ListLevel(context.Background(), store, "data:2024:", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(objs))
+	assert.Equal(t, "data:2024:a.csv", objs[0].Name())
+	assert.Equal(t, 0, len(folders))
+
+	assert.Equal(t, ":", cloudstorage.Separator(store))
+	folder, err := cloudstorage.GetFolder(context.Background(), store, "data:2024")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "data:2024:", folder)
+
+	_, err = store.NewWriter("data:2024:", nil)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestAllParallel(t *testing.T) {
+	os.RemoveAll("/tmp/mockcloud_parallel")
+
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_parallel",
+		TmpDir:     "/tmp/localcache_parallel",
+	})
+	assert.Equal(t, nil, err)
+	testutils.RunTestsParallel(t, store)
+}
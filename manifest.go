@@ -0,0 +1,62 @@
+package cloudstorage
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// ManifestHeader is the header row of ExportManifest csv.
+var ManifestHeader = []string{"name", "size", "updated", "etag", "md5"}
+
+// ExportManifest writes a manifest of the objects under prefix to w as gzip
+// compressed csv, a ManifestHeader row then one row per object in listing
+// order.  updated is RFC3339 UTC, md5 is hex and empty where the store has no
+// md5 of the object.  The listing is streamed a page at a time, so memory
+// doesn't grow with the number of objects.  If ctx is done part way the
+// export stops with its error, w has a truncated manifest.
+func ExportManifest(ctx context.Context, store Store, prefix string, w io.Writer) error {
+	iter, err := store.Objects(ctx, NewQuery(prefix))
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	gz := gzip.NewWriter(w)
+	cw := csv.NewWriter(gz)
+	if err := cw.Write(ManifestHeader); err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return err
+		}
+		row := []string{
+			o.Name(),
+			strconv.FormatInt(o.Size(), 10),
+			o.Updated().UTC().Format(time.RFC3339),
+			o.ETag(),
+			hex.EncodeToString(o.MD5()),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
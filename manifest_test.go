@@ -0,0 +1,45 @@
+package cloudstorage_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestExportManifest(t *testing.T) {
+	store := newLocalStore(t, "manifest")
+	ctx := context.Background()
+	for _, name := range []string{"inv/a.csv", "inv/b/c.csv", "other/d.csv"} {
+		w, err := store.NewWriterWithContext(ctx, name, nil)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(name))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Equal(t, nil, cloudstorage.ExportManifest(ctx, store, "inv/", buf))
+
+	gz, err := gzip.NewReader(buf)
+	assert.Equal(t, nil, err)
+	rows, err := csv.NewReader(gz).ReadAll()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, cloudstorage.ManifestHeader, rows[0])
+	// in listing order, which for localfs isn't always by name
+	sort.Slice(rows[1:], func(i, j int) bool { return rows[1+i][0] < rows[1+j][0] })
+	assert.Equal(t, "inv/a.csv", rows[1][0])
+	assert.Equal(t, "9", rows[1][1])
+	assert.Equal(t, "inv/b/c.csv", rows[2][0])
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, cloudstorage.ExportManifest(cctx, store, "inv/", &bytes.Buffer{}))
+}
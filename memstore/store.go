@@ -0,0 +1,588 @@
+package memstore
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/pborman/uuid"
+	"golang.org/x/net/context"
+)
+
+func init() {
+	cloudstorage.Register(StoreType, memProvider)
+}
+func memProvider(conf *cloudstorage.Config) (cloudstorage.Store, error) {
+	return NewMemStore(conf.TmpDir)
+}
+
+var (
+	// Ensure Our MemStore implement CloudStorage interfaces
+	_ cloudstorage.StoreReader         = (*MemStore)(nil)
+	_ cloudstorage.StoreCopy           = (*MemStore)(nil)
+	_ cloudstorage.StoreMove           = (*MemStore)(nil)
+	_ cloudstorage.StoreUpdateMetadata = (*MemStore)(nil)
+	_ cloudstorage.ObjectOpenContext   = (*object)(nil)
+	_ cloudstorage.ObjectRange         = (*object)(nil)
+)
+
+const (
+	// StoreType name of our in-memory store = "memory"
+	StoreType = "memory"
+)
+
+// MemStore is an in-memory store, ie for the unit tests of code taking a
+// cloudstorage.Store without a filesystem or cloud to clean up.  It has the
+// semantics of the object stores:  names are keys (a name ending in "/" is a
+// folder marker object), folders are the prefixes of objects, and listings
+// are in name order and consistent with writes and deletes.  The objects are
+// lost with the store.  Object.Open still needs a local file, so the cached
+// copies of opened objects are under the cache path.
+type MemStore struct {
+	mu        sync.RWMutex
+	objects   map[string]*entry
+	cachepath string
+	Id        string
+}
+
+// entry is an object as stored, it is replaced not modified by writes.
+type entry struct {
+	data     []byte
+	metadata map[string]string
+	md5      []byte
+	updated  time.Time
+}
+
+// NewMemStore create an empty in-memory store, cachepath is the directory
+// of the cached copies of opened objects.
+func NewMemStore(cachepath string) (*MemStore, error) {
+	if cachepath == "" {
+		cachepath = os.TempDir()
+	}
+	if err := os.MkdirAll(cachepath, 0775); err != nil {
+		return nil, fmt.Errorf("unable to create path. path=%s err=%v", cachepath, err)
+	}
+
+	uid := uuid.NewUUID().String()
+	uid = strings.Replace(uid, "-", "", -1)
+
+	return &MemStore{
+		objects:   make(map[string]*entry),
+		cachepath: cachepath,
+		Id:        uid,
+	}, nil
+}
+
+// Type is store type = "memory"
+func (m *MemStore) Type() string {
+	return StoreType
+}
+func (m *MemStore) Client() interface{} {
+	return m
+}
+
+// ResolveKey is the name, the store has no normalization of names.
+func (m *MemStore) ResolveKey(o string) string {
+	return o
+}
+
+// put stores the bytes and metadata of object o.
+func (m *MemStore) put(o string, data []byte, metadata map[string]string) {
+	sum := md5.Sum(data)
+	e := &entry{
+		data:     data,
+		metadata: copyMetadata(metadata),
+		md5:      sum[:],
+		updated:  time.Now(),
+	}
+	m.mu.Lock()
+	m.objects[o] = e
+	m.mu.Unlock()
+}
+
+func (m *MemStore) get(o string) (*entry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.objects[o]
+	return e, ok
+}
+
+func (m *MemStore) newObject(o string, e *entry) *object {
+	obj := &object{
+		name:      o,
+		store:     m,
+		cachepath: cloudstorage.CachePathObj(m.cachepath, o, m.Id),
+	}
+	if e != nil {
+		obj.updated = e.updated
+		obj.size = int64(len(e.data))
+		obj.md5 = e.md5
+		obj.metadata = copyMetadata(e.metadata)
+	}
+	return obj
+}
+
+// UpdateMetadata replaces the metadata of object o.
+func (m *MemStore) UpdateMetadata(ctx context.Context, o string, metadata map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.objects[o]
+	if !ok {
+		return cloudstorage.ErrObjectNotFound
+	}
+	m.objects[o] = &entry{data: e.data, metadata: copyMetadata(metadata), md5: e.md5, updated: time.Now()}
+	return nil
+}
+
+// NewObject create new object of given name.
+func (m *MemStore) NewObject(objectname string) (cloudstorage.Object, error) {
+	if _, ok := m.get(objectname); ok {
+		return nil, cloudstorage.ErrObjectExists
+	}
+	return m.newObject(objectname, nil), nil
+}
+
+// List objects at Query location in name order, a page of PageSize objects
+// (all if zero) after the Marker.
+func (m *MemStore) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.ObjectsResponse, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	resp := cloudstorage.NewObjectsResponse()
+	m.mu.RLock()
+	names := make([]string, 0)
+	for name := range m.objects {
+		if strings.HasPrefix(name, q.Prefix) && name > q.Marker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if q.PageSize > 0 && len(names) > q.PageSize {
+		names = names[:q.PageSize]
+		resp.NextMarker = names[len(names)-1]
+		resp.HasMore = true
+	}
+	for _, name := range names {
+		resp.Objects = append(resp.Objects, m.newObject(name, m.objects[name]))
+	}
+	m.mu.RUnlock()
+
+	resp.Objects = q.ApplyFilters(resp.Objects)
+	return resp, nil
+}
+
+// Objects returns an iterator over the objects in the store that match the Query q.
+func (m *MemStore) Objects(ctx context.Context, q cloudstorage.Query) (cloudstorage.ObjectIterator, error) {
+	return cloudstorage.NewObjectPageIterator(ctx, m, q), nil
+}
+
+// Folders list of folders for given path query, the prefixes of objects
+// under it.
+func (m *MemStore) Folders(ctx context.Context, q cloudstorage.Query) ([]string, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	m.mu.RLock()
+	seen := make(map[string]bool)
+	folders := make([]string, 0)
+	for name := range m.objects {
+		if !strings.HasPrefix(name, q.Prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(name, q.Prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			folder := q.Prefix + rest[:i+1]
+			if !seen[folder] {
+				seen[folder] = true
+				folders = append(folders, folder)
+			}
+		}
+	}
+	m.mu.RUnlock()
+	sort.Strings(folders)
+	return folders, nil
+}
+
+// NewReader create in-memory store reader.
+func (m *MemStore) NewReader(o string) (io.ReadCloser, error) {
+	return m.NewReaderWithContext(context.Background(), o)
+}
+func (m *MemStore) NewReaderWithContext(ctx context.Context, o string, opts ...cloudstorage.ReadOptions) (io.ReadCloser, error) {
+	e, ok := m.get(o)
+	if !ok {
+		return nil, cloudstorage.ErrObjectNotFound
+	}
+	rc := cloudstorage.NewContextReadCloser(ctx, ioutil.NopCloser(bytes.NewReader(e.data)))
+	if len(opts) > 0 && opts[0].VerifyChecksum {
+		rc = cloudstorage.NewChecksumReader(rc, md5.New(), e.md5)
+	}
+	return cloudstorage.MaxBytesReader(rc, opts), nil
+}
+
+func (m *MemStore) NewWriter(o string, metadata map[string]string) (io.WriteCloser, error) {
+	return m.NewWriterWithContext(context.Background(), o, metadata)
+}
+
+// NewWriterWithContext buffers the bytes written, the object is stored on
+// Close.  The conditions of Opts (IfNotExists, IfMatch) are checked on Close
+// as the object is stored, as in the object stores.
+func (m *MemStore) NewWriterWithContext(ctx context.Context, o string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+	if len(opts) > 0 && opts[0].IfGenerationMatch != 0 {
+		return nil, cloudstorage.ErrConditionNotSupported
+	}
+	if len(opts) > 0 && opts[0].PublicRead {
+		return nil, cloudstorage.ErrFeatureNotSupported
+	}
+	if cloudstorage.SkipsIfIdentical(opts) {
+		return cloudstorage.NewSkipIfIdenticalWriter(ctx, m, o, metadata, opts)
+	}
+	if cloudstorage.VerifiesOnClose(opts) {
+		return cloudstorage.NewVerifyOnCloseWriter(ctx, m, o, metadata, opts)
+	}
+	if cloudstorage.DetectsContentType(metadata, opts) {
+		return cloudstorage.NewContentTypeWriter(o, metadata, opts, func(md map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+			return m.NewWriterWithContext(ctx, o, md, opts...)
+		}), nil
+	}
+	w := &writer{m: m, name: o, metadata: copyMetadata(metadata)}
+	if len(opts) > 0 {
+		w.opts = opts[0]
+	}
+	return w, nil
+}
+
+func (m *MemStore) Get(ctx context.Context, o string) (cloudstorage.Object, error) {
+	e, ok := m.get(o)
+	if !ok {
+		return nil, cloudstorage.ErrObjectNotFound
+	}
+	return m.newObject(o, e), nil
+}
+
+// Copy the stored object src to des, sharing its bytes.
+func (m *MemStore) Copy(ctx context.Context, src, des cloudstorage.Object) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.objects[src.Name()]
+	if !ok {
+		return cloudstorage.ErrObjectNotFound
+	}
+	m.objects[des.Name()] = &entry{data: e.data, metadata: copyMetadata(e.metadata), md5: e.md5, updated: time.Now()}
+	return nil
+}
+
+// Move renames src to des.
+func (m *MemStore) Move(ctx context.Context, src, des cloudstorage.Object) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.objects[src.Name()]
+	if !ok {
+		return cloudstorage.ErrObjectNotFound
+	}
+	delete(m.objects, src.Name())
+	m.objects[des.Name()] = e
+	return nil
+}
+
+// Delete the object from the store, deleting a missing object isn't an error.
+func (m *MemStore) Delete(ctx context.Context, obj string, opts ...cloudstorage.DeleteOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(opts) > 0 {
+		if opts[0].IfGenerationMatch != 0 {
+			return cloudstorage.ErrConditionNotSupported
+		}
+		if opts[0].IfMatch != "" {
+			e, ok := m.objects[obj]
+			if !ok || e.etag() != opts[0].IfMatch {
+				return cloudstorage.ErrPreconditionFailed
+			}
+		}
+	}
+	delete(m.objects, obj)
+	return nil
+}
+
+func (m *MemStore) String() string {
+	return fmt.Sprintf("[id:%s memory://]", m.Id)
+}
+
+// etag is the hex md5 of the bytes, as S3 for objects written in one PUT.
+func (e *entry) etag() string {
+	return hex.EncodeToString(e.md5)
+}
+
+func copyMetadata(md map[string]string) map[string]string {
+	if md == nil {
+		return nil
+	}
+	c := make(map[string]string, len(md))
+	for k, v := range md {
+		c[k] = v
+	}
+	return c
+}
+
+type writer struct {
+	m        *MemStore
+	name     string
+	metadata map[string]string
+	opts     cloudstorage.Opts
+	buf      bytes.Buffer
+	closed   bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("memstore: write to closed writer name=%q", w.name)
+	}
+	return w.buf.Write(p)
+}
+
+// Close stores the object, if the conditions of the write hold.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	data := w.buf.Bytes()
+	sum := md5.Sum(data)
+	if w.opts.ContentMD5 != nil && !bytes.Equal(w.opts.ContentMD5, sum[:]) {
+		return cloudstorage.ErrChecksumMismatch
+	}
+
+	m := w.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, exists := m.objects[w.name]
+	if w.opts.IfNotExists && exists {
+		return cloudstorage.ErrPreconditionFailed
+	}
+	if w.opts.IfMatch != "" && (!exists || e.etag() != w.opts.IfMatch) {
+		return cloudstorage.ErrPreconditionFailed
+	}
+	m.objects[w.name] = &entry{data: data, metadata: w.metadata, md5: sum[:], updated: time.Now()}
+	return nil
+}
+
+type object struct {
+	name     string
+	updated  time.Time
+	size     int64
+	md5      []byte
+	metadata map[string]string
+
+	store     *MemStore
+	cachepath string
+
+	cachedcopy *os.File
+	readonly   bool
+	opened     bool
+}
+
+func (o *object) StorageSource() string {
+	return StoreType
+}
+func (o *object) Name() string {
+	return o.name
+}
+func (o *object) String() string {
+	return o.name
+}
+func (o *object) Updated() time.Time {
+	return o.updated
+}
+func (o *object) ETag() string {
+	if o.md5 == nil {
+		return ""
+	}
+	return hex.EncodeToString(o.md5)
+}
+func (o *object) Size() int64 {
+	return o.size
+}
+func (o *object) ContentType() string {
+	return cloudstorage.MetadataContentType(o.metadata)
+}
+func (o *object) MD5() []byte {
+	return o.md5
+}
+func (o *object) MetaData() map[string]string {
+	return o.metadata
+}
+func (o *object) SetMetaData(meta map[string]string) {
+	o.metadata = meta
+}
+
+func (o *object) Delete() error {
+	if err := o.Release(); err != nil {
+		return err
+	}
+	return o.store.Delete(context.Background(), o.name)
+}
+
+func (o *object) Open(accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+// OpenWithContext for cloudstorage.ObjectOpenContext, the bytes are in memory
+// so ctx is only checked before the cached copy is written.
+func (o *object) OpenWithContext(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	if !accesslevel.Valid() {
+		return nil, cloudstorage.ErrInvalidAccessLevel
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if o.opened {
+		return nil, fmt.Errorf("the store object is already opened. %s", o.name)
+	}
+
+	var readonly = accesslevel == cloudstorage.ReadOnly
+
+	err := cloudstorage.EnsureDir(o.cachepath)
+	if err != nil {
+		return nil, fmt.Errorf("memstore: cachepath=%s could not create cachedcopy dir err=%v", o.cachepath, err)
+	}
+
+	cachedcopy, err := os.Create(o.cachepath)
+	if err != nil {
+		return nil, fmt.Errorf("memstore: cachepath=%s could not create cachedcopy err=%v", o.cachepath, err)
+	}
+
+	if e, ok := o.store.get(o.name); ok {
+		if _, err := cachedcopy.Write(e.data); err != nil {
+			cachedcopy.Close()
+			return nil, fmt.Errorf("memstore: name=%s cachedcopy=%v could not copy from store to cache err=%v", o.name, cachedcopy.Name(), err)
+		}
+	}
+
+	if readonly {
+		cachedcopy.Close()
+		cachedcopy, err = os.Open(o.cachepath)
+		if err != nil {
+			return nil, fmt.Errorf("memstore: name=%s cachedcopy=%v could not opencache err=%v", o.name, o.cachepath, err)
+		}
+	} else {
+		if _, err := cachedcopy.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("error seeking to start of cachedcopy err=%v", err)
+		}
+	}
+
+	o.cachedcopy = cachedcopy
+	o.readonly = readonly
+	o.opened = true
+	if readonly {
+		return cloudstorage.VerifyOpened(o, o.cachedcopy, opts)
+	}
+	return o.cachedcopy, nil
+}
+
+func (o *object) File() *os.File {
+	return o.cachedcopy
+}
+func (o *object) Read(p []byte) (n int, err error) {
+	return o.cachedcopy.Read(p)
+}
+
+// Write the given bytes to object.  Won't be writen until Close() or Sync() called.
+func (o *object) Write(p []byte) (n int, err error) {
+	if o.opened && o.readonly {
+		return 0, cloudstorage.ErrReadOnly
+	}
+	if o.cachedcopy == nil {
+		_, err := o.Open(cloudstorage.ReadWrite)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return o.cachedcopy.Write(p)
+}
+
+// Sync stores the cached copy as the object.
+func (o *object) Sync() error {
+	if !o.opened {
+		return fmt.Errorf("object isn't opened %s", o.name)
+	}
+	if o.readonly {
+		return cloudstorage.ErrReadOnly
+	}
+	data, err := ioutil.ReadFile(o.cachepath)
+	if err != nil {
+		return err
+	}
+	o.store.put(o.name, data, o.metadata)
+	return nil
+}
+
+func (o *object) Close() error {
+	if !o.opened {
+		return nil
+	}
+
+	defer func() {
+		os.Remove(o.cachepath)
+		o.cachedcopy = nil
+		o.opened = false
+	}()
+
+	if !o.readonly {
+		if err := o.cachedcopy.Sync(); err != nil {
+			return err
+		}
+	}
+
+	err := o.cachedcopy.Close()
+	if err != nil {
+		if !strings.Contains(err.Error(), os.ErrClosed.Error()) {
+			return err
+		}
+	}
+
+	if !o.readonly {
+		return o.Sync()
+	}
+	return nil
+}
+
+// OpenRange for cloudstorage.ObjectRange, reads the range of the stored bytes.
+func (o *object) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	e, ok := o.store.get(o.name)
+	if !ok {
+		return nil, cloudstorage.ErrObjectNotFound
+	}
+	size := int64(len(e.data))
+	if err := cloudstorage.CheckRange(start, length, size); err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		length = size - start
+	}
+	return ioutil.NopCloser(bytes.NewReader(e.data[start : start+length])), nil
+}
+
+func (o *object) Release() error {
+	if o.cachedcopy != nil {
+		o.cachedcopy.Close()
+		o.cachedcopy = nil
+		o.opened = false
+	}
+	// most likely this doesn't exist so don't return error
+	os.Remove(o.cachepath)
+	return nil
+}
@@ -0,0 +1,73 @@
+package memstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/memstore"
+	"github.com/lytics/cloudstorage/testutils"
+)
+
+func TestAll(t *testing.T) {
+	os.RemoveAll("/tmp/memcache")
+
+	conf := &cloudstorage.Config{
+		Type:   memstore.StoreType,
+		TmpDir: "/tmp/memcache",
+	}
+	store, err := cloudstorage.NewStore(conf)
+	if err != nil {
+		t.Fatalf("Could not create store: config=%+v  err=%v", conf, err)
+		return
+	}
+	testutils.RunTests(t, store, conf)
+	testutils.RunTestsParallel(t, store)
+}
+
+func TestStoresAreSeparate(t *testing.T) {
+	ctx := context.Background()
+	s1, err := memstore.NewMemStore("/tmp/memcache_separate")
+	assert.Equal(t, nil, err)
+	s2, err := memstore.NewMemStore("/tmp/memcache_separate")
+	assert.Equal(t, nil, err)
+
+	w, err := s1.NewWriterWithContext(ctx, "a.csv", map[string]string{"owner": "ingest"})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, err)
+	// not stored until Close
+	_, err = s1.Get(ctx, "a.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	assert.Equal(t, nil, w.Close())
+
+	obj, err := s1.Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(6), obj.Size())
+	assert.Equal(t, "ingest", obj.MetaData()["owner"])
+	_, err = s1.NewObject("a.csv")
+	assert.Equal(t, cloudstorage.ErrObjectExists, err)
+
+	_, err = s2.Get(ctx, "a.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// conditional writes are checked on Close
+	w, err = s1.NewWriterWithContext(ctx, "a.csv", nil, cloudstorage.Opts{IfMatch: "stale"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, cloudstorage.ErrPreconditionFailed, w.Close())
+	w, err = s1.NewWriterWithContext(ctx, "a.csv", nil, cloudstorage.Opts{IfMatch: obj.ETag()})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("d,e,f\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	rc, err := s1.NewReaderWithContext(ctx, "a.csv", cloudstorage.ReadOptions{VerifyChecksum: true})
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(rc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "d,e,f\n", string(b))
+}
@@ -0,0 +1,16 @@
+package cloudstorage
+
+import (
+	"golang.org/x/net/context"
+)
+
+// UpdateMetadata replaces the metadata of object o in s, without re-uploading
+// its contents, if the store supports it (see StoreUpdateMetadata),
+// otherwise ErrFeatureNotSupported.  A ContentTypeKey in metadata sets the
+// objects content type.
+func UpdateMetadata(ctx context.Context, s Store, o string, metadata map[string]string) error {
+	if um, ok := s.(StoreUpdateMetadata); ok {
+		return um.UpdateMetadata(ctx, o, metadata)
+	}
+	return ErrFeatureNotSupported
+}
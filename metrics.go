@@ -0,0 +1,237 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// Metric is the observation of a store operation, for recording to a
+// metrics system.
+type Metric struct {
+	// Op is the operation, one of "get", "list", "folders", "read", "write"
+	// or "delete".
+	Op string
+	// Backend is the Type of the store, ie "gcs" or "s3".
+	Backend string
+	// Object is the bucket of the object name (or listing prefix) from the
+	// KeyBucketer, empty without one.
+	Object string
+	// Elapsed is the duration of the operation, for reads and writes until
+	// Close.
+	Elapsed time.Duration
+	// Bytes read or written.
+	Bytes int64
+	// Err of the operation, ErrObjectNotFound and iterator.Done aren't
+	// errors.
+	Err error
+}
+
+// MetricsStore is a Store reporting a Metric per operation to a callback,
+// ie to export as prometheus histograms and counters (or statsd timers)
+// labelled with the Op and Backend, counting those with an Err as errors
+// and adding up the Bytes transferred.  Every distinct label
+// value is its own time series, so object names (unbounded, often unique
+// per write) mustn't be used as labels: the Metric Object is empty unless a
+// KeyBucketer maps names to a small, fixed set of values, such as
+// TopLevelPrefix.
+type MetricsStore struct {
+	Store
+	// KeyBucketer maps an object name to the Object of its metrics.  It must
+	// return a bounded set of values, a bucketer returning the name (or
+	// ids, dates ...) has the same cardinality as no bucketing at all.
+	KeyBucketer func(name string) string
+	observe     func(Metric)
+	backend     string
+}
+
+// NewMetricsStore create a store calling observe with a Metric for each
+// operation on s.  observe is called concurrently, and from the callers
+// goroutine so it must not block.
+func NewMetricsStore(s Store, observe func(Metric)) *MetricsStore {
+	return &MetricsStore{Store: s, observe: observe, backend: s.Type()}
+}
+
+// TopLevelPrefix is a KeyBucketer of the first path segment of name with
+// its "/", ie "logs/" for "logs/2024/01/a.log", names without one are "".
+func TopLevelPrefix(name string) string {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i+1]
+	}
+	return ""
+}
+
+func (m *MetricsStore) record(op, name string, start time.Time, n int64, err error) {
+	if err == ErrObjectNotFound || err == iterator.Done || err == io.EOF {
+		err = nil
+	}
+	bucket := ""
+	if m.KeyBucketer != nil {
+		bucket = m.KeyBucketer(name)
+	}
+	m.observe(Metric{Op: op, Backend: m.backend, Object: bucket, Elapsed: time.Since(start), Bytes: n, Err: err})
+}
+
+// Get an object, recording a "get".
+func (m *MetricsStore) Get(ctx context.Context, name string) (Object, error) {
+	start := time.Now()
+	o, err := m.Store.Get(ctx, name)
+	m.record("get", name, start, 0, err)
+	return o, err
+}
+
+// Objects iterates objects, recording a "list" once iterated (or closed).
+func (m *MetricsStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	start := time.Now()
+	iter, err := m.Store.Objects(ctx, q)
+	if err != nil {
+		m.record("list", q.Prefix, start, 0, err)
+		return nil, err
+	}
+	return &metricsIterator{ObjectIterator: iter, m: m, prefix: q.Prefix, start: start}, nil
+}
+
+// List objects, recording a "list".
+func (m *MetricsStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	start := time.Now()
+	resp, err := m.Store.List(ctx, q)
+	m.record("list", q.Prefix, start, 0, err)
+	return resp, err
+}
+
+// Folders lists folders, recording a "folders".
+func (m *MetricsStore) Folders(ctx context.Context, q Query) ([]string, error) {
+	start := time.Now()
+	folders, err := m.Store.Folders(ctx, q)
+	m.record("folders", q.Prefix, start, 0, err)
+	return folders, err
+}
+
+// NewReader of an object, recording a "read" on Close.
+func (m *MetricsStore) NewReader(name string) (io.ReadCloser, error) {
+	return m.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of an object, recording a "read" on Close.
+func (m *MetricsStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := m.Store.NewReaderWithContext(ctx, name, opts...)
+	if err != nil {
+		m.record("read", name, start, 0, err)
+		return nil, err
+	}
+	return &metricsReader{ReadCloser: rc, m: m, name: name, start: start}, nil
+}
+
+// NewWriter to an object, recording a "write" on Close.
+func (m *MetricsStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return m.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to an object, recording a "write" on Close.
+func (m *MetricsStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	start := time.Now()
+	wc, err := m.Store.NewWriterWithContext(ctx, name, metadata, opts...)
+	if err != nil {
+		m.record("write", name, start, 0, err)
+		return nil, err
+	}
+	return &metricsWriter{WriteCloser: wc, m: m, name: name, start: start}, nil
+}
+
+// Delete an object, recording a "delete".
+func (m *MetricsStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	start := time.Now()
+	err := m.Store.Delete(ctx, name, opts...)
+	m.record("delete", name, start, 0, err)
+	return err
+}
+
+func (m *MetricsStore) String() string {
+	return fmt.Sprintf("metrics(%s)", m.Store)
+}
+
+type metricsIterator struct {
+	ObjectIterator
+	m      *MetricsStore
+	prefix string
+	start  time.Time
+	once   sync.Once
+}
+
+func (it *metricsIterator) Next() (Object, error) {
+	o, err := it.ObjectIterator.Next()
+	if err != nil {
+		it.once.Do(func() { it.m.record("list", it.prefix, it.start, 0, err) })
+	}
+	return o, err
+}
+
+func (it *metricsIterator) Close() {
+	it.once.Do(func() { it.m.record("list", it.prefix, it.start, 0, nil) })
+	it.ObjectIterator.Close()
+}
+
+type metricsReader struct {
+	io.ReadCloser
+	m     *MetricsStore
+	name  string
+	start time.Time
+	n     int64
+	err   error
+}
+
+func (r *metricsReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *metricsReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.err != nil {
+		err = r.err
+	}
+	r.m.record("read", r.name, r.start, r.n, err)
+	return err
+}
+
+type metricsWriter struct {
+	io.WriteCloser
+	m     *MetricsStore
+	name  string
+	start time.Time
+	n     int64
+	err   error
+}
+
+func (w *metricsWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n += int64(n)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *metricsWriter) Close() error {
+	err := w.WriteCloser.Close()
+	recorded := err
+	if w.err != nil {
+		recorded = w.err
+	}
+	w.m.record("write", w.name, w.start, w.n, recorded)
+	return err
+}
+
+func (w *metricsWriter) Resumed() int64 {
+	return Resumed(w.WriteCloser)
+}
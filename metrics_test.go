@@ -0,0 +1,44 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestMetricsStore(t *testing.T) {
+	mu := sync.Mutex{}
+	var metrics []cloudstorage.Metric
+	store := cloudstorage.NewMetricsStore(newLocalStore(t, "metrics"), func(m cloudstorage.Metric) {
+		mu.Lock()
+		defer mu.Unlock()
+		metrics = append(metrics, m)
+	})
+	ctx := context.Background()
+
+	// object names aren't labels by default
+	writeObject(t, store, "logs/2024/a.log", "hello")
+	assert.Equal(t, 1, len(metrics))
+	assert.Equal(t, "write", metrics[0].Op)
+	assert.Equal(t, "localfs", metrics[0].Backend)
+	assert.Equal(t, "", metrics[0].Object)
+	assert.Equal(t, int64(5), metrics[0].Bytes)
+	assert.Equal(t, nil, metrics[0].Err)
+
+	store.KeyBucketer = cloudstorage.TopLevelPrefix
+	assert.Equal(t, "hello", readAll(t, store, "logs/2024/a.log"))
+	_, err := store.Get(ctx, "missing")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	assert.Equal(t, 3, len(metrics))
+	assert.Equal(t, cloudstorage.Metric{Op: "read", Backend: "localfs", Object: "logs/", Elapsed: metrics[1].Elapsed, Bytes: 5}, metrics[1])
+	assert.Equal(t, "get", metrics[2].Op)
+	assert.Equal(t, "", metrics[2].Object)
+	assert.Equal(t, nil, metrics[2].Err)
+
+	assert.Equal(t, "logs/", cloudstorage.TopLevelPrefix("logs/2024/a.log"))
+	assert.Equal(t, "", cloudstorage.TopLevelPrefix("a.log"))
+}
@@ -0,0 +1,5 @@
+// Package otelstore is a cloudstorage Store tracing its operations with
+// OpenTelemetry, in its own package so the cloudstorage package doesn't
+// depend on otel.  It needs go1.18, as otel does, on older versions the
+// package is empty.
+package otelstore
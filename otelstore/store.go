@@ -0,0 +1,292 @@
+//go:build go1.18
+// +build go1.18
+
+package otelstore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// TracingStore is a Store with an OpenTelemetry span per operation, named
+// "cloudstorage.<op>" with the op of a cloudstorage.Metric, and with the
+// backend, object name (or listing prefix) and bytes as attributes.  Spans
+// are children of the span in the ctx, the spans of an objects Open, Sync,
+// Close and Delete are children of the span in the ctx it was Got or listed
+// with.
+type TracingStore struct {
+	cloudstorage.Store
+	tracer  trace.Tracer
+	backend string
+}
+
+// NewTracingStore create a store tracing s with tracer, or without one the
+// tracer of the global otel TracerProvider, which is a no-op until one is
+// set.
+func NewTracingStore(s cloudstorage.Store, tracer trace.Tracer) *TracingStore {
+	if tracer == nil {
+		tracer = otel.Tracer("github.com/lytics/cloudstorage")
+	}
+	return &TracingStore{Store: s, tracer: tracer, backend: s.Type()}
+}
+
+func (t *TracingStore) start(ctx context.Context, op, key, name string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "cloudstorage."+op, trace.WithAttributes(
+		attribute.String("cloudstorage.backend", t.backend),
+		attribute.String(key, name),
+	))
+}
+
+func endSpan(span trace.Span, n int64, err error) {
+	if n > 0 {
+		span.SetAttributes(attribute.Int64("cloudstorage.bytes", n))
+	}
+	if err != nil && err != cloudstorage.ErrObjectNotFound && err != iterator.Done && err != io.EOF {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Get an object, in a "cloudstorage.get" span.
+func (t *TracingStore) Get(ctx context.Context, name string) (cloudstorage.Object, error) {
+	sctx, span := t.start(ctx, "get", "cloudstorage.object", name)
+	o, err := t.Store.Get(sctx, name)
+	if err != nil {
+		endSpan(span, 0, err)
+		return nil, err
+	}
+	endSpan(span, o.Size(), nil)
+	return &tracingObject{Object: o, t: t, ctx: ctx}, nil
+}
+
+// Objects iterates objects, in a "cloudstorage.list" span ending once
+// iterated (or closed).
+func (t *TracingStore) Objects(ctx context.Context, q cloudstorage.Query) (cloudstorage.ObjectIterator, error) {
+	sctx, span := t.start(ctx, "list", "cloudstorage.prefix", q.Prefix)
+	iter, err := t.Store.Objects(sctx, q)
+	if err != nil {
+		endSpan(span, 0, err)
+		return nil, err
+	}
+	return &tracingIterator{ObjectIterator: iter, t: t, ctx: ctx, span: span}, nil
+}
+
+// List objects, in a "cloudstorage.list" span.
+func (t *TracingStore) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.ObjectsResponse, error) {
+	sctx, span := t.start(ctx, "list", "cloudstorage.prefix", q.Prefix)
+	resp, err := t.Store.List(sctx, q)
+	endSpan(span, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	for i, o := range resp.Objects {
+		resp.Objects[i] = &tracingObject{Object: o, t: t, ctx: ctx}
+	}
+	return resp, nil
+}
+
+// Folders lists folders, in a "cloudstorage.folders" span.
+func (t *TracingStore) Folders(ctx context.Context, q cloudstorage.Query) ([]string, error) {
+	sctx, span := t.start(ctx, "folders", "cloudstorage.prefix", q.Prefix)
+	folders, err := t.Store.Folders(sctx, q)
+	endSpan(span, 0, err)
+	return folders, err
+}
+
+// NewObject of name, its Open, Sync, Close and Delete are traced without a
+// parent.
+func (t *TracingStore) NewObject(name string) (cloudstorage.Object, error) {
+	o, err := t.Store.NewObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracingObject{Object: o, t: t, ctx: context.Background()}, nil
+}
+
+// NewReader of an object, in a "cloudstorage.read" span ending on Close.
+func (t *TracingStore) NewReader(name string) (io.ReadCloser, error) {
+	return t.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of an object, in a "cloudstorage.read" span ending
+// on Close.
+func (t *TracingStore) NewReaderWithContext(ctx context.Context, name string, opts ...cloudstorage.ReadOptions) (io.ReadCloser, error) {
+	sctx, span := t.start(ctx, "read", "cloudstorage.object", name)
+	rc, err := t.Store.NewReaderWithContext(sctx, name, opts...)
+	if err != nil {
+		endSpan(span, 0, err)
+		return nil, err
+	}
+	return &tracingReader{ReadCloser: rc, span: span}, nil
+}
+
+// NewWriter to an object, in a "cloudstorage.write" span ending on Close.
+func (t *TracingStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return t.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to an object, in a "cloudstorage.write" span ending
+// on Close.
+func (t *TracingStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+	sctx, span := t.start(ctx, "write", "cloudstorage.object", name)
+	wc, err := t.Store.NewWriterWithContext(sctx, name, metadata, opts...)
+	if err != nil {
+		endSpan(span, 0, err)
+		return nil, err
+	}
+	return &tracingWriter{WriteCloser: wc, span: span}, nil
+}
+
+// Delete an object, in a "cloudstorage.delete" span.
+func (t *TracingStore) Delete(ctx context.Context, name string, opts ...cloudstorage.DeleteOptions) error {
+	sctx, span := t.start(ctx, "delete", "cloudstorage.object", name)
+	err := t.Store.Delete(sctx, name, opts...)
+	endSpan(span, 0, err)
+	return err
+}
+
+func (t *TracingStore) String() string {
+	return fmt.Sprintf("tracing(%s)", t.Store)
+}
+
+type tracingIterator struct {
+	cloudstorage.ObjectIterator
+	t    *TracingStore
+	ctx  context.Context
+	span trace.Span
+	once sync.Once
+}
+
+func (it *tracingIterator) Next() (cloudstorage.Object, error) {
+	o, err := it.ObjectIterator.Next()
+	if err != nil {
+		it.once.Do(func() { endSpan(it.span, 0, err) })
+		return nil, err
+	}
+	return &tracingObject{Object: o, t: it.t, ctx: it.ctx}, nil
+}
+
+func (it *tracingIterator) Close() {
+	it.once.Do(func() { endSpan(it.span, 0, nil) })
+	it.ObjectIterator.Close()
+}
+
+// tracingObject traces the calls to the backend of an object, ctx is the
+// parent of their spans.
+type tracingObject struct {
+	cloudstorage.Object
+	t   *TracingStore
+	ctx context.Context
+}
+
+func (o *tracingObject) Open(accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(o.ctx, accesslevel, opts...)
+}
+
+func (o *tracingObject) OpenWithContext(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	sctx, span := o.t.start(ctx, "open", "cloudstorage.object", o.Name())
+	f, err := cloudstorage.OpenWithContext(sctx, o.Object, accesslevel, opts...)
+	if err != nil {
+		endSpan(span, 0, err)
+		return nil, err
+	}
+	endSpan(span, o.Size(), nil)
+	return f, nil
+}
+
+func (o *tracingObject) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	sctx, span := o.t.start(ctx, "read", "cloudstorage.object", o.Name())
+	rc, err := cloudstorage.OpenRange(sctx, o.Object, start, length)
+	if err != nil {
+		endSpan(span, 0, err)
+		return nil, err
+	}
+	return &tracingReader{ReadCloser: rc, span: span}, nil
+}
+
+func (o *tracingObject) Sync() error {
+	_, span := o.t.start(o.ctx, "sync", "cloudstorage.object", o.Name())
+	err := o.Object.Sync()
+	endSpan(span, 0, err)
+	return err
+}
+
+func (o *tracingObject) Close() error {
+	_, span := o.t.start(o.ctx, "close", "cloudstorage.object", o.Name())
+	err := o.Object.Close()
+	endSpan(span, 0, err)
+	return err
+}
+
+func (o *tracingObject) Delete() error {
+	_, span := o.t.start(o.ctx, "delete", "cloudstorage.object", o.Name())
+	err := o.Object.Delete()
+	endSpan(span, 0, err)
+	return err
+}
+
+type tracingReader struct {
+	io.ReadCloser
+	span trace.Span
+	n    int64
+	err  error
+}
+
+func (r *tracingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *tracingReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.err != nil {
+		err = r.err
+	}
+	endSpan(r.span, r.n, err)
+	return err
+}
+
+type tracingWriter struct {
+	io.WriteCloser
+	span trace.Span
+	n    int64
+	err  error
+}
+
+func (w *tracingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n += int64(n)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *tracingWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if w.err != nil && err == nil {
+		err = w.err
+	}
+	endSpan(w.span, w.n, err)
+	return err
+}
+
+func (w *tracingWriter) Resumed() int64 {
+	return cloudstorage.Resumed(w.WriteCloser)
+}
@@ -0,0 +1,96 @@
+//go:build go1.18
+// +build go1.18
+
+package otelstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/localfs"
+	"github.com/lytics/cloudstorage/otelstore"
+)
+
+func newLocalStore(t *testing.T) cloudstorage.Store {
+	os.RemoveAll("/tmp/mockcloud_tracing")
+	store, err := cloudstorage.NewStore(&cloudstorage.Config{
+		Type:       localfs.StoreType,
+		AuthMethod: localfs.AuthFileSystem,
+		LocalFS:    "/tmp/mockcloud_tracing",
+		TmpDir:     "/tmp/localcache_tracing",
+	})
+	assert.Equal(t, nil, err)
+	return store
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key string) string {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracingStore(t *testing.T) {
+	local := newLocalStore(t)
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	store := otelstore.NewTracingStore(local, tracer)
+	ctx, parent := tracer.Start(context.Background(), "pipeline")
+
+	wc, err := store.NewWriterWithContext(ctx, "a.csv", nil)
+	assert.Equal(t, nil, err)
+	wc.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, wc.Close())
+	obj, err := store.Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	_, err = obj.Open(cloudstorage.ReadOnly)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, obj.Close())
+	_, err = store.Get(ctx, "missing.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name())
+	}
+	assert.Equal(t, []string{"cloudstorage.write", "cloudstorage.get", "cloudstorage.open", "cloudstorage.close", "cloudstorage.get", "pipeline"}, names)
+	for _, s := range spans[:5] {
+		assert.Equal(t, parent.SpanContext().SpanID(), s.Parent().SpanID())
+		assert.Equal(t, "localfs", spanAttr(s, "cloudstorage.backend"))
+		assert.Equal(t, codes.Unset, s.Status().Code)
+	}
+	assert.Equal(t, "a.csv", spanAttr(spans[0], "cloudstorage.object"))
+	assert.Equal(t, "6", spanAttr(spans[0], "cloudstorage.bytes"))
+	assert.Equal(t, "6", spanAttr(spans[2], "cloudstorage.bytes"))
+	assert.Equal(t, "missing.csv", spanAttr(spans[4], "cloudstorage.object"))
+
+	// failures are errored spans.
+	store = otelstore.NewTracingStore(cloudstorage.NewReadOnlyStore(local), tracer)
+	assert.Equal(t, cloudstorage.ErrReadOnly, store.Delete(ctx, "a.csv"))
+	spans = recorder.Ended()
+	s := spans[len(spans)-1]
+	assert.Equal(t, "cloudstorage.delete", s.Name())
+	assert.Equal(t, sdktrace.Status{Code: codes.Error, Description: cloudstorage.ErrReadOnly.Error()}, s.Status())
+
+	// without a tracer the global no-op one is used.
+	store = otelstore.NewTracingStore(local, nil)
+	rc, err := store.NewReader("a.csv")
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a,b,c\n", string(b))
+	assert.Equal(t, len(spans), len(recorder.Ended()))
+}
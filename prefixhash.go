@@ -0,0 +1,66 @@
+package cloudstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// PrefixHash is a sha256 digest of the objects under prefix in store, that
+// changes if any object is added, removed or modified, ie to check whether a
+// dataset changed between runs without reading it.  Each object is hashed
+// from its name, size and stored checksum (md5, or else the ETag, or else the
+// Updated time), and those hashes folded in name order, so the digest doesn't
+// depend on listing order or page boundaries.
+func PrefixHash(ctx context.Context, store Store, prefix string) ([]byte, error) {
+	iter, err := store.Objects(ctx, NewQuery(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	type leaf struct {
+		name string
+		sum  []byte
+	}
+	var leaves []leaf
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, leaf{o.Name(), objectHash(o)})
+	}
+	sort.Slice(leaves, func(i, j int) bool { return leaves[i].name < leaves[j].name })
+
+	h := sha256.New()
+	for _, l := range leaves {
+		h.Write(l.sum)
+	}
+	return h.Sum(nil), nil
+}
+
+// objectHash is the leaf hash of o for PrefixHash.
+func objectHash(o Object) []byte {
+	checksum := ""
+	if sum := o.MD5(); sum != nil {
+		checksum = hex.EncodeToString(sum)
+	} else if etag := o.ETag(); etag != "" {
+		checksum = etag
+	} else {
+		checksum = strconv.FormatInt(o.Updated().UnixNano(), 10)
+	}
+	h := sha256.New()
+	for _, field := range []string{o.Name(), strconv.FormatInt(o.Size(), 10), checksum} {
+		h.Write([]byte(field))
+		// separate the fields so they can't run into each other
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,41 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestPrefixHash(t *testing.T) {
+	store := newLocalStore(t, "prefixhash")
+	ctx := context.Background()
+	for _, name := range []string{"data/a.csv", "data/b/c.csv", "other/d.csv"} {
+		writeObject(t, store, name, name)
+	}
+
+	hash := func() []byte {
+		sum, err := cloudstorage.PrefixHash(ctx, store, "data/")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 32, len(sum))
+		return sum
+	}
+	first := hash()
+	assert.Equal(t, first, hash())
+
+	// outside the prefix
+	writeObject(t, store, "other/e.csv", "e")
+	assert.Equal(t, first, hash())
+
+	writeObject(t, store, "data/e.csv", "e")
+	added := hash()
+	assert.NotEqual(t, first, added)
+
+	writeObject(t, store, "data/e.csv", "ee")
+	assert.NotEqual(t, added, hash())
+
+	assert.Equal(t, nil, store.Delete(ctx, "data/e.csv"))
+	assert.Equal(t, first, hash())
+}
@@ -1,12 +1,29 @@
 package cloudstorage
 
 import (
+	"regexp"
 	"sort"
+	"strings"
+	"time"
 )
 
 // Filter func type definition for filtering objects
 type Filter func(objects Objects) Objects
 
+// SortBy is the ordering of objects returned for a Query.
+type SortBy int
+
+const (
+	// SortByName orders objects lexically by name, this is the natural
+	// listing order of the stores so is the default.
+	SortByName SortBy = iota
+	// SortByCustomTime orders objects by ascending CustomTime(), objects without
+	// one sort first, ties are ordered by name.  None of the stores can list
+	// in this order, so the full result set for the query is buffered and
+	// sorted client side before the first object is returned.
+	SortByCustomTime
+)
+
 // Query used to query the cloud source. The primary query is a prefix query like
 // `ls /my-csv-files/baseball/*`.  This is the Request, and includes the
 // PageSize, cursor/next token as well.
@@ -17,8 +34,59 @@ type Query struct {
 	ShowHidden bool     // Show hidden files?
 	Filters    []Filter // Applied to the result sets to filter out Objects (i.e. remove objects by extension)
 	PageSize   int      // PageSize defaults to global, or you can supply an override
+	SortBy     SortBy   // SortBy ordering of results, see SortBy for buffering costs.
+	StartAfter string   // StartAfter key to resume listing from, exclusive so only names after it are listed.
+	// IncludeMetadata populates ContentType, MD5 and MetaData of listed objects.  GCS
+	// listings always include them, Azure adds metadata to the listing, S3 makes a HEAD
+	// request per object.  Stores without them leave them empty.
+	IncludeMetadata bool
+	// Reverse orders results descending per SortBy.  None of the stores list in
+	// reverse, so Objects buffers the full result set (as for SortByCustomTime)
+	// and List only reverses each page.
+	Reverse bool
+	// Limit caps the objects returned by Objects, ie with Reverse the last Limit
+	// objects.  Zero is unlimited.  Unless filtered (see Filtered) pages are
+	// requested for at most the objects still to be returned, so a large
+	// prefix isn't paged through for its first objects.  See Limited.
+	Limit int
+	// MaxStale is how old a cached listing a ListingCacheStore may return,
+	// zero always lists the store.
+	MaxStale time.Duration
+	// ImplicitFolders has Folders on the filesystem stores (localfs, sftp)
+	// return only the directories with objects under them, as object stores
+	// do.  By default they return every directory, including empty ones.
+	ImplicitFolders bool
+	// MinSize and MaxSize list only objects of at least MinSize and at most
+	// MaxSize bytes, from the listed sizes so without requests per object.
+	// Zero is unbounded.  They are applied before Limit.
+	MinSize int64
+	MaxSize int64
+	// UpdatedAfter and UpdatedBefore list only the objects Updated after
+	// and before them, ie those modified since the last run of an
+	// incremental ingest.  None of the stores can filter listings by time,
+	// so they are applied client side to the listed times (every stores
+	// listing has them, there are no requests per object) before Limit.
+	// Zero is unbounded.  See ModifiedAfter and ModifiedBefore.
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	// Suffix and Match list only the objects whose names end in Suffix and
+	// match Match, ie ".csv" under a prefix that also has json and tmp files.
+	// They are applied client side to the prefix listing (by every store,
+	// unlike Filters) before Limit.  See FilterSuffix and FilterMatch.
+	Suffix string
+	Match  *regexp.Regexp
+	// NamesOnly lists objects for their names, ie to delete them all, their
+	// Size is UnknownSize and ContentType, MD5 and MetaData are empty.  GCS
+	// requests only the names (and sizes for MinSize and MaxSize) so the
+	// listing is smaller to send and parse, and localfs doesn't read the
+	// metadata files.  S3, Azure and sftp listings can't be narrowed, so
+	// there it only hides the fields.
+	NamesOnly bool
 }
 
+// UnknownSize is the Size of objects listed with Query.NamesOnly.
+const UnknownSize int64 = -1
+
 // NewQuery create a query for finding files under given prefix.
 func NewQuery(prefix string) Query {
 	return Query{
@@ -57,15 +125,186 @@ func (q *Query) Sorted() *Query {
 	return q
 }
 
+// Limited caps the objects returned by Objects at n, see Limit.
+func (q *Query) Limited(n int) *Query {
+	q.Limit = n
+	return q
+}
+
+// limitedPageSize is the page size to list with once count objects have
+// been returned, at most the objects left to the Limit if the listed objects
+// aren't filtered client side, otherwise PageSize.
+func (q *Query) limitedPageSize(count int) int {
+	if q.Limit <= 0 || q.Filtered() {
+		return q.PageSize
+	}
+	if left := q.Limit - count; q.PageSize <= 0 || left < q.PageSize {
+		return left
+	}
+	return q.PageSize
+}
+
+// FilterSuffix lists only the objects whose names end in suffix, see Suffix.
+func (q *Query) FilterSuffix(suffix string) *Query {
+	q.Suffix = suffix
+	return q
+}
+
+// FilterMatch lists only the objects whose names match re, see Match.
+func (q *Query) FilterMatch(re *regexp.Regexp) *Query {
+	q.Match = re
+	return q
+}
+
+// ModifiedAfter lists only the objects updated after t, see UpdatedAfter.
+func (q *Query) ModifiedAfter(t time.Time) *Query {
+	q.UpdatedAfter = t
+	return q
+}
+
+// ModifiedBefore lists only the objects updated before t, see
+// UpdatedBefore.
+func (q *Query) ModifiedBefore(t time.Time) *Query {
+	q.UpdatedBefore = t
+	return q
+}
+
+// Filtered is true if listed objects are filtered client side, by Filters,
+// MinSize and MaxSize, UpdatedAfter and UpdatedBefore, or Suffix and Match,
+// so a page of the listing can have fewer objects than were listed.
+func (q *Query) Filtered() bool {
+	return len(q.Filters) > 0 || q.MinSize > 0 || q.MaxSize > 0 || q.timeRanged() || q.Suffix != "" || q.Match != nil
+}
+
+func (q *Query) timeRanged() bool {
+	return !q.UpdatedAfter.IsZero() || !q.UpdatedBefore.IsZero()
+}
+
+// Buffered is true if the stores don't list in the query order, so Objects has
+// to buffer the full result set before returning the first object.
+func (q *Query) Buffered() bool {
+	return q.SortBy != SortByName || q.Reverse
+}
+
+// InSizeRange is true if an object of size bytes is listed given MinSize and
+// MaxSize.
+func (q *Query) InSizeRange(size int64) bool {
+	return size >= q.MinSize && (q.MaxSize <= 0 || size <= q.MaxSize)
+}
+
+// InTimeRange is true if an object updated at updated is listed given
+// UpdatedAfter and UpdatedBefore.
+func (q *Query) InTimeRange(updated time.Time) bool {
+	return (q.UpdatedAfter.IsZero() || updated.After(q.UpdatedAfter)) &&
+		(q.UpdatedBefore.IsZero() || updated.Before(q.UpdatedBefore))
+}
+
+// NameMatches is true if the object name is listed given Suffix and Match.
+func (q *Query) NameMatches(name string) bool {
+	return strings.HasSuffix(name, q.Suffix) && (q.Match == nil || q.Match.MatchString(name))
+}
+
+// After is true if the object name is listed given the StartAfter key.
+func (q *Query) After(name string) bool {
+	return name > q.StartAfter
+}
+
 // ApplyFilters is called as the last step in store.List() to filter out the
 // results before they are returned.
 func (q *Query) ApplyFilters(objects Objects) Objects {
+	if q.StartAfter != "" {
+		after := make(Objects, 0, len(objects))
+		for _, o := range objects {
+			if q.After(o.Name()) {
+				after = append(after, o)
+			}
+		}
+		objects = after
+	}
+	objects = q.listFilter(objects)
 	for _, f := range q.Filters {
 		objects = f(objects)
 	}
+	return q.namesOnly(sortObjects(objects, q))
+}
+
+// NameOnly is o as listed by q, ie if it's NamesOnly with only a name.
+func (q *Query) NameOnly(o Object) Object {
+	if _, ok := o.(*nameOnlyObject); ok || !q.NamesOnly {
+		return o
+	}
+	return &nameOnlyObject{o}
+}
+
+// namesOnly is objects as listed by q, see NameOnly.
+func (q *Query) namesOnly(objects Objects) Objects {
+	if !q.NamesOnly {
+		return objects
+	}
+	for i, o := range objects {
+		objects[i] = q.NameOnly(o)
+	}
 	return objects
 }
 
+// nameOnlyObject is an object of a NamesOnly listing.
+type nameOnlyObject struct {
+	Object
+}
+
+func (o *nameOnlyObject) Size() int64                 { return UnknownSize }
+func (o *nameOnlyObject) ContentType() string         { return "" }
+func (o *nameOnlyObject) MD5() []byte                 { return nil }
+func (o *nameOnlyObject) MetaData() map[string]string { return nil }
+
+// listFilter removes the objects not InSizeRange or InTimeRange, or whose
+// names don't NameMatches.  Objects already listed NamesOnly by the store's
+// List were filtered by size before their sizes were hidden.
+func (q *Query) listFilter(objects Objects) Objects {
+	if q.MinSize <= 0 && q.MaxSize <= 0 && !q.timeRanged() && q.Suffix == "" && q.Match == nil {
+		return objects
+	}
+	listed := make(Objects, 0, len(objects))
+	for _, o := range objects {
+		_, nameOnly := o.(*nameOnlyObject)
+		if (nameOnly || q.InSizeRange(o.Size())) && q.InTimeRange(o.Updated()) && q.NameMatches(o.Name()) {
+			listed = append(listed, o)
+		}
+	}
+	return listed
+}
+
+// sortObjects orders objects per the query SortBy and Reverse, SortByName is
+// left as listed unless reversed.
+func sortObjects(objs Objects, q *Query) Objects {
+	switch q.SortBy {
+	case SortByCustomTime:
+		sort.Stable(objectsByCustomTime(objs))
+	default:
+		if q.Reverse {
+			sort.Stable(objs)
+		}
+	}
+	if q.Reverse {
+		for i, j := 0, len(objs)-1; i < j; i, j = i+1, j-1 {
+			objs[i], objs[j] = objs[j], objs[i]
+		}
+	}
+	return objs
+}
+
+type objectsByCustomTime Objects
+
+func (o objectsByCustomTime) Len() int      { return len(o) }
+func (o objectsByCustomTime) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o objectsByCustomTime) Less(i, j int) bool {
+	ti, tj := CustomTime(o[i]), CustomTime(o[j])
+	if ti.Equal(tj) {
+		return o[i].Name() < o[j].Name()
+	}
+	return ti.Before(tj)
+}
+
 var ObjectSortFilter = func(objs Objects) Objects {
 	sort.Stable(objs)
 	return objs
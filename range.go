@@ -0,0 +1,112 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// OpenRange reads length bytes of o from start, -1 for the rest of the
+// object, ie to parse the header of a large file without downloading it.
+// The objects of all the stores implement ObjectRange, with range requests
+// on GCS, S3 and Azure and seeks on localfs and sftp.  Other objects are
+// opened (downloaded) and the range read from the cached copy, which is
+// released on Close of the reader unless o was already open.  A range
+// starting past the end of the object, or that has a length ending past it,
+// is ErrRangeNotSatisfiable.  The rest of an object from its end is empty.
+func OpenRange(ctx context.Context, o Object, start, length int64) (io.ReadCloser, error) {
+	if start < 0 || length < -1 {
+		return nil, fmt.Errorf("invalid range start=%d length=%d", start, length)
+	}
+	if ro, ok := o.(ObjectRange); ok {
+		size := o.Size()
+		if size != UnknownSize {
+			if err := CheckRange(start, length, size); err != nil {
+				return nil, err
+			}
+		}
+		// the providers reject a range of no bytes, ie from the end.
+		if length == 0 || (size != UnknownSize && start == size) {
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		return ro.OpenRange(ctx, start, length)
+	}
+
+	opened := o.File() != nil
+	f, err := OpenWithContext(ctx, o, ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err == nil {
+		err = CheckRange(start, length, fi.Size())
+	}
+	if err != nil {
+		if !opened {
+			o.Release()
+		}
+		return nil, err
+	}
+	if length < 0 {
+		length = fi.Size() - start
+	}
+	return &fileRange{SectionReader: io.NewSectionReader(f, start, length), o: o, release: !opened}, nil
+}
+
+// CheckRange is ErrRangeNotSatisfiable if the range of OpenRange is past the
+// end of an object of size bytes.
+func CheckRange(start, length, size int64) error {
+	if start > size || (length > 0 && start+length > size) {
+		return ErrRangeNotSatisfiable
+	}
+	return nil
+}
+
+// RangeHeader is the http Range header of a range of OpenRange.
+func RangeHeader(start, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", start)
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, start+length-1)
+}
+
+// NewFileRange reads length bytes of the file f from start, -1 for the rest
+// of the file, closing it on Close.  For the OpenRange of the filesystem
+// stores.
+func NewFileRange(f *os.File, start, length int64) (io.ReadCloser, error) {
+	fi, err := f.Stat()
+	if err == nil {
+		err = CheckRange(start, length, fi.Size())
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		length = fi.Size() - start
+	}
+	return &fileRange{SectionReader: io.NewSectionReader(f, start, length), f: f}, nil
+}
+
+// fileRange is a range of a local file, closing the file or releasing the
+// object it is the cached copy of on Close.
+type fileRange struct {
+	*io.SectionReader
+	f       *os.File
+	o       Object
+	release bool
+}
+
+func (r *fileRange) Close() error {
+	if r.f != nil {
+		return r.f.Close()
+	}
+	if r.release {
+		return r.o.Release()
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// rejectingRangeObject fails ranges of no bytes, as the providers answer
+// them with a 416.
+type rejectingRangeObject struct {
+	cloudstorage.Object
+}
+
+func (o *rejectingRangeObject) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	if start >= o.Size() {
+		return nil, cloudstorage.ErrRangeNotSatisfiable
+	}
+	return o.Object.(cloudstorage.ObjectRange).OpenRange(ctx, start, length)
+}
+
+func TestOpenRange(t *testing.T) {
+	local := newLocalStore(t, "range")
+	ctx := context.Background()
+	body := "0123456789abcdef"
+	writeObject(t, local, "a.csv", body)
+
+	readRange := func(s cloudstorage.Store, start, length int64) (string, error) {
+		obj, err := s.Get(ctx, "a.csv")
+		if err != nil {
+			return "", err
+		}
+		rc, err := cloudstorage.OpenRange(ctx, obj, start, length)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		b, err := ioutil.ReadAll(rc)
+		return string(b), err
+	}
+
+	// localfs reads the range of the store file, compressed objects are
+	// opened and read from the cached copy.
+	for _, s := range []cloudstorage.Store{local, cloudstorage.NewCompressedStore(local)} {
+		if s != local {
+			writeObject(t, s, "a.csv", body)
+		}
+		for _, tc := range []struct {
+			start, length int64
+			expected      string
+			err           error
+		}{
+			{0, 4, "0123", nil},
+			{10, 6, "abcdef", nil},
+			{10, -1, "abcdef", nil},
+			{0, -1, body, nil},
+			{3, 0, "", nil},
+			{16, -1, "", nil},
+			{10, 7, "", cloudstorage.ErrRangeNotSatisfiable},
+			{17, -1, "", cloudstorage.ErrRangeNotSatisfiable},
+		} {
+			got, err := readRange(s, tc.start, tc.length)
+			assert.Equal(t, tc.err, err, "%v %d,%d", s, tc.start, tc.length)
+			assert.Equal(t, tc.expected, got, "%v %d,%d", s, tc.start, tc.length)
+		}
+		_, err := readRange(s, -1, 4)
+		assert.NotEqual(t, nil, err)
+		_, err = readRange(s, 0, -2)
+		assert.NotEqual(t, nil, err)
+	}
+
+	// the cached copy of an object that wasn't open is released on Close.
+	obj, err := cloudstorage.NewCompressedStore(local).Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	rc, err := cloudstorage.OpenRange(ctx, obj, 0, 4)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, nil, obj.File())
+	assert.Equal(t, nil, rc.Close())
+	assert.True(t, obj.File() == nil)
+
+	// the wrappers read the range of the store's object.
+	ro, err := cloudstorage.NewReadOnlyStore(local).Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	_, ok := ro.(cloudstorage.ObjectRange)
+	assert.True(t, ok)
+
+	// the rest of the object from its end isn't requested.
+	obj, err = local.Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	rc, err = cloudstorage.OpenRange(ctx, &rejectingRangeObject{obj}, obj.Size(), -1)
+	assert.Equal(t, nil, err)
+	b, err := ioutil.ReadAll(rc)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(b))
+}
@@ -0,0 +1,137 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitMode is what a RateLimitedStore does with a request over its rate.
+type RateLimitMode int
+
+const (
+	// RateLimitBlock waits until the request is within the rate, or the ctx
+	// is done.
+	RateLimitBlock RateLimitMode = iota
+	// RateLimitFail returns ErrRateLimited without waiting.
+	RateLimitFail
+)
+
+// RateLimitedStore is a Store capping the rate of requests to the backend, ie
+// to stay under a provider's per bucket request quota.  Each Get, List,
+// Folders, NewReader, NewWriter and Delete is a request, as is each page of
+// an Objects iteration, reading and writing aren't.  The limiter is of the
+// store, so it is shared by every goroutine using it.
+type RateLimitedStore struct {
+	Store
+	limiter *rate.Limiter
+	mode    RateLimitMode
+}
+
+// NewRateLimitedStore create a store of s allowing perSecond requests, with
+// bursts of up to burst requests.
+func NewRateLimitedStore(s Store, perSecond float64, burst int, mode RateLimitMode) *RateLimitedStore {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimitedStore{Store: s, limiter: rate.NewLimiter(rate.Limit(perSecond), burst), mode: mode}
+}
+
+func (r *RateLimitedStore) wait(ctx context.Context) error {
+	if r.mode == RateLimitFail {
+		if !r.limiter.Allow() {
+			return ErrRateLimited
+		}
+		return nil
+	}
+	return r.limiter.Wait(ctx)
+}
+
+// Get an object once within the rate.
+func (r *RateLimitedStore) Get(ctx context.Context, name string) (Object, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.Store.Get(ctx, name)
+}
+
+// Objects iterates objects a page at a time through List, each page within
+// the rate.  The first is waited for here, so a RateLimitFail store fails
+// the Objects call rather than its first Next.
+func (r *RateLimitedStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return NewObjectPageIterator(ctx, &rateLimitedPages{RateLimitedStore: r, waited: true}, q), nil
+}
+
+// rateLimitedPages lists the pages of an Objects iteration, each within the
+// rate but the first one Objects waited for.
+type rateLimitedPages struct {
+	*RateLimitedStore
+	waited bool
+}
+
+func (p *rateLimitedPages) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	if p.waited {
+		p.waited = false
+		return p.Store.List(ctx, q)
+	}
+	return p.RateLimitedStore.List(ctx, q)
+}
+
+// List objects once within the rate.
+func (r *RateLimitedStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.Store.List(ctx, q)
+}
+
+// Folders lists folders once within the rate.
+func (r *RateLimitedStore) Folders(ctx context.Context, q Query) ([]string, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.Store.Folders(ctx, q)
+}
+
+// NewReader of an object once within the rate.
+func (r *RateLimitedStore) NewReader(name string) (io.ReadCloser, error) {
+	return r.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of an object once within the rate.
+func (r *RateLimitedStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.Store.NewReaderWithContext(ctx, name, opts...)
+}
+
+// NewWriter to an object once within the rate.
+func (r *RateLimitedStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return r.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to an object once within the rate.
+func (r *RateLimitedStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.Store.NewWriterWithContext(ctx, name, metadata, opts...)
+}
+
+// Delete an object once within the rate.
+func (r *RateLimitedStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	if err := r.wait(ctx); err != nil {
+		return err
+	}
+	return r.Store.Delete(ctx, name, opts...)
+}
+
+func (r *RateLimitedStore) String() string {
+	return fmt.Sprintf("ratelimited(%s)", r.Store)
+}
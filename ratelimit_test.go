@@ -0,0 +1,78 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestRateLimitedStore(t *testing.T) {
+	local := newLocalStore(t, "ratelimited")
+	ctx := context.Background()
+	writeObject(t, local, "a.csv", "a,b,c\n")
+
+	// failing, the burst is allowed and then every kind of request fails.
+	store := cloudstorage.NewRateLimitedStore(local, 1, 2, cloudstorage.RateLimitFail)
+	_, err := store.Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	_, err = store.List(ctx, cloudstorage.NewQueryAll())
+	assert.Equal(t, nil, err)
+	_, err = store.Get(ctx, "a.csv")
+	assert.Equal(t, cloudstorage.ErrRateLimited, err)
+	_, err = store.Objects(ctx, cloudstorage.NewQueryAll())
+	assert.Equal(t, cloudstorage.ErrRateLimited, err)
+	_, err = store.NewWriterWithContext(ctx, "b.csv", nil)
+	assert.Equal(t, cloudstorage.ErrRateLimited, err)
+	assert.Equal(t, cloudstorage.ErrRateLimited, store.Delete(ctx, "a.csv"))
+
+	// blocking, the limiter is shared by the goroutines.
+	store = cloudstorage.NewRateLimitedStore(local, 20, 1, cloudstorage.RateLimitBlock)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Get(ctx, "a.csv")
+			assert.Equal(t, nil, err)
+		}()
+	}
+	wg.Wait()
+	assert.True(t, time.Since(start) >= 150*time.Millisecond, "took %v", time.Since(start))
+
+	// blocking until the ctx is done.
+	store = cloudstorage.NewRateLimitedStore(local, 0.1, 1, cloudstorage.RateLimitBlock)
+	assert.Equal(t, nil, store.Delete(ctx, "a.csv"))
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = store.Get(cctx, "a.csv")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestRateLimitedStorePages(t *testing.T) {
+	local := newLocalStore(t, "ratelimited_pages")
+	ctx := context.Background()
+	for _, name := range []string{"a.csv", "b.csv", "c.csv"} {
+		writeObject(t, local, name, "a,b,c\n")
+	}
+
+	// each page of the iteration is a request, the third is over the burst.
+	store := cloudstorage.NewRateLimitedStore(local, 0.1, 2, cloudstorage.RateLimitFail)
+	q := cloudstorage.NewQueryAll()
+	q.PageSize = 1
+	iter, err := store.Objects(ctx, q)
+	assert.Equal(t, nil, err)
+	defer iter.Close()
+	for _, name := range []string{"a.csv", "b.csv"} {
+		o, err := iter.Next()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, name, o.Name())
+	}
+	_, err = iter.Next()
+	assert.Equal(t, cloudstorage.ErrRateLimited, err)
+}
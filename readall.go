@@ -0,0 +1,48 @@
+package cloudstorage
+
+import (
+	"io/ioutil"
+
+	"golang.org/x/net/context"
+)
+
+// ReadAll gets object name from s along with its contents.  With an
+// opts InlineThreshold objects smaller than it are read in a single request
+// on stores that support it (see StoreGetInline), halving the requests for
+// many small objects.  Larger objects, VerifyChecksum reads and other stores
+// Get the object and then read it.
+func ReadAll(ctx context.Context, s Store, name string, opts ...ReadOptions) (Object, []byte, error) {
+	var o Object
+	if len(opts) > 0 && opts[0].InlineThreshold > 0 && !opts[0].VerifyChecksum {
+		if gi, ok := s.(StoreGetInline); ok {
+			obj, b, err := gi.GetInline(ctx, name, opts[0].InlineThreshold)
+			if err != nil {
+				return nil, nil, err
+			}
+			if b != nil {
+				if opts[0].MaxBytes > 0 && int64(len(b)) > opts[0].MaxBytes {
+					return nil, nil, ErrObjectTooLarge
+				}
+				return obj, b, nil
+			}
+			o = obj
+		}
+	}
+	if o == nil {
+		obj, err := s.Get(ctx, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		o = obj
+	}
+	rc, err := s.NewReaderWithContext(ctx, name, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return o, b, nil
+}
@@ -0,0 +1,78 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// inlineStore counts requests, getting small objects inline.
+type inlineStore struct {
+	cloudstorage.Store
+	requests int
+}
+
+func (s *inlineStore) Get(ctx context.Context, name string) (cloudstorage.Object, error) {
+	s.requests++
+	return s.Store.Get(ctx, name)
+}
+
+func (s *inlineStore) NewReaderWithContext(ctx context.Context, name string, opts ...cloudstorage.ReadOptions) (io.ReadCloser, error) {
+	s.requests++
+	return s.Store.NewReaderWithContext(ctx, name, opts...)
+}
+
+func (s *inlineStore) GetInline(ctx context.Context, name string, threshold int64) (cloudstorage.Object, []byte, error) {
+	s.requests++
+	o, err := s.Store.Get(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if o.Size() >= threshold {
+		return o, nil, nil
+	}
+	rc, err := s.Store.NewReaderWithContext(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	return o, b, err
+}
+
+func TestReadAll(t *testing.T) {
+	store := &inlineStore{Store: newLocalStore(t, "readall")}
+	ctx := context.Background()
+	writeObject(t, store, "small.csv", "a,b\n")
+	writeObject(t, store, "large.csv", "a,b\nc,d\ne,f\n")
+	inline := cloudstorage.ReadOptions{InlineThreshold: 8}
+
+	read := func(name string, opts ...cloudstorage.ReadOptions) (string, int) {
+		store.requests = 0
+		o, b, err := cloudstorage.ReadAll(ctx, store, name, opts...)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, name, o.Name())
+		return string(b), store.requests
+	}
+
+	b, n := read("small.csv")
+	assert.Equal(t, "a,b\n", b)
+	assert.Equal(t, 2, n)
+	b, n = read("small.csv", inline)
+	assert.Equal(t, "a,b\n", b)
+	assert.Equal(t, 1, n)
+	// above the threshold it is read separately
+	b, n = read("large.csv", inline)
+	assert.Equal(t, "a,b\nc,d\ne,f\n", b)
+	assert.Equal(t, 2, n)
+
+	_, _, err := cloudstorage.ReadAll(ctx, store, "missing.csv", inline)
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	_, _, err = cloudstorage.ReadAll(ctx, store, "small.csv", cloudstorage.ReadOptions{InlineThreshold: 8, MaxBytes: 2})
+	assert.Equal(t, cloudstorage.ErrObjectTooLarge, err)
+}
@@ -0,0 +1,130 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/net/context"
+)
+
+// ReadOnlyStore is a Store that only reads, ie to pass a store to code that
+// mustn't write to it.  Writes, deletes, copies and moves fail with
+// ErrReadOnly, as do writes to its objects, everything else is passed through
+// to the wrapped store.
+type ReadOnlyStore struct {
+	Store
+}
+
+// NewReadOnlyStore create a read only view of s.
+func NewReadOnlyStore(s Store) *ReadOnlyStore {
+	return &ReadOnlyStore{Store: s}
+}
+
+// Get an object, which can't be written.
+func (r *ReadOnlyStore) Get(ctx context.Context, name string) (Object, error) {
+	o, err := r.Store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyObject{o}, nil
+}
+
+// Objects iterates objects, which can't be written.
+func (r *ReadOnlyStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	iter, err := r.Store.Objects(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyIterator{iter}, nil
+}
+
+// List objects, which can't be written.
+func (r *ReadOnlyStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	resp, err := r.Store.List(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for i, o := range resp.Objects {
+		resp.Objects[i] = &readOnlyObject{o}
+	}
+	return resp, nil
+}
+
+// NewObject is ErrReadOnly.
+func (r *ReadOnlyStore) NewObject(name string) (Object, error) {
+	return nil, ErrReadOnly
+}
+
+// NewWriter is ErrReadOnly.
+func (r *ReadOnlyStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return nil, ErrReadOnly
+}
+
+// NewWriterWithContext is ErrReadOnly.
+func (r *ReadOnlyStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	return nil, ErrReadOnly
+}
+
+// Delete is ErrReadOnly.
+func (r *ReadOnlyStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	return ErrReadOnly
+}
+
+// Copy is ErrReadOnly.
+func (r *ReadOnlyStore) Copy(ctx context.Context, src, dst Object) error {
+	return ErrReadOnly
+}
+
+// Move is ErrReadOnly.
+func (r *ReadOnlyStore) Move(ctx context.Context, src, dst Object) error {
+	return ErrReadOnly
+}
+
+func (r *ReadOnlyStore) String() string {
+	return fmt.Sprintf("readonly(%s)", r.Store)
+}
+
+type readOnlyIterator struct {
+	ObjectIterator
+}
+
+func (it *readOnlyIterator) Next() (Object, error) {
+	o, err := it.ObjectIterator.Next()
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyObject{o}, nil
+}
+
+// readOnlyObject can only be opened ReadOnly, and not written back.
+type readOnlyObject struct {
+	Object
+}
+
+func (o *readOnlyObject) Open(accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+func (o *readOnlyObject) OpenWithContext(ctx context.Context, accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	if accesslevel != ReadOnly {
+		return nil, ErrReadOnly
+	}
+	return OpenWithContext(ctx, o.Object, accesslevel, opts...)
+}
+
+func (o *readOnlyObject) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	return OpenRange(ctx, o.Object, start, length)
+}
+
+func (o *readOnlyObject) Write(p []byte) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (o *readOnlyObject) Sync() error {
+	return ErrReadOnly
+}
+
+func (o *readOnlyObject) Delete() error {
+	return ErrReadOnly
+}
@@ -0,0 +1,44 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestReadOnlyStore(t *testing.T) {
+	store := newLocalStore(t, "readonly")
+	ctx := context.Background()
+	writeObject(t, store, "ro/a.csv", "a,b\n")
+
+	ro := cloudstorage.NewReadOnlyStore(store)
+	assert.Equal(t, "a,b\n", readAll(t, ro, "ro/a.csv"))
+	iter, err := ro.Objects(ctx, cloudstorage.NewQuery("ro/"))
+	assert.Equal(t, nil, err)
+	objs, err := cloudstorage.ObjectsAll(iter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(objs))
+
+	_, err = ro.NewWriterWithContext(ctx, "ro/b.csv", nil)
+	assert.Equal(t, cloudstorage.ErrReadOnly, err)
+	_, err = ro.NewObject("ro/b.csv")
+	assert.Equal(t, cloudstorage.ErrReadOnly, err)
+	assert.Equal(t, cloudstorage.ErrReadOnly, ro.Delete(ctx, "ro/a.csv"))
+
+	src, err := ro.Get(ctx, "ro/a.csv")
+	assert.Equal(t, nil, err)
+	dst, err := store.NewObject("ro/c.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, cloudstorage.ErrReadOnly, cloudstorage.Copy(ctx, ro, src, dst))
+	assert.Equal(t, cloudstorage.ErrReadOnly, cloudstorage.Move(ctx, ro, src, dst))
+
+	// objects are read only too
+	assert.Equal(t, cloudstorage.ErrReadOnly, src.Delete())
+	_, err = src.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, cloudstorage.ErrReadOnly, err)
+	assert.Equal(t, cloudstorage.ErrReadOnly, objs[0].Delete())
+	assert.Equal(t, "a,b\n", readAll(t, store, "ro/a.csv"))
+}
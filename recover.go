@@ -0,0 +1,21 @@
+package cloudstorage
+
+import (
+	"fmt"
+
+	"github.com/araddon/gou"
+)
+
+// RecoverPanic converts a panic in a store operation, ie in a provider's SDK
+// on a malformed response, to an error so it doesn't crash the process.  The
+// stack is logged.  It must be deferred by the operation, with a named error
+// result for it to set:
+//
+//	func (f *FS) Get(ctx context.Context, name string) (_ cloudstorage.Object, err error) {
+//		defer cloudstorage.RecoverPanic("s3 get", &err)
+func RecoverPanic(op string, err *error) {
+	if r := recover(); r != nil {
+		gou.Errorf("%s: panic recovery %v\n %s", op, r, gou.PrettyStack(12))
+		*err = fmt.Errorf("%s: recovered from panic: %v", op, r)
+	}
+}
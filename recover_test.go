@@ -0,0 +1,28 @@
+package cloudstorage_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestRecoverPanic(t *testing.T) {
+	get := func() (err error) {
+		defer cloudstorage.RecoverPanic("fake get", &err)
+		var m map[string]string
+		m["a"] = "b"
+		return nil
+	}
+	err := get()
+	assert.NotEqual(t, nil, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "fake get: recovered from panic"), err.Error())
+
+	ok := func() (err error) {
+		defer cloudstorage.RecoverPanic("fake get", &err)
+		return nil
+	}
+	assert.Equal(t, nil, ok())
+}
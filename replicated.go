@@ -0,0 +1,311 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/araddon/gou"
+	"golang.org/x/net/context"
+)
+
+// ReplicaPolicy is how a ReplicatedStore handles a replica failing to apply a
+// write or delete the primary has already applied.
+type ReplicaPolicy int
+
+const (
+	// ReplicaBestEffort logs replica failures, the operation succeeds if the
+	// primary succeeded.
+	ReplicaBestEffort ReplicaPolicy = iota
+	// ReplicaStrict fails the operation with a *ReplicaError naming the
+	// replicas that diverged from the primary.
+	ReplicaStrict
+)
+
+// ReplicaError is returned in ReplicaStrict mode when the primary applied a
+// write or delete but some replicas did not, so they have diverged.
+type ReplicaError struct {
+	// Name of the object.
+	Name string
+	// Diverged are the replicas that failed, with their errors in Errs.
+	Diverged []Store
+	Errs     []error
+}
+
+func (e *ReplicaError) Error() string {
+	msgs := make([]string, len(e.Diverged))
+	for i, s := range e.Diverged {
+		msgs[i] = fmt.Sprintf("%s: %v", s, e.Errs[i])
+	}
+	return fmt.Sprintf("replicas diverged for %q: %s", e.Name, strings.Join(msgs, ", "))
+}
+
+// ReplicatedStore is a Store that synchronously mirrors writes and deletes to
+// replica stores, ie for disaster recovery.  Each write or delete is applied
+// to the primary, and once that has succeeded to each replica in turn.  A
+// replica is written by copying the committed object back from the primary,
+// so replicas only ever get objects the primary has.  Reads are from the
+// primary.
+type ReplicatedStore struct {
+	Store
+	replicas []Store
+	// Policy for replica failures, defaults to ReplicaBestEffort.
+	Policy ReplicaPolicy
+	// Failover reads to the replicas, in order, if the primary returns an
+	// error (including ErrObjectNotFound).  Objects read from a replica are
+	// that replica's and aren't replicated.
+	Failover bool
+}
+
+// NewReplicatedStore create a store writing to primary and replicas.
+func NewReplicatedStore(primary Store, replicas ...Store) *ReplicatedStore {
+	return &ReplicatedStore{Store: primary, replicas: replicas}
+}
+
+// Get an object from the primary, see Failover.
+func (r *ReplicatedStore) Get(ctx context.Context, name string) (Object, error) {
+	o, err := r.Store.Get(ctx, name)
+	if err == nil {
+		return r.wrap(o), nil
+	}
+	for i := 0; r.Failover && i < len(r.replicas); i++ {
+		if o, rerr := r.replicas[i].Get(ctx, name); rerr == nil {
+			return o, nil
+		}
+	}
+	return nil, err
+}
+
+// Objects iterates the primary objects.
+func (r *ReplicatedStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	iter, err := r.Store.Objects(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &replicatedIterator{iter, r}, nil
+}
+
+// List the primary objects.
+func (r *ReplicatedStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	resp, err := r.Store.List(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for i, o := range resp.Objects {
+		resp.Objects[i] = r.wrap(o)
+	}
+	return resp, nil
+}
+
+// NewReader of the primary object, see Failover.
+func (r *ReplicatedStore) NewReader(name string) (io.ReadCloser, error) {
+	return r.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of the primary object, see Failover.
+func (r *ReplicatedStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	rc, err := r.Store.NewReaderWithContext(ctx, name, opts...)
+	if err == nil {
+		return rc, nil
+	}
+	for i := 0; r.Failover && i < len(r.replicas); i++ {
+		if rc, rerr := r.replicas[i].NewReaderWithContext(ctx, name, opts...); rerr == nil {
+			return rc, nil
+		}
+	}
+	return nil, err
+}
+
+// NewWriter to the primary, replicated on Close.
+func (r *ReplicatedStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return r.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to the primary, replicated on Close.
+func (r *ReplicatedStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	wc, err := r.Store.NewWriterWithContext(ctx, name, metadata, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &replicatedWriter{wc, ctx, r, name}, nil
+}
+
+// NewObject on the primary, replicated when it is Sync'd or Closed.
+func (r *ReplicatedStore) NewObject(name string) (Object, error) {
+	o, err := r.Store.NewObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.wrap(o), nil
+}
+
+// Delete from the primary then the replicas.  DeleteOptions are conditions on
+// the primary object only, the replica copies have their own ETags.
+func (r *ReplicatedStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	err := r.Store.Delete(ctx, name, opts...)
+	if err != nil && err != ErrObjectNotFound {
+		return err
+	}
+	// the replicas may still have it even if the primary didn't
+	var ropts []DeleteOptions
+	if len(opts) > 0 && opts[0].WaitConsistent {
+		ropts = append(ropts, DeleteOptions{WaitConsistent: true})
+	}
+	if rerr := r.deleteReplicas(ctx, name, ropts...); err == nil {
+		return rerr
+	}
+	return err
+}
+
+func (r *ReplicatedStore) String() string {
+	return fmt.Sprintf("replicated(%s)", r.Store)
+}
+
+func (r *ReplicatedStore) wrap(o Object) Object {
+	return &replicatedObject{Object: o, r: r}
+}
+
+// replicate copies the primary object to each replica.
+func (r *ReplicatedStore) replicate(ctx context.Context, name string) error {
+	obj, err := r.Store.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	var failed []Store
+	var errs []error
+	for _, replica := range r.replicas {
+		if err := copyToReplica(ctx, r.Store, replica, obj); err != nil {
+			failed = append(failed, replica)
+			errs = append(errs, err)
+		}
+	}
+	return r.diverged(name, failed, errs)
+}
+
+func copyToReplica(ctx context.Context, primary, replica Store, obj Object) error {
+	rc, err := primary.NewReaderWithContext(ctx, obj.Name())
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	md := make(map[string]string, len(obj.MetaData()))
+	for k, v := range obj.MetaData() {
+		md[k] = v
+	}
+	wc, err := replica.NewWriterWithContext(ctx, obj.Name(), md)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(wc, rc); err != nil {
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+func (r *ReplicatedStore) deleteReplicas(ctx context.Context, name string, opts ...DeleteOptions) error {
+	var failed []Store
+	var errs []error
+	for _, replica := range r.replicas {
+		if err := replica.Delete(ctx, name, opts...); err != nil && err != ErrObjectNotFound {
+			failed = append(failed, replica)
+			errs = append(errs, err)
+		}
+	}
+	return r.diverged(name, failed, errs)
+}
+
+// diverged applies the Policy to the replica failures.
+func (r *ReplicatedStore) diverged(name string, failed []Store, errs []error) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	if r.Policy == ReplicaStrict {
+		return &ReplicaError{Name: name, Diverged: failed, Errs: errs}
+	}
+	for i, s := range failed {
+		gou.Warnf("replica %s failed for %q err=%v", s, name, errs[i])
+	}
+	return nil
+}
+
+type replicatedWriter struct {
+	io.WriteCloser
+	ctx  context.Context
+	r    *ReplicatedStore
+	name string
+}
+
+func (w *replicatedWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.r.replicate(w.ctx, w.name)
+}
+
+func (w *replicatedWriter) Resumed() int64 {
+	return Resumed(w.WriteCloser)
+}
+
+type replicatedIterator struct {
+	ObjectIterator
+	r *ReplicatedStore
+}
+
+func (it *replicatedIterator) Next() (Object, error) {
+	o, err := it.ObjectIterator.Next()
+	if err != nil {
+		return nil, err
+	}
+	return it.r.wrap(o), nil
+}
+
+// replicatedObject replicates the primary object when it is written back.
+type replicatedObject struct {
+	Object
+	r        *ReplicatedStore
+	writable bool
+}
+
+func (o *replicatedObject) Open(accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+func (o *replicatedObject) OpenWithContext(ctx context.Context, accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	f, err := OpenWithContext(ctx, o.Object, accesslevel, opts...)
+	if err == nil && accesslevel == ReadWrite {
+		o.writable = true
+	}
+	return f, err
+}
+
+func (o *replicatedObject) OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error) {
+	return OpenRange(ctx, o.Object, start, length)
+}
+
+func (o *replicatedObject) Sync() error {
+	if err := o.Object.Sync(); err != nil {
+		return err
+	}
+	return o.r.replicate(context.Background(), o.Name())
+}
+
+func (o *replicatedObject) Close() error {
+	writable := o.writable
+	o.writable = false
+	if err := o.Object.Close(); err != nil {
+		return err
+	}
+	if !writable {
+		return nil
+	}
+	return o.r.replicate(context.Background(), o.Name())
+}
+
+func (o *replicatedObject) Delete() error {
+	if err := o.Object.Delete(); err != nil {
+		return err
+	}
+	return o.r.deleteReplicas(context.Background(), o.Name())
+}
@@ -0,0 +1,119 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/localfs"
+	"github.com/lytics/cloudstorage/testutils"
+)
+
+// failingStore fails all writes and deletes.
+type failingStore struct {
+	cloudstorage.Store
+}
+
+func (s *failingStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("replica unavailable")
+}
+
+func (s *failingStore) Delete(ctx context.Context, name string, opts ...cloudstorage.DeleteOptions) error {
+	return fmt.Errorf("replica unavailable")
+}
+
+func readAll(t *testing.T, s cloudstorage.Store, name string) string {
+	rc, err := s.NewReader(name)
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	assert.Equal(t, nil, err)
+	return string(b)
+}
+
+func TestReplicatedStore(t *testing.T) {
+	primary := newLocalStore(t, "primary")
+	replica := newLocalStore(t, "replica")
+	store := cloudstorage.NewReplicatedStore(primary, replica)
+
+	testutils.RunTests(t, store, &cloudstorage.Config{
+		Type:    localfs.StoreType,
+		LocalFS: "/tmp/mockcloud_primary",
+		TmpDir:  "/tmp/localcache_primary",
+	})
+
+	ctx := context.Background()
+	w, err := store.NewWriterWithContext(ctx, "dr/a.csv", map[string]string{"k": "v"})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+	assert.Equal(t, "a,b\n", readAll(t, replica, "dr/a.csv"))
+	obj, err := replica.Get(ctx, "dr/a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "v", obj.MetaData()["k"])
+
+	// objects written through Open/Close are replicated
+	obj, err = store.NewObject("dr/b.csv")
+	assert.Equal(t, nil, err)
+	f, err := obj.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, nil, err)
+	f.WriteString("c,d\n")
+	assert.Equal(t, nil, obj.Close())
+	assert.Equal(t, "c,d\n", readAll(t, replica, "dr/b.csv"))
+
+	assert.Equal(t, nil, store.Delete(ctx, "dr/a.csv"))
+	_, err = replica.Get(ctx, "dr/a.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// failover reads from the replica
+	assert.Equal(t, nil, primary.Delete(ctx, "dr/b.csv"))
+	_, err = store.Get(ctx, "dr/b.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	store.Failover = true
+	assert.Equal(t, "c,d\n", readAll(t, store, "dr/b.csv"))
+}
+
+func TestReplicatedStorePolicy(t *testing.T) {
+	primary := newLocalStore(t, "primary_policy")
+	replica := newLocalStore(t, "replica_policy")
+	broken := &failingStore{newLocalStore(t, "broken_policy")}
+	store := cloudstorage.NewReplicatedStore(primary, broken, replica)
+	ctx := context.Background()
+
+	write := func(name string) error {
+		w, err := store.NewWriterWithContext(ctx, name, nil)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(name))
+		assert.Equal(t, nil, err)
+		return w.Close()
+	}
+
+	// best effort, the working replica still gets it
+	assert.Equal(t, nil, write("a.csv"))
+	assert.Equal(t, "a.csv", readAll(t, replica, "a.csv"))
+
+	store.Policy = cloudstorage.ReplicaStrict
+	err := write("b.csv")
+	rerr, ok := err.(*cloudstorage.ReplicaError)
+	assert.True(t, ok, "expected a ReplicaError got %v", err)
+	if ok {
+		assert.Equal(t, "b.csv", rerr.Name)
+		assert.Equal(t, []cloudstorage.Store{broken}, rerr.Diverged)
+	}
+	assert.Equal(t, "b.csv", readAll(t, primary, "b.csv"))
+	assert.Equal(t, "b.csv", readAll(t, replica, "b.csv"))
+
+	_, ok = store.Delete(ctx, "b.csv").(*cloudstorage.ReplicaError)
+	assert.True(t, ok)
+	_, err = replica.Get(ctx, "b.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+}
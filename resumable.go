@@ -0,0 +1,83 @@
+package cloudstorage
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// uploadSessionExt is the extension of the files resumable upload sessions
+// are persisted in, the cache cleanup leaves them alone.
+const uploadSessionExt = ".upload"
+
+// Resumes is true if a write with opts is a resumable upload, see
+// Opts.Resume.  It is ErrConditionNotSupported if opts has anything the
+// resumed upload can't honor, as the bytes written before aren't seen again.
+func Resumes(metadata map[string]string, opts []Opts) (bool, error) {
+	if len(opts) == 0 || !opts[0].Resume {
+		return false, nil
+	}
+	o := opts[0]
+	if o.IfNotExists || o.IfMatch != "" || o.IfGenerationMatch != 0 || o.ContentMD5 != nil ||
+		o.SkipIfIdentical || o.VerifyOnClose || DetectsContentType(metadata, opts) {
+		return false, ErrConditionNotSupported
+	}
+	return true, nil
+}
+
+// Resumed is the number of bytes of the object the resumable upload of w
+// already has, the caller writes the rest of the object from there.  0 for
+// new uploads and writers that don't resume, see Opts.Resume.
+func Resumed(w io.Writer) int64 {
+	if r, ok := w.(WriterResume); ok {
+		return r.Resumed()
+	}
+	return 0
+}
+
+// UploadSessionPath is the file in cachepath the session of a resumable
+// upload of object name to bucket of storeType is persisted in.  It is the
+// same for every store of the bucket, so a new process finds it.
+func UploadSessionPath(cachepath, storeType, bucket, name string) string {
+	return filepath.Join(cachepath, "uploads", storeType, bucket, name+uploadSessionExt)
+}
+
+// LoadUploadSession reads the session persisted at path into v, false if
+// there isn't one.
+func LoadUploadSession(path string, v interface{}) (bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SaveUploadSession persists the session v at path, replacing the file so a
+// crash part way through leaves the previous session.
+func SaveUploadSession(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := EnsureDir(path); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RemoveUploadSession removes the session persisted at path once its upload
+// is done.
+func RemoveUploadSession(path string) {
+	os.Remove(path)
+}
@@ -0,0 +1,49 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestUploadSession(t *testing.T) {
+	os.RemoveAll("/tmp/localcache_resume")
+	path := cloudstorage.UploadSessionPath("/tmp/localcache_resume", "s3", "bucket", "a/b.csv")
+	assert.Equal(t, "/tmp/localcache_resume/uploads/s3/bucket/a/b.csv.upload", path)
+
+	type session struct {
+		ID string
+	}
+	var sess session
+	ok, err := cloudstorage.LoadUploadSession(path, &sess)
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+	assert.Equal(t, nil, cloudstorage.SaveUploadSession(path, session{ID: "upload1"}))
+	ok, err = cloudstorage.LoadUploadSession(path, &sess)
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	assert.Equal(t, "upload1", sess.ID)
+	cloudstorage.RemoveUploadSession(path)
+	ok, err = cloudstorage.LoadUploadSession(path, &sess)
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+
+	resumes, err := cloudstorage.Resumes(nil, []cloudstorage.Opts{{Resume: true}})
+	assert.Equal(t, nil, err)
+	assert.True(t, resumes)
+	_, err = cloudstorage.Resumes(nil, []cloudstorage.Opts{{Resume: true, ContentMD5: []byte("x")}})
+	assert.Equal(t, cloudstorage.ErrConditionNotSupported, err)
+
+	// stores that don't resume write the whole object.
+	store := newLocalStore(t, "resume")
+	wc, err := store.NewWriterWithContext(context.Background(), "a.csv", nil, cloudstorage.Opts{Resume: true})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), cloudstorage.Resumed(wc))
+	wc.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, wc.Close())
+	assert.Equal(t, "a,b,c\n", readAll(t, store, "a.csv"))
+}
@@ -0,0 +1,195 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// DefaultRetryAttempts is the MaxAttempts of a RetryableStore if unset.
+	DefaultRetryAttempts = 4
+	// DefaultRetryDelay is the BaseDelay of a RetryableStore if unset.
+	DefaultRetryDelay = 100 * time.Millisecond
+	// maxRetryDelay caps the backoff of a single retry.
+	maxRetryDelay = 16 * time.Second
+)
+
+// RetryableStore is a Store retrying the operations that fail with transient
+// errors (see IsTransient), ie the intermittent 500s and connection resets
+// of S3 and GCS.  Attempts are spaced by an exponential backoff with full
+// jitter: retry n waits a random period in [0, BaseDelay*2^n), and no retry
+// is made once ctx is done.  Get, List, Folders, Objects, Delete and the
+// opening of readers and writers are retried.  The bytes of readers and
+// writers are streamed so their Reads, Writes and Close aren't, nor are the
+// Next of iterators (the ObjectPageIterator of most stores retries its
+// pages), nor operations on objects.
+type RetryableStore struct {
+	Store
+	// MaxAttempts of an operation, including the first.
+	MaxAttempts int
+	// BaseDelay of the backoff, the longest wait before the first retry.
+	BaseDelay time.Duration
+	// Retryable is whether an error is worth retrying, IsTransient if nil.
+	Retryable func(err error) bool
+}
+
+// NewRetryableStore create a store retrying the operations of s up to
+// maxAttempts times with a backoff of baseDelay, zero values use
+// DefaultRetryAttempts and DefaultRetryDelay.
+func NewRetryableStore(s Store, maxAttempts int, baseDelay time.Duration) *RetryableStore {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultRetryAttempts
+	}
+	if baseDelay <= 0 {
+		baseDelay = DefaultRetryDelay
+	}
+	return &RetryableStore{Store: s, MaxAttempts: maxAttempts, BaseDelay: baseDelay}
+}
+
+// statusRe matches the http status of errors only carrying it in their
+// message, ie "googleapi: Error 503: Backend Error".
+var statusRe = regexp.MustCompile(`(?i)(error|status ?code[=:]?) ?5[0-9][0-9]\b`)
+
+// IsTransient is true if err is likely to succeed when retried:  timeouts,
+// connection resets and unexpected EOFs, and the 5xx and 429 (rate limited)
+// responses of the stores.  ErrObjectNotFound, context errors, auth failures
+// and other 4xx responses aren't.
+func IsTransient(err error) bool {
+	switch err {
+	case nil, ErrObjectNotFound, context.Canceled, context.DeadlineExceeded:
+		return false
+	case io.ErrUnexpectedEOF:
+		return true
+	}
+	// ie the awserr.RequestFailure of S3.
+	if sc, ok := err.(interface {
+		StatusCode() int
+	}); ok {
+		code := sc.StatusCode()
+		return code >= 500 || code == 429
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "connection reset"),
+		strings.Contains(msg, "broken pipe"),
+		strings.Contains(msg, "i/o timeout"),
+		strings.Contains(msg, "TLS handshake timeout"),
+		strings.Contains(msg, "Error 429"):
+		return true
+	}
+	return statusRe.MatchString(msg)
+}
+
+// retry calls fn until it succeeds, fails with an error that isn't
+// retryable, MaxAttempts is reached, or ctx is done.
+func (r *RetryableStore) retry(ctx context.Context, fn func() error) error {
+	retryable := r.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	var err error
+	for try := 0; ; try++ {
+		if err = fn(); err == nil || !retryable(err) || try+1 >= r.MaxAttempts {
+			return err
+		}
+		select {
+		case <-time.After(r.backoff(try)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// backoff is the random wait before retry try.
+func (r *RetryableStore) backoff(try int) time.Duration {
+	d := r.BaseDelay << uint(try)
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// Get an object, with retries.
+func (r *RetryableStore) Get(ctx context.Context, name string) (o Object, err error) {
+	err = r.retry(ctx, func() error {
+		o, err = r.Store.Get(ctx, name)
+		return err
+	})
+	return o, err
+}
+
+// Objects iterates objects, retrying the creation of the iterator.
+func (r *RetryableStore) Objects(ctx context.Context, q Query) (iter ObjectIterator, err error) {
+	err = r.retry(ctx, func() error {
+		iter, err = r.Store.Objects(ctx, q)
+		return err
+	})
+	return iter, err
+}
+
+// List objects, with retries.
+func (r *RetryableStore) List(ctx context.Context, q Query) (resp *ObjectsResponse, err error) {
+	err = r.retry(ctx, func() error {
+		resp, err = r.Store.List(ctx, q)
+		return err
+	})
+	return resp, err
+}
+
+// Folders lists folders, with retries.
+func (r *RetryableStore) Folders(ctx context.Context, q Query) (folders []string, err error) {
+	err = r.retry(ctx, func() error {
+		folders, err = r.Store.Folders(ctx, q)
+		return err
+	})
+	return folders, err
+}
+
+// NewReader of an object, retrying the open.
+func (r *RetryableStore) NewReader(name string) (io.ReadCloser, error) {
+	return r.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of an object, retrying the open.
+func (r *RetryableStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (rc io.ReadCloser, err error) {
+	err = r.retry(ctx, func() error {
+		rc, err = r.Store.NewReaderWithContext(ctx, name, opts...)
+		return err
+	})
+	return rc, err
+}
+
+// NewWriter to an object, retrying the open.
+func (r *RetryableStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return r.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to an object, retrying the open.
+func (r *RetryableStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (wc io.WriteCloser, err error) {
+	err = r.retry(ctx, func() error {
+		wc, err = r.Store.NewWriterWithContext(ctx, name, metadata, opts...)
+		return err
+	})
+	return wc, err
+}
+
+// Delete an object, with retries.
+func (r *RetryableStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	return r.retry(ctx, func() error {
+		return r.Store.Delete(ctx, name, opts...)
+	})
+}
+
+func (r *RetryableStore) String() string {
+	return fmt.Sprintf("retryable(%s)", r.Store)
+}
@@ -0,0 +1,87 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// flakyStore fails its first failures Gets with err.
+type flakyStore struct {
+	cloudstorage.Store
+	failures int
+	err      error
+	gets     int
+}
+
+func (s *flakyStore) Get(ctx context.Context, name string) (cloudstorage.Object, error) {
+	s.gets++
+	if s.gets <= s.failures {
+		return nil, s.err
+	}
+	return s.Store.Get(ctx, name)
+}
+
+type statusError int
+
+func (e statusError) Error() string   { return fmt.Sprintf("status %d", int(e)) }
+func (e statusError) StatusCode() int { return int(e) }
+
+func TestIsTransient(t *testing.T) {
+	assert.False(t, cloudstorage.IsTransient(nil))
+	assert.False(t, cloudstorage.IsTransient(cloudstorage.ErrObjectNotFound))
+	assert.False(t, cloudstorage.IsTransient(context.Canceled))
+	assert.False(t, cloudstorage.IsTransient(statusError(403)))
+	assert.False(t, cloudstorage.IsTransient(fmt.Errorf("googleapi: Error 401: Invalid Credentials")))
+
+	assert.True(t, cloudstorage.IsTransient(statusError(503)))
+	assert.True(t, cloudstorage.IsTransient(statusError(429)))
+	assert.True(t, cloudstorage.IsTransient(io.ErrUnexpectedEOF))
+	assert.True(t, cloudstorage.IsTransient(fmt.Errorf("googleapi: Error 503: Backend Error")))
+	assert.True(t, cloudstorage.IsTransient(fmt.Errorf("read tcp 10.0.0.1:443: read: connection reset by peer")))
+}
+
+func TestRetryableStore(t *testing.T) {
+	local := newLocalStore(t, "retryable")
+	writeObject(t, local, "a.csv", "a")
+	ctx := context.Background()
+
+	flaky := &flakyStore{Store: local, failures: 2, err: statusError(500)}
+	store := cloudstorage.NewRetryableStore(flaky, 3, time.Millisecond)
+	o, err := store.Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a.csv", o.Name())
+	assert.Equal(t, 3, flaky.gets)
+
+	// gives up after MaxAttempts.
+	flaky.gets, flaky.failures = 0, 5
+	_, err = store.Get(ctx, "a.csv")
+	assert.Equal(t, statusError(500), err)
+	assert.Equal(t, 3, flaky.gets)
+
+	// errors that aren't transient aren't retried.
+	flaky.gets, flaky.err = 0, statusError(403)
+	_, err = store.Get(ctx, "a.csv")
+	assert.Equal(t, statusError(403), err)
+	assert.Equal(t, 1, flaky.gets)
+	flaky.gets, flaky.failures = 0, 0
+	_, err = store.Get(ctx, "missing.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	assert.Equal(t, 1, flaky.gets)
+
+	// nor once the context is done.
+	flaky.gets, flaky.failures, flaky.err = 0, 5, statusError(503)
+	store = cloudstorage.NewRetryableStore(flaky, 5, time.Hour)
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err = store.Get(cctx, "a.csv")
+	assert.Equal(t, statusError(503), err)
+	assert.True(t, time.Since(started) < time.Minute)
+}
@@ -0,0 +1,134 @@
+package cloudstorage
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// RetypePrefix sets the content type of every object under prefix in s, to
+// override if it is set, or if detect the type detected from its name and
+// contents (see DetectContentType).  Only the metadata is updated (see
+// UpdateMetadata), objects aren't re-uploaded, and objects that already have
+// the type are skipped.  concurrency objects are updated at once, defaults to
+// DefaultCopyConcurrency.  Returns the number of objects changed.
+func RetypePrefix(ctx context.Context, s Store, prefix string, detect bool, override string, concurrency int) (int, error) {
+	if override == "" && !detect {
+		return 0, nil
+	}
+	if _, ok := s.(StoreUpdateMetadata); !ok {
+		return 0, ErrFeatureNotSupported
+	}
+	workers := concurrency
+	if workers < 1 {
+		workers = DefaultCopyConcurrency
+	}
+
+	iter, err := s.Objects(ctx, NewQuery(prefix))
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var changed int64
+	names := make(chan string)
+	errs := make(chan error, workers+1)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				ok, err := retypeObject(ctx, s, name, detect, override)
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				if ok {
+					atomic.AddInt64(&changed, 1)
+				}
+			}
+		}()
+	}
+
+produce:
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			errs <- err
+			break
+		}
+		select {
+		case names <- o.Name():
+		case <-ctx.Done():
+			break produce
+		}
+	}
+	close(names)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return int(changed), err
+	default:
+	}
+	return int(changed), ctx.Err()
+}
+
+// retypeObject sets the content type of object name, false if it already had
+// the type.
+func retypeObject(ctx context.Context, s Store, name string, detect bool, override string) (bool, error) {
+	// listings may not have the metadata, Get has all of it
+	o, err := s.Get(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	ctype := override
+	if ctype == "" {
+		if ctype, err = sniffContentType(ctx, s, name); err != nil {
+			return false, err
+		}
+	}
+	if o.ContentType() == ctype {
+		return false, nil
+	}
+	md := make(map[string]string, len(o.MetaData())+1)
+	for k, v := range o.MetaData() {
+		md[k] = v
+	}
+	md[ContentTypeKey] = ctype
+	if err := UpdateMetadata(ctx, s, name, md); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sniffContentType detects the content type of object name, reading its
+// first bytes only if the name doesn't have a known extension.
+func sniffContentType(ctx context.Context, s Store, name string) (string, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype, nil
+	}
+	rc, err := s.NewReaderWithContext(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, sniffLen))
+	if err != nil {
+		return "", err
+	}
+	return DetectContentType(name, data), nil
+}
@@ -0,0 +1,65 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestRetypePrefix(t *testing.T) {
+	store := newLocalStore(t, "retype")
+	ctx := context.Background()
+
+	write := func(name, ctype, body string) {
+		md := map[string]string{"owner": "retype"}
+		if ctype != "" {
+			md[cloudstorage.ContentTypeKey] = ctype
+		}
+		w, err := store.NewWriterWithContext(ctx, name, md)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(body))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+	}
+	contentType := func(name string) string {
+		obj, err := store.Get(ctx, name)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "retype", obj.MetaData()["owner"])
+		return obj.ContentType()
+	}
+
+	write("docs/page.html", "text/plain", "<p>hi</p>")
+	write("docs/noext", "", "<html><body>hi</body></html>")
+	write("docs/data.json", "application/json", "{}")
+	write("other/page.html", "text/plain", "<p>hi</p>")
+
+	// detected from the extension, or sniffed without one
+	n, err := cloudstorage.RetypePrefix(ctx, store, "docs/", true, "", 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "text/html; charset=utf-8", contentType("docs/page.html"))
+	assert.Equal(t, "text/html; charset=utf-8", contentType("docs/noext"))
+	assert.Equal(t, "application/json", contentType("docs/data.json"))
+	assert.Equal(t, "text/plain", contentType("other/page.html"))
+
+	// already detected, nothing changes
+	n, err = cloudstorage.RetypePrefix(ctx, store, "docs/", true, "", 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, n)
+
+	// override wins over detect
+	n, err = cloudstorage.RetypePrefix(ctx, store, "docs/", true, "application/octet-stream", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "application/octet-stream", contentType("docs/data.json"))
+
+	rc, err := store.NewReader("docs/page.html")
+	assert.Equal(t, nil, err)
+	b := make([]byte, 64)
+	m, _ := rc.Read(b)
+	rc.Close()
+	assert.Equal(t, "<p>hi</p>", string(b[:m]))
+}
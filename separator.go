@@ -0,0 +1,48 @@
+package cloudstorage
+
+import "strings"
+
+// DefaultSeparator is the folder separator of object names if the Config
+// doesn't set one.
+const DefaultSeparator = "/"
+
+// KeySeparator is the separator of the folders in object names, ie ":" for
+// keys like "logs:2024:01:a.log".  The object stores use it as the delimiter
+// of Folders and ListLevel, the filesystem stores (localfs, sftp) store the
+// objects in directories named by the folders so names there mustn't also
+// contain "/".
+func (c *Config) KeySeparator() string {
+	if c.Separator == "" {
+		return DefaultSeparator
+	}
+	return c.Separator
+}
+
+// Separator is the folder separator of store s, DefaultSeparator unless it
+// implements StoreSeparator.
+func Separator(s Store) string {
+	if ss, ok := s.(StoreSeparator); ok {
+		if sep := ss.KeySeparator(); sep != "" {
+			return sep
+		}
+	}
+	return DefaultSeparator
+}
+
+// KeyToPath is the filesystem path of the object name with folders separated
+// by sep.
+func KeyToPath(name, sep string) string {
+	if sep == "" || sep == DefaultSeparator {
+		return name
+	}
+	return strings.Replace(name, sep, "/", -1)
+}
+
+// PathToKey is the object name with folders separated by sep of the
+// filesystem path, the inverse of KeyToPath.
+func PathToKey(p, sep string) string {
+	if sep == "" || sep == DefaultSeparator {
+		return p
+	}
+	return strings.Replace(p, "/", sep, -1)
+}
@@ -2,6 +2,7 @@ package sftp
 
 import (
 	"bytes"
+	"crypto/md5"
 	"fmt"
 	"io"
 	"os"
@@ -16,6 +17,7 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/lytics/cloudstorage"
+	"github.com/lytics/cloudstorage/csbufio"
 )
 
 const (
@@ -75,6 +77,9 @@ type (
 		bucket    string
 		files     []string
 		paths     map[string]struct{}
+		// separator of the folders in object names, which are directories
+		// on the server.
+		separator string
 	}
 
 	// File represents sftp File
@@ -87,6 +92,10 @@ type (
 		readonly   bool
 		opened     bool
 		cachepath  string
+		// perms of the uploaded file, see cloudstorage.Opts.FileMode
+		perms cloudstorage.Opts
+		// ctx of OpenWithContext, of the download and Sync.
+		ctx context.Context
 		//updated    time.Time
 		//metadata   map[string]string
 		//infoOnce   sync.Once
@@ -164,6 +173,7 @@ func NewClient(clientCtx context.Context, conf *cloudstorage.Config, host string
 		cachepath: conf.TmpDir,
 		bucket:    folder,
 		paths:     make(map[string]struct{}),
+		separator: conf.KeySeparator(),
 	}
 
 	//gou.Infof("%p created sftp client %#v", client, ftpClient)
@@ -240,10 +250,27 @@ func (m *Client) Client() interface{} {
 	return m.client
 }
 
+// ResolveKey is the path of the file of object o on the server, relative to
+// the login directory.  Spaces are written as "+".
+func (m *Client) ResolveKey(o string) string {
+	return m.filePath(strings.Replace(o, " ", "+", -1))
+}
+
+// filePath is the path of the file of object name on the server.
+func (m *Client) filePath(name string) string {
+	return Concat(m.bucket, cloudstorage.KeyToPath(name, m.separator))
+}
+
 func (m *Client) String() string {
 	return fmt.Sprintf("<sftp host=%q />", m.host)
 }
 
+// KeySeparator for cloudstorage.StoreSeparator, the separator of the folders
+// in object names.
+func (m *Client) KeySeparator() string {
+	return m.separator
+}
+
 // NewObject create a new object with given name.  Will not write to remote
 // sftp until Close is called.
 func (m *Client) NewObject(objectname string) (cloudstorage.Object, error) {
@@ -268,16 +295,21 @@ func (m *Client) NewObject(objectname string) (cloudstorage.Object, error) {
 }
 
 // Get opens a file for read or writing
-func (m *Client) Get(ctx context.Context, name string) (cloudstorage.Object, error) {
+func (m *Client) Get(ctx context.Context, name string) (_ cloudstorage.Object, err error) {
+	defer cloudstorage.RecoverPanic("sftp get", &err)
 	if !m.Exists(name) {
 		return nil, cloudstorage.ErrObjectNotFound
 	}
-	get := Concat(m.bucket, name)
+	get := m.filePath(name)
 	//gou.DebugCtx(m.clientCtx, "getting file %s", get)
 	f, err := m.client.Stat(get)
 	if err != nil {
 		return nil, err
 	}
+	if f.IsDir() || strings.HasSuffix(name, m.separator) {
+		// directories are folders not objects, see cloudstorage.GetFolder.
+		return nil, cloudstorage.ErrObjectNotFound
+	}
 	return newObjectFromFile(m, get, f), nil
 }
 
@@ -301,12 +333,16 @@ func (m *Client) Objects(ctx context.Context, q cloudstorage.Query) (cloudstorag
 }
 
 // Delete deletes a file
-func (m *Client) Delete(ctx context.Context, filename string) error {
+func (m *Client) Delete(ctx context.Context, filename string, opts ...cloudstorage.DeleteOptions) (err error) {
+	defer cloudstorage.RecoverPanic("sftp delete", &err)
+	if len(opts) > 0 && (opts[0].IfMatch != "" || opts[0].IfGenerationMatch != 0) {
+		return cloudstorage.ErrConditionNotSupported
+	}
 	if !m.Exists(filename) {
 		gou.Warnf("does not exist????? %q", filename)
 		return os.ErrNotExist
 	}
-	r := Concat(m.bucket, filename)
+	r := m.filePath(filename)
 	//gou.InfoCtx(m.clientCtx, "removing file %q", r)
 	return m.client.Remove(r)
 }
@@ -325,9 +361,20 @@ func (m *Client) Rename(oldname, newname string) error {
 	return m.client.Rename(o, n)
 }
 */
+// Move renames the file of src to des on the server, replacing des if it
+// exists.  The server needs the posix-rename@openssh.com extension.
+func (m *Client) Move(ctx context.Context, src, des cloudstorage.Object) (err error) {
+	defer cloudstorage.RecoverPanic("sftp move", &err)
+	if !m.Exists(src.Name()) {
+		return cloudstorage.ErrObjectNotFound
+	}
+	m.ensureDir(des.Name())
+	return m.client.PosixRename(m.filePath(src.Name()), m.filePath(des.Name()))
+}
+
 // Exists checks to see if files exists
 func (m *Client) Exists(filename string) bool {
-	_, err := m.client.Stat(filename)
+	_, err := m.client.Stat(cloudstorage.KeyToPath(filename, m.separator))
 	if err == nil {
 		return true
 	}
@@ -356,7 +403,7 @@ func (m *Client) Exists(filename string) bool {
 
 func (m *Client) ensureDir(name string) {
 
-	name = Concat(m.bucket, name)
+	name = m.filePath(name)
 	parts := strings.Split(strings.ToLower(name), "/")
 	dir := ""
 	for _, dirPart := range parts[0 : len(parts)-1] {
@@ -380,17 +427,20 @@ func (m *Client) ensureDir(name string) {
 }
 
 // List lists files in a directory
-func (m *Client) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.ObjectsResponse, error) {
+func (m *Client) List(ctx context.Context, q cloudstorage.Query) (_ *cloudstorage.ObjectsResponse, err error) {
+	defer cloudstorage.RecoverPanic("sftp list", &err)
 
 	objs := &cloudstorage.ObjectsResponse{
 		Objects: make(cloudstorage.Objects, 0),
 	}
 
-	err := m.listFiles(ctx, q, objs, m.bucket)
+	err = m.listFiles(ctx, q, objs, m.bucket)
 	if err != nil {
 		gou.Warnf("fetch listFiles error %v", err)
 		return nil, err
 	}
+	// the whole tree is read, paged by the Marker and PageSize.
+	objs = cloudstorage.PageObjects(objs.Objects, q)
 	objs.Objects = q.ApplyFilters(objs.Objects)
 	return objs, nil
 }
@@ -418,6 +468,7 @@ func (m *Client) listFiles(ctx context.Context, q cloudstorage.Query, objs *clou
 			} else {
 				name = Concat(path, fi.Name())
 			}
+			name = cloudstorage.PathToKey(name, m.separator)
 			if q.Prefix != "" && !strings.HasPrefix(name, q.Prefix) {
 				continue
 			}
@@ -459,8 +510,75 @@ func (m *Client) ListFiles(folder string, hidden bool) ([]string, error) {
 }
 */
 // Folders lists directories in a directory
-func (m *Client) Folders(ctx context.Context, q cloudstorage.Query) ([]string, error) {
-	return m.listDirs(ctx, q.Prefix, "", q.ShowHidden)
+func (m *Client) Folders(ctx context.Context, q cloudstorage.Query) (_ []string, err error) {
+	defer cloudstorage.RecoverPanic("sftp folders", &err)
+	dirs, err := m.listDirs(ctx, q.Prefix, "", q.ShowHidden)
+	if err != nil || !q.ImplicitFolders {
+		return dirs, err
+	}
+	var out []string
+	for _, d := range dirs {
+		found, err := m.hasFiles(ctx, d, q.ShowHidden)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// ListLevel for cloudstorage.StoreListLevel, reading only the directory of
+// prefix rather than the tree under it.  Hidden directories aren't folders,
+// as for Folders.
+func (m *Client) ListLevel(ctx context.Context, prefix string, limit int) (_ cloudstorage.Objects, _ []string, err error) {
+	defer cloudstorage.RecoverPanic("sftp list level", &err)
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	default:
+	}
+	fi, err := m.fetchFiles(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects := make(cloudstorage.Objects, 0)
+	folders := make([]string, 0)
+	dir := cloudstorage.KeyToPath(prefix, m.separator)
+	for _, f := range fi {
+		name := path.Join(dir, f.Name())
+		if !f.IsDir() {
+			objects = append(objects, newObjectFromFile(m, name, f))
+		} else if strings.Index(f.Name(), ".") != 0 {
+			folders = append(folders, cloudstorage.PathToKey(name, m.separator)+m.separator)
+		}
+	}
+	objects, folders = cloudstorage.LimitLevel(objects, folders, limit)
+	return objects, folders, nil
+}
+
+// hasFiles is true if there is a file anywhere under folder.
+func (m *Client) hasFiles(ctx context.Context, folder string, hidden bool) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	default:
+	}
+	fi, err := m.fetchFiles(folder)
+	if err != nil {
+		return false, err
+	}
+	if len(filterFiles(fi, false, true, hidden)) > 0 {
+		return true, nil
+	}
+	for _, d := range filterFiles(fi, true, false, hidden) {
+		found, err := m.hasFiles(ctx, path.Join(folder, d), hidden)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
 }
 
 func (m *Client) listDirs(ctx context.Context, folder, prefix string, hidden bool) ([]string, error) {
@@ -476,7 +594,8 @@ func (m *Client) listDirs(ctx context.Context, folder, prefix string, hidden boo
 	}
 	var out []string
 	for _, d := range dirs {
-		out = append(out, fmt.Sprintf("%s/", path.Join(folder, d)))
+		dir := path.Join(cloudstorage.KeyToPath(folder, m.separator), d)
+		out = append(out, cloudstorage.PathToKey(dir, m.separator)+m.separator)
 	}
 	return out, nil
 }
@@ -533,18 +652,24 @@ func (m *Client) NewReader(o string) (io.ReadCloser, error) {
 }
 
 // NewReaderWithContext create new File reader with context.
-func (m *Client) NewReaderWithContext(ctx context.Context, name string) (io.ReadCloser, error) {
+func (m *Client) NewReaderWithContext(ctx context.Context, name string, opts ...cloudstorage.ReadOptions) (_ io.ReadCloser, err error) {
+	defer cloudstorage.RecoverPanic("sftp read", &err)
+	if len(opts) > 0 && opts[0].VerifyChecksum {
+		// sftp has no checksums or metadata to verify against.
+		return nil, cloudstorage.ErrChecksumUnavailable
+	}
 	if !m.Exists(name) {
 		return nil, cloudstorage.ErrObjectNotFound
 	}
-	get := Concat(m.bucket, name)
+	get := m.filePath(name)
 	gou.DebugCtx(m.clientCtx, "NewReaderWithContext getting file %s", get)
 	f, err := m.client.Open(get)
 	if err != nil {
 		return nil, err
 	}
 
-	return f, nil
+	// the sftp reads don't take a context
+	return cloudstorage.MaxBytesReader(cloudstorage.NewContextReadCloser(ctx, f), opts), nil
 }
 
 // NewWriter create Object Writer.
@@ -553,9 +678,20 @@ func (m *Client) NewWriter(objectName string, metadata map[string]string) (io.Wr
 }
 
 // NewWriterWithContext create writer with provided context and metadata.
-func (m *Client) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
-	if len(opts) > 0 && opts[0].IfNotExists {
-		return nil, fmt.Errorf("options IfNotExists not supported for store type")
+func (m *Client) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...cloudstorage.Opts) (_ io.WriteCloser, err error) {
+	defer cloudstorage.RecoverPanic("sftp write", &err)
+	if len(opts) > 0 && (opts[0].IfNotExists || opts[0].IfMatch != "" || opts[0].IfGenerationMatch != 0) {
+		return nil, cloudstorage.ErrConditionNotSupported
+	}
+	if len(opts) > 0 && opts[0].PublicRead {
+		return nil, cloudstorage.ErrFeatureNotSupported
+	}
+
+	if cloudstorage.SkipsIfIdentical(opts) {
+		return cloudstorage.NewSkipIfIdenticalWriter(ctx, m, name, metadata, opts)
+	}
+	if cloudstorage.VerifiesOnClose(opts) {
+		return cloudstorage.NewVerifyOnCloseWriter(ctx, m, name, metadata, opts)
 	}
 
 	name = strings.Replace(name, " ", "+", -1)
@@ -571,6 +707,10 @@ func (m *Client) NewWriterWithContext(ctx context.Context, name string, metadata
 	// pr, pw := io.Pipe()
 	// bw := csbufio.NewWriter(pw)
 
+	if len(opts) > 0 && opts[0].Stream {
+		return m.newStreamWriter(ctx, name, opts[0])
+	}
+
 	//o := &object{name: name}
 	o, err := m.NewObject(name)
 	if err != nil {
@@ -581,9 +721,54 @@ func (m *Client) NewWriterWithContext(ctx context.Context, name string, metadata
 		gou.Errorf("could not open %v %v", name, err)
 		return nil, err
 	}
+	if len(opts) > 0 {
+		o.(*object).perms = opts[0]
+	}
+	if len(opts) > 0 && opts[0].ContentMD5 != nil {
+		// the upload happens on Close, a mismatch drops the local copy instead.
+		return cloudstorage.NewChecksumWriter(o, md5.New(), opts[0].ContentMD5, o.Release), nil
+	}
 	return o, nil
 }
 
+// newStreamWriter writes the remote file directly, see cloudstorage.Opts.Stream.
+func (m *Client) newStreamWriter(ctx context.Context, name string, opts cloudstorage.Opts) (io.WriteCloser, error) {
+	m.ensureDir(name)
+	fp := m.filePath(name)
+	f, err := m.client.Create(fp)
+	if err != nil {
+		return nil, err
+	}
+	o := &object{client: m, name: name, perms: opts}
+	if err := o.chown(f); err != nil {
+		f.Close()
+		m.client.Remove(fp)
+		return nil, err
+	}
+	wc := csbufio.NewWriter(&streamFile{File: f, ctx: ctx})
+	if opts.ContentMD5 != nil {
+		// the file is written in place, so a mismatch removes it.
+		return cloudstorage.NewChecksumWriter(wc, md5.New(), opts.ContentMD5, func() error {
+			wc.Close()
+			return m.client.Remove(fp)
+		}), nil
+	}
+	return wc, nil
+}
+
+// streamFile is a remote file written until ctx is done.
+type streamFile struct {
+	*ftp.File
+	ctx context.Context
+}
+
+func (f *streamFile) Write(p []byte) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
 /*
 // NewFile creates file with filename in upload folder
 func (m *Client) NewFile(filename string) (Uploader, error) {
@@ -599,7 +784,7 @@ func (m *Client) NewFile(filename string) (Uploader, error) {
 }
 */
 func (m *Client) fetchFiles(f string) ([]os.FileInfo, error) {
-	folder := Concat(m.bucket, f)
+	folder := Concat(m.bucket, cloudstorage.KeyToPath(f, m.separator))
 	if folder == "" {
 		folder = "."
 	}
@@ -624,7 +809,7 @@ func (m *Client) filterFileNames(folder string, dirs, files, hidden bool) ([]str
 }
 
 func newObjectFromFile(c *Client, name string, f os.FileInfo) *object {
-	name = strings.TrimLeft(name, "/")
+	name = cloudstorage.PathToKey(strings.TrimLeft(name, "/"), c.separator)
 	cf := cloudstorage.CachePathObj(c.cachepath, name, c.ID)
 	return &object{
 		client:    c,
@@ -639,7 +824,7 @@ func (o *object) upload(body io.Reader) (int64, error) {
 
 	o.client.ensureDir(o.name)
 
-	name := Concat(o.client.bucket, o.name)
+	name := o.client.filePath(o.name)
 
 	//gou.Infof("upload %q", name)
 
@@ -668,6 +853,11 @@ func (o *object) upload(body io.Reader) (int64, error) {
 
 	defer f.Close()
 
+	if err := o.chown(f); err != nil {
+		gou.Warnf("Could not set permissions of %q err=%v", name, err)
+		return 0, err
+	}
+
 	wLength, err := f.ReadFrom(body)
 	if err != nil {
 		gou.Errorf("could not read file %v", err)
@@ -678,6 +868,35 @@ func (o *object) upload(body io.Reader) (int64, error) {
 	return wLength, nil
 }
 
+// chown sets the permissions and owner of the created file f.
+func (o *object) chown(f *ftp.File) error {
+	if o.perms.FileMode != 0 {
+		if err := f.Chmod(o.perms.FileMode); err != nil {
+			return err
+		}
+	}
+	if o.perms.UID == 0 && o.perms.GID == 0 {
+		return nil
+	}
+	// sftp sets both, keep the one not given as created
+	uid, gid := o.perms.UID, o.perms.GID
+	if uid == 0 || gid == 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if st, ok := fi.Sys().(*ftp.FileStat); ok {
+			if uid == 0 {
+				uid = int(st.UID)
+			}
+			if gid == 0 {
+				gid = int(st.GID)
+			}
+		}
+	}
+	return f.Chown(uid, gid)
+}
+
 func statinfo(msg, name string) {
 	fi, err := os.Stat(name)
 	if err != nil {
@@ -689,11 +908,23 @@ func statinfo(msg, name string) {
 }
 
 // Open ensures the file is available for read/write (or accessevel)
-func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
+func (o *object) Open(accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+// OpenWithContext for cloudstorage.ObjectOpenContext.  The sftp reads don't
+// take a context, so the download stops between reads once ctx is done, and
+// Sync doesn't start the upload.  An upload isn't stopped part way, as the
+// previous file is already removed.
+func (o *object) OpenWithContext(ctx context.Context, accesslevel cloudstorage.AccessLevel, opts ...cloudstorage.ReadOptions) (*os.File, error) {
+	if !accesslevel.Valid() {
+		return nil, cloudstorage.ErrInvalidAccessLevel
+	}
 
 	if o.opened {
 		return nil, fmt.Errorf("the store object is already opened. %s", o.cachepath)
 	}
+	o.ctx = ctx
 
 	readonly := accesslevel == cloudstorage.ReadOnly
 	//gou.Infof("sftp object.Open(%q) readonly?%v", o.name, readonly)
@@ -720,9 +951,9 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 
 	if o.file != nil {
 		//gou.Debugf("has file so copy to local cached copy")
-		_, err = io.Copy(cachedcopy, o.file)
+		_, err = io.Copy(cachedcopy, cloudstorage.NewContextReader(ctx, o.file))
 		if err != nil {
-			return nil, err
+			return nil, o.abortOpen(cachedcopy, err)
 		}
 	} else if o.fi == nil {
 		// this is a new file
@@ -730,7 +961,7 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 		//statinfo("new file statinfo", o.cachepath)
 	} else if o.fi != nil {
 		// existing file
-		get := Concat(o.client.bucket, o.name)
+		get := o.client.filePath(o.name)
 		//gou.Debugf("existingfile, open %s", get)
 		f, err := o.client.client.Open(get)
 		if err != nil {
@@ -739,20 +970,24 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 		}
 		o.file = f
 
-		_, err = io.Copy(cachedcopy, f)
+		_, err = io.Copy(cachedcopy, cloudstorage.NewContextReader(ctx, f))
 		if err != nil {
 			gou.WarnCtx(o.client.clientCtx, "Could not copy %q err=%v", o.name, err)
-			return nil, err
+			return nil, o.abortOpen(cachedcopy, err)
 		}
 		cachedcopy.Close()
 		//statinfo("after close/iotutil readall", o.cachepath)
 
-		cachedcopy, err = os.OpenFile(o.cachepath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0665)
+		// not O_APPEND, so writes after a Seek patch the copy in place.
+		cachedcopy, err = os.OpenFile(o.cachepath, os.O_RDWR|os.O_CREATE, 0665)
 		if err != nil {
 			gou.Error(err)
 			return nil, err
 		}
 	}
+	if _, err := cachedcopy.Seek(0, io.SeekStart); err != nil {
+		return nil, o.abortOpen(cachedcopy, err)
+	}
 
 	o.cachedcopy = cachedcopy
 	o.readonly = readonly
@@ -762,11 +997,54 @@ func (o *object) Open(accesslevel cloudstorage.AccessLevel) (*os.File, error) {
 	// o.cachedcopy.Close()
 	//gou.Debugf("opened %q  readonly?%v opened?%v", o.cachepath, o.readonly, o.opened)
 	//gou.Infof("Open() returning cache copy %p", o.cachedcopy)
+	if readonly {
+		return cloudstorage.VerifyOpened(o, o.cachedcopy, opts)
+	}
 	return o.cachedcopy, nil
 
 	//return nil, fmt.Errorf("fetch error retry cnt reached: obj=%s tfile=%v", o.name, o.cachepath)
 }
 
+// abortOpen removes the partial cachedcopy of a failed open.
+func (o *object) abortOpen(cachedcopy *os.File, err error) error {
+	cachedcopy.Close()
+	os.Remove(o.cachepath)
+	return err
+}
+
+// OpenRange for cloudstorage.ObjectRange, seeks to start in the file, the
+// reads stop between reads once ctx is done.
+func (o *object) OpenRange(ctx context.Context, start, length int64) (_ io.ReadCloser, err error) {
+	defer cloudstorage.RecoverPanic("sftp read", &err)
+	f, err := o.client.client.Open(o.client.filePath(o.name))
+	if os.IsNotExist(err) {
+		return nil, cloudstorage.ErrObjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err == nil {
+		err = cloudstorage.CheckRange(start, length, fi.Size())
+	}
+	if err == nil {
+		_, err = f.Seek(start, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		length = fi.Size() - start
+	}
+	return &rangeReader{cloudstorage.NewContextReader(ctx, io.LimitReader(f, length)), f}, nil
+}
+
+// rangeReader reads a range of the file, closing it on Close.
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
 // Delete delete the underlying object from ftp server.
 func (o *object) Delete() error {
 	// this should be path/name ??
@@ -780,11 +1058,14 @@ func (o *object) Sync() error {
 		return fmt.Errorf("object isn't opened object:%s", o.name)
 	}
 	if o.readonly {
-		return fmt.Errorf("trying to Sync a readonly object:%s", o.name)
+		return cloudstorage.ErrReadOnly
 	}
 	if o.cachedcopy == nil {
 		return fmt.Errorf("No cached copy")
 	}
+	if o.ctx != nil && o.ctx.Err() != nil {
+		return o.ctx.Err()
+	}
 
 	//statinfo("about to sync cachecopy", o.cachepath)
 	if err := o.cachedcopy.Sync(); err != nil {
@@ -892,6 +1173,9 @@ func (o *object) Read(p []byte) (n int, err error) {
 	return o.cachedcopy.Read(p)
 }
 func (o *object) Write(p []byte) (n int, err error) {
+	if o.opened && o.readonly {
+		return 0, cloudstorage.ErrReadOnly
+	}
 	if o.cachedcopy == nil {
 		_, err := o.Open(cloudstorage.ReadWrite)
 		if err != nil {
@@ -915,12 +1199,32 @@ func (o *object) Name() string {
 func (o *object) String() string {
 	return o.name
 }
+// ETag is empty, sftp has no object versions.
+func (o *object) ETag() string {
+	return ""
+}
+
+// ContentType is empty, sftp has no content types.
+func (o *object) ContentType() string {
+	return ""
+}
+
+// MD5 is nil, sftp has no checksums.
+func (o *object) MD5() []byte {
+	return nil
+}
 func (o *object) Updated() time.Time {
 	if o.fi != nil {
 		return o.fi.ModTime()
 	}
 	return time.Time{}
 }
+func (o *object) Size() int64 {
+	if o.fi != nil {
+		return o.fi.Size()
+	}
+	return 0
+}
 
 type ByModTime []os.FileInfo
 
@@ -0,0 +1,16 @@
+package cloudstorage
+
+import (
+	"golang.org/x/net/context"
+)
+
+// SignedURL creates a url granting temporary access to object name in s,
+// ie a download link valid for opts.Expiry, if the store supports it (see
+// StoreSignedURL), otherwise ErrFeatureNotSupported.  The filesystem stores
+// (localfs, sftp) don't.
+func SignedURL(ctx context.Context, s Store, name string, opts SignedURLOptions) (string, error) {
+	if su, ok := s.(StoreSignedURL); ok {
+		return su.SignedURL(ctx, name, opts)
+	}
+	return "", ErrFeatureNotSupported
+}
@@ -0,0 +1,110 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// SluggingStore is a Store that passes every object name through a slug
+// func before the wrapped store sees it, so keys follow one convention
+// whatever the callers pass, ie "Reports/Q1 Sales.CSV" is stored as
+// "reports/q1-sales.csv".  Names are slugged on write, read, Get and
+// delete, as are the Prefix and StartAfter of queries, so the slug has to
+// map the prefixes of a name to prefixes of its slug, as DefaultSlug does.
+// Slugs can't be mapped back to the names they came from, listings and the
+// objects return the slugged names.
+type SluggingStore struct {
+	Store
+	slug func(name string) string
+}
+
+// NewSluggingStore create a store slugging the names of s with fn, or
+// DefaultSlug if fn is nil.
+func NewSluggingStore(s Store, fn func(name string) string) *SluggingStore {
+	if fn == nil {
+		fn = DefaultSlug
+	}
+	return &SluggingStore{Store: s, slug: fn}
+}
+
+// DefaultSlug lowercases name and replaces the characters other than ascii
+// letters, digits, "/", ".", "_" and "-" with "-".
+func DefaultSlug(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		case r == '/' || r == '.' || r == '_' || r == '-':
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// query is q with its names slugged.
+func (s *SluggingStore) query(q Query) Query {
+	q.Prefix = s.slug(q.Prefix)
+	if q.StartAfter != "" {
+		q.StartAfter = s.slug(q.StartAfter)
+	}
+	return q
+}
+
+// Get the object of the slug of name.
+func (s *SluggingStore) Get(ctx context.Context, name string) (Object, error) {
+	return s.Store.Get(ctx, s.slug(name))
+}
+
+// NewObject creates the object of the slug of name.
+func (s *SluggingStore) NewObject(name string) (Object, error) {
+	return s.Store.NewObject(s.slug(name))
+}
+
+// Objects iterates the objects under the slug of the query prefix.
+func (s *SluggingStore) Objects(ctx context.Context, q Query) (ObjectIterator, error) {
+	return s.Store.Objects(ctx, s.query(q))
+}
+
+// List the objects under the slug of the query prefix.
+func (s *SluggingStore) List(ctx context.Context, q Query) (*ObjectsResponse, error) {
+	return s.Store.List(ctx, s.query(q))
+}
+
+// Folders lists the folders under the slug of the query prefix.
+func (s *SluggingStore) Folders(ctx context.Context, q Query) ([]string, error) {
+	return s.Store.Folders(ctx, s.query(q))
+}
+
+// NewReader of the slug of name.
+func (s *SluggingStore) NewReader(name string) (io.ReadCloser, error) {
+	return s.NewReaderWithContext(context.Background(), name)
+}
+
+// NewReaderWithContext of the slug of name.
+func (s *SluggingStore) NewReaderWithContext(ctx context.Context, name string, opts ...ReadOptions) (io.ReadCloser, error) {
+	return s.Store.NewReaderWithContext(ctx, s.slug(name), opts...)
+}
+
+// NewWriter to the slug of name.
+func (s *SluggingStore) NewWriter(name string, metadata map[string]string) (io.WriteCloser, error) {
+	return s.NewWriterWithContext(context.Background(), name, metadata)
+}
+
+// NewWriterWithContext to the slug of name.
+func (s *SluggingStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...Opts) (io.WriteCloser, error) {
+	return s.Store.NewWriterWithContext(ctx, s.slug(name), metadata, opts...)
+}
+
+// Delete the object of the slug of name.
+func (s *SluggingStore) Delete(ctx context.Context, name string, opts ...DeleteOptions) error {
+	return s.Store.Delete(ctx, s.slug(name), opts...)
+}
+
+func (s *SluggingStore) String() string {
+	return fmt.Sprintf("slugging(%s)", s.Store)
+}
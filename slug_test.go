@@ -0,0 +1,41 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestDefaultSlug(t *testing.T) {
+	assert.Equal(t, "reports/q1-sales.csv", cloudstorage.DefaultSlug("Reports/Q1 Sales.CSV"))
+	assert.Equal(t, "a-b-c/d_e-f.txt", cloudstorage.DefaultSlug("a?b#c/d_e-f.txt"))
+	assert.Equal(t, "caf-/", cloudstorage.DefaultSlug("Café/"))
+}
+
+func TestSluggingStore(t *testing.T) {
+	store := newLocalStore(t, "slugging")
+	ctx := context.Background()
+	slugs := cloudstorage.NewSluggingStore(store, nil)
+
+	writeObject(t, slugs, "Reports/Q1 Sales.CSV", "a,b\n")
+	assert.Equal(t, "a,b\n", readAll(t, store, "reports/q1-sales.csv"))
+	assert.Equal(t, "a,b\n", readAll(t, slugs, "REPORTS/q1 sales.csv"))
+
+	o, err := slugs.Get(ctx, "Reports/Q1 Sales.CSV")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "reports/q1-sales.csv", o.Name())
+
+	// listings return the slugged names.
+	assert.Equal(t, []string{"reports/q1-sales.csv"}, listNames(t, slugs, cloudstorage.NewQuery("Reports/")))
+
+	assert.Equal(t, nil, slugs.Delete(ctx, "Reports/Q1 Sales.CSV"))
+	_, err = store.Get(ctx, "reports/q1-sales.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	versioned := cloudstorage.NewSluggingStore(store, func(name string) string { return "v1/" + name })
+	writeObject(t, versioned, "a.csv", "a")
+	assert.Equal(t, "a", readAll(t, store, "v1/a.csv"))
+}
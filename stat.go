@@ -0,0 +1,44 @@
+package cloudstorage
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ObjectInfo is the attributes of an object, see Stat.
+type ObjectInfo struct {
+	Name        string
+	Size        int64
+	Updated     time.Time
+	ETag        string
+	ContentType string
+	MetaData    map[string]string
+	// MD5 of the object, nil if the store doesn't have it, see Object.MD5.
+	MD5 []byte
+	// CRC32C of the object, HasCRC32C is false if the store doesn't have
+	// it, see ObjectChecksumCRC32C.
+	CRC32C    uint32
+	HasCRC32C bool
+}
+
+// Stat the attributes of object name, or ErrObjectNotFound.  They are read
+// with the metadata request of Get (a HEAD of S3 and Azure, an attributes
+// request of GCS), the object isn't downloaded.
+func Stat(ctx context.Context, s Store, name string) (*ObjectInfo, error) {
+	o, err := s.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	info := &ObjectInfo{
+		Name:        o.Name(),
+		Size:        o.Size(),
+		Updated:     o.Updated(),
+		ETag:        o.ETag(),
+		ContentType: o.ContentType(),
+		MetaData:    o.MetaData(),
+		MD5:         o.MD5(),
+	}
+	info.CRC32C, info.HasCRC32C = ObjectChecksumCRC32C(o)
+	return info, nil
+}
@@ -0,0 +1,34 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestStat(t *testing.T) {
+	store := newLocalStore(t, "stat")
+	ctx := context.Background()
+
+	wc, err := store.NewWriterWithContext(ctx, "a.csv", map[string]string{"owner": "etl"})
+	assert.Equal(t, nil, err)
+	wc.Write([]byte("a,b,c\n"))
+	assert.Equal(t, nil, wc.Close())
+
+	info, err := cloudstorage.Stat(ctx, store, "a.csv")
+	assert.Equal(t, nil, err)
+	obj, err := store.Get(ctx, "a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a.csv", info.Name)
+	assert.Equal(t, int64(6), info.Size)
+	assert.Equal(t, obj.Updated(), info.Updated)
+	assert.Equal(t, obj.ETag(), info.ETag)
+	assert.Equal(t, "etl", info.MetaData["owner"])
+	assert.Equal(t, obj.ContentType(), info.ContentType)
+
+	_, err = cloudstorage.Stat(ctx, store, "missing.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+}
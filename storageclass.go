@@ -0,0 +1,33 @@
+package cloudstorage
+
+import (
+	"golang.org/x/net/context"
+)
+
+// SetStorageClass of object o if the store supports storage classes (see
+// StoreStorageClass), otherwise ErrFeatureNotSupported.
+func SetStorageClass(ctx context.Context, s Store, o string, class string) error {
+	if sc, ok := s.(StoreStorageClass); ok {
+		return sc.SetStorageClass(ctx, o, class)
+	}
+	return ErrFeatureNotSupported
+}
+
+// Restore starts restoring the archived object o so it can be read (see
+// StoreRestore), poll Restoring for when it is done.  ErrFeatureNotSupported
+// if the store has no archive classes.
+func Restore(ctx context.Context, s Store, o string) error {
+	if r, ok := s.(StoreRestore); ok {
+		return r.Restore(ctx, o)
+	}
+	return ErrFeatureNotSupported
+}
+
+// Restoring is true while a restore of object o started by Restore is in
+// progress.
+func Restoring(ctx context.Context, s Store, o string) (bool, error) {
+	if r, ok := s.(StoreRestore); ok {
+		return r.Restoring(ctx, o)
+	}
+	return false, ErrFeatureNotSupported
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -17,6 +19,23 @@ const (
 	StoreCacheFileExt = ".cache"
 	// ContentTypeKey
 	ContentTypeKey = "content_type"
+	// ContentTypeHeader is accepted in metadata as an alias of ContentTypeKey,
+	// ie for metadata copied from http headers.  ContentTypeKey wins if the
+	// metadata has both.
+	ContentTypeHeader = "Content-Type"
+	// CustomTimeKey metadata key for a user supplied event-time (RFC3339) of the
+	// object, as opposed to the Updated time the store assigns on upload.  GCS
+	// stores this natively as the objects Custom-Time.
+	CustomTimeKey = "custom_time"
+	// ChecksumSHA256Key metadata key of a hex encoded sha256 of the object
+	// contents, for stores without a native checksum that can be verified.
+	ChecksumSHA256Key = "x-checksum-sha256"
+	// ChecksumCRC32CKey metadata key of a hex encoded crc32c (Castagnoli) of the
+	// object contents.
+	ChecksumCRC32CKey = "x-checksum-crc32c"
+	// CompressionKey metadata key of the compression of the objects written
+	// by a CompressedStore, "gzip".
+	CompressionKey = "x-compression"
 	// MaxResults default number of objects to retrieve during a list-objects request,
 	// if more objects exist, then they will need to be paged
 	MaxResults = 3000
@@ -31,6 +50,12 @@ const (
 	ReadWrite AccessLevel = 1
 )
 
+// Valid is true for the known access levels, Open rejects others with
+// ErrInvalidAccessLevel.
+func (a AccessLevel) Valid() bool {
+	return a == ReadOnly || a == ReadWrite
+}
+
 var (
 	// ErrObjectNotFound Error of not finding a file(object)
 	ErrObjectNotFound = fmt.Errorf("object not found")
@@ -38,11 +63,185 @@ var (
 	ErrObjectExists = fmt.Errorf("object already exists in backing store (use store.Get)")
 	// ErrNotImplemented this feature is not implemented for this store
 	ErrNotImplemented = fmt.Errorf("Not implemented")
+	// ErrChecksumMismatch the bytes read or written don't match the object checksum.
+	ErrChecksumMismatch = fmt.Errorf("object checksum mismatch")
+	// ErrObjectTooLarge the object has more bytes than ReadOptions.MaxBytes,
+	// or an Upload more than Opts.MaxBytes.
+	ErrObjectTooLarge = fmt.Errorf("object is larger than the size limit")
+	// ErrObjectArchived the object is in an archive storage class and has to be
+	// restored before it can be read, see Restore.
+	ErrObjectArchived = fmt.Errorf("object is archived, restore it before reading")
+	// ErrPreconditionFailed the object didn't match the conditions of the request.
+	ErrPreconditionFailed = fmt.Errorf("object precondition failed")
+	// ErrConditionNotSupported the store can't apply a condition of the
+	// request, ie Opts.IfGenerationMatch on stores without generations.
+	ErrConditionNotSupported = fmt.Errorf("condition not supported for store type")
+	// ErrNotConsistent the store didn't become consistent within ConsistencyTimeout.
+	ErrNotConsistent = fmt.Errorf("store not consistent before timeout")
+	// ErrChecksumUnavailable verification was requested but the store has no
+	// checksum for the object it can verify against.
+	ErrChecksumUnavailable = fmt.Errorf("object checksum unavailable for verification")
+	// ErrWriteNotVerified the object in the store after a write doesn't match
+	// the bytes written, see Opts.VerifyOnClose.
+	ErrWriteNotVerified = fmt.Errorf("object written could not be verified")
+	// ErrReadOnly the store is read only, see NewReadOnlyStore, or the object
+	// was opened ReadOnly and is written to.
+	ErrReadOnly = fmt.Errorf("store is read only")
+	// ErrInvalidAccessLevel Open was called with an unknown AccessLevel.
+	ErrInvalidAccessLevel = fmt.Errorf("invalid access level")
+	// ErrJobNotFound there is no bulk job with the id, see BulkJobStatus.
+	ErrJobNotFound = fmt.Errorf("bulk job not found")
+	// ErrDecryptionFailed an object of an EncryptedStore isn't encrypted, or
+	// failed authentication as it was encrypted with another key, modified
+	// or truncated.
+	ErrDecryptionFailed = fmt.Errorf("object decryption failed, not encrypted with the key or corrupt")
+	// ErrRangeNotSatisfiable the range of an OpenRange starts or ends past
+	// the end of the object.
+	ErrRangeNotSatisfiable = fmt.Errorf("range is past the end of the object")
+	// ErrRateLimited a RateLimitFail RateLimitedStore is over its rate.
+	ErrRateLimited = fmt.Errorf("request rate limit exceeded")
+	// ErrFeatureNotSupported the store can't do what the request asked for,
+	// ie Opts.PublicRead on the filesystem stores.
+	ErrFeatureNotSupported = fmt.Errorf("feature not supported for store type")
 )
 
 type (
+	// Opts are optional settings for writing an object.
 	Opts struct {
 		IfNotExists bool
+		// IfMatch writes the object only if its current ETag is IfMatch, if
+		// it doesn't match (or the object doesn't exist) the write fails with
+		// ErrPreconditionFailed, see CompareAndSwap.
+		IfMatch string
+		// IfGenerationMatch writes the object only if its current generation
+		// is IfGenerationMatch (see Object's Generation), as IfMatch.  GCS only,
+		// other stores fail the write with ErrConditionNotSupported.
+		IfGenerationMatch int64
+		// ContentMD5 is the md5 of the bytes the caller is going to write.  The
+		// store verifies the bytes it receives against it (server side where
+		// the provider supports it) and Close fails with ErrChecksumMismatch if
+		// they differ.
+		ContentMD5 []byte
+		// DetectContentType sets the content type from DetectContentType if
+		// the metadata doesn't have a ContentTypeKey.  The object isn't
+		// created in the store until the first 512 bytes (or all, if fewer) are
+		// written.
+		DetectContentType bool
+		// FileMode are the permissions set on the file by the filesystem
+		// stores (localfs, sftp), object stores ignore it.  Zero is the stores
+		// default mode, less the umask.
+		FileMode os.FileMode
+		// UID and GID are the owner and group set on the file by the
+		// filesystem stores, object stores ignore them.  Zero leaves them as
+		// created.
+		UID, GID int
+		// VerifyOnClose checks the object after the write is committed, Close
+		// fails with ErrWriteNotVerified if it isn't in the store with the size
+		// (and md5, if the store has one) of the bytes written.
+		VerifyOnClose bool
+		// UserProject and RequestPayer are who pays for the write of a
+		// requester pays bucket, overriding the store's default, as for
+		// ReadOptions.
+		UserProject  string
+		RequestPayer string
+		// SkipIfIdentical doesn't write the object if the store already has
+		// it with the same bytes, so rewriting unchanged content doesn't make
+		// a new version (or generation) in versioned buckets.  The bytes are
+		// buffered to a local file and on Close the md5 compared to the
+		// stored object's, at the cost of a Get (HEAD) per write.  Objects
+		// without an md5 in the store (S3 multipart uploads, sftp) are always
+		// written.  See WriteIfDifferent.
+		SkipIfIdentical bool
+		// MaxBytes caps the bytes Upload writes, larger uploads fail with
+		// ErrObjectTooLarge.  Zero is unlimited.  The writers of the stores
+		// ignore it.
+		MaxBytes int64
+		// Resume makes the write a resumable upload on the gcs and s3 stores,
+		// whose session is persisted in the store's TmpDir until Close.  A
+		// later Resume write of the same object (by this or a new process)
+		// continues the session, Resumed is the number of bytes of the object
+		// it already has and the caller writes the object from there.  Other
+		// stores ignore it, their Resumed is 0.  Resume can't be combined
+		// with conditions, a ContentMD5, SkipIfIdentical, VerifyOnClose or
+		// DetectContentType, the write fails with ErrConditionNotSupported.
+		Resume bool
+		// Stream writes to the store as the bytes are written, without a
+		// local copy of the object to upload on Close, for stores whose
+		// writers have one (sftp), the other stores always stream.  A streamed
+		// write failing part way may leave a partial object.
+		Stream bool
+		// PublicRead makes the object readable by anyone, without
+		// credentials, ie for serving through a CDN.  s3 writes it with the
+		// public-read canned ACL and gcs with the publicRead predefined ACL
+		// (neither works on buckets that block public ACLs), azure has no
+		// per blob ACL so the write fails with azure.ErrContainerPrivate
+		// unless the container allows public blob reads.  The other stores
+		// fail the write with ErrFeatureNotSupported.  Objects are private
+		// by default.
+		PublicRead bool
+	}
+	// WriteOptions are the Opts for NewWriterWithContext.
+	WriteOptions = Opts
+
+	// ReadOptions are optional settings for reading an object.
+	ReadOptions struct {
+		// VerifyChecksum compares the bytes read against the checksum the store
+		// has for the object, the reader returns ErrChecksumMismatch in place of
+		// io.EOF if they differ.  If there is no usable checksum the reader is
+		// not created and ErrChecksumUnavailable is returned.  Open(ReadOnly)
+		// verifies the downloaded cached copy the same way (see VerifyOpened).
+		VerifyChecksum bool
+		// DownloadConcurrency is the number of ranges fetched in parallel when
+		// Open(ReadOnly) downloads an object larger than PartSize to the local
+		// cache.  Zero or one downloads over a single stream.
+		DownloadConcurrency int
+		// PartSize is the size of each range for DownloadConcurrency, defaults
+		// to DefaultDownloadPartSize.
+		PartSize int64
+		// MaxBytes caps the bytes read, the reader returns ErrObjectTooLarge
+		// once the object has more than MaxBytes.  For objects the store
+		// decompresses while reading it caps the decompressed bytes.  Zero is
+		// unlimited.
+		MaxBytes int64
+		// InlineThreshold is the size below which ReadAll gets an object and its
+		// contents in a single GET, which is also the existence check, on stores
+		// that support it (see StoreGetInline).  Larger objects, and other
+		// stores, use the normal Get then read.
+		InlineThreshold int64
+		// UserProject is the project billed for the read of a requester pays
+		// GCS bucket, RequestPayer is "requester" to accept the charges of a
+		// requester pays S3 bucket.  Set per read they override the store's
+		// default (google.ConfKeyUserProject, awss3.ConfKeyRequestPayer), so
+		// one store can read buckets billing different projects.
+		UserProject  string
+		RequestPayer string
+	}
+
+	// SignedURLOptions are the settings of a signed url, a url granting
+	// temporary access to an object without credentials.
+	SignedURLOptions struct {
+		// Method the url is valid for, GET (default) or PUT.
+		Method string
+		// Expiry is how long the url is valid for, the stores default (ie 15
+		// minutes) if zero.
+		Expiry time.Duration
+		// ContentType a PUT must be sent with, if set.  Azure SAS urls can't
+		// require one.
+		ContentType string
+	}
+
+	// DeleteOptions are optional conditions for deleting an object, if the
+	// object doesn't match them it isn't deleted and ErrPreconditionFailed is
+	// returned.
+	DeleteOptions struct {
+		// IfMatch the objects current ETag.
+		IfMatch string
+		// IfGenerationMatch the objects current generation (GCS only).
+		IfGenerationMatch int64
+		// WaitConsistent blocks after the delete until the object is no longer
+		// returned by Get or listings (see WaitDeleted), for stores whose
+		// listings lag deletes.
+		WaitConsistent bool
 	}
 
 	// StoreReader interface to define the Storage Interface abstracting
@@ -54,7 +253,10 @@ type (
 		Client() interface{}
 		// Get returns an object (file) from the cloud store. The object
 		// isn't opened already, see Object.Open()
-		// ObjectNotFound will be returned if the object is not found.
+		// ObjectNotFound will be returned if the object is not found.  Names
+		// ending in "/" are only found if a (folder marker) object exists
+		// with exactly that name, folders are never returned as objects, see
+		// GetFolder.
 		Get(ctx context.Context, o string) (Object, error)
 		// Objects returns an object Iterator to allow paging through object
 		// which keeps track of page cursors.  Query defines the specific set
@@ -68,8 +270,9 @@ type (
 		// NewReader creates a new Reader to read the contents of the object.
 		// ErrObjectNotFound will be returned if the object is not found.
 		NewReader(o string) (io.ReadCloser, error)
-		// NewReader with context (for cancelation, etc)
-		NewReaderWithContext(ctx context.Context, o string) (io.ReadCloser, error)
+		// NewReader with context (for cancelation, etc).  Once ctx is done the
+		// stream is closed, freeing the upstream read, and reads are ctx.Err().
+		NewReaderWithContext(ctx context.Context, o string, opts ...ReadOptions) (io.ReadCloser, error)
 		// String default descriptor.
 		String() string
 	}
@@ -88,6 +291,88 @@ type (
 		Move(ctx context.Context, src, dst Object) error
 	}
 
+	// StoreUpdateMetadata Optional interface for stores that update the
+	// metadata of an object without re-uploading it.
+	StoreUpdateMetadata interface {
+		// UpdateMetadata replaces the metadata of object o, a ContentTypeKey
+		// sets its content type.
+		UpdateMetadata(ctx context.Context, o string, metadata map[string]string) error
+	}
+
+	// StoreStorageClass Optional interface for stores with a storage class
+	// (tier) per object.
+	StoreStorageClass interface {
+		// SetStorageClass of object o, class names are the stores own.
+		SetStorageClass(ctx context.Context, o string, class string) error
+	}
+
+	// StoreRestore Optional interface for stores with archive storage classes
+	// whose objects have to be restored before they can be read.
+	StoreRestore interface {
+		// Restore starts restoring the archived object o, which may take hours.
+		Restore(ctx context.Context, o string) error
+		// Restoring is true while a restore of o is in progress.
+		Restoring(ctx context.Context, o string) (bool, error)
+	}
+
+	// StoreGetInline Optional interface for stores that can get an object and
+	// its contents in a single request, see ReadOptions.InlineThreshold.
+	StoreGetInline interface {
+		// GetInline gets object o, and its contents if it is smaller than
+		// threshold, otherwise the contents are nil.
+		GetInline(ctx context.Context, o string, threshold int64) (Object, []byte, error)
+	}
+
+	// StoreBulkCopy Optional interface for stores with a provider managed
+	// batch copy service, see SubmitBulkCopy.
+	StoreBulkCopy interface {
+		// SubmitBulkCopy creates a job copying the objects under srcPrefix to
+		// dstPrefix, ErrFeatureNotSupported if the service can't.
+		SubmitBulkCopy(ctx context.Context, srcPrefix, dstPrefix string) (JobID, error)
+		// BulkJobStatus of a job created by SubmitBulkCopy.
+		BulkJobStatus(ctx context.Context, id JobID) (*BulkJob, error)
+	}
+
+	// StoreSignedURL Optional interface for stores that sign urls granting
+	// temporary access to objects, see SignedURL.
+	StoreSignedURL interface {
+		// SignedURL of object o with the method and expiry of opts.
+		SignedURL(ctx context.Context, o string, opts SignedURLOptions) (string, error)
+	}
+
+	// StoreListLevel Optional interface to fast path ListLevel.  Stores with
+	// delimiter listing return the objects and folders of a level together.
+	StoreListLevel interface {
+		// ListLevel the objects and folders directly under prefix, at most
+		// limit of them together if limit is above 0.
+		ListLevel(ctx context.Context, prefix string, limit int) (Objects, []string, error)
+	}
+
+	// StoreWalk Optional interface for stores streaming a listing to a
+	// callback themselves, see Walk.  It isn't a Store method so that the
+	// stores and wrappers without a faster walk than Objects needn't each
+	// implement it.
+	StoreWalk interface {
+		// Walk calls fn with each object matching q, stopping without an
+		// error if fn returns SkipRemaining.
+		Walk(ctx context.Context, q Query, fn func(Object) error) error
+	}
+
+	// StoreSeparator Optional interface for stores with a configured folder
+	// separator, see Separator.
+	StoreSeparator interface {
+		// KeySeparator of the folders in object names.
+		KeySeparator() string
+	}
+
+	// StoreDeleteAll Optional interface for stores with a batch delete, see
+	// DeleteAll.
+	StoreDeleteAll interface {
+		// DeleteAll deletes the objects of names, returning the error of each
+		// name by index, nil for those deleted or that didn't exist.
+		DeleteAll(ctx context.Context, names []string) []error
+	}
+
 	// Store interface to define the Storage Interface abstracting
 	// the GCS, S3, LocalFile interfaces
 	Store interface {
@@ -109,8 +394,14 @@ type (
 		// until the object is Closed/Sync'ed.
 		NewObject(o string) (Object, error)
 
-		// Delete removes the object from the cloud store.
-		Delete(ctx context.Context, o string) error
+		// ResolveKey is the exact key the store uses for object name o, after
+		// any normalization of names, for referencing the object from outside
+		// the package (urls, external tools).
+		ResolveKey(o string) string
+
+		// Delete removes the object from the cloud store.  DeleteOptions make
+		// the delete conditional on the object being unchanged.
+		Delete(ctx context.Context, o string, opts ...DeleteOptions) error
 	}
 
 	// Object is a handle to a cloud stored file/object.  Calling Open will pull the remote file onto
@@ -123,7 +414,22 @@ type (
 		String() string
 		// Updated timestamp.
 		Updated() time.Time
-		// MetaData is map of arbitrary name/value pairs about object.
+		// ETag of the object version, see DeleteOptions.IfMatch.  Empty if the
+		// store doesn't have one.
+		ETag() string
+		// Size in bytes of the object as it was when listed or fetched with
+		// Get, 0 for empty objects and new objects that haven't been written.
+		Size() int64
+		// ContentType of the object, empty if the store doesn't have it, or for
+		// listed objects of stores whose listings don't include it (see
+		// Query.IncludeMetadata).
+		ContentType() string
+		// MD5 of the object, nil if the store doesn't have it (ie for S3
+		// multipart uploads), or for listed objects like ContentType.
+		MD5() []byte
+		// MetaData is map of arbitrary name/value pairs about object, the
+		// metadata it was written with.  Get reads it with the rest of the
+		// object's attributes, without downloading the object.
 		MetaData() map[string]string
 		// SetMetaData allows you to set key/value pairs.
 		SetMetaData(meta map[string]string)
@@ -131,8 +437,18 @@ type (
 		StorageSource() string
 		// Open copies the remote file to a local cache and opens the cached version
 		// for read/writing.  Calling Close/Sync will push the copy back to the
-		// backing store.
-		Open(readonly AccessLevel) (*os.File, error)
+		// backing store.  ReadOptions apply to the download of the remote file.
+		// Unknown access levels are ErrInvalidAccessLevel, and Write or Sync
+		// of an object opened ReadOnly are ErrReadOnly.  A ReadWrite file is
+		// at offset 0, it can be Seek'd and written (or WriteAt) anywhere to
+		// patch the cached copy in place, ie Seek(0, io.SeekEnd) to append,
+		// and Close/Sync upload the whole copy as the object.  None of the
+		// stores update part of an object: s3 and gcs objects are immutable,
+		// azure objects are block blobs (not the page or append blobs that
+		// can be written to in part) and the filesystem stores replace their
+		// file with the copy, so a patch costs a download and upload of the
+		// object.
+		Open(readonly AccessLevel, opts ...ReadOptions) (*os.File, error)
 		// Release will remove the locally cached copy of the file.  You most call Close
 		// before releasing.  Release will call os.Remove(local_copy_file) so opened
 		// filehandles need to be closed.
@@ -149,6 +465,41 @@ type (
 		Delete() error
 	}
 
+	// ObjectCustomTime Optional interface for objects whose store natively
+	// records a user supplied event-time.  See CustomTime().
+	ObjectCustomTime interface {
+		CustomTime() time.Time
+	}
+
+	// ObjectOpenContext Optional interface for objects whose Open can be
+	// cancelled, see OpenWithContext.
+	ObjectOpenContext interface {
+		// OpenWithContext is Open, aborting the download of the object (and
+		// the upload of Sync and Close of a ReadWrite open) once ctx is done.
+		OpenWithContext(ctx context.Context, accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error)
+	}
+
+	// ObjectRange Optional interface for objects whose store reads byte
+	// ranges without downloading the object, see OpenRange.
+	ObjectRange interface {
+		// OpenRange reads length bytes of the object from start, -1 for the
+		// rest of the object.  length isn't 0.
+		OpenRange(ctx context.Context, start, length int64) (io.ReadCloser, error)
+	}
+
+	// WriterResume Optional interface of the writers of resumable uploads,
+	// see Opts.Resume and Resumed.
+	WriterResume interface {
+		// Resumed is the number of bytes the upload already has.
+		Resumed() int64
+	}
+
+	// ObjectCRC32C Optional interface for objects whose store computes a
+	// crc32c (Castagnoli) of them, ie GCS.  See ObjectChecksumCRC32C.
+	ObjectCRC32C interface {
+		CRC32C() uint32
+	}
+
 	// ObjectIterator interface to page through objects
 	// See go doc for examples https://github.com/GoogleCloudPlatform/google-cloud-go/wiki/Iterator-Guidelines
 	ObjectIterator interface {
@@ -162,6 +513,10 @@ type (
 	ObjectsResponse struct {
 		Objects    Objects
 		NextMarker string
+		// HasMore is true if the response was truncated, ie it is one page
+		// (of Query.PageSize) of a larger listing, and there are more objects
+		// to fetch by listing again with Query.Marker = NextMarker.
+		HasMore bool
 	}
 	// Objects are just a collection of Object(s).
 	// Used as the results for store.List commands.
@@ -202,8 +557,30 @@ type (
 		TmpDir string `json:"tmpdir,omitempty"`
 		// Settings are catch-all-bag to allow per-implementation over-rides
 		Settings gou.JsonHelper `json:"settings,omitempty"`
+		// HTTPClient if set is used by the gcs, s3 and azure stores in place of
+		// their own, so stores made from configs with the same client share its
+		// connection pool.  Size the pool for all of them with the Transport's
+		// MaxIdleConnsPerHost, http.DefaultTransport only keeps 2 idle
+		// connections per host.  For gcs the client's Transport is the base
+		// of the authorized transport.
+		HTTPClient *http.Client `json:"-"`
 		// LogPrefix Logging Prefix/Context message
 		LogPrefix string
+		// Separator is the separator of the folders in object names, defaults
+		// to "/", see KeySeparator.
+		Separator string `json:"separator,omitempty"`
+		// ReadCDN is the base url of the bucket on a CDN, NewStore wraps the
+		// store in a CDNStore reading through it.  CDNCacheBust sets its
+		// CacheBust.
+		ReadCDN      string `json:"readcdn,omitempty"`
+		CDNCacheBust bool   `json:"cdncachebust,omitempty"`
+		// UploadPartSize and UploadConcurrency are the size of the parts, and
+		// the number uploaded at once, of the s3 multipart uploads and the gcs
+		// composite uploads of large objects.  Writes smaller than a part are
+		// a single upload.  s3 defaults to 5MiB parts 5 at a time, gcs only
+		// uploads parts if UploadConcurrency is more than 1, see the stores.
+		UploadPartSize    int64 `json:"uploadpartsize,omitempty"`
+		UploadConcurrency int   `json:"uploadconcurrency,omitempty"`
 	}
 
 	// JwtConf For use with google/google_jwttransporter.go
@@ -248,7 +625,46 @@ func NewStore(conf *Config) (Store, error) {
 	if conf.TmpDir == "" {
 		conf.TmpDir = os.TempDir()
 	}
-	return st(conf)
+	s, err := st(conf)
+	if err != nil || conf.ReadCDN == "" {
+		return s, err
+	}
+	cdn := NewCDNStore(s, conf.ReadCDN)
+	cdn.CacheBust = conf.CDNCacheBust
+	return cdn, nil
+}
+
+// ObjectExists is whether object name exists in s.  It's a Get, which for
+// every store only requests the object's metadata (GCS Attrs, S3 and Azure
+// HEAD, sftp and localfs stat) and doesn't download it.  A missing object is
+// (false, nil), errors are of failed requests.  (Exists is of local files.)
+func ObjectExists(ctx context.Context, s Store, name string) (bool, error) {
+	_, err := s.Get(ctx, name)
+	if err == ErrObjectNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MoveByName moves object src to dst in s, renaming it on the stores that
+// can (localfs, sftp, see StoreMove) and else copying it server side (see
+// CopyByName) then deleting src.  src is only deleted once the copy
+// succeeded.  Returns ErrObjectNotFound if src doesn't exist.
+func MoveByName(ctx context.Context, s Store, src, dst string) error {
+	srcObj, err := s.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	dstObj, err := s.NewObject(dst)
+	if err == ErrObjectExists {
+		dstObj, err = s.Get(ctx, dst)
+	}
+	if err != nil {
+		return err
+	}
+	return Move(ctx, s, srcObj, dstObj)
 }
 
 // Copy source to destination.
@@ -260,10 +676,16 @@ func Copy(ctx context.Context, s Store, src, des Object) error {
 		}
 	}
 
-	// Slow path, open an io.Reader from the source and copy it to an
-	// io.Writer to the destination.  This is considered a "slow path" because we
-	// have to act as a broker to relay bytes between the two objects.  Some
-	// stores support moving data using an API call.
+	return StreamCopy(ctx, s, src, des)
+}
+
+// StreamCopy copies source to destination through this process, the slow
+// path of Copy.  Open an io.Reader from the source and copy it to an io.Writer
+// to the destination.  This is considered a "slow path" because we have to
+// act as a broker to relay bytes between the two objects.  Some stores
+// support moving data using an API call.  The copy is checked against the
+// source md5, see VerifyCopy.
+func StreamCopy(ctx context.Context, s Store, src, des Object) error {
 	fout, err := s.NewWriterWithContext(ctx, des.Name(), src.MetaData())
 	if err != nil {
 		gou.Warnf("Move could not open destination %v", src.Name())
@@ -283,7 +705,7 @@ func Copy(ctx context.Context, s Store, src, des Object) error {
 	if err := fout.Close(); err != nil { //this will flush and sync the file.
 		return err
 	}
-	return nil
+	return VerifyCopy(ctx, s, src, des.Name())
 }
 
 // Move source object to destination.
@@ -311,6 +733,27 @@ func NewObjectsResponse() *ObjectsResponse {
 		Objects: make(Objects, 0),
 	}
 }
+
+// PageObjects is the page of q of a store's whole listing objects, for the
+// stores that can't list a page at a time: the objects after q.Marker in
+// name order, at most q.PageSize of them (all if zero) with HasMore and the
+// NextMarker set if there are more.
+func PageObjects(objects Objects, q Query) *ObjectsResponse {
+	sort.Sort(objects)
+	resp := NewObjectsResponse()
+	for _, o := range objects {
+		if o.Name() <= q.Marker {
+			continue
+		}
+		if q.PageSize > 0 && len(resp.Objects) == q.PageSize {
+			resp.NextMarker = resp.Objects[len(resp.Objects)-1].Name()
+			resp.HasMore = true
+			break
+		}
+		resp.Objects = append(resp.Objects, o)
+	}
+	return resp
+}
 func (o Objects) Len() int           { return len(o) }
 func (o Objects) Less(i, j int) bool { return o[i].Name() < o[j].Name() }
 func (o Objects) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
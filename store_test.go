@@ -1,7 +1,10 @@
 package cloudstorage_test
 
 import (
+	"context"
 	"encoding/json"
+	"regexp"
+	"sort"
 	"testing"
 	"time"
 
@@ -107,3 +110,137 @@ func TestJwtConf(t *testing.T) {
 	assert.Equal(t, "aGVsbG8td29ybGQ=", conf.JwtConf.PrivateKey)
 	assert.Equal(t, "service_account", conf.JwtConf.Type)
 }
+
+func TestMoveByName(t *testing.T) {
+	store := newLocalStore(t, "movebyname")
+	ctx := context.Background()
+	w, err := store.NewWriterWithContext(ctx, "staging/a.csv", map[string]string{"owner": "a"})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte("a,b\n"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	assert.Equal(t, nil, cloudstorage.MoveByName(ctx, store, "staging/a.csv", "final/a.csv"))
+	assert.Equal(t, "a,b\n", readAll(t, store, "final/a.csv"))
+	o, err := store.Get(ctx, "final/a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "a", o.MetaData()["owner"])
+	_, err = store.Get(ctx, "staging/a.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// replaces an existing destination.
+	writeObject(t, store, "staging/b.csv", "c,d\n")
+	assert.Equal(t, nil, cloudstorage.MoveByName(ctx, store, "staging/b.csv", "final/a.csv"))
+	assert.Equal(t, "c,d\n", readAll(t, store, "final/a.csv"))
+
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, cloudstorage.MoveByName(ctx, store, "staging/b.csv", "final/b.csv"))
+}
+
+func TestObjectExists(t *testing.T) {
+	store := newLocalStore(t, "objectexists")
+	ctx := context.Background()
+	writeObject(t, store, "a.csv", "a")
+
+	ok, err := cloudstorage.ObjectExists(ctx, store, "a.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	ok, err = cloudstorage.ObjectExists(ctx, store, "b.csv")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+
+	// failed requests are errors.
+	ok, err = cloudstorage.ObjectExists(ctx, &getFailingStore{Store: store, name: "a.csv"}, "a.csv")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, false, ok)
+}
+
+func TestSignedURLNotSupported(t *testing.T) {
+	store := newLocalStore(t, "signedurl")
+	_, err := cloudstorage.SignedURL(context.Background(), store, "a.csv", cloudstorage.SignedURLOptions{})
+	assert.Equal(t, cloudstorage.ErrFeatureNotSupported, err)
+}
+
+// pagingStore lists the objects of the wrapped store in pages of the query
+// PageSize, recording the page sizes requested.
+type pagingStore struct {
+	cloudstorage.Store
+	pageSizes []int
+}
+
+func (s *pagingStore) List(ctx context.Context, q cloudstorage.Query) (*cloudstorage.ObjectsResponse, error) {
+	s.pageSizes = append(s.pageSizes, q.PageSize)
+	resp, err := s.Store.List(ctx, cloudstorage.NewQuery(q.Prefix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(resp.Objects)
+	objs := cloudstorage.Objects{}
+	for _, o := range resp.Objects {
+		if o.Name() > q.Marker {
+			objs = append(objs, o)
+		}
+	}
+	out := &cloudstorage.ObjectsResponse{Objects: objs}
+	if q.PageSize > 0 && len(objs) > q.PageSize {
+		out.Objects = objs[:q.PageSize]
+		out.NextMarker = objs[q.PageSize-1].Name()
+	}
+	return out, nil
+}
+
+func TestQueryLimited(t *testing.T) {
+	local := newLocalStore(t, "querylimited")
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		writeObject(t, local, "limit/"+name+".csv", name)
+	}
+	store := &pagingStore{Store: local}
+
+	q := cloudstorage.NewQuery("limit/")
+	q.PageSize = 2
+	iter := cloudstorage.NewObjectPageIterator(context.Background(), store, *q.Limited(3))
+	objs, err := cloudstorage.ObjectsAll(iter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(objs))
+	assert.Equal(t, "limit/c.csv", objs[2].Name())
+	// the second page is only of the object left.
+	assert.Equal(t, []int{2, 1}, store.pageSizes)
+
+	// filtered listings keep their page size.
+	store.pageSizes = nil
+	q = cloudstorage.NewQuery("limit/")
+	q.PageSize, q.MinSize = 2, 1
+	iter = cloudstorage.NewObjectPageIterator(context.Background(), store, *q.Limited(3))
+	objs, err = cloudstorage.ObjectsAll(iter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(objs))
+	assert.Equal(t, []int{2, 2}, store.pageSizes)
+}
+
+func TestQueryFilterSuffix(t *testing.T) {
+	local := newLocalStore(t, "queryfilter")
+	for _, name := range []string{"d.csv", "a.json", "b.csv", "c.tmp", "a.csv", "c.csv"} {
+		writeObject(t, local, "filter/"+name, name)
+	}
+	store := &pagingStore{Store: local}
+
+	q := cloudstorage.NewQuery("filter/")
+	q.FilterSuffix(".csv")
+	q.PageSize = 2
+	iter := cloudstorage.NewObjectPageIterator(context.Background(), store, *q.Limited(3))
+	objs, err := cloudstorage.ObjectsAll(iter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(objs))
+	assert.Equal(t, "filter/c.csv", objs[2].Name())
+	// the json and tmp files may be on any page, so pages aren't narrowed.
+	for _, size := range store.pageSizes {
+		assert.Equal(t, 2, size)
+	}
+
+	q = cloudstorage.NewQuery("filter/")
+	q.FilterSuffix(".csv").Sorted()
+	assert.Equal(t, []string{"filter/a.csv", "filter/b.csv", "filter/c.csv", "filter/d.csv"}, listNames(t, local, q))
+
+	q = cloudstorage.NewQuery("filter/")
+	q.FilterMatch(regexp.MustCompile(`/[ab]\.`)).Sorted()
+	assert.Equal(t, []string{"filter/a.csv", "filter/a.json", "filter/b.csv"}, listNames(t, local, q))
+}
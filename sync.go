@@ -0,0 +1,296 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// DefaultCheckpointInterval is the number of objects Sync processes between
+// checkpoints when SyncOptions.CheckpointInterval isn't set.
+var DefaultCheckpointInterval = 100
+
+// SyncOptions are options for Sync.
+type SyncOptions struct {
+	// Concurrency is the number of objects copied at once, defaults to
+	// DefaultCopyConcurrency.
+	Concurrency int
+	// CheckpointPath is a local file Sync persists its progress to, so a Sync
+	// that failed part way (or was cancelled) resumes where it left off when
+	// run again with the same path rather than relisting and rechecking every
+	// object.  The file is replaced atomically, so it can always be read by a
+	// new process, and is removed once the Sync completes.  Empty doesn't
+	// checkpoint.
+	CheckpointPath string
+	// CheckpointInterval is the number of objects processed between writes of
+	// the checkpoint, defaults to DefaultCheckpointInterval.  It is also
+	// written when Sync returns.
+	CheckpointInterval int
+}
+
+// Sync copies the objects under prefix in src to the same names in dst,
+// which can be a different store (or provider).  Objects already in dst with
+// the same size and checksum are skipped, see syncedObject.  Objects
+// are listed in name order, the checkpoint is the last name all objects up
+// to which are synced plus the objects synced after it, so a resumed Sync
+// lists from that name.  Objects deleted from src aren't deleted from dst.
+// Returns the number of objects copied, on error the copies made so far are
+// counted.
+func Sync(ctx context.Context, src, dst Store, prefix string, opts *SyncOptions) (int, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	workers := opts.Concurrency
+	if workers < 1 {
+		workers = DefaultCopyConcurrency
+	}
+	progress, err := loadSyncProgress(opts.CheckpointPath, prefix, opts.CheckpointInterval)
+	if err != nil {
+		return 0, err
+	}
+
+	q := NewQuery(prefix)
+	q.StartAfter = progress.cp.LastKey
+	q.Sorted()
+	iter, err := src.Objects(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var copied int64
+	objs := make(chan Object)
+	errs := make(chan error, workers+1)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range objs {
+				ok, err := syncObject(ctx, src, dst, o)
+				if err == nil {
+					err = progress.synced(o.Name())
+				}
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				if ok {
+					atomic.AddInt64(&copied, 1)
+				}
+			}
+		}()
+	}
+
+produce:
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			errs <- err
+			cancel()
+			break
+		}
+		progress.listed(o.Name())
+		if progress.done(o.Name()) {
+			// synced by the run the checkpoint is from.
+			if err := progress.synced(o.Name()); err != nil {
+				errs <- err
+				cancel()
+				break
+			}
+			continue
+		}
+		select {
+		case objs <- o:
+		case <-ctx.Done():
+			break produce
+		}
+	}
+	close(objs)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		progress.save()
+		return int(copied), err
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		progress.save()
+		return int(copied), err
+	}
+	if opts.CheckpointPath != "" {
+		os.Remove(opts.CheckpointPath)
+	}
+	return int(copied), nil
+}
+
+// syncObject copies o from src to dst, false if dst already has it.
+func syncObject(ctx context.Context, src, dst Store, o Object) (bool, error) {
+	existing, err := dst.Get(ctx, o.Name())
+	if err == nil && syncedObject(o, existing) {
+		return false, nil
+	} else if err != nil && err != ErrObjectNotFound {
+		return false, err
+	}
+
+	fin, err := src.NewReaderWithContext(ctx, o.Name())
+	if err != nil {
+		return false, err
+	}
+	defer fin.Close()
+	fout, err := dst.NewWriterWithContext(ctx, o.Name(), o.MetaData())
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(fout, fin); err != nil {
+		fout.Close()
+		return false, err
+	}
+	if err := fout.Close(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// syncedObject is true if existing in dst is a copy of o, it has the size
+// and the md5 (or crc32c) of o.  If the stores don't both have a checksum an
+// existing copy older than o has been replaced since it was synced.
+func syncedObject(o, existing Object) bool {
+	if existing.Size() != o.Size() {
+		return false
+	}
+	if sum, dsum := o.MD5(), existing.MD5(); len(sum) > 0 && len(dsum) > 0 {
+		return bytes.Equal(sum, dsum)
+	}
+	if sum, ok := ObjectChecksumCRC32C(o); ok {
+		if dsum, ok := ObjectChecksumCRC32C(existing); ok {
+			return sum == dsum
+		}
+	}
+	return !existing.Updated().Before(o.Updated())
+}
+
+// syncCheckpoint is the persisted progress of a Sync.
+type syncCheckpoint struct {
+	Prefix string `json:"prefix"`
+	// LastKey is the name every object up to (and including) which is synced.
+	LastKey string `json:"last_key"`
+	// Synced are the objects after LastKey that are synced.
+	Synced map[string]bool `json:"synced,omitempty"`
+}
+
+// syncProgress tracks the objects synced, in listing order, to advance the
+// checkpoint.
+type syncProgress struct {
+	mu      sync.Mutex
+	path    string
+	every   int
+	since   int
+	cp      syncCheckpoint
+	pending []string
+}
+
+func loadSyncProgress(path, prefix string, every int) (*syncProgress, error) {
+	if every < 1 {
+		every = DefaultCheckpointInterval
+	}
+	p := &syncProgress{path: path, every: every, cp: syncCheckpoint{Prefix: prefix}}
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &p.cp); err != nil {
+				return nil, fmt.Errorf("invalid sync checkpoint %q err=%v", path, err)
+			}
+			if p.cp.Prefix != prefix {
+				return nil, fmt.Errorf("sync checkpoint %q is for prefix %q not %q", path, p.cp.Prefix, prefix)
+			}
+		}
+	}
+	if p.cp.Synced == nil {
+		p.cp.Synced = make(map[string]bool)
+	}
+	return p, nil
+}
+
+// listed records name is being synced, in listing order.
+func (p *syncProgress) listed(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, name)
+}
+
+// done is true if name was synced after LastKey by a previous run.
+func (p *syncProgress) done(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cp.Synced[name]
+}
+
+// synced records name as synced and checkpoints every interval.
+func (p *syncProgress) synced(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cp.Synced[name] = true
+	for len(p.pending) > 0 && p.cp.Synced[p.pending[0]] {
+		p.cp.LastKey = p.pending[0]
+		delete(p.cp.Synced, p.pending[0])
+		p.pending = p.pending[1:]
+	}
+	p.since++
+	if p.since < p.every {
+		return nil
+	}
+	p.since = 0
+	return p.write()
+}
+
+func (p *syncProgress) save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.write()
+}
+
+// write replaces the checkpoint file, via a rename so it is never partly
+// written.
+func (p *syncProgress) write() error {
+	if p.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(p.cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p.path), filepath.Base(p.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), p.path)
+}
@@ -0,0 +1,80 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// outageStore accepts its first writes then fails the rest, as a store going
+// down part way through, and counts Gets.
+type outageStore struct {
+	cloudstorage.Store
+	writes int32
+	gets   int32
+}
+
+func (s *outageStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+	if atomic.AddInt32(&s.writes, -1) < 0 {
+		return nil, fmt.Errorf("store unavailable")
+	}
+	return s.Store.NewWriterWithContext(ctx, name, metadata, opts...)
+}
+
+func (s *outageStore) Get(ctx context.Context, name string) (cloudstorage.Object, error) {
+	atomic.AddInt32(&s.gets, 1)
+	return s.Store.Get(ctx, name)
+}
+
+func TestSyncResume(t *testing.T) {
+	src := newLocalStore(t, "sync_src")
+	dst := newLocalStore(t, "sync_dst")
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		writeObject(t, src, fmt.Sprintf("data/%02d.csv", i), fmt.Sprintf("row %d", i))
+	}
+	checkpoint := "/tmp/cloudstorage_sync_checkpoint.json"
+	os.Remove(checkpoint)
+	opts := &cloudstorage.SyncOptions{Concurrency: 1, CheckpointPath: checkpoint, CheckpointInterval: 1}
+
+	// the destination goes down after 4 objects
+	down := &outageStore{Store: dst, writes: 4}
+	n, err := cloudstorage.Sync(ctx, src, down, "data/", opts)
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 4, n)
+	_, err = os.Stat(checkpoint)
+	assert.Equal(t, nil, err)
+
+	// the resumed sync doesn't revisit the synced objects
+	up := &outageStore{Store: dst, writes: 100}
+	n, err = cloudstorage.Sync(ctx, src, up, "data/", opts)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, int32(6), up.gets)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, fmt.Sprintf("row %d", i), readAll(t, dst, fmt.Sprintf("data/%02d.csv", i)))
+	}
+	_, err = os.Stat(checkpoint)
+	assert.True(t, os.IsNotExist(err))
+
+	// without a checkpoint everything is checked, nothing copied
+	n, err = cloudstorage.Sync(ctx, src, dst, "data/", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, n)
+
+	// without checksums a change of the same size is seen by its time
+	time.Sleep(10 * time.Millisecond)
+	writeObject(t, src, "data/03.csv", "row X")
+	n, err = cloudstorage.Sync(ctx, src, dst, "data/", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "row X", readAll(t, dst, "data/03.csv"))
+}
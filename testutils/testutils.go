@@ -6,6 +6,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -63,29 +64,10 @@ func Setup() {
 
 func Clearstore(t TestingT, store cloudstorage.Store) {
 	//t.Logf("----------------Clearstore-----------------\n")
-	q := cloudstorage.NewQueryAll()
-	q.Sorted()
 	ctx := gou.NewContext(context.Background(), "clearstore")
-	iter, _ := store.Objects(ctx, q)
-	objs, err := cloudstorage.ObjectsAll(iter)
-	if err != nil {
-		t.Fatalf("Could not list store %v", err)
-	}
-	for _, o := range objs {
-		//t.Logf("clearstore(): deleting %v", o.Name())
-		err = store.Delete(ctx, o.Name())
-		assert.Equal(t, nil, err)
-	}
-
-	switch store.Type() {
-	case "s3":
-		// S3 maybe lazy about deletes...
-		fmt.Println("doing s3 delete sleep 5")
-		time.Sleep(5 * time.Second)
-	case "gcs":
-		// GCS is lazy about deletes...
-		fmt.Println("doing GCS delete sleep 15")
-		time.Sleep(15 * time.Second)
+	// S3 and GCS maybe lazy about deletes, wait until they are consistent.
+	if _, err := cloudstorage.DeletePrefix(ctx, store, "", cloudstorage.DeleteOptions{WaitConsistent: true}); err != nil {
+		t.Fatalf("Could not clear store %v", err)
 	}
 }
 
@@ -99,6 +81,10 @@ func RunTests(t TestingT, s cloudstorage.Store, conf *cloudstorage.Config) {
 	BasicRW(t, s)
 	gou.Debugf("finished basicrw")
 
+	t.Logf("running EmptyObject")
+	EmptyObject(t, s)
+	gou.Debugf("finished EmptyObject")
+
 	t.Logf("running MoveCopy")
 	Move(t, s)
 	Copy(t, s)
@@ -108,10 +94,46 @@ func RunTests(t TestingT, s cloudstorage.Store, conf *cloudstorage.Config) {
 	Append(t, s)
 	gou.Debugf("finished append")
 
+	t.Logf("running PatchInPlace")
+	PatchInPlace(t, s)
+	gou.Debugf("finished PatchInPlace")
+
 	t.Logf("running ListObjsAndFolders")
 	ListObjsAndFolders(t, s)
 	gou.Debugf("finished ListObjsAndFolders")
 
+	t.Logf("running ListBySize")
+	ListBySize(t, s)
+	gou.Debugf("finished ListBySize")
+
+	t.Logf("running ListNamesOnly")
+	ListNamesOnly(t, s)
+	gou.Debugf("finished ListNamesOnly")
+
+	t.Logf("running ListByUpdated")
+	ListByUpdated(t, s)
+	gou.Debugf("finished ListByUpdated")
+
+	t.Logf("running ListLevel")
+	ListLevel(t, s)
+	gou.Debugf("finished ListLevel")
+
+	t.Logf("running Range")
+	Range(t, s)
+	gou.Debugf("finished Range")
+
+	t.Logf("running StreamWrite")
+	StreamWrite(t, s)
+	gou.Debugf("finished StreamWrite")
+
+	t.Logf("running FolderObjects")
+	FolderObjects(t, s)
+	gou.Debugf("finished FolderObjects")
+
+	t.Logf("running ListMetadata")
+	ListMetadata(t, s)
+	gou.Debugf("finished ListMetadata")
+
 	t.Logf("running Truncate")
 	Truncate(t, s)
 	gou.Debugf("finished Truncate")
@@ -199,6 +221,43 @@ func BasicRW(t TestingT, store cloudstorage.Store) {
 
 }
 
+func EmptyObject(t TestingT, store cloudstorage.Store) {
+
+	ctx := context.Background()
+	deleteIfExists(store, "empty/writer.csv")
+	deleteIfExists(store, "empty/object.csv")
+
+	// a writer closed without writing creates the object
+	w, err := store.NewWriterWithContext(ctx, "empty/writer.csv", nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	// so does an object opened and closed without writing
+	obj, err := store.NewObject("empty/object.csv")
+	assert.Equal(t, nil, err)
+	_, err = obj.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, obj.Close())
+
+	for _, name := range []string{"empty/writer.csv", "empty/object.csv"} {
+		obj, err := store.Get(ctx, name)
+		assert.Equal(t, nil, err, name)
+		if err != nil {
+			continue
+		}
+		assert.Equal(t, int64(0), obj.Size(), name)
+
+		rc, err := store.NewReaderWithContext(ctx, name)
+		assert.Equal(t, nil, err, name)
+		b, err := ioutil.ReadAll(rc)
+		assert.Equal(t, nil, err, name)
+		assert.Equal(t, 0, len(b), name)
+		rc.Close()
+
+		assert.Equal(t, nil, obj.Delete(), name)
+	}
+}
+
 func createFile(t TestingT, store cloudstorage.Store, name, data string) cloudstorage.Object {
 
 	obj, err := store.NewObject(name)
@@ -348,6 +407,11 @@ func Copy(t TestingT, store cloudstorage.Store) {
 
 	// And also to should exist
 	ensureContents(t, store, "to/testcopy.csv", testcsv, "target file validation")
+
+	// with the same checksum
+	copied, err := store.Get(context.Background(), "to/testcopy.csv")
+	assert.Equalf(t, nil, err, caller)
+	assert.Equalf(t, obj2.MD5(), copied.MD5(), caller)
 }
 
 func Append(t TestingT, store cloudstorage.Store) {
@@ -405,8 +469,7 @@ func Append(t TestingT, store cloudstorage.Store) {
 	assert.Equal(t, nil, err)
 	assert.NotEqual(t, nil, f2)
 
-	// DANGER HERE BE DRAGONS.  This didn't used to be here
-	// so would our app have to implement this behavior?
+	// the ReadWrite file is at the start of the object, seek to append.
 	_, err = f2.Seek(0, os.SEEK_END)
 	assert.Equal(t, nil, err)
 
@@ -459,6 +522,32 @@ func Append(t TestingT, store cloudstorage.Store) {
 	assert.Equal(t, nil, err)
 }
 
+// PatchInPlace writes over bytes of an existing object at offsets of its
+// ReadWrite file, the rest of the object is unchanged.
+func PatchInPlace(t TestingT, store cloudstorage.Store) {
+	deleteIfExists(store, "patch.csv")
+	writeString(t, store, "patch.csv", "Year,Make,Model\n2003,VW,EuroVan\n")
+
+	obj, err := store.Get(context.Background(), "patch.csv")
+	assert.Equal(t, nil, err)
+	f, err := obj.Open(cloudstorage.ReadWrite)
+	assert.Equal(t, nil, err)
+	_, err = f.Seek(5, io.SeekStart)
+	assert.Equal(t, nil, err)
+	_, err = f.Write([]byte("MAKE"))
+	assert.Equal(t, nil, err)
+	// the objects Write is at the files offset.
+	_, err = f.Seek(1, io.SeekCurrent)
+	assert.Equal(t, nil, err)
+	_, err = obj.Write([]byte("MOD"))
+	assert.Equal(t, nil, err)
+	_, err = f.WriteAt([]byte("2004"), 16)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, obj.Close())
+
+	assert.Equal(t, "Year,MAKE,MODel\n2004,VW,EuroVan\n", readString(t, store, "patch.csv"))
+}
+
 func dumpfile(msg, file string) {
 	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
@@ -535,6 +624,30 @@ func ListObjsAndFolders(t TestingT, store cloudstorage.Store) {
 	objResp, err := store.List(context.Background(), q)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 15, len(objResp.Objects), "incorrect list len. wanted 15 got %d", len(objResp.Objects))
+	assert.Equal(t, objResp.NextMarker != "", objResp.HasMore)
+
+	// a page smaller than the listing has more, up to the last page.
+	q = cloudstorage.NewQuery("list-test/")
+	q.PageSize = 4
+	paged := make([]string, 0)
+	for page := 0; page < 4; page++ {
+		objResp, err = store.List(context.Background(), q)
+		assert.Equal(t, nil, err)
+		if err != nil {
+			break
+		}
+		for _, o := range objResp.Objects {
+			paged = append(paged, o.Name())
+		}
+		assert.Equal(t, page < 3, objResp.HasMore, "page %d", page)
+		assert.Equal(t, objResp.HasMore, objResp.NextMarker != "", "page %d", page)
+		if !objResp.HasMore {
+			break
+		}
+		q.Marker = objResp.NextMarker
+	}
+	sort.Strings(paged)
+	assert.Equal(t, names, paged)
 
 	// Now we are going to re-run this test using an Object Iterator
 	// that uses store.List() instead of store.Objects()
@@ -584,6 +697,31 @@ func ListObjsAndFolders(t TestingT, store cloudstorage.Store) {
 
 	assert.Equal(t, 5, len(objs), "incorrect list len.")
 
+	// resume after a key, which is itself excluded
+	q = cloudstorage.NewQuery("list-test/")
+	q.StartAfter = "list-test/b/test2.csv"
+	q.Sorted()
+	iter, _ = store.Objects(context.Background(), q)
+	objs, err = cloudstorage.ObjectsAll(iter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 7, len(objs), "incorrect list len. wanted 7 got %d", len(objs))
+	for i, o := range objs {
+		assert.Equal(t, names[i+8], o.Name(), "unexpected name.")
+	}
+
+	// the last names first
+	q = cloudstorage.NewQuery("list-test/")
+	q.Reverse = true
+	q.Limit = 4
+	q.Sorted()
+	iter, _ = store.Objects(context.Background(), q)
+	objs, err = cloudstorage.ObjectsAll(iter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, len(objs), "incorrect list len. wanted 4 got %d", len(objs))
+	for i, o := range objs {
+		assert.Equal(t, names[14-i], o.Name(), "unexpected name.")
+	}
+
 	q = cloudstorage.NewQueryForFolders("list-test/")
 	folders, err = store.Folders(context.Background(), q)
 	assert.Equal(t, nil, err)
@@ -617,6 +755,19 @@ func ListObjsAndFolders(t TestingT, store cloudstorage.Store) {
 	assert.Equal(t, 2, len(folders), "incorrect list len. wanted 2 folders. %v", folders)
 	assert.Equal(t, []string{"list-test/b/b1/", "list-test/b/b2/"}, folders)
 
+	// a folder emptied of its objects is gone from object stores, the
+	// filesystem stores only drop its directory for ImplicitFolders.  It is
+	// outside list-test/ as the directory outlives the test.
+	createObjects([]string{"folders-test/emptied/test0.csv", "folders-test/full/test0.csv"})
+	err = store.Delete(context.Background(), "folders-test/emptied/test0.csv")
+	assert.Equal(t, nil, err)
+	q = cloudstorage.NewQueryForFolders("folders-test/")
+	q.ImplicitFolders = true
+	folders, err = store.Folders(context.Background(), q)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"folders-test/full/"}, folders)
+
+	q = cloudstorage.NewQueryForFolders("list-test/b/")
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 	folders, err = store.Folders(ctx, q)
@@ -624,6 +775,184 @@ func ListObjsAndFolders(t TestingT, store cloudstorage.Store) {
 	assert.Equal(t, 0, len(folders), "incorrect list len. wanted 0 folders. %v", folders)
 }
 
+func ListLevel(t TestingT, store cloudstorage.Store) {
+
+	Clearstore(t, store)
+
+	for _, n := range []string{
+		"level-test/x.csv",
+		"level-test/y.csv",
+		"level-test/sub/z.csv",
+		"level-test/sub/deeper/w.csv",
+		"level-test/other/v.csv",
+	} {
+		createFile(t, store, n, testcsv)
+	}
+
+	objs, folders, err := cloudstorage.ListLevel(context.Background(), store, "level-test/", 0)
+	assert.Equal(t, nil, err)
+	names := make([]string, 0, len(objs))
+	for _, o := range objs {
+		names = append(names, o.Name())
+	}
+	sort.Strings(names)
+	sort.Strings(folders)
+	assert.Equal(t, []string{"level-test/x.csv", "level-test/y.csv"}, names)
+	assert.Equal(t, []string{"level-test/other/", "level-test/sub/"}, folders)
+
+	objs, folders, err = cloudstorage.ListLevel(context.Background(), store, "level-test/sub/deeper/", 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(objs), "incorrect list len. wanted 1 got %d", len(objs))
+	assert.Equal(t, 0, len(folders), "incorrect list len. wanted 0 folders. %v", folders)
+
+	// the limit is of the first names, folders and objects together.
+	objs, folders, err = cloudstorage.ListLevel(context.Background(), store, "level-test/", 3)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(objs), "incorrect list len. wanted 1 got %d", len(objs))
+	if len(objs) == 1 {
+		assert.Equal(t, "level-test/x.csv", objs[0].Name())
+	}
+	sort.Strings(folders)
+	assert.Equal(t, []string{"level-test/other/", "level-test/sub/"}, folders)
+
+	objs, folders, err = cloudstorage.ListLevel(context.Background(), store, "level-test/", 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(objs), "incorrect list len. wanted 0 got %d", len(objs))
+	assert.Equal(t, []string{"level-test/other/"}, folders)
+}
+
+func Range(t TestingT, store cloudstorage.Store) {
+
+	Clearstore(t, store)
+
+	writeString(t, store, "range-test/a.csv", "0123456789abcdef")
+	obj, err := store.Get(context.Background(), "range-test/a.csv")
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+
+	for _, tc := range []struct {
+		start, length int64
+		expected      string
+	}{
+		{0, 1, "0"},
+		{0, 4, "0123"},
+		{10, 6, "abcdef"},
+		{10, -1, "abcdef"},
+		{0, -1, "0123456789abcdef"},
+	} {
+		rc, err := cloudstorage.OpenRange(context.Background(), obj, tc.start, tc.length)
+		assert.Equal(t, nil, err, "range %d,%d", tc.start, tc.length)
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		assert.Equal(t, nil, err)
+		assert.Equal(t, tc.expected, string(b), "range %d,%d", tc.start, tc.length)
+	}
+
+	_, err = cloudstorage.OpenRange(context.Background(), obj, 10, 7)
+	assert.Equal(t, cloudstorage.ErrRangeNotSatisfiable, err)
+	_, err = cloudstorage.OpenRange(context.Background(), obj, 17, -1)
+	assert.Equal(t, cloudstorage.ErrRangeNotSatisfiable, err)
+}
+
+func StreamWrite(t TestingT, store cloudstorage.Store) {
+
+	Clearstore(t, store)
+
+	ctx := context.Background()
+	// overwrites an existing object
+	writeString(t, store, "stream-test/a.csv", "old")
+	w, err := store.NewWriterWithContext(ctx, "stream-test/a.csv", nil, cloudstorage.Opts{Stream: true})
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	for i := 0; i < 100; i++ {
+		_, err = w.Write([]byte(testcsv))
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, nil, w.Close())
+	assert.Equal(t, strings.Repeat(testcsv, 100), readString(t, store, "stream-test/a.csv"))
+}
+
+func FolderObjects(t TestingT, store cloudstorage.Store) {
+
+	Clearstore(t, store)
+
+	ctx := context.Background()
+	createFile(t, store, "folder-test/sub/a.csv", testcsv)
+
+	// folders aren't objects
+	_, err := store.Get(ctx, "folder-test/sub/")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	_, err = store.Get(ctx, "folder-test/sub")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	// nor are objects folders
+	_, err = store.Get(ctx, "folder-test/sub/a.csv/")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	for _, name := range []string{"folder-test/sub", "folder-test/sub/", "folder-test"} {
+		prefix, err := cloudstorage.GetFolder(ctx, store, name)
+		assert.Equal(t, nil, err, name)
+		assert.Equal(t, strings.TrimSuffix(name, "/")+"/", prefix)
+	}
+	_, err = cloudstorage.GetFolder(ctx, store, "folder-test/missing")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	// stores whose names can end in "/" have folder marker objects
+	w, err := store.NewWriterWithContext(ctx, "folder-test/marker/", nil)
+	if err != nil {
+		return
+	}
+	assert.Equal(t, nil, w.Close())
+	marker, err := store.Get(ctx, "folder-test/marker/")
+	assert.Equal(t, nil, err)
+	if marker != nil {
+		assert.Equal(t, int64(0), marker.Size())
+	}
+	prefix, err := cloudstorage.GetFolder(ctx, store, "folder-test/marker")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "folder-test/marker/", prefix)
+}
+
+func ListMetadata(t TestingT, store cloudstorage.Store) {
+
+	Clearstore(t, store)
+
+	ctx := context.Background()
+	w, err := store.NewWriterWithContext(ctx, "meta-test/a.csv", map[string]string{
+		cloudstorage.ContentTypeKey: "text/csv",
+		"owner":                     "ingest",
+	})
+	assert.Equal(t, nil, err)
+	_, err = w.Write([]byte(testcsv))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+
+	obj, err := store.Get(ctx, "meta-test/a.csv")
+	assert.Equal(t, nil, err)
+
+	q := cloudstorage.NewQuery("meta-test/")
+	q.IncludeMetadata = true
+	resp, err := store.List(ctx, q)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(resp.Objects))
+	if len(resp.Objects) != 1 || obj.ContentType() == "" {
+		// stores without content types have no metadata to list
+		return
+	}
+	// the metadata written is read back by Get.
+	assert.Equal(t, "ingest", obj.MetaData()["owner"])
+	listed := resp.Objects[0]
+	assert.Equal(t, obj.ContentType(), listed.ContentType())
+	assert.Equal(t, obj.MD5(), listed.MD5())
+	assert.Equal(t, "ingest", listed.MetaData()["owner"])
+}
+
 func Truncate(t TestingT, store cloudstorage.Store) {
 
 	deleteIfExists(store, "test.csv")
@@ -679,6 +1008,116 @@ func Truncate(t TestingT, store cloudstorage.Store) {
 	assert.Equal(t, nil, err)
 
 	assert.Equal(t, newtestcsv, string(bytes), "not the rows we expected.")
+
+	// a ReadOnly object, and its file, can't be written
+	_, err = obj3.Write([]byte("2014,VW,Golf\n"))
+	assert.Equal(t, cloudstorage.ErrReadOnly, err)
+	assert.Equal(t, cloudstorage.ErrReadOnly, obj3.Sync())
+	assert.NotEqual(t, nil, f3.Truncate(0))
+
+	obj4, err := store.Get(context.Background(), "test.csv")
+	assert.Equal(t, nil, err)
+	_, err = obj4.Open(cloudstorage.AccessLevel(2))
+	assert.Equal(t, cloudstorage.ErrInvalidAccessLevel, err)
+}
+
+func ListBySize(t TestingT, store cloudstorage.Store) {
+
+	sizes := map[string]int{"size-test/large.csv": 100, "size-test/medium.csv": 10, "size-test/small.csv": 1}
+	for name, size := range sizes {
+		deleteIfExists(store, name)
+		w, err := store.NewWriter(name, nil)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(strings.Repeat("a", size)))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+
+		obj, err := store.Get(context.Background(), name)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, int64(size), obj.Size(), name)
+	}
+	total, count, err := cloudstorage.PrefixSize(context.Background(), store, cloudstorage.NewQuery("size-test/"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(111), total)
+	assert.Equal(t, 3, count)
+
+	list := func(min, max int64, limit int) []string {
+		q := cloudstorage.NewQuery("size-test/")
+		q.MinSize, q.MaxSize, q.Limit = min, max, limit
+		q.Sorted()
+		iter, err := store.Objects(context.Background(), q)
+		assert.Equal(t, nil, err)
+		objs, err := cloudstorage.ObjectsAll(iter)
+		assert.Equal(t, nil, err)
+		names := []string{}
+		for _, o := range objs {
+			names = append(names, o.Name())
+		}
+		return names
+	}
+	assert.Equal(t, []string{"size-test/large.csv", "size-test/medium.csv"}, list(10, 0, 0))
+	assert.Equal(t, []string{"size-test/medium.csv", "size-test/small.csv"}, list(0, 10, 0))
+	assert.Equal(t, []string{"size-test/medium.csv"}, list(5, 50, 0))
+	assert.Equal(t, []string{"size-test/large.csv", "size-test/medium.csv"}, list(1, 0, 2))
+}
+
+// ListNamesOnly lists the objects of ListBySize for their names.
+func ListNamesOnly(t TestingT, store cloudstorage.Store) {
+	q := cloudstorage.NewQuery("size-test/")
+	q.NamesOnly, q.MinSize = true, 10
+	q.Sorted()
+	iter, err := store.Objects(context.Background(), q)
+	assert.Equal(t, nil, err)
+	objs, err := cloudstorage.ObjectsAll(iter)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(objs))
+	for _, o := range objs {
+		assert.Equal(t, true, strings.HasPrefix(o.Name(), "size-test/"))
+		assert.Equal(t, cloudstorage.UnknownSize, o.Size())
+		assert.Equal(t, "", o.ContentType())
+		assert.Equal(t, 0, len(o.MetaData()))
+	}
+}
+
+// ListByUpdated lists the objects modified after and before the updated
+// times of objects written a second apart.
+func ListByUpdated(t TestingT, store cloudstorage.Store) {
+	ctx := context.Background()
+	updated := make(map[string]time.Time)
+	for _, name := range []string{"updated-test/old.csv", "updated-test/new.csv"} {
+		deleteIfExists(store, name)
+		writeString(t, store, name, testcsv)
+		obj, err := store.Get(ctx, name)
+		assert.Equal(t, nil, err)
+		updated[name] = obj.Updated()
+		// stores have times of a second resolution.
+		time.Sleep(time.Millisecond * 1100)
+	}
+	first, second := updated["updated-test/old.csv"], updated["updated-test/new.csv"]
+
+	list := func(q *cloudstorage.Query) []string {
+		iter, err := store.Objects(ctx, *q.Sorted())
+		assert.Equal(t, nil, err)
+		objs, err := cloudstorage.ObjectsAll(iter)
+		assert.Equal(t, nil, err)
+		names := []string{}
+		for _, o := range objs {
+			names = append(names, o.Name())
+		}
+		return names
+	}
+	q := cloudstorage.NewQuery("updated-test/")
+	assert.Equal(t, []string{"updated-test/new.csv"}, list(q.ModifiedAfter(first)))
+	q = cloudstorage.NewQuery("updated-test/")
+	assert.Equal(t, []string{"updated-test/old.csv"}, list(q.ModifiedBefore(second)))
+	q = cloudstorage.NewQuery("updated-test/")
+	assert.Equal(t, []string{"updated-test/new.csv", "updated-test/old.csv"},
+		list(q.ModifiedAfter(first.Add(-time.Second)).ModifiedBefore(second.Add(time.Second))))
+	q = cloudstorage.NewQuery("updated-test/")
+	q.NamesOnly = true
+	assert.Equal(t, []string{"updated-test/new.csv"}, list(q.ModifiedAfter(first).Limited(1)))
+	q = cloudstorage.NewQuery("updated-test/")
+	assert.Equal(t, []string{}, list(q.ModifiedAfter(second)))
 }
 
 func NewObjectWithExisting(t TestingT, store cloudstorage.Store) {
@@ -872,3 +1311,105 @@ func MultipleRW(t TestingT, store cloudstorage.Store, conf *cloudstorage.Config)
 		assert.Equal(t, nil, err)
 	}
 }
+
+// ParallelWorkers is the number of goroutines of RunTestsParallel.
+var ParallelWorkers = 8
+
+// RunTestsParallel runs ParallelWorkers goroutines against s at once, each
+// writing, reading, listing and deleting objects in its own
+// "parallel-test/<n>/" namespace while all of them read a shared object, to
+// shake out races in the shared state of the store (its cache, clients and
+// connection pool).  Run it with -race.
+func RunTestsParallel(t TestingT, s cloudstorage.Store) {
+	ctx := context.Background()
+	shared := "parallel-test/shared.csv"
+	deleteIfExists(s, shared)
+	writeString(t, s, shared, testcsv)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < ParallelWorkers; i++ {
+		wg.Add(1)
+		go func(prefix string) {
+			defer wg.Done()
+			parallelWorker(t, s, prefix, shared)
+		}(fmt.Sprintf("parallel-test/%d/", i))
+	}
+	wg.Wait()
+
+	assert.Equal(t, nil, s.Delete(ctx, shared))
+}
+
+// parallelWorker is one goroutine of RunTestsParallel, its objects are all
+// under prefix.
+func parallelWorker(t TestingT, s cloudstorage.Store, prefix, shared string) {
+	ctx := context.Background()
+	names := []string{prefix + "a.csv", prefix + "b.csv", prefix + "nested/c.csv"}
+	for _, name := range names {
+		deleteIfExists(s, name)
+	}
+
+	for round := 0; round < 3; round++ {
+		body := fmt.Sprintf("%s%d\n", testcsv, round)
+		for _, name := range names {
+			writeString(t, s, name, body)
+		}
+		for _, name := range names {
+			assert.Equal(t, body, readString(t, s, name))
+		}
+		assert.Equal(t, testcsv, readString(t, s, shared))
+
+		// rewrite one through its object, as Open/Close use the cache.
+		obj, err := s.Get(ctx, names[0])
+		assert.Equal(t, nil, err)
+		if err == nil {
+			f, err := obj.Open(cloudstorage.ReadWrite)
+			assert.Equal(t, nil, err)
+			if err == nil {
+				_, err = f.Seek(0, os.SEEK_END)
+				assert.Equal(t, nil, err)
+				_, err = f.WriteString("appended\n")
+				assert.Equal(t, nil, err)
+				assert.Equal(t, nil, obj.Close())
+				assert.Equal(t, nil, obj.Release())
+				assert.Equal(t, body+"appended\n", readString(t, s, names[0]))
+			}
+		}
+
+		q := cloudstorage.NewQuery(prefix)
+		q.Sorted()
+		iter, err := s.Objects(ctx, q)
+		assert.Equal(t, nil, err)
+		objs, err := cloudstorage.ObjectsAll(iter)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, len(names), len(objs))
+	}
+
+	for _, name := range names {
+		assert.Equal(t, nil, s.Delete(ctx, name, cloudstorage.DeleteOptions{WaitConsistent: true}))
+		_, err := s.Get(ctx, name)
+		assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+	}
+}
+
+func writeString(t TestingT, s cloudstorage.Store, name, body string) {
+	w, err := s.NewWriterWithContext(context.Background(), name, nil)
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return
+	}
+	_, err = w.Write([]byte(body))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.Close())
+}
+
+func readString(t TestingT, s cloudstorage.Store, name string) string {
+	rc, err := s.NewReaderWithContext(context.Background(), name)
+	assert.Equal(t, nil, err)
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	assert.Equal(t, nil, err)
+	return string(b)
+}
@@ -0,0 +1,144 @@
+package cloudstorage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/net/context"
+)
+
+// transcodedObject is an object of a store transforming the bytes of its
+// objects as they are read and written (EncryptedStore, CompressedStore).  It
+// is opened to a local temp file of the transformed bytes, read with the
+// store's NewReader, which Sync and Close of a ReadWrite open write back with
+// its NewWriter.
+type transcodedObject struct {
+	Object
+	// s is the transforming store.
+	s Store
+	// created by NewObject, so there is nothing to download.
+	created  bool
+	f        *os.File
+	readonly bool
+}
+
+func (o *transcodedObject) Open(accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	return o.OpenWithContext(context.Background(), accesslevel, opts...)
+}
+
+// OpenWithContext reads the object to a local temp file.
+func (o *transcodedObject) OpenWithContext(ctx context.Context, accesslevel AccessLevel, opts ...ReadOptions) (*os.File, error) {
+	if !accesslevel.Valid() {
+		return nil, ErrInvalidAccessLevel
+	}
+	if o.f != nil {
+		return o.f, nil
+	}
+	f, err := ioutil.TempFile("", "cloudstorage-transcoded")
+	if err != nil {
+		return nil, err
+	}
+	if !o.created {
+		if err := o.download(ctx, f, opts); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+	}
+	o.f, o.readonly = f, accesslevel == ReadOnly
+	return f, nil
+}
+
+func (o *transcodedObject) download(ctx context.Context, f *os.File, opts []ReadOptions) error {
+	rc, err := o.s.NewReaderWithContext(ctx, o.Name(), opts...)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if _, err := io.Copy(f, NewContextReader(ctx, rc)); err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	return err
+}
+
+// Release removes the local temp file.
+func (o *transcodedObject) Release() error {
+	if o.f == nil {
+		return nil
+	}
+	o.f.Close()
+	err := os.Remove(o.f.Name())
+	o.f = nil
+	return err
+}
+
+func (o *transcodedObject) Read(p []byte) (int, error) {
+	if o.f == nil {
+		return 0, fmt.Errorf("object %q is not opened", o.Name())
+	}
+	return o.f.Read(p)
+}
+
+func (o *transcodedObject) Write(p []byte) (int, error) {
+	if o.f == nil {
+		return 0, fmt.Errorf("object %q is not opened", o.Name())
+	}
+	if o.readonly {
+		return 0, ErrReadOnly
+	}
+	return o.f.Write(p)
+}
+
+// Sync writes the local temp file to the store.
+func (o *transcodedObject) Sync() error {
+	if o.f == nil {
+		return fmt.Errorf("object %q is not opened", o.Name())
+	}
+	if o.readonly {
+		return ErrReadOnly
+	}
+	if _, err := o.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wc, err := o.s.NewWriterWithContext(ctx, o.Name(), o.MetaData())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(wc, o.f); err != nil {
+		abortWriter(cancel, wc)
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	o.created = false
+	return nil
+}
+
+// Close syncs a ReadWrite open, and removes the local temp file.
+func (o *transcodedObject) Close() error {
+	if o.f == nil {
+		return nil
+	}
+	if !o.readonly {
+		if err := o.Sync(); err != nil {
+			return err
+		}
+	}
+	return o.Release()
+}
+
+func (o *transcodedObject) File() *os.File {
+	return o.f
+}
+
+// Delete removes the object from the store and the local temp file.
+func (o *transcodedObject) Delete() error {
+	o.Release()
+	return o.Object.Delete()
+}
@@ -0,0 +1,197 @@
+package cloudstorage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Upload streams r to object name in s without buffering it, ie the body of
+// a request uploading a file, returning the written object.  Uploads larger
+// than opts.MaxBytes fail with ErrObjectTooLarge once the limit is passed.
+// The upload is written to a temporary object moved to name once it's
+// complete (see stagedWriter), so a failed upload leaves an existing object
+// at name as it was.  opts may be nil.
+func Upload(ctx context.Context, s Store, name string, r io.Reader, metadata map[string]string, opts *WriteOptions) (Object, error) {
+	var wopts []Opts
+	var limit int64
+	if opts != nil {
+		wopts = []Opts{*opts}
+		limit = opts.MaxBytes
+	}
+
+	wc, err := newStagedWriter(ctx, s, name, metadata, wopts)
+	if err != nil {
+		return nil, err
+	}
+
+	src := r
+	if limit > 0 {
+		// one byte more than the limit tells a full upload from a larger one.
+		src = io.LimitReader(r, limit+1)
+	}
+	n, err := io.Copy(writerOnly{wc}, src)
+	if err == nil && limit > 0 && n > limit {
+		err = ErrObjectTooLarge
+	}
+	if err != nil {
+		wc.abort()
+		return nil, err
+	}
+	if err := wc.Close(); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, name)
+}
+
+// stagedWriter writes object name of s to a temporary object in its folder,
+// moved into place by Close.  Abandoning the write (see abort) only removes
+// the temporary object, even on the stores that commit a canceled write
+// (localfs and sftp write in place), so an existing object at name is never
+// touched by a failed write.
+type stagedWriter struct {
+	io.WriteCloser
+	ctx      context.Context
+	cancel   context.CancelFunc
+	s        Store
+	name     string
+	temp     string
+	metadata map[string]string
+	opts     Opts
+}
+
+// newStagedWriter creates a write of name in s staged in a temporary object.
+// The options of the bytes written (ContentMD5, DetectContentType, the file
+// mode and owner, who pays, Stream) apply to the temporary object, the rest
+// (conditions, PublicRead, SkipIfIdentical, VerifyOnClose) to the write of
+// name when it's moved into place.
+func newStagedWriter(ctx context.Context, s Store, name string, metadata map[string]string, opts []Opts) (*stagedWriter, error) {
+	w := &stagedWriter{ctx: ctx, s: s, name: name, temp: stagingKey(s, name), metadata: metadata}
+	var topts []Opts
+	if len(opts) > 0 {
+		w.opts = opts[0]
+		topts = []Opts{{
+			ContentMD5:        w.opts.ContentMD5,
+			DetectContentType: w.opts.DetectContentType,
+			FileMode:          w.opts.FileMode,
+			UID:               w.opts.UID,
+			GID:               w.opts.GID,
+			UserProject:       w.opts.UserProject,
+			RequestPayer:      w.opts.RequestPayer,
+			Stream:            w.opts.Stream,
+		}}
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	wc, err := s.NewWriterWithContext(wctx, w.temp, metadata, topts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	w.WriteCloser, w.cancel = wc, cancel
+	return w, nil
+}
+
+// stagingKey is a unique temporary object name in the folder of name, with
+// the extension of name so it has the same content type.
+func stagingKey(s Store, name string) string {
+	sep := Separator(s)
+	dir, base := "", name
+	if i := strings.LastIndex(name, sep); i >= 0 {
+		dir, base = name[:i+len(sep)], name[i+len(sep):]
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return dir + ".staged-" + hex.EncodeToString(id) + "-" + base
+}
+
+// Close commits the temporary object and moves it to name.  If the move
+// fails the temporary object is removed, and name is as the store's move
+// (or write) left it.
+func (w *stagedWriter) Close() error {
+	defer w.cancel()
+	if err := w.WriteCloser.Close(); err != nil {
+		w.s.Delete(w.ctx, w.temp)
+		return err
+	}
+	_, move := w.s.(StoreMove)
+	_, copies := w.s.(StoreCopy)
+	o := w.opts
+	if (move || copies) && !o.IfNotExists && o.IfMatch == "" && o.IfGenerationMatch == 0 &&
+		!o.PublicRead && !o.SkipIfIdentical && !o.VerifyOnClose {
+		if err := MoveByName(w.ctx, w.s, w.temp, w.name); err != nil {
+			w.s.Delete(w.ctx, w.temp)
+			return err
+		}
+		return nil
+	}
+	// the store can't move it, or the write has options only a write of
+	// name applies, the temporary object is copied to name by a write.
+	defer w.s.Delete(w.ctx, w.temp)
+	rc, err := w.s.NewReaderWithContext(w.ctx, w.temp)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	o.Resume = false
+	wctx, cancel := context.WithCancel(w.ctx)
+	defer cancel()
+	wc, err := w.s.NewWriterWithContext(wctx, w.name, w.metadata, o)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writerOnly{wc}, rc); err != nil {
+		cancel()
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+// abort abandons the write, canceling it and removing the temporary object
+// in case the store committed it anyway.
+func (w *stagedWriter) abort() {
+	w.cancel()
+	w.WriteCloser.Close()
+	w.s.Delete(w.ctx, w.temp)
+}
+
+// aborter is a writer that can be abandoned without committing it, the
+// stagedWriter and the writers of the stores transforming the bytes written
+// through one (EncryptedStore, CompressedStore).
+type aborter interface {
+	abort()
+}
+
+// abortWriter abandons the write wc, whose context cancel cancels.  An
+// aborter is aborted, other writers are closed once canceled, which aborts
+// the uploads of the object stores.
+func abortWriter(cancel context.CancelFunc, wc io.WriteCloser) {
+	cancel()
+	if a, ok := wc.(aborter); ok {
+		a.abort()
+		return
+	}
+	wc.Close()
+}
+
+// UploadMultipartPart streams a file part of a multipart/form-data request
+// to object name in s, as Upload.  The part's Content-Type is set as the
+// ContentTypeKey of the object.
+func UploadMultipartPart(ctx context.Context, s Store, name string, part *multipart.Part, opts *WriteOptions) (Object, error) {
+	var metadata map[string]string
+	if ctype := part.Header.Get("Content-Type"); ctype != "" {
+		metadata = map[string]string{ContentTypeKey: ctype}
+	}
+	return Upload(ctx, s, name, part, metadata, opts)
+}
+
+// writerOnly hides the io.ReaderFrom of a writer from io.Copy, as
+// bufio.Writer's keeps the error of a failed read and fails the Close of the
+// writer with it.
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,75 @@
+package cloudstorage_test
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// formPart is the file part of a multipart/form-data body with contents.
+func formPart(t *testing.T, contents string) *multipart.Part {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="file"; filename="a.csv"`)
+	h.Set("Content-Type", "text/csv")
+	pw, err := mw.CreatePart(h)
+	assert.Equal(t, nil, err)
+	_, err = pw.Write([]byte(contents))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, mw.Close())
+
+	part, err := multipart.NewReader(body, mw.Boundary()).NextPart()
+	assert.Equal(t, nil, err)
+	return part
+}
+
+func TestUploadMultipartPart(t *testing.T) {
+	store := newLocalStore(t, "upload")
+	ctx := context.Background()
+
+	o, err := cloudstorage.UploadMultipartPart(ctx, store, "uploads/a.csv", formPart(t, "a,b\n"), &cloudstorage.WriteOptions{MaxBytes: 4})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "uploads/a.csv", o.Name())
+	assert.Equal(t, "text/csv", o.MetaData()[cloudstorage.ContentTypeKey])
+	assert.Equal(t, "a,b\n", readAll(t, store, "uploads/a.csv"))
+
+	// too large, the partial object isn't left behind.
+	_, err = cloudstorage.UploadMultipartPart(ctx, store, "uploads/b.csv", formPart(t, "a,b\nc,d\n"), &cloudstorage.WriteOptions{MaxBytes: 4})
+	assert.Equal(t, cloudstorage.ErrObjectTooLarge, err)
+	_, err = store.Get(ctx, "uploads/b.csv")
+	assert.Equal(t, cloudstorage.ErrObjectNotFound, err)
+
+	o, err = cloudstorage.Upload(ctx, store, "uploads/c.csv", strings.NewReader("c,d\n"), nil, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(4), o.Size())
+
+	// a failed upload over an object leaves it as it was.
+	_, err = cloudstorage.UploadMultipartPart(ctx, store, "uploads/a.csv", formPart(t, "a,b\nc,d\n"), &cloudstorage.WriteOptions{MaxBytes: 4})
+	assert.Equal(t, cloudstorage.ErrObjectTooLarge, err)
+	assert.Equal(t, "a,b\n", readAll(t, store, "uploads/a.csv"))
+	_, err = cloudstorage.Upload(ctx, store, "uploads/a.csv", strings.NewReader("e,f\n"), nil, &cloudstorage.WriteOptions{IfNotExists: true})
+	assert.Equal(t, cloudstorage.ErrPreconditionFailed, err)
+	assert.Equal(t, "a,b\n", readAll(t, store, "uploads/a.csv"))
+
+	o, err = cloudstorage.Upload(ctx, store, "uploads/a.csv", strings.NewReader("g,h\ni,j\n"), nil, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(8), o.Size())
+	assert.Equal(t, "g,h\ni,j\n", readAll(t, store, "uploads/a.csv"))
+
+	// nor are the staged objects left behind.
+	resp, err := store.List(ctx, cloudstorage.NewQuery("uploads/"))
+	assert.Equal(t, nil, err)
+	var names []string
+	for _, o := range resp.Objects {
+		names = append(names, o.Name())
+	}
+	assert.Equal(t, []string{"uploads/a.csv", "uploads/c.csv"}, names)
+}
@@ -0,0 +1,67 @@
+package cloudstorage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash"
+	"io"
+
+	"golang.org/x/net/context"
+)
+
+// VerifiesOnClose is true if a write with opts should use a
+// NewVerifyOnCloseWriter.
+func VerifiesOnClose(opts []Opts) bool {
+	return len(opts) > 0 && opts[0].VerifyOnClose
+}
+
+// NewVerifyOnCloseWriter opens a writer for object name in s, that once
+// Closed checks the object in s has the size and md5 (if s has one) of the
+// bytes written, see Opts.VerifyOnClose.  opts are passed through to s with
+// VerifyOnClose turned off.
+func NewVerifyOnCloseWriter(ctx context.Context, s Store, name string, metadata map[string]string, opts []Opts) (io.WriteCloser, error) {
+	opts = append([]Opts(nil), opts...)
+	if len(opts) > 0 {
+		opts[0].VerifyOnClose = false
+	}
+	wc, err := s.NewWriterWithContext(ctx, name, metadata, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyWriter{wc: wc, ctx: ctx, s: s, name: name, h: md5.New()}, nil
+}
+
+type verifyWriter struct {
+	wc   io.WriteCloser
+	ctx  context.Context
+	s    Store
+	name string
+	h    hash.Hash
+	n    int64
+}
+
+func (w *verifyWriter) Write(p []byte) (int, error) {
+	n, err := w.wc.Write(p)
+	w.h.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *verifyWriter) Close() error {
+	if err := w.wc.Close(); err != nil {
+		return err
+	}
+	obj, err := w.s.Get(w.ctx, w.name)
+	if err == ErrObjectNotFound {
+		return ErrWriteNotVerified
+	} else if err != nil {
+		return err
+	}
+	if obj.Size() != w.n {
+		return ErrWriteNotVerified
+	}
+	if sum := obj.MD5(); sum != nil && !bytes.Equal(sum, w.h.Sum(nil)) {
+		return ErrWriteNotVerified
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+// lossyStore silently drops every other write.
+type lossyStore struct {
+	cloudstorage.Store
+}
+
+func (s *lossyStore) NewWriterWithContext(ctx context.Context, name string, metadata map[string]string, opts ...cloudstorage.Opts) (io.WriteCloser, error) {
+	if cloudstorage.VerifiesOnClose(opts) {
+		return cloudstorage.NewVerifyOnCloseWriter(ctx, s, name, metadata, opts)
+	}
+	wc, err := s.Store.NewWriterWithContext(ctx, name, metadata, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &lossyWriter{WriteCloser: wc}, nil
+}
+
+type lossyWriter struct {
+	io.WriteCloser
+	n int
+}
+
+func (w *lossyWriter) Write(p []byte) (int, error) {
+	w.n++
+	if w.n%2 == 0 {
+		return len(p), nil
+	}
+	return w.WriteCloser.Write(p)
+}
+
+func TestVerifyOnClose(t *testing.T) {
+	store := newLocalStore(t, "verify")
+	ctx := context.Background()
+	verify := cloudstorage.WriteOptions{VerifyOnClose: true}
+
+	write := func(s cloudstorage.Store, name string, opts ...cloudstorage.Opts) error {
+		w, err := s.NewWriterWithContext(ctx, name, nil, opts...)
+		assert.Equal(t, nil, err)
+		for _, chunk := range []string{"a,b\n", "c,d\n"} {
+			_, err = w.Write([]byte(chunk))
+			assert.Equal(t, nil, err)
+		}
+		return w.Close()
+	}
+
+	assert.Equal(t, nil, write(store, "ok.csv", verify))
+	assert.Equal(t, "a,b\nc,d\n", readAll(t, store, "ok.csv"))
+
+	// the lost write is only caught if verified
+	lossy := &lossyStore{store}
+	assert.Equal(t, nil, write(lossy, "lost.csv"))
+	assert.Equal(t, cloudstorage.ErrWriteNotVerified, write(lossy, "lost.csv", verify))
+}
@@ -0,0 +1,134 @@
+package cloudstorage
+
+import (
+	"time"
+
+	"github.com/araddon/gou"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// EventType is the kind of change of a watch Event.
+type EventType int
+
+const (
+	// EventCreated an object appeared in the listing.
+	EventCreated EventType = iota
+	// EventModified an object's Updated time or ETag changed.
+	EventModified
+	// EventDeleted an object disappeared from the listing.
+	EventDeleted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreated:
+		return "created"
+	case EventModified:
+		return "modified"
+	case EventDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Event is a change to an object seen by Watch.
+type Event struct {
+	Type EventType
+	Name string
+	// Updated time of the object, for deletes the last Updated seen.
+	Updated time.Time
+}
+
+// watchState is what Watch remembers of each object between listings.
+type watchState struct {
+	updated time.Time
+	etag    string
+}
+
+// Watch polls the objects under prefix every interval and sends an Event for
+// each object created, modified or deleted between listings.  The objects
+// present when Watch is called are the starting state and aren't sent.  Only
+// the name, Updated time and ETag of each object are kept, so memory grows
+// with the number of objects but not their size.  A failed listing is logged
+// and retried on the next interval, events are only sent for complete
+// listings.  The channel is closed once ctx is done, Watch only returns an
+// error if the first listing fails.
+//
+// Changes that are undone within an interval aren't seen, and stores with
+// eventually consistent listings may send events late.
+func Watch(ctx context.Context, s Store, prefix string, interval time.Duration) (<-chan Event, error) {
+	state, err := watchListing(ctx, s, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			next, err := watchListing(ctx, s, prefix)
+			if err != nil {
+				if ctx.Err() == nil {
+					gou.Warnf("watch could not list %q err=%v", prefix, err)
+				}
+				continue
+			}
+			for _, ev := range diffListings(state, next) {
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			state = next
+		}
+	}()
+	return events, nil
+}
+
+func watchListing(ctx context.Context, s Store, prefix string) (map[string]watchState, error) {
+	iter, err := s.Objects(ctx, NewQuery(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	state := make(map[string]watchState)
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			return state, nil
+		} else if err != nil {
+			return nil, err
+		}
+		state[o.Name()] = watchState{updated: o.Updated(), etag: o.ETag()}
+	}
+}
+
+// diffListings is the events that turn listing prev into next.
+func diffListings(prev, next map[string]watchState) []Event {
+	var events []Event
+	for name, st := range next {
+		old, ok := prev[name]
+		if !ok {
+			events = append(events, Event{Type: EventCreated, Name: name, Updated: st.updated})
+		} else if !old.updated.Equal(st.updated) || old.etag != st.etag {
+			events = append(events, Event{Type: EventModified, Name: name, Updated: st.updated})
+		}
+	}
+	for name, st := range prev {
+		if _, ok := next[name]; !ok {
+			events = append(events, Event{Type: EventDeleted, Name: name, Updated: st.updated})
+		}
+	}
+	return events
+}
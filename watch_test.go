@@ -0,0 +1,65 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestWatch(t *testing.T) {
+	store := newLocalStore(t, "watch")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	write := func(name, data string) {
+		w, err := store.NewWriterWithContext(ctx, name, nil)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(data))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+	}
+	// writes aren't atomic, so a listing mid write can add a modified event
+	waitFor := func(events <-chan cloudstorage.Event, typ cloudstorage.EventType, name string) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-events:
+				if ev.Type == typ && ev.Name == name {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("no %v event for %s", typ, name)
+			}
+		}
+	}
+
+	write("watched/existing.csv", "a")
+	events, err := cloudstorage.Watch(ctx, store, "watched/", 10*time.Millisecond)
+	assert.Equal(t, nil, err)
+
+	write("watched/new.csv", "b")
+	waitFor(events, cloudstorage.EventCreated, "watched/new.csv")
+
+	write("watched/existing.csv", "changed")
+	waitFor(events, cloudstorage.EventModified, "watched/existing.csv")
+
+	assert.Equal(t, nil, store.Delete(ctx, "watched/new.csv"))
+	waitFor(events, cloudstorage.EventDeleted, "watched/new.csv")
+
+	// objects outside the prefix aren't watched
+	write("other/x.csv", "x")
+	select {
+	case ev := <-events:
+		if ev.Name == "other/x.csv" {
+			t.Fatalf("unexpected event %v %s", ev.Type, ev.Name)
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	for range events {
+	}
+}
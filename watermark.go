@@ -0,0 +1,77 @@
+package cloudstorage
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// Watermark is the position of a ListSince poll, the caller persists it
+// between polls (it marshals to json).  The zero Watermark lists everything.
+type Watermark struct {
+	// Updated is the newest Updated time seen.
+	Updated time.Time `json:"updated"`
+	// Seen are the names and ETags of the objects at exactly Updated, so
+	// objects sharing the timestamp aren't returned twice or skipped.
+	Seen map[string]string `json:"seen,omitempty"`
+}
+
+// seen is true if o was returned by the poll that made the watermark.
+func (wm Watermark) seen(o Object) bool {
+	if o.Updated().Before(wm.Updated) {
+		return true
+	}
+	if o.Updated().Equal(wm.Updated) {
+		etag, ok := wm.Seen[o.Name()]
+		return ok && etag == o.ETag()
+	}
+	return false
+}
+
+// ListSince lists the objects under prefix created or modified since the
+// poll that returned wm, and the watermark to pass to the next poll.  It
+// lists the whole prefix each call but only returns the new objects, so
+// callers polling a bucket don't need to diff listings themselves.  Deletes
+// are not reported, see Watch.
+//
+// The watermark is the newest Updated time seen, so it relies on the stores
+// timestamps.  Stores with eventually consistent listings (S3) may list an
+// object after objects that were written later, if that happens across polls
+// the late object has an Updated older than the watermark and is missed.
+// Callers that need to see every object on such stores should poll with a
+// watermark moved back by the listing lag, and accept repeats.
+func ListSince(ctx context.Context, s Store, prefix string, wm Watermark) (Objects, Watermark, error) {
+	iter, err := s.Objects(ctx, NewQuery(prefix))
+	if err != nil {
+		return nil, wm, err
+	}
+	defer iter.Close()
+
+	next := Watermark{Updated: wm.Updated, Seen: make(map[string]string)}
+	for name, etag := range wm.Seen {
+		next.Seen[name] = etag
+	}
+	var objects Objects
+	for {
+		o, err := iter.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, wm, err
+		}
+		if wm.seen(o) {
+			continue
+		}
+		objects = append(objects, o)
+
+		switch updated := o.Updated(); {
+		case updated.After(next.Updated):
+			next.Updated = updated
+			next.Seen = map[string]string{o.Name(): o.ETag()}
+		case updated.Equal(next.Updated):
+			next.Seen[o.Name()] = o.ETag()
+		}
+	}
+	return objects, next, nil
+}
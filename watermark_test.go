@@ -0,0 +1,65 @@
+package cloudstorage_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lytics/cloudstorage"
+)
+
+func TestListSince(t *testing.T) {
+	store := newLocalStore(t, "listsince")
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write := func(name, data string, updated time.Time) {
+		w, err := store.NewWriterWithContext(ctx, name, nil)
+		assert.Equal(t, nil, err)
+		_, err = w.Write([]byte(data))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, w.Close())
+		assert.Equal(t, nil, os.Chtimes("/tmp/mockcloud_listsince/"+name, updated, updated))
+	}
+	names := func(objs cloudstorage.Objects) []string {
+		var n []string
+		for _, o := range objs {
+			n = append(n, o.Name())
+		}
+		sort.Strings(n)
+		return n
+	}
+
+	write("in/a.csv", "a", base)
+	write("in/b.csv", "b", base.Add(time.Second))
+	objs, wm, err := cloudstorage.ListSince(ctx, store, "in/", cloudstorage.Watermark{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"in/a.csv", "in/b.csv"}, names(objs))
+	assert.Equal(t, base.Add(time.Second).Unix(), wm.Updated.Unix())
+
+	// nothing new
+	objs, wm, err = cloudstorage.ListSince(ctx, store, "in/", wm)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(objs))
+
+	// the watermark survives a round trip through json
+	b, err := json.Marshal(wm)
+	assert.Equal(t, nil, err)
+	wm = cloudstorage.Watermark{}
+	assert.Equal(t, nil, json.Unmarshal(b, &wm))
+
+	// a new object at the watermark's time, and a modified one
+	write("in/c.csv", "c", base.Add(time.Second))
+	write("in/a.csv", "aa", base.Add(2*time.Second))
+	objs, wm, err = cloudstorage.ListSince(ctx, store, "in/", wm)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"in/a.csv", "in/c.csv"}, names(objs))
+
+	objs, _, err = cloudstorage.ListSince(ctx, store, "in/", wm)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(objs))
+}